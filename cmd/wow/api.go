@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/arl/go-detour/detour"
+)
+
+// apiVersion is the version of the HTTP API responses.
+const apiVersion = 1
+
+// Response is the envelope of all the HTTP API responses. Exactly one of
+// Data and Error is not null.
+type Response struct {
+	Version int         `json:"version"`
+	Data    interface{} `json:"data"`
+	Error   *Error      `json:"error"`
+}
+
+// Error describes the failure of an HTTP API request.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes.
+const (
+	codeBadRequest     = "bad_request"
+	codeInvalidPolyRef = "invalid_poly_ref"
+	codeTileNotLoaded  = "tile_not_loaded"
+	codeInvalidParam   = "invalid_param"
+	codeOutOfNodes     = "out_of_nodes"
+	codeOutOfMemory    = "out_of_memory"
+	codeFailure        = "failure"
+)
+
+// queryErrors maps the detour errors to their error code and HTTP status,
+// more specific errors first.
+var queryErrors = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{detour.ErrInvalidPolyRef, codeInvalidPolyRef, http.StatusUnprocessableEntity},
+	{detour.ErrTileNotLoaded, codeTileNotLoaded, http.StatusUnprocessableEntity},
+	{detour.ErrInvalidParam, codeInvalidParam, http.StatusUnprocessableEntity},
+	{detour.ErrOutOfNodes, codeOutOfNodes, http.StatusInternalServerError},
+	{detour.ErrOutOfMemory, codeOutOfMemory, http.StatusInternalServerError},
+}
+
+// writeData writes a successful response containing data.
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeResponse(w, http.StatusOK, Response{Version: apiVersion, Data: data})
+}
+
+// writeError writes an error response, with the given HTTP status and error
+// code.
+func writeError(w http.ResponseWriter, status int, code string, err error) {
+	writeResponse(w, status, Response{
+		Version: apiVersion,
+		Error:   &Error{Code: code, Message: err.Error()},
+	})
+}
+
+// writeQueryError writes the error response of a failed navmesh query, the
+// error code and HTTP status of which depend on the detour error.
+func writeQueryError(w http.ResponseWriter, err error) {
+	code, status := queryErrorCode(err)
+	writeError(w, status, code, err)
+}
+
+// queryErrorCode returns the error code and HTTP status of a navmesh query
+// error.
+func queryErrorCode(err error) (code string, status int) {
+	for _, qe := range queryErrors {
+		if errors.Is(err, qe.err) {
+			return qe.code, qe.status
+		}
+	}
+	return codeFailure, http.StatusInternalServerError
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
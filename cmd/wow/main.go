@@ -1,16 +1,21 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"runtime"
+	"sync"
+	"syscall"
 
+	"github.com/arl/go-detour/cmd/wow/mmap"
 	"github.com/arl/go-detour/detour"
 	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
 	"github.com/gorilla/mux"
 )
 
@@ -23,26 +28,18 @@ func check(err error) {
 
 func checkStatus(status detour.Status) {
 	if detour.StatusFailed(status) {
-		check(fmt.Errorf("status failed %s", status.Error()))
+		check(status.Err())
 	}
 }
 
-// Converts from wow coords to detour coords
+// Converts from wow coords to detour coords, in is not modified.
 func FromWowCoords(in d3.Vec3) d3.Vec3 {
-	ox := in[0]
-	in[0] = in[1]
-	in[1] = in[2]
-	in[2] = ox
-	return in
+	return d3.Vec3{in[1], in[2], in[0]}
 }
 
-// Coverts from detour coords to wow coords
+// Coverts from detour coords to wow coords, in is not modified.
 func ToWowCoords(in d3.Vec3) d3.Vec3 {
-	oz := in[2]
-	in[2] = in[1]
-	in[1] = in[0]
-	in[0] = oz
-	return in
+	return d3.Vec3{in[2], in[0], in[1]}
 }
 
 func Vector3ToVec3(in Vector3) d3.Vec3 {
@@ -53,14 +50,13 @@ func Vec3ToVector3(in d3.Vec3) Vector3 {
 	return Vector3{X: in[0], Y: in[1], Z: in[2]}
 }
 
-type MMTileHeader struct {
-	MMapMagic   uint32
-	DTVersion   uint32
-	MMapVersion uint32
-	Size        uint32
-	UsesLiquids byte
-	Padding     [3]byte
-}
+// Polygon flags of the mmaps terrain types.
+const (
+	navGround      uint16 = 0x01
+	navGroundSteep uint16 = 0x02
+	navWater       uint16 = 0x04
+	navMagmaSlime  uint16 = 0x08
+)
 
 var (
 	start    = FromWowCoords(d3.Vec3{-8921.09, -119.135, 82.195})
@@ -70,9 +66,11 @@ var (
 
 type Nav struct {
 	mesh    *detour.NavMesh
-	query   *detour.NavMeshQuery
+	queries *detour.NavMeshQueryPool // queries shared by the HTTP handlers
 	filter  *detour.StandardQueryFilter
 	extents d3.Vec3
+	tiles   int        // number of loaded tiles
+	cache   *pathCache // nil if disabled
 }
 
 type Vector3 struct {
@@ -87,13 +85,21 @@ type PathRequest struct {
 }
 
 func main() {
-	nav := NewNav("mmaps/", "000")
+	cacheSize := flag.Int("cache-size", 1024, "maximum number of cached paths, 0 disables the cache")
+	cacheBucket := flag.Float64("cache-bucket", 0.5, "size of the buckets the cached path positions are quantized to")
+	flag.Parse()
 
-	fmt.Println(nav.GetStraightPath(start, end))
+	// stop loading, or serving, on interrupt
+	ctx, stop := signalContext(os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	var s server
 	r := mux.NewRouter()
-	r.HandleFunc("/path", nav.HandleGetPath).Methods("POST")
-	r.HandleFunc("/closest", nav.HandleGetClosestPoints).Methods("POST")
+	r.HandleFunc("/healthz", s.HandleHealthz).Methods("GET")
+	r.HandleFunc("/path", s.withNav((*Nav).HandleGetPath)).Methods("POST")
+	r.HandleFunc("/paths", s.withNav((*Nav).HandleGetPaths)).Methods("POST")
+	r.HandleFunc("/closest", s.withNav((*Nav).HandleGetClosestPoints)).Methods("POST")
+	r.HandleFunc("/path/stream", s.withNav((*Nav).HandleStreamSmoothPath)).Methods("GET")
 
 	http.Handle("/", r)
 
@@ -101,198 +107,386 @@ func main() {
 		Addr:    ":8080",
 		Handler: r,
 	}
-	srv.ListenAndServe()
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- srv.ListenAndServe()
+	}()
+
+	// the server is unavailable, and unhealthy, until the navmesh is loaded
+	nav := NewNav(ctx, "mmaps/", "000")
+	nav.SetPathCache(*cacheSize, float32(*cacheBucket))
+	s.setNav(nav)
+
+	if path, err := nav.GetStraightPath(start, end); err != nil {
+		fmt.Printf("error, %v\n", err)
+	} else {
+		fmt.Println(path)
+	}
+
+	select {
+	case err := <-srvErr:
+		check(err)
+	case <-ctx.Done():
+	}
+
+	// let in-flight requests complete
+	fmt.Println("shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	check(srv.Shutdown(sctx))
 }
 
 func (n *Nav) HandleGetPath(w http.ResponseWriter, r *http.Request) {
 	var req PathRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err)))
+		writeError(w, http.StatusBadRequest, codeBadRequest, err)
 		return
 	}
 
 	start := FromWowCoords(Vector3ToVec3(req.Start))
 	end := FromWowCoords(Vector3ToVec3(req.End))
 
-	path := n.GetStraightPath(start, end)
+	path, err := n.GetStraightPath(start, end)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
 
 	vecs := make([]Vector3, len(path))
 	for i, vec := range path {
 		vecs[i] = Vec3ToVector3(ToWowCoords(vec))
 	}
 
-	json.NewEncoder(w).Encode(vecs)
+	writeData(w, vecs)
+}
+
+// maxBatchPaths is the maximum number of paths of a /paths request.
+const maxBatchPaths = 256
+
+// PathResult is the result of one of the paths of a /paths request. Exactly
+// one of Path and Error is not null.
+type PathResult struct {
+	Path  []Vector3 `json:"path"`
+	Error *Error    `json:"error"`
+}
+
+// HandleGetPaths finds the straight paths of a batch of path requests.
+//
+// The results are index-aligned with the requests. They are computed
+// concurrently, by as many goroutines as there are queries in the pool. The
+// failure of a path is reported in its result, without failing the batch.
+func (n *Nav) HandleGetPaths(w http.ResponseWriter, r *http.Request) {
+	var reqs []PathRequest
+	err := json.NewDecoder(r.Body).Decode(&reqs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err)
+		return
+	}
+	if len(reqs) > maxBatchPaths {
+		writeError(w, http.StatusBadRequest, codeBadRequest,
+			fmt.Errorf("too many paths, %d > %d", len(reqs), maxBatchPaths))
+		return
+	}
+
+	res := make([]PathResult, len(reqs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < n.queries.Size() && i < len(reqs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := FromWowCoords(Vector3ToVec3(reqs[i].Start))
+				end := FromWowCoords(Vector3ToVec3(reqs[i].End))
+
+				path, err := n.GetStraightPath(start, end)
+				if err != nil {
+					code, _ := queryErrorCode(err)
+					res[i].Error = &Error{Code: code, Message: err.Error()}
+					continue
+				}
+				res[i].Path = make([]Vector3, len(path))
+				for j, vec := range path {
+					res[i].Path[j] = Vec3ToVector3(ToWowCoords(vec))
+				}
+			}
+		}()
+	}
+	for i := range reqs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	writeData(w, res)
 }
 
 func (n *Nav) HandleGetClosestPoints(w http.ResponseWriter, r *http.Request) {
 	var req []Vector3
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err)))
+		writeError(w, http.StatusBadRequest, codeBadRequest, err)
 		return
 	}
 
-	res := make([]Vector3, len(req))
-
+	centers := make([]d3.Vec3, len(req))
 	for i, point := range req {
-		in := FromWowCoords(Vector3ToVec3(point))
-		closest, _ := n.GetClosestPoint(in)
-		res[i] = Vec3ToVector3(ToWowCoords(closest))
+		centers[i] = FromWowCoords(Vector3ToVec3(point))
 	}
 
-	json.NewEncoder(w).Encode(res)
+	refs := make([]detour.PolyRef, len(centers))
+	points := make([]d3.Vec3, len(centers))
+	q := n.queries.Get()
+	status := q.FindNearestPolys(centers, n.extents, n.filter, refs, points)
+	n.queries.Put(q)
+	if detour.StatusFailed(status) {
+		writeQueryError(w, status.Err())
+		return
+	}
+
+	// points with no nearby poly are returned unchanged
+	res := make([]Vector3, len(req))
+	for i := range req {
+		if refs[i] == 0 {
+			res[i] = req[i]
+			continue
+		}
+		res[i] = Vec3ToVector3(ToWowCoords(points[i]))
+	}
+
+	writeData(w, res)
 }
 
-func NewNav(path, mapId string) *Nav {
-	mesh := loadMap(path, mapId)
+// NewNav loads the navmesh of a map. Cancelling ctx stops the load.
+func NewNav(ctx context.Context, path, mapId string) *Nav {
+	mesh, tileErrs, err := loadMap(ctx, path, mapId, runtime.NumCPU(), func(loaded, total int) {
+		fmt.Printf("\rloaded %d/%d tiles", loaded, total)
+	})
+	fmt.Println()
+	check(err)
+	for _, err := range tileErrs {
+		if errors.Is(err, detour.ErrTileOccupied) {
+			// the first tile loaded at this location is kept
+			fmt.Printf("warning, overlapping tile, %v\n", err)
+			continue
+		}
+		fmt.Printf("error, %v\n", err)
+	}
+	for _, err := range mesh.Validate() {
+		fmt.Printf("invalid navmesh, %v\n", err)
+	}
 
-	status, query := detour.NewNavMeshQuery(mesh, 65535)
+	// one query per CPU, for the concurrent requests
+	status, queries := detour.NewNavMeshQueryPool(mesh, 65535, runtime.NumCPU())
 	checkStatus(status)
+	// allocate the search nodes now rather than on the first requests
+	queries.Warmup()
 
-	filter := detour.NewStandardQueryFilter()
-	filter.SetIncludeFlags(5)
-	filter.SetExcludeFlags(10)
+	// walk on ground and swim, but avoid steep slopes and magma/slime
+	filter := detour.NewQueryFilterForCapabilities(detour.CapabilitySet{
+		Has:   navGround | navWater,
+		Known: navGround | navGroundSteep | navWater | navMagmaSlime,
+	})
 
 	return &Nav{
 		mesh:    mesh,
-		query:   query,
+		queries: queries,
 		filter:  filter,
 		extents: d3.Vec3{6, 6, 6},
+		tiles:   mesh.MemoryStats().TileCount,
 	}
 }
 
 func (n *Nav) GetClosestPoint(in d3.Vec3) (d3.Vec3, detour.PolyRef) {
-	status, poly, point := n.query.FindNearestPoly(in, n.extents, n.filter)
+	q := n.queries.Get()
+	defer n.queries.Put(q)
+
+	status, poly, point := q.FindNearestPoly(in, n.extents, n.filter)
 	checkStatus(status)
-	if !n.query.AttachedNavMesh().IsValidPolyRef(poly) {
-		check(fmt.Errorf("not a valid poly ref"))
-	}
+	check(n.mesh.CheckPolyRef(poly))
 
 	return point, poly
 }
 
-// TODO
-func (n *Nav) GetSmoothPath(start, end d3.Vec3) []d3.Vec3 {
-	path := n.GetStraightPath(start, end)
-	if len(path) == 0 {
-		return []d3.Vec3{}
-	}
+// Smooth path sampling.
+const (
+	smoothStepSize  = 0.5  // distance between the smooth path points
+	maxSmoothPoints = 2048 // maximum number of smooth path points
+)
 
+// GetSmoothPath returns the straight path from start to end, subdivided in
+// steps of smoothStepSize following the navmesh surface, up to
+// maxSmoothPoints points.
+func (n *Nav) GetSmoothPath(start, end d3.Vec3) ([]d3.Vec3, error) {
 	smoothPath := make([]d3.Vec3, 0)
+	err := n.WalkSmoothPath(start, end, func(pt d3.Vec3) bool {
+		smoothPath = append(smoothPath, pt)
+		return true
+	})
+	return smoothPath, err
+}
+
+// WalkSmoothPath calls emit with each point of the smooth path from start to
+// end, as they are computed, until emit returns false or maxSmoothPoints
+// points have been emitted.
+//
+// see GetSmoothPath
+func (n *Nav) WalkSmoothPath(start, end d3.Vec3, emit func(pt d3.Vec3) bool) error {
+	path, err := n.GetStraightPath(start, end)
+	if err != nil || len(path) == 0 {
+		return err
+	}
+
+	var count int
+	put := func(pt d3.Vec3) bool {
+		if count == maxSmoothPoints {
+			return false
+		}
+		count++
+		return emit(n.onSurface(pt))
+	}
 
+	if !put(path[0]) {
+		return nil
+	}
 	for i := 0; i < len(path)-1; i++ {
 		current := path[i]
 		next := path[i+1]
 
-		smoothPath = append(smoothPath, current)
-
-		for {
-			iter := current.Lerp(next, 1)
-			smoothPath = append(smoothPath, iter)
-
-			if iter.Dist(end) < 0.01 {
-				break
+		steps := int(math32.Ceil(current.Dist(next) / smoothStepSize))
+		for s := 1; s <= steps; s++ {
+			if !put(current.Lerp(next, float32(s)/float32(steps))) {
+				return nil
 			}
 		}
-
 	}
-
-	return smoothPath
+	return nil
 }
 
-func (n *Nav) GetStraightPath(start, end d3.Vec3) []d3.Vec3 {
-	path := n.GetPath(start, end)
-	if len(path) == 0 {
-		return []d3.Vec3{}
-	}
+// onSurface returns pt moved vertically onto the detail mesh of the polygon
+// containing it, or pt itself if there is none.
+func (n *Nav) onSurface(pt d3.Vec3) d3.Vec3 {
+	q := n.queries.Get()
+	defer n.queries.Put(q)
 
-	spath := make([]d3.Vec3, maxPolys)
-	for i := range spath {
-		spath[i] = d3.NewVec3()
+	ref, status := q.PolyContaining(pt, n.extents[1], n.filter)
+	if detour.StatusFailed(status) || ref == 0 {
+		return pt
 	}
-
-	count, status := n.query.FindStraightPath(start, end, path, spath, nil, nil, int32(detour.StraightPathAreaCrossings&detour.StraightPathAllCrossings))
-	checkStatus(status)
-
-	return spath[:count]
+	closest := d3.NewVec3()
+	if detour.StatusFailed(q.ClosestPointOnPoly(ref, pt, closest, nil)) {
+		return pt
+	}
+	return closest
 }
 
-func (n *Nav) GetPath(start, end d3.Vec3) []detour.PolyRef {
-	// Get Start Poly
-	status, startRef, _ := n.query.FindNearestPoly(start, n.extents, n.filter)
-	checkStatus(status)
-	n.query.AttachedNavMesh()
-	if !n.query.AttachedNavMesh().IsValidPolyRef(startRef) {
-		check(fmt.Errorf("not a valid poly ref"))
+// SetPathCache enables the cache of the straight paths, holding up to size
+// paths, or disables it if size is 0. The start and end positions of the
+// paths are quantized to bucket, so the paths between close positions are
+// shared.
+//
+// The cached paths assume a static navmesh, the cache is cleared when tiles
+// are added or removed. It must not be called while requests are served.
+func (n *Nav) SetPathCache(size int, bucket float32) {
+	n.cache = nil
+	if size > 0 && bucket > 0 {
+		n.cache = newPathCache(n.mesh, size, bucket)
 	}
+}
 
-	// Get End Poly
-	status, endRef, _ := n.query.FindNearestPoly(end, n.extents, n.filter)
-	checkStatus(status)
-	if !n.query.AttachedNavMesh().IsValidPolyRef(startRef) {
-		check(fmt.Errorf("not a valid poly ref"))
+// GetStraightPath returns the straight path from start to end. The returned
+// path may be shared with the path cache, it must not be modified.
+func (n *Nav) GetStraightPath(start, end d3.Vec3) ([]d3.Vec3, error) {
+	if n.cache == nil {
+		return n.findStraightPath(start, end)
+	}
+	if path, ok := n.cache.get(start, end); ok {
+		return path, nil
+	}
+	path, err := n.findStraightPath(start, end)
+	if err == nil {
+		n.cache.add(start, end, path)
 	}
+	return path, err
+}
 
-	path := make([]detour.PolyRef, maxPolys)
+func (n *Nav) findStraightPath(start, end d3.Vec3) ([]d3.Vec3, error) {
+	q := n.queries.Get()
+	defer n.queries.Put(q)
+	b := getPathBuffers()
+	defer putPathBuffers(b)
 
-	// Get Path
-	count, status := n.query.FindPath(startRef, endRef, start, end, n.filter, path[:])
-	checkStatus(status)
-	if count == 0 {
-		return []detour.PolyRef{}
+	path, err := n.findPath(q, start, end, b.polys)
+	if err != nil || len(path) == 0 {
+		return []d3.Vec3{}, err
 	}
 
-	return path[:count]
+	// grow the buffer until the whole straight path fits in, the grown
+	// buffer is kept for the next requests
+	for {
+		count, status := q.FindStraightPath(start, end, path, b.straight, nil, nil, int32(detour.StraightPathAreaCrossings&detour.StraightPathAllCrossings))
+		if detour.StatusFailed(status) {
+			return nil, status.Err()
+		}
+		if !detour.StatusDetail(status, detour.BufferTooSmall) {
+			return copyPoints(b.straight[:count]), nil
+		}
+		b.growStraight(2 * len(b.straight))
+	}
 }
 
-func loadMap(path, mapId string) *detour.NavMesh {
-	fmt.Println("Loading: " + path + mapId + ".mmap")
-
-	f, err := os.Open(path + mapId + ".mmap")
-	check(err)
-	defer f.Close()
+func (n *Nav) GetPath(start, end d3.Vec3) ([]detour.PolyRef, error) {
+	q := n.queries.Get()
+	defer n.queries.Put(q)
+	b := getPathBuffers()
+	defer putPathBuffers(b)
 
-	params := detour.NavMeshParams{}
-	err = binary.Read(f, binary.LittleEndian, &params)
-	check(err)
-
-	mesh := detour.NavMesh{}
-	status := mesh.Init(&params)
+	path, err := n.findPath(q, start, end, b.polys)
+	if err != nil {
+		return nil, err
+	}
+	return append([]detour.PolyRef{}, path...), nil
+}
 
+// findPath finds the polygon path from start to end with q, in buf. The
+// returned path is a slice of buf.
+func (n *Nav) findPath(q *detour.NavMeshQuery, start, end d3.Vec3, buf []detour.PolyRef) ([]detour.PolyRef, error) {
+	// Get Start Poly
+	status, startRef, _ := q.FindNearestPoly(start, n.extents, n.filter)
 	if detour.StatusFailed(status) {
-		check(fmt.Errorf("status failed 0x%x", status))
+		return nil, status.Err()
+	}
+	if err := n.mesh.CheckPolyRef(startRef); err != nil {
+		return nil, err
 	}
 
-	ref := detour.TileRef(0)
-
-	for x := 1; x < 64; x++ {
-		for y := 1; y < 64; y++ {
-			tileMapFileName := fmt.Sprintf("%s%s%d%d.mmtile", path, mapId, x, y)
-
-			if _, err := os.Stat(tileMapFileName); errors.Is(err, os.ErrNotExist) {
-				continue
-			}
-
-			f, err := os.Open(tileMapFileName)
-			check(err)
-			defer f.Close()
+	// Get End Poly
+	status, endRef, _ := q.FindNearestPoly(end, n.extents, n.filter)
+	if detour.StatusFailed(status) {
+		return nil, status.Err()
+	}
+	if err := n.mesh.CheckPolyRef(endRef); err != nil {
+		return nil, err
+	}
 
-			header := MMTileHeader{}
-			err = binary.Read(f, binary.LittleEndian, &header)
-			check(err)
+	// Get Path
+	count, status := q.FindPath(startRef, endRef, start, end, n.filter, buf)
+	if detour.StatusFailed(status) {
+		return nil, status.Err()
+	}
+	return buf[:count], nil
+}
 
-			data := make([]byte, header.Size)
-			_, err = io.ReadFull(f, data)
-			check(err)
+// loadMap loads the navmesh of a map, made of a .mmap parameters file and its
+// .mmtile tiles, from the path directory.
+//
+// see mmap.LoadFSContext
+func loadMap(ctx context.Context, path, mapId string, workers int, progress func(loaded, total int)) (mesh *detour.NavMesh, tileErrs []error, err error) {
+	fmt.Println("Loading: " + path + mapId + ".mmap")
 
-			status, _ = mesh.AddTile(data, ref)
-			// fmt.Println("loaded tile ", tileMapFileName, status.Error())
-			checkStatus(status)
-		}
+	if path == "" {
+		path = "."
 	}
-
-	return &mesh
+	return mmap.LoadFSContext(ctx, os.DirFS(path), mapId, workers, progress)
 }
@@ -0,0 +1,185 @@
+// Package mmap loads the navmeshes of the maps generated by the TrinityCore
+// mmaps generator, made of a .mmap parameters file and of .mmtile tiles.
+package mmap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/arl/go-detour/detour"
+)
+
+// TileHeader is the header of a .mmtile file, followed by the tile data.
+type TileHeader struct {
+	MMapMagic   uint32
+	DTVersion   uint32
+	MMapVersion uint32
+	Size        uint32
+	UsesLiquids byte
+	Padding     [3]byte
+}
+
+// TileErrors are the errors of the tiles of a map that failed to load.
+type TileErrors []error
+
+func (e TileErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d tiles failed to load: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// LoadFS loads the navmesh of a map from fsys, which root directory holds
+// the .mmap parameters file and the .mmtile tiles of the map, such as a
+// directory (see os.DirFS), a zip archive (see zip.Reader) or an embed.FS.
+//
+// A tile that fails to load does not abort the whole load: the navmesh is
+// returned with the tiles that did load, along with a TileErrors error
+// holding the error of each failed tile. Any other error means the navmesh
+// could not be loaded.
+func LoadFS(fsys fs.FS, mapID string) (*detour.NavMesh, error) {
+	mesh, tileErrs, err := LoadFSContext(context.Background(), fsys, mapID, runtime.NumCPU(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(tileErrs) != 0 {
+		return mesh, TileErrors(tileErrs)
+	}
+	return mesh, nil
+}
+
+// LoadFSContext loads the navmesh of a map, as LoadFS, and reports the
+// progress of the load.
+//
+// Tile files are read by at most workers goroutines, while tiles are added to
+// the navmesh one at a time. progress, if not nil, is called after each tile
+// file has been processed. A tile that fails to load does not abort the
+// whole load, its error is returned in tileErrs. Cancelling ctx stops the
+// load, the navmesh is then returned with the tiles loaded so far.
+func LoadFSContext(ctx context.Context, fsys fs.FS, mapID string, workers int, progress func(loaded, total int)) (mesh *detour.NavMesh, tileErrs []error, err error) {
+	f, err := fsys.Open(mapID + ".mmap")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	params := detour.NavMeshParams{}
+	if err = binary.Read(f, binary.LittleEndian, &params); err != nil {
+		return nil, nil, err
+	}
+
+	mesh = &detour.NavMesh{}
+	// TrinityCore mmaps use their own 64 bits references layout
+	if status := mesh.InitWithLayout(&params, detour.PolyRefLayoutTrinityCore); detour.StatusFailed(status) {
+		return nil, nil, status.Err()
+	}
+
+	var tileNames []string
+	for x := 1; x < 64; x++ {
+		for y := 1; y < 64; y++ {
+			tileMapFileName := fmt.Sprintf("%s%d%d.mmtile", mapID, x, y)
+			if _, err := fs.Stat(fsys, tileMapFileName); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			tileNames = append(tileNames, tileMapFileName)
+		}
+	}
+
+	type tileData struct {
+		name string
+		data []byte
+		err  error
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	names := make(chan string)
+	tiles := make(chan tileData)
+
+	// dispatch tile file names until they all have been read or the load
+	// is cancelled
+	go func() {
+		defer close(names)
+		for _, name := range tileNames {
+			select {
+			case names <- name:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				data, err := readTile(fsys, name)
+				tiles <- tileData{name: name, data: data, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(tiles)
+	}()
+
+	// tiles are added from this goroutine only, AddTile is not safe for
+	// concurrent use
+	var loaded int
+	for tile := range tiles {
+		loaded++
+		if tile.err == nil {
+			if status, _ := mesh.AddTile(tile.data, 0); detour.StatusFailed(status) {
+				tile.err = status.Err()
+			}
+		}
+		if tile.err != nil {
+			tileErrs = append(tileErrs, fmt.Errorf("%s: %w", tile.name, tile.err))
+		}
+		if progress != nil {
+			progress(loaded, len(tileNames))
+		}
+	}
+
+	return mesh, tileErrs, ctx.Err()
+}
+
+// readTile reads the navmesh tile data contained in a .mmtile file of fsys.
+func readTile(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := TileHeader{}
+	if err = binary.Read(f, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	// don't trust the header size before allocating
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if int64(header.Size) > fi.Size() {
+		return nil, fmt.Errorf("tile size %d larger than file size %d", header.Size, fi.Size())
+	}
+
+	data := make([]byte, header.Size)
+	if _, err = io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package mmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/arl/go-detour/detour"
+)
+
+// testMap returns a map of id 000 made of a tile, holding a single square
+// polygon, and of a truncated tile.
+func testMap(t *testing.T) fstest.MapFS {
+	data, err := detour.CreateNavMeshData(&detour.NavMeshCreateParams{
+		Verts:          []uint16{0, 0, 0, 0, 0, 4, 4, 0, 4, 4, 0, 0},
+		VertCount:      4,
+		Polys:          []uint16{0, 1, 2, 3, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff},
+		PolyFlags:      []uint16{1},
+		PolyAreas:      []uint8{0},
+		PolyCount:      1,
+		Nvp:            6,
+		WalkableHeight: 2,
+		WalkableRadius: 0.5,
+		WalkableClimb:  0.5,
+		BMax:           [3]float32{2, 1, 2},
+		Cs:             0.5,
+		Ch:             0.2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := detour.NavMeshParams{TileWidth: 2, TileHeight: 2, MaxTiles: 64, MaxPolys: 16}
+
+	var mmap, mmtile bytes.Buffer
+	if err := binary.Write(&mmap, binary.LittleEndian, &params); err != nil {
+		t.Fatal(err)
+	}
+	hdr := TileHeader{Size: uint32(len(data))}
+	if err := binary.Write(&mmtile, binary.LittleEndian, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	mmtile.Write(data)
+
+	return fstest.MapFS{
+		"000.mmap":     {Data: mmap.Bytes()},
+		"00011.mmtile": {Data: mmtile.Bytes()},
+		"00012.mmtile": {Data: mmtile.Bytes()[:mmtile.Len()-1]},
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := testMap(t)
+
+	mesh, err := LoadFS(fsys, "000")
+	var tileErrs TileErrors
+	if !errors.As(err, &tileErrs) || len(tileErrs) != 1 {
+		t.Fatalf("LoadFS() error = %v, want the error of the truncated tile", err)
+	}
+	if n := mesh.MemoryStats().TileCount; n != 1 {
+		t.Errorf("loaded %d tiles, want 1", n)
+	}
+
+	delete(fsys, "00012.mmtile")
+	if _, err := LoadFS(fsys, "000"); err != nil {
+		t.Errorf("LoadFS() error = %v, want nil", err)
+	}
+	if _, err := LoadFS(fsys, "001"); err == nil {
+		t.Errorf("LoadFS() of a missing map succeeded")
+	}
+}
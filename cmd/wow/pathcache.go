@@ -0,0 +1,102 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// pathKey is the key of a cached path: the start and end positions,
+// quantized to the cache bucket size.
+type pathKey [6]int32
+
+type pathEntry struct {
+	key  pathKey
+	path []d3.Vec3
+}
+
+// pathCache is a LRU cache of straight paths, safe for concurrent use.
+//
+// Paths are cached by quantized start and end positions, so the paths between
+// positions in the same buckets are considered equal. The cached paths assume
+// the navmesh is static: the whole cache is cleared as soon as a tile is
+// added to, or removed from, the navmesh. Note that, as for queries, the
+// navmesh must not be modified while the cache is in use.
+type pathCache struct {
+	mesh    *detour.NavMesh
+	size    int     // maximum number of paths
+	bucket  float32 // quantization step of the positions
+	changes uint64  // tile changes of mesh when the paths were cached
+
+	mu      sync.Mutex
+	lru     *list.List // of *pathEntry, most recently used first
+	entries map[pathKey]*list.Element
+}
+
+func newPathCache(mesh *detour.NavMesh, size int, bucket float32) *pathCache {
+	return &pathCache{
+		mesh:    mesh,
+		size:    size,
+		bucket:  bucket,
+		changes: mesh.TileChanges(),
+		lru:     list.New(),
+		entries: make(map[pathKey]*list.Element, size),
+	}
+}
+
+func (c *pathCache) key(start, end d3.Vec3) pathKey {
+	var k pathKey
+	for i := 0; i < 3; i++ {
+		k[i] = int32(math32.Floor(start[i] / c.bucket))
+		k[i+3] = int32(math32.Floor(end[i] / c.bucket))
+	}
+	return k
+}
+
+// invalidate clears the cache if the navmesh tiles have changed.
+func (c *pathCache) invalidate() {
+	if changes := c.mesh.TileChanges(); changes != c.changes {
+		c.changes = changes
+		c.lru.Init()
+		c.entries = make(map[pathKey]*list.Element, c.size)
+	}
+}
+
+// get returns the path cached for start and end, it must not be modified.
+func (c *pathCache) get(start, end d3.Vec3) ([]d3.Vec3, bool) {
+	k := c.key(start, end)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate()
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*pathEntry).path, true
+}
+
+// add caches the path from start to end, evicting the least recently used
+// path if the cache is full. path must not be modified afterwards.
+func (c *pathCache) add(start, end d3.Vec3, path []d3.Vec3) {
+	k := c.key(start, end)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate()
+	if e, ok := c.entries[k]; ok {
+		e.Value.(*pathEntry).path = path
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		last := c.lru.Back()
+		delete(c.entries, last.Value.(*pathEntry).key)
+		c.lru.Remove(last)
+	}
+	c.entries[k] = c.lru.PushFront(&pathEntry{key: k, path: path})
+}
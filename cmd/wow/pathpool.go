@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// pathBuffers are the buffers a path request fills with the polygon path and
+// the straight path. They are borrowed from pathBuffersPool, so that they are
+// reused by the following requests instead of being allocated by each of
+// them, as the queries of the query pool are.
+type pathBuffers struct {
+	polys    []detour.PolyRef
+	straight []d3.Vec3 // straight path points, sharing verts
+	verts    []float32
+}
+
+var pathBuffersPool = sync.Pool{
+	New: func() interface{} {
+		b := &pathBuffers{polys: make([]detour.PolyRef, maxPolys)}
+		b.growStraight(maxPolys)
+		return b
+	},
+}
+
+// getPathBuffers borrows path buffers from the pool. They must be returned
+// with putPathBuffers, once the results have been copied out of them.
+func getPathBuffers() *pathBuffers {
+	return pathBuffersPool.Get().(*pathBuffers)
+}
+
+// putPathBuffers returns path buffers to the pool.
+func putPathBuffers(b *pathBuffers) {
+	pathBuffersPool.Put(b)
+}
+
+// growStraight replaces the straight path buffer with a buffer of size
+// points. The points are slices of a single array, rather than allocated one
+// by one.
+func (b *pathBuffers) growStraight(size int) {
+	b.verts = make([]float32, size*3)
+	b.straight = make([]d3.Vec3, size)
+	for i := range b.straight {
+		b.straight[i] = b.verts[i*3 : i*3+3 : i*3+3]
+	}
+}
+
+// copyPoints returns a copy of pts, which points share a single array.
+func copyPoints(pts []d3.Vec3) []d3.Vec3 {
+	verts := make([]float32, len(pts)*3)
+	cpy := make([]d3.Vec3, len(pts))
+	for i, pt := range pts {
+		cpy[i] = verts[i*3 : i*3+3 : i*3+3]
+		copy(cpy[i], pt)
+	}
+	return cpy
+}
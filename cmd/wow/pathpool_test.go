@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/detour/detourtest"
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// testNav returns a Nav on the wall test navmesh, without path cache.
+func testNav(tb testing.TB) *Nav {
+	mesh := detourtest.LoadTestMesh("wall")
+	status, queries := detour.NewNavMeshQueryPool(mesh, 2048, runtime.NumCPU())
+	if detour.StatusFailed(status) {
+		tb.Fatalf("query pool creation failed: %v", status.Err())
+	}
+	return &Nav{
+		mesh:    mesh,
+		queries: queries,
+		filter:  detour.NewStandardQueryFilter(),
+		extents: d3.Vec3{1, 2, 1},
+	}
+}
+
+func BenchmarkHandleGetPath(b *testing.B) {
+	nav := testNav(b)
+	body, err := json.Marshal(PathRequest{
+		Start: Vec3ToVector3(ToWowCoords(d3.Vec3{1.5, 0, 0.5})),
+		End:   Vec3ToVector3(ToWowCoords(d3.Vec3{4.5, 0, 0.5})),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w := httptest.NewRecorder()
+			nav.HandleGetPath(w, httptest.NewRequest("POST", "/path", bytes.NewReader(body)))
+			if w.Code != http.StatusOK {
+				b.Errorf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "req/s")
+}
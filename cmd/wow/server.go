@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownTimeout is the time allowed to in-flight requests to complete
+// once the server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+// codeUnavailable is the error code of the requests received while the
+// navmesh is loading.
+const codeUnavailable = "unavailable"
+
+// server serves the HTTP API, once the navmesh has been loaded.
+type server struct {
+	nav atomic.Value // *Nav, once loaded
+}
+
+func (s *server) setNav(n *Nav) {
+	s.nav.Store(n)
+}
+
+// loadedNav returns the Nav, or nil if it's not loaded yet.
+func (s *server) loadedNav() *Nav {
+	n, _ := s.nav.Load().(*Nav)
+	return n
+}
+
+// withNav returns an HTTP handler calling h with the loaded Nav, or replying
+// with a 503 status while the navmesh is loading.
+func (s *server) withNav(h func(n *Nav, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := s.loadedNav()
+		if n == nil {
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, errors.New("navmesh is loading"))
+			return
+		}
+		h(n, w, r)
+	}
+}
+
+// Health is the response data of the health check.
+type Health struct {
+	Tiles int `json:"tiles"` // number of loaded tiles
+}
+
+// HandleHealthz reports whether the server is able to process requests, that
+// is when the navmesh is loaded, with at least one tile. Otherwise the status
+// is 503.
+func (s *server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	n := s.loadedNav()
+	switch {
+	case n == nil:
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, errors.New("navmesh is loading"))
+	case n.tiles == 0:
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, errors.New("navmesh has no tiles"))
+	default:
+		writeData(w, Health{Tiles: n.tiles})
+	}
+}
+
+// signalContext returns a context cancelled when one of sigs is received.
+// stop stops listening to the signals.
+func signalContext(sigs ...os.Signal) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/gorilla/websocket"
+)
+
+// writeWait is the time allowed to write a message to the websocket peer.
+const writeWait = 10 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// HandleStreamSmoothPath streams the smooth path over a websocket.
+//
+// The client sends a PathRequest message, the server then sends one Response
+// message per smooth path point, as they are computed, and closes the
+// connection once the path is complete, or with the Response of the error
+// if the request fails. The stream stops early if the client disconnects.
+func (n *Nav) HandleStreamSmoothPath(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied with an HTTP error
+		return
+	}
+	defer conn.Close()
+
+	var req PathRequest
+	if err := conn.ReadJSON(&req); err != nil {
+		closeStream(conn, websocket.CloseUnsupportedData, &Error{Code: codeBadRequest, Message: err.Error()})
+		return
+	}
+
+	// The client isn't supposed to send anything else, reading is only
+	// needed to process the control messages, and to detect the client
+	// has gone.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := FromWowCoords(Vector3ToVec3(req.Start))
+	end := FromWowCoords(Vector3ToVec3(req.End))
+
+	var writeErr error
+	err = n.WalkSmoothPath(start, end, func(pt d3.Vec3) bool {
+		select {
+		case <-gone:
+			writeErr = errors.New("client disconnected")
+			return false
+		default:
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		writeErr = conn.WriteJSON(Response{Version: apiVersion, Data: Vec3ToVector3(ToWowCoords(pt))})
+		return writeErr == nil
+	})
+	switch {
+	case writeErr != nil:
+		// nothing can be sent anymore
+	case err != nil:
+		closeCode := websocket.CloseNormalClosure
+		code, status := queryErrorCode(err)
+		if status >= http.StatusInternalServerError {
+			closeCode = websocket.CloseInternalServerErr
+		}
+		closeStream(conn, closeCode, &Error{Code: code, Message: err.Error()})
+	default:
+		closeStream(conn, websocket.CloseNormalClosure, nil)
+	}
+}
+
+// closeStream sends the Response of apiErr, if not nil, then closes the
+// websocket with the given close code.
+func closeStream(conn *websocket.Conn, closeCode int, apiErr *Error) {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if apiErr != nil {
+		if err := conn.WriteJSON(Response{Version: apiVersion, Error: apiErr}); err != nil {
+			return
+		}
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""))
+}
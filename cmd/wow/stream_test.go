@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/gorilla/websocket"
+)
+
+// dialStream starts a server handling the stream with nav and opens a
+// websocket to it.
+func dialStream(t *testing.T, nav *Nav) *websocket.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(nav.HandleStreamSmoothPath))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+type streamResponse struct {
+	Version int     `json:"version"`
+	Data    Vector3 `json:"data"`
+	Error   *Error  `json:"error"`
+}
+
+func TestHandleStreamSmoothPath(t *testing.T) {
+	nav := testNav(t)
+	start, end := d3.Vec3{1.5, 0, 0.5}, d3.Vec3{4.5, 0, 0.5}
+	want, err := nav.GetSmoothPath(start, end)
+	if err != nil {
+		t.Fatalf("GetSmoothPath failed: %v", err)
+	}
+
+	conn := dialStream(t, nav)
+	if err := conn.WriteJSON(PathRequest{
+		Start: Vec3ToVector3(ToWowCoords(start)),
+		End:   Vec3ToVector3(ToWowCoords(end)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// one message per smooth path point, then a normal closure
+	var got []d3.Vec3
+	for {
+		var resp streamResponse
+		err := conn.ReadJSON(&resp)
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("message %d: %v", len(got), err)
+		}
+		if resp.Version != apiVersion || resp.Error != nil {
+			t.Fatalf("message %d: got version %d, error %v", len(got), resp.Version, resp.Error)
+		}
+		got = append(got, FromWowCoords(Vector3ToVec3(resp.Data)))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d points, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Approx(want[i]) {
+			t.Errorf("point %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// a bad request gets the error response, then the connection is closed
+	conn = dialStream(t, nav)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	var resp streamResponse
+	if err := conn.ReadJSON(&resp); err != nil || resp.Error == nil || resp.Error.Code != codeBadRequest {
+		t.Fatalf("got error %v, response error %v, want a %s response", err, resp.Error, codeBadRequest)
+	}
+	if err := conn.ReadJSON(&resp); !websocket.IsCloseError(err, websocket.CloseUnsupportedData) {
+		t.Errorf("got %v after the error response, want a close with code %d", err, websocket.CloseUnsupportedData)
+	}
+}
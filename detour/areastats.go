@@ -0,0 +1,68 @@
+package detour
+
+import "github.com/arl/gogeo/f32/d3"
+
+// AreaHistogram returns the number of polygons of each area type, over all
+// the loaded tiles.
+//
+// Off-mesh connections are not counted, as they are not part of the navmesh
+// surface. It is a single pass over the polygons, meant to check the areas
+// set when the navmesh has been built. Like other NavMesh methods, it must
+// not be called while the navmesh is modified.
+func (m *NavMesh) AreaHistogram() map[uint8]int {
+	hist := make(map[uint8]int)
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		for j := range tile.Polys {
+			poly := &tile.Polys[j]
+			if poly.Type() == polyTypeOffMeshConnection {
+				continue
+			}
+			hist[poly.Area()]++
+		}
+	}
+	return hist
+}
+
+// AreaSurfaceHistogram returns the surface covered by the polygons of each
+// area type, over all the loaded tiles.
+//
+// The surface of a polygon is the one of its triangulation, in world units,
+// not the one of its detail mesh, which hardly differs. Like in
+// AreaHistogram, off-mesh connections are not counted.
+func (m *NavMesh) AreaSurfaceHistogram() map[uint8]float32 {
+	hist := make(map[uint8]float32)
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		for j := range tile.Polys {
+			poly := &tile.Polys[j]
+			if poly.Type() == polyTypeOffMeshConnection {
+				continue
+			}
+			hist[poly.Area()] += polySurface(tile, poly)
+		}
+	}
+	return hist
+}
+
+// polySurface returns the surface of the triangulation of poly, a fan
+// around its first vertex.
+func polySurface(tile *MeshTile, poly *Poly) float32 {
+	vert := func(i uint8) d3.Vec3 {
+		idx := poly.Verts[i] * 3
+		return tile.Verts[idx : idx+3]
+	}
+	var surf float32
+	v0 := vert(0)
+	for i := uint8(2); i < poly.VertCount; i++ {
+		// The cross product length is twice the triangle area.
+		surf += vert(i - 1).Sub(v0).Cross(vert(i).Sub(v0)).Len() / 2
+	}
+	return surf
+}
@@ -82,6 +82,29 @@ func OverlapBounds(amin, amax, bmin, bmax []float32) bool {
 	return true
 }
 
+// pointInPolygon reports whether pt is inside the polygon, on the xz-plane.
+// The polygon are the nverts first vertices of verts.
+func pointInPolygon(pt, verts []float32, nverts int32) bool {
+	// TODO: Replace pnpoly with triArea2D tests?
+	c := false
+	for i, j := 0, (nverts - 1); i < int(nverts); i++ {
+		vi := verts[i*3 : i*3+3]
+		vj := verts[j*3 : j*3+3]
+		if ((vi[2] > pt[2]) != (vj[2] > pt[2])) &&
+			(pt[0] < (vj[0]-vi[0])*(pt[2]-vi[2])/(vj[2]-vi[2])+vi[0]) {
+			c = !c
+		}
+		j = int32(i)
+	}
+	return c
+}
+
+// detailTriEdgeFlags returns the flags of an edge of a detail triangle,
+// from the triangle flags.
+func detailTriEdgeFlags(triFlags uint8, edgeIndex int) uint8 {
+	return (triFlags >> uint(edgeIndex*2)) & 0x3
+}
+
 func distancePtPolyEdgesSqr(pt, verts []float32, nverts int32, ed, et []float32) bool {
 	// TODO: Replace pnpoly with triArea2D tests?
 	c := false
@@ -181,8 +204,16 @@ func closestHeightPointTriangle(p, a, b, c d3.Vec3, h *float32) bool {
 	dot11 := v1.Dot2D(v1)
 	dot12 := v1.Dot2D(v2)
 
+	// Degenerate triangles, with collinear or coincident vertices, have no
+	// barycentric coordinates: the callers fall back to the nearest point of
+	// the polygon edges.
+	denom := dot00*dot11 - dot01*dot01
+	if denom <= 1e-6*dot00*dot11 {
+		return false
+	}
+
 	// Compute barycentric coordinates
-	invDenom := 1.0 / (dot00*dot11 - dot01*dot01)
+	invDenom := 1.0 / denom
 	u := (dot11*dot02 - dot01*dot12) * invDenom
 	v := (dot00*dot12 - dot01*dot02) * invDenom
 
@@ -3,6 +3,9 @@ package detour
 const (
 	navMeshSetMagic   = 'M'<<24 | 'S'<<16 | 'E'<<8 | 'T'
 	navMeshSetVersion = 1
+
+	navMeshDiffMagic   = 'M'<<24 | 'D'<<16 | 'I'<<8 | 'F'
+	navMeshDiffVersion = 1
 )
 
 const (
@@ -14,4 +17,7 @@ const (
 
 	// The maximum number of user defined area ids.
 	maxAreas int32 = 64
+
+	// Detail triangle edge flag of the edges lying on the polygon boundary.
+	detailEdgeBoundary uint8 = 0x01
 )
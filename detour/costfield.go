@@ -0,0 +1,136 @@
+package detour
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// CostField returns the cost to reach each polygon reachable from the start
+// polygon within maxCost, for visualizing the effect of the filter costs.
+//
+//  Arguments:
+//   startRef  The reference id of the start polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   maxCost   The maximum cost to reach a polygon. [Limit: >= 0]
+//   filter    The polygon filter to apply to the query.
+//
+// This is ReachableSet, without the status: the field is nil if the
+// arguments are invalid, and may miss some polygons if the query ran out of
+// nodes. Like the reachable set, the field grows with the reachable area,
+// roughly as the square of maxCost on open ground, up to the number of nodes
+// of the query. Use ReachableSetLimit in order to bound it further.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) CostField(startRef PolyRef, startPos d3.Vec3, maxCost float32, filter QueryFilter) map[PolyRef]float32 {
+	field, st := q.ReachableSet(startRef, startPos, maxCost, filter)
+	if StatusFailed(st) {
+		return nil
+	}
+	return field
+}
+
+// A Projection maps a navmesh position to 2D map coordinates, for example a
+// longitude and a latitude.
+type Projection func(pos d3.Vec3) (x, y float64)
+
+// WriteCostFieldGeoJSON writes field, a cost field of nav, to w as a GeoJSON
+// FeatureCollection, for display in a GeoJSON viewer.
+//
+//  Arguments:
+//   w        The writer.
+//   nav      The navmesh the polygons of field belong to.
+//   field    The cost to reach each polygon. (See CostField)
+//   project  The projection of the polygon vertices, or nil to use the x and
+//            z world coordinates.
+//
+// Each polygon of field is a Polygon feature which properties are its
+// reference, "ref", its cost, "cost", and a "fill" color, from green for a
+// zero cost to red for the highest cost of the field, following the
+// simplestyle convention so that viewers color the polygons by cost. The
+// reference is an hexadecimal string, such as "0x1f", since a JSON number
+// can't hold a 64 bits reference exactly. The features are sorted by polygon
+// reference. Off-mesh connections, which have no surface, and invalid polygon
+// references are skipped.
+func WriteCostFieldGeoJSON(w io.Writer, nav *NavMesh, field map[PolyRef]float32, project Projection) error {
+	if project == nil {
+		project = func(pos d3.Vec3) (float64, float64) {
+			return float64(pos[0]), float64(pos[2])
+		}
+	}
+
+	refs := make([]PolyRef, 0, len(field))
+	var maxCost float32
+	for ref, cost := range field {
+		refs = append(refs, ref)
+		if cost > maxCost {
+			maxCost = cost
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i] < refs[j] })
+
+	type geometry struct {
+		Type        string         `json:"type"`
+		Coordinates [][][2]float64 `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string                 `json:"type"`
+		Geometry   geometry               `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	collection := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+
+	for _, ref := range refs {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(nav.TileAndPolyByRef(ref, &tile, &poly)) || poly.Type() == polyTypeOffMeshConnection {
+			continue
+		}
+
+		ring := make([][2]float64, 0, poly.VertCount+1)
+		for i := uint8(0); i < poly.VertCount; i++ {
+			idx := poly.Verts[i] * 3
+			x, y := project(tile.Verts[idx : idx+3])
+			ring = append(ring, [2]float64{x, y})
+		}
+		// Exterior rings are counterclockwise and closed.
+		var area float64
+		for i := range ring {
+			j := (i + 1) % len(ring)
+			area += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+		}
+		if area < 0 {
+			for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+				ring[i], ring[j] = ring[j], ring[i]
+			}
+		}
+		ring = append(ring, ring[0])
+
+		cost := field[ref]
+		var t float32
+		if maxCost > 0 {
+			t = cost / maxCost
+		}
+		collection.Features = append(collection.Features, feature{
+			Type:     "Feature",
+			Geometry: geometry{Type: "Polygon", Coordinates: [][][2]float64{ring}},
+			Properties: map[string]interface{}{
+				"ref":  fmt.Sprintf("0x%x", uint64(ref)),
+				"cost": cost,
+				"fill": fmt.Sprintf("#%02x%02x00", int(255*t+0.5), int(255*(1-t)+0.5)),
+			},
+		})
+	}
+
+	return json.NewEncoder(w).Encode(collection)
+}
@@ -0,0 +1,46 @@
+package detourtest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+	"github.com/arl/go-detour/sample/solomesh"
+)
+
+// BuildSingleTileMesh builds a single tile navmesh from a triangle mesh, in
+// memory, with the Recast pipeline.
+//
+//  Arguments:
+//   verts  The mesh vertices. [(x, y, z) * nverts]
+//   tris   The mesh triangles, as indices in verts.
+//          [(vertA, vertB, vertC) * ntris]
+//   cfg    The build settings, solomesh.DefaultSettings() is a good start.
+//
+// Triangles are walkable on their counter-clockwise side, when seen from
+// above. The returned error contains the Recast error logs, if any.
+func BuildSingleTileMesh(verts []float32, tris []int32, cfg recast.BuildSettings) (*detour.NavMesh, error) {
+	ctx := recast.NewBuildContext(true)
+	sm := solomesh.New(ctx)
+	sm.SetSettings(cfg)
+	if err := sm.SetGeometry(verts, tris); err != nil {
+		return nil, fmt.Errorf("detourtest: %v", err)
+	}
+
+	mesh, ok := sm.Build()
+	if !ok {
+		var logs []string
+		for i := 0; i < ctx.LogCount(); i++ {
+			if msg := ctx.LogText(int32(i)); strings.HasPrefix(msg, "ERR ") {
+				logs = append(logs, strings.TrimPrefix(msg, "ERR "))
+			}
+		}
+		if len(logs) == 0 {
+			return nil, errors.New("detourtest: navmesh build failed")
+		}
+		return nil, fmt.Errorf("detourtest: navmesh build failed: %s", strings.Join(logs, ", "))
+	}
+	return mesh, nil
+}
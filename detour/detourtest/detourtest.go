@@ -0,0 +1,98 @@
+// Package detourtest provides utilities for testing code using detour
+// navigation meshes.
+//
+// It comes with tiny navigation meshes, built from hand written polygon
+// meshes, on which paths are known in advance:
+//
+//	grid  A flat 6x6 square at y=0, from (0,0,0) to (6,0,6), made of 36
+//	      square polygons of 1x1.
+//	wall  The same square, with a wall from (3,0,0) to (4,0,4) cutting it, so
+//	      that going from one side to the other requires to turn around the
+//	      wall end.
+//
+// Both meshes are single tile and their polygons have flag 1 and area 0, so
+// the query filter returned by detour.NewStandardQueryFilter accepts them.
+//
+// Other navmeshes can be built in memory, from a triangle mesh, with
+// BuildSingleTileMesh.
+//
+// Query results, such as straight paths, are compared to their known values
+// with PathMatches or AssertPath, within a tolerance, since floating point
+// results may slightly differ across platforms:
+//
+//	mesh := detourtest.LoadTestMesh("wall")
+//	...
+//	n, st := query.FindStraightPath(start, end, path, straight, flags, refs, 0)
+//	...
+//	detourtest.AssertPath(t, straight[:n], []d3.Vec3{
+//		{1.5, 0, 0.5}, {2, 0, 1}, {3, 0, 4}, {4, 0, 4}, {4.5, 0, 0.5},
+//	}, 1e-3)
+package detourtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// TestdataDir returns the directory containing the test navmesh files.
+//
+// The files are located from the source of this package, so they are only
+// found when the package source is available, as in the module cache.
+func TestdataDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata")
+}
+
+// LoadTestMesh loads one of the test navmeshes, by name, as listed in the
+// package documentation.
+//
+// It panics if the navmesh can't be loaded, it is meant to be called from
+// tests.
+func LoadTestMesh(name string) *detour.NavMesh {
+	f, err := os.Open(filepath.Join(TestdataDir(), name+".bin"))
+	if err != nil {
+		panic(fmt.Sprintf("detourtest: %v", err))
+	}
+	defer f.Close()
+
+	mesh, err := detour.Decode(f)
+	if err != nil {
+		panic(fmt.Sprintf("detourtest: can't decode %s navmesh: %v", name, err))
+	}
+	return mesh
+}
+
+// PathMatches checks that got and want have the same number of points and
+// that each point of got is within distance tol of the corresponding point
+// of want. It returns nil if it is the case, or an error describing the first
+// difference.
+func PathMatches(got, want []d3.Vec3, tol float32) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("path has %d points, want %d\n got: %v\nwant: %v", len(got), len(want), got, want)
+	}
+	for i := range want {
+		if len(got[i]) < 3 || len(want[i]) < 3 {
+			return fmt.Errorf("point %d: %v or %v is not a 3D point", i, got[i], want[i])
+		}
+		if d := got[i].Dist(want[i]); d > tol || math32.IsNaN(d) {
+			return fmt.Errorf("point %d is %v, want %v (distance %g > %g)", i, got[i], want[i], d, tol)
+		}
+	}
+	return nil
+}
+
+// AssertPath reports a test error, with tb.Errorf, if got does not match
+// want within distance tol. See PathMatches.
+func AssertPath(tb testing.TB, got, want []d3.Vec3, tol float32) {
+	tb.Helper()
+	if err := PathMatches(got, want, tol); err != nil {
+		tb.Error(err)
+	}
+}
@@ -0,0 +1,273 @@
+package detourtest
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/sample/solomesh"
+	"github.com/arl/gogeo/f32/d3"
+)
+
+var update = flag.Bool("update", false, "regenerate the test navmesh files")
+
+// fixtures lists the test navmeshes, with the grid cells which are not
+// walkable.
+var fixtures = []struct {
+	name    string
+	blocked func(x, z int) bool
+}{
+	{"grid", func(x, z int) bool { return false }},
+	{"wall", func(x, z int) bool { return x == 3 && z < 4 }},
+}
+
+const gridSize = 6
+
+// buildGridMesh builds a navmesh made of a flat grid of square polygons of
+// 1x1, except for the blocked cells.
+func buildGridMesh(blocked func(x, z int) bool) (*detour.NavMesh, error) {
+	const (
+		cs  = 0.5 // cell size
+		nvp = 6   // max vertices per polygon
+	)
+
+	// Grid vertices, in voxel units.
+	var verts []uint16
+	for z := 0; z <= gridSize; z++ {
+		for x := 0; x <= gridSize; x++ {
+			verts = append(verts, uint16(float32(x)/cs), 0, uint16(float32(z)/cs))
+		}
+	}
+	vert := func(x, z int) uint16 { return uint16(z*(gridSize+1) + x) }
+
+	// Polygons indices, by cell.
+	polyIdx := make(map[[2]int]uint16)
+	for z := 0; z < gridSize; z++ {
+		for x := 0; x < gridSize; x++ {
+			if !blocked(x, z) {
+				polyIdx[[2]int{x, z}] = uint16(len(polyIdx))
+			}
+		}
+	}
+	nei := func(x, z int) uint16 {
+		if i, ok := polyIdx[[2]int{x, z}]; ok {
+			return i
+		}
+		return 0xffff
+	}
+
+	npolys := len(polyIdx)
+	polys := make([]uint16, npolys*2*nvp)
+	for i := range polys {
+		polys[i] = 0xffff
+	}
+	for z := 0; z < gridSize; z++ {
+		for x := 0; x < gridSize; x++ {
+			i, ok := polyIdx[[2]int{x, z}]
+			if !ok {
+				continue
+			}
+			p := polys[int(i)*2*nvp:]
+			// Vertices and, for the edge starting at each vertex, the
+			// neighbour polygon.
+			p[0], p[nvp+0] = vert(x, z), nei(x-1, z)
+			p[1], p[nvp+1] = vert(x, z+1), nei(x, z+1)
+			p[2], p[nvp+2] = vert(x+1, z+1), nei(x+1, z)
+			p[3], p[nvp+3] = vert(x+1, z), nei(x, z-1)
+		}
+	}
+
+	flags := make([]uint16, npolys)
+	for i := range flags {
+		flags[i] = 1
+	}
+
+	// Detail meshes, made of the polygon vertices and of 2 triangles per
+	// polygon, with all their vertices on the polygon boundary.
+	var (
+		dmeshes []int32
+		dverts  []float32
+		dtris   []uint8
+	)
+	for i := 0; i < npolys; i++ {
+		p := polys[i*2*nvp:]
+		dmeshes = append(dmeshes, int32(i*4), 4, int32(i*2), 2)
+		for j := 0; j < 4; j++ {
+			v := verts[p[j]*3:]
+			dverts = append(dverts, float32(v[0])*cs, 0, float32(v[2])*cs)
+		}
+		// vertex indices, then edge flags: 1 for edges on the polygon boundary.
+		dtris = append(dtris, 0, 1, 2, 1|1<<2, 0, 2, 3, 1<<2|1<<4)
+	}
+
+	data, err := detour.CreateNavMeshData(&detour.NavMeshCreateParams{
+		Verts:            verts,
+		VertCount:        int32(len(verts) / 3),
+		Polys:            polys,
+		PolyFlags:        flags,
+		PolyAreas:        make([]uint8, npolys),
+		PolyCount:        int32(npolys),
+		Nvp:              nvp,
+		DetailMeshes:     dmeshes,
+		DetailVerts:      dverts,
+		DetailVertsCount: int32(len(dverts) / 3),
+		DetailTris:       dtris,
+		DetailTriCount:   int32(len(dtris) / 4),
+		BMin:             [3]float32{0, 0, 0},
+		BMax:             [3]float32{gridSize, 1, gridSize},
+		WalkableHeight:   2,
+		WalkableRadius:   0.5,
+		WalkableClimb:    0.5,
+		Cs:               cs,
+		Ch:               0.2,
+		BuildBvTree:      true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var mesh detour.NavMesh
+	if st := mesh.InitForSingleTileWithLayout(data, 0, detour.PolyRefLayout32); detour.StatusFailed(st) {
+		return nil, st
+	}
+	return &mesh, nil
+}
+
+func TestFixtures(t *testing.T) {
+	for _, fx := range fixtures {
+		mesh, err := buildGridMesh(fx.blocked)
+		if err != nil {
+			t.Fatalf("%s: %v", fx.name, err)
+		}
+
+		tmp, err := ioutil.TempFile("", "detourtest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		if err := mesh.SaveToFile(tmp.Name()); err != nil {
+			t.Fatalf("%s: %v", fx.name, err)
+		}
+		built, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fname := filepath.Join(TestdataDir(), fx.name+".bin")
+		if *update {
+			if err := ioutil.WriteFile(fname, built, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		committed, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(built, committed) {
+			t.Errorf("%s: committed navmesh differs from the generated one, run go test -update", fx.name)
+		}
+	}
+}
+
+func TestGoldenPaths(t *testing.T) {
+	tests := []struct {
+		mesh       string
+		start, end d3.Vec3
+		want       []d3.Vec3
+	}{
+		{
+			"grid",
+			d3.Vec3{0.5, 0, 1.5}, d3.Vec3{5.5, 0, 1.5},
+			[]d3.Vec3{{0.5, 0, 1.5}, {5.5, 0, 1.5}},
+		},
+		{
+			"wall",
+			d3.Vec3{1.5, 0, 0.5}, d3.Vec3{4.5, 0, 0.5},
+			[]d3.Vec3{{1.5, 0, 0.5}, {2, 0, 1}, {3, 0, 4}, {4, 0, 4}, {4.5, 0, 0.5}},
+		},
+	}
+
+	for _, tt := range tests {
+		mesh := LoadTestMesh(tt.mesh)
+		st, query := detour.NewNavMeshQuery(mesh, 256)
+		if detour.StatusFailed(st) {
+			t.Fatalf("%s: query creation failed with status 0x%x", tt.mesh, uint32(st))
+		}
+		filter := detour.NewStandardQueryFilter()
+		extents := d3.NewVec3XYZ(0.5, 1, 0.5)
+
+		_, startRef, startPt := query.FindNearestPoly(tt.start, extents, filter)
+		_, endRef, endPt := query.FindNearestPoly(tt.end, extents, filter)
+		if startRef == 0 || endRef == 0 {
+			t.Fatalf("%s: start or end poly not found", tt.mesh)
+		}
+
+		path := make([]detour.PolyRef, 64)
+		npath, st := query.FindPath(startRef, endRef, startPt, endPt, filter, path)
+		if st != detour.Success {
+			t.Fatalf("%s: FindPath returned status 0x%x", tt.mesh, uint32(st))
+		}
+
+		straight := make([]d3.Vec3, 16)
+		for i := range straight {
+			straight[i] = d3.NewVec3()
+		}
+		flags := make([]uint8, 16)
+		refs := make([]detour.PolyRef, 16)
+		n, st := query.FindStraightPath(startPt, endPt, path[:npath], straight, flags, refs, 0)
+		if detour.StatusFailed(st) {
+			t.Fatalf("%s: FindStraightPath returned status 0x%x", tt.mesh, uint32(st))
+		}
+		AssertPath(t, straight[:n], tt.want, 1e-3)
+	}
+}
+
+func TestPathMatches(t *testing.T) {
+	want := []d3.Vec3{{0, 0, 0}, {1, 0, 1}}
+	if err := PathMatches([]d3.Vec3{{0, 0, 0.0005}, {1, 0, 1}}, want, 1e-3); err != nil {
+		t.Errorf("close paths don't match: %v", err)
+	}
+	if err := PathMatches([]d3.Vec3{{0, 0, 0.1}, {1, 0, 1}}, want, 1e-3); err == nil {
+		t.Errorf("distant paths match")
+	}
+	if err := PathMatches(want[:1], want, 1e-3); err == nil {
+		t.Errorf("paths of different lengths match")
+	}
+}
+
+func TestBuildSingleTileMesh(t *testing.T) {
+	// A flat 10x10 square, made of 2 triangles.
+	verts := []float32{0, 0, 0, 10, 0, 0, 10, 0, 10, 0, 0, 10}
+	tris := []int32{0, 3, 2, 0, 2, 1}
+
+	mesh, err := BuildSingleTileMesh(verts, tris, solomesh.DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, query := detour.NewNavMeshQuery(mesh, 256)
+	if detour.StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := detour.NewStandardQueryFilter()
+	extents := d3.NewVec3XYZ(1, 1, 1)
+	_, startRef, startPt := query.FindNearestPoly(d3.Vec3{1, 0, 1}, extents, filter)
+	_, endRef, endPt := query.FindNearestPoly(d3.Vec3{9, 0, 9}, extents, filter)
+	if startRef == 0 || endRef == 0 {
+		t.Fatalf("start or end poly not found")
+	}
+	path := make([]detour.PolyRef, 64)
+	npath, st := query.FindPath(startRef, endRef, startPt, endPt, filter, path)
+	if st != detour.Success || path[npath-1] != endRef {
+		t.Errorf("FindPath returned status 0x%x and didn't reach the end poly", uint32(st))
+	}
+
+	if _, err := BuildSingleTileMesh(verts, []int32{0, 1, 4}, solomesh.DefaultSettings()); err == nil {
+		t.Errorf("want error for out of range triangle vertex")
+	}
+}
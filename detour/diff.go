@@ -0,0 +1,212 @@
+package detour
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// ErrDiffBaseline is returned by NavMesh.DiffFrom and NavMesh.ApplyDiff when
+// the navmeshes do not share the same tiles and polygons.
+var ErrDiffBaseline = errors.New("detour: navmesh diff baseline mismatch")
+
+// navMeshDiffHeader is the header of a navmesh diff.
+type navMeshDiffHeader struct {
+	Magic       uint32
+	Version     uint32
+	Fingerprint uint32 // fingerprint of the baseline geometry
+	NumTiles    uint32 // number of tiles with changes
+}
+
+// navMeshDiffTile is the header of the changes of a tile in a navmesh diff,
+// followed by NumPolys navMeshDiffPoly.
+type navMeshDiffTile struct {
+	X, Y, Layer int32
+	NumPolys    uint32
+}
+
+// navMeshDiffPoly is the state of a changed polygon in a navmesh diff.
+type navMeshDiffPoly struct {
+	Poly  uint32 // polygon index in the tile
+	Flags uint16
+	Area  uint8
+}
+
+// DiffFrom returns the differences of the mutable state of the navmesh from
+// baseline, the navmesh it has been loaded from, typically for a save-game
+// only storing what changed since the baked navmesh.
+//
+// The mutable state is the flags and the area of each polygon, everything
+// else in the tiles is the immutable data of the baked navmesh. The diff
+// holds the state of the changed polygons, it is then much smaller than the
+// serialized navmesh when most of it is unchanged. Tiles are identified by
+// their grid location, so the diff does not depend on the order in which
+// they have been added.
+//
+// The diff format is versioned, and records a fingerprint of the geometry
+// of baseline. ErrDiffBaseline is returned if the navmesh and baseline do not
+// have the same tiles and polygons.
+//
+// see ApplyDiff
+func (m *NavMesh) DiffFrom(baseline *NavMesh) ([]byte, error) {
+	fp := m.fingerprint()
+	if baseline.fingerprint() != fp {
+		return nil, ErrDiffBaseline
+	}
+
+	var (
+		body  bytes.Buffer
+		ntile uint32
+		polys []navMeshDiffPoly
+	)
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		base := baseline.TileAt(tile.Header.X, tile.Header.Y, tile.Header.Layer)
+		if base == nil || len(base.Polys) != len(tile.Polys) {
+			return nil, ErrDiffBaseline
+		}
+
+		polys = polys[:0]
+		for ip := range tile.Polys {
+			poly, bpoly := &tile.Polys[ip], &base.Polys[ip]
+			if poly.Flags != bpoly.Flags || poly.Area() != bpoly.Area() {
+				polys = append(polys, navMeshDiffPoly{Poly: uint32(ip), Flags: poly.Flags, Area: poly.Area()})
+			}
+		}
+		if len(polys) == 0 {
+			continue
+		}
+
+		tileHdr := navMeshDiffTile{
+			X:        tile.Header.X,
+			Y:        tile.Header.Y,
+			Layer:    tile.Header.Layer,
+			NumPolys: uint32(len(polys)),
+		}
+		binary.Write(&body, binary.LittleEndian, &tileHdr)
+		binary.Write(&body, binary.LittleEndian, polys)
+		ntile++
+	}
+
+	hdr := navMeshDiffHeader{
+		Magic:       navMeshDiffMagic,
+		Version:     navMeshDiffVersion,
+		Fingerprint: fp,
+		NumTiles:    ntile,
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// ApplyDiff applies data, a diff returned by DiffFrom, to the navmesh, which
+// must have the geometry of the baseline of the diff, for example the baked
+// navmesh loaded again.
+//
+// The polygons of the diff get the flags and area they had when the diff
+// was computed, the other polygons are left untouched. ErrDiffBaseline is
+// returned if the navmesh geometry does not match the baseline. The diff is
+// checked in full before being applied: if an error is returned, the navmesh
+// is left unchanged.
+//
+// see DiffFrom
+func (m *NavMesh) ApplyDiff(data []byte) error {
+	r := bytes.NewReader(data)
+	var hdr navMeshDiffHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	if hdr.Magic != navMeshDiffMagic {
+		return fmt.Errorf("wrong magic number: %x", hdr.Magic)
+	}
+	if hdr.Version != navMeshDiffVersion {
+		return fmt.Errorf("wrong version: %d", hdr.Version)
+	}
+	if hdr.Fingerprint != m.fingerprint() {
+		return ErrDiffBaseline
+	}
+
+	type tileDiff struct {
+		tile  *MeshTile
+		polys []navMeshDiffPoly
+	}
+	diffs := make([]tileDiff, 0, hdr.NumTiles)
+	for i := uint32(0); i < hdr.NumTiles; i++ {
+		var tileHdr navMeshDiffTile
+		if err := binary.Read(r, binary.LittleEndian, &tileHdr); err != nil {
+			return err
+		}
+		tile := m.TileAt(tileHdr.X, tileHdr.Y, tileHdr.Layer)
+		if tile == nil {
+			return ErrDiffBaseline
+		}
+		if int64(tileHdr.NumPolys)*int64(binary.Size(navMeshDiffPoly{})) > int64(r.Len()) {
+			return fmt.Errorf("tile (%d,%d,%d): truncated diff", tileHdr.X, tileHdr.Y, tileHdr.Layer)
+		}
+		polys := make([]navMeshDiffPoly, tileHdr.NumPolys)
+		if err := binary.Read(r, binary.LittleEndian, polys); err != nil {
+			return err
+		}
+		for _, p := range polys {
+			if p.Poly >= uint32(len(tile.Polys)) || int32(p.Area) >= maxAreas {
+				return fmt.Errorf("tile (%d,%d,%d): invalid polygon %d", tileHdr.X, tileHdr.Y, tileHdr.Layer, p.Poly)
+			}
+		}
+		diffs = append(diffs, tileDiff{tile, polys})
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%d trailing bytes after diff", r.Len())
+	}
+
+	for _, d := range diffs {
+		for _, p := range d.polys {
+			poly := &d.tile.Polys[p.Poly]
+			poly.Flags = p.Flags
+			poly.SetArea(p.Area)
+		}
+	}
+	return nil
+}
+
+// fingerprint returns a hash of the immutable geometry of the navmesh: the
+// location, the polygons and the vertices of its tiles, whatever their order.
+func (m *NavMesh) fingerprint() uint32 {
+	var (
+		sum uint32
+		buf [4]byte
+	)
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		h := fnv.New32a()
+		put := func(v uint32) {
+			binary.LittleEndian.PutUint32(buf[:], v)
+			h.Write(buf[:])
+		}
+		put(uint32(tile.Header.X))
+		put(uint32(tile.Header.Y))
+		put(uint32(tile.Header.Layer))
+		put(uint32(len(tile.Polys)))
+		for ip := range tile.Polys {
+			poly := &tile.Polys[ip]
+			put(uint32(poly.VertCount)<<8 | uint32(poly.Type()))
+			for _, v := range poly.Verts[:poly.VertCount] {
+				put(uint32(v))
+			}
+		}
+		for _, v := range tile.Verts {
+			put(math.Float32bits(v))
+		}
+		sum += h.Sum32()
+	}
+	return sum
+}
@@ -0,0 +1,84 @@
+package detour
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNavMeshDiff(t *testing.T) {
+	baseline, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	// Toggle some poly flags in two tiles, and change an area.
+	var changed int
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil || changed >= 2 {
+			continue
+		}
+		for ip := 0; ip < len(tile.Polys); ip += 3 {
+			tile.Polys[ip].Flags ^= 0x10
+		}
+		tile.Polys[len(tile.Polys)-1].SetArea(5)
+		changed++
+	}
+
+	data, err := mesh.DiffFrom(baseline)
+	checkt(t, err)
+	if len(data) <= 16 {
+		t.Fatalf("diff is %d bytes, want changes after the 16 bytes header", len(data))
+	}
+	var full int
+	for i := range mesh.Tiles {
+		if mesh.Tiles[i].Header != nil {
+			full += len(mesh.tileData(&mesh.Tiles[i]))
+		}
+	}
+	if len(data)*10 >= full {
+		t.Errorf("diff is %d bytes, want much less than the %d bytes of the serialized tiles", len(data), full)
+	}
+
+	restored, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	checkt(t, restored.ApplyDiff(data))
+	for i := range mesh.Tiles {
+		tile, rtile := &mesh.Tiles[i], &restored.Tiles[i]
+		for ip := range tile.Polys {
+			if p, rp := &tile.Polys[ip], &rtile.Polys[ip]; p.Flags != rp.Flags || p.AreaAndType != rp.AreaAndType {
+				t.Errorf("tile %d poly %d: got flags 0x%x and area %d, want 0x%x and %d",
+					i, ip, rp.Flags, rp.Area(), p.Flags, p.Area())
+			}
+		}
+	}
+
+	// An unchanged navmesh has an empty diff.
+	if empty, err := baseline.DiffFrom(baseline); err != nil || len(empty) != 16 {
+		t.Errorf("diff of the baseline from itself is %d bytes and error %v, want a 16 bytes header", len(empty), err)
+	}
+
+	// Mismatched baselines.
+	other, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+	if _, err := mesh.DiffFrom(other); !errors.Is(err, ErrDiffBaseline) {
+		t.Errorf("DiffFrom another navmesh returned error %v, want %v", err, ErrDiffBaseline)
+	}
+	if err := other.ApplyDiff(data); !errors.Is(err, ErrDiffBaseline) {
+		t.Errorf("ApplyDiff to another navmesh returned error %v, want %v", err, ErrDiffBaseline)
+	}
+
+	// Invalid diffs leave the navmesh unchanged.
+	fresh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	badVersion := append([]byte(nil), data...)
+	badVersion[4]++
+	for _, bad := range [][]byte{data[:len(data)-1], badVersion, data[:8]} {
+		if err := fresh.ApplyDiff(bad); err == nil {
+			t.Errorf("ApplyDiff of an invalid diff succeeded")
+		}
+	}
+	if d, err := fresh.DiffFrom(baseline); err != nil || len(d) != 16 {
+		t.Errorf("navmesh changed by invalid diffs")
+	}
+}
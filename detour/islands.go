@@ -0,0 +1,107 @@
+package detour
+
+import "sort"
+
+// FindIslands partitions the polygons of the loaded tiles into islands, the
+// sets of polygons connected together by links.
+//
+// Only the polygons passing filter are part of the islands, and a link to a
+// polygon that does not pass filter does not connect it. Off-mesh
+// connections are polygons like the others: they connect the islands of
+// their endpoints. The islands are made of the polygons linked together
+// whatever the direction of the links, so an island only left through a
+// one-way off-mesh connection is merged with the island it leads to.
+//
+// The islands are sorted by decreasing number of polygons, the main
+// navigable area coming first, so that the last islands are the small
+// isolated ones, where an agent could be placed but never leave. The
+// polygons of an island are sorted by tile, then by polygon index. nil is
+// returned if filter is nil.
+//
+// The cost is O(polys + links), plus the sorting of the islands. It is meant
+// for offline validation of a navmesh and must not be called while the
+// navmesh is modified.
+func (m *NavMesh) FindIslands(filter QueryFilter) [][]PolyRef {
+	if filter == nil {
+		return nil
+	}
+
+	// Index of the first polygon of each tile, over all the tiles.
+	base := make([]int, len(m.Tiles)+1)
+	for it := range m.Tiles {
+		base[it+1] = base[it]
+		if m.Tiles[it].Header != nil {
+			base[it+1] += len(m.Tiles[it].Polys)
+		}
+	}
+
+	// Merge the linked polygons, parent being -1 for the filtered out
+	// polygons.
+	n := base[len(m.Tiles)]
+	parent := make([]int, n)
+	refs := make([]PolyRef, n)
+	for it := range m.Tiles {
+		tile := &m.Tiles[it]
+		if tile.Header == nil {
+			continue
+		}
+		polyBase := m.polyRefBase(tile)
+		for ip := range tile.Polys {
+			i := base[it] + ip
+			refs[i] = polyBase | PolyRef(ip)
+			parent[i] = -1
+			if filter.PassFilter(refs[i], tile, &tile.Polys[ip]) {
+				parent[i] = i
+			}
+		}
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	for it := range m.Tiles {
+		tile := &m.Tiles[it]
+		if tile.Header == nil {
+			continue
+		}
+		for ip := range tile.Polys {
+			i := base[it] + ip
+			if parent[i] < 0 {
+				continue
+			}
+			for l := tile.Polys[ip].FirstLink; l != nullLink; l = tile.Links[l].Next {
+				nei := tile.Links[l].Ref
+				j := base[m.decodePolyIDTile(nei)] + int(m.decodePolyIDPoly(nei))
+				if parent[j] < 0 {
+					continue
+				}
+				if ri, rj := find(i), find(j); ri != rj {
+					parent[rj] = ri
+				}
+			}
+		}
+	}
+
+	var islands [][]PolyRef
+	island := make(map[int]int) // the island of each root
+	for i := range parent {
+		if parent[i] < 0 {
+			continue
+		}
+		root := find(i)
+		k, ok := island[root]
+		if !ok {
+			k = len(islands)
+			island[root] = k
+			islands = append(islands, nil)
+		}
+		islands[k] = append(islands[k], refs[i])
+	}
+	sort.SliceStable(islands, func(i, j int) bool {
+		return len(islands[i]) > len(islands[j])
+	})
+	return islands
+}
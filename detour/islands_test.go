@@ -0,0 +1,77 @@
+package detour
+
+import (
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestFindIslands(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+	filter := NewStandardQueryFilter()
+
+	islands := mesh.FindIslands(filter)
+	if len(islands) < 2 {
+		t.Fatalf("found %d islands, want several", len(islands))
+	}
+
+	// Each polygon is in a single island, and no link crosses islands.
+	island := make(map[PolyRef]int)
+	for i, polys := range islands {
+		if i > 0 && len(polys) > len(islands[i-1]) {
+			t.Errorf("island %d has %d polygons, more than island %d", i, len(polys), i-1)
+		}
+		for _, ref := range polys {
+			if _, ok := island[ref]; ok {
+				t.Fatalf("poly 0x%x is in several islands", ref)
+			}
+			island[ref] = i
+		}
+	}
+	var npolys int
+	for i := range mesh.Tiles {
+		if mesh.Tiles[i].Header != nil {
+			npolys += len(mesh.Tiles[i].Polys)
+		}
+	}
+	if len(island) != npolys {
+		t.Errorf("islands have %d polygons, want %d", len(island), npolys)
+	}
+	for ref, i := range island {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		mesh.TileAndPolyByRef(ref, &tile, &poly)
+		for l := poly.FirstLink; l != nullLink; l = tile.Links[l].Next {
+			if j := island[tile.Links[l].Ref]; j != i {
+				t.Errorf("poly 0x%x of island %d is linked to poly 0x%x of island %d", ref, i, tile.Links[l].Ref, j)
+			}
+		}
+	}
+
+	// There is no path from the main island to the others.
+	st, q := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	from, to := islands[0][0], islands[len(islands)-1][0]
+	fromPos, toPos := d3.NewVec3(), d3.NewVec3()
+	q.ClosestPointOnPolyBoundary(from, fromPos, fromPos)
+	q.ClosestPointOnPolyBoundary(to, toPos, toPos)
+	path := make([]PolyRef, 256)
+	if _, st := q.FindPath(from, to, fromPos, toPos, filter, path); !StatusDetail(st, PartialResult) {
+		t.Errorf("FindPath between islands returned status 0x%x, want PartialResult", uint32(st))
+	}
+
+	// Filtered out polygons are in no island.
+	excl := NewStandardQueryFilter()
+	excl.SetExcludeFlags(0xffff)
+	if got := mesh.FindIslands(excl); len(got) != 0 {
+		t.Errorf("found %d islands with all polygons excluded, want 0", len(got))
+	}
+	if got := mesh.FindIslands(nil); got != nil {
+		t.Errorf("FindIslands(nil) = %v, want nil", got)
+	}
+}
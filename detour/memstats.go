@@ -0,0 +1,149 @@
+package detour
+
+import "unsafe"
+
+// MeshMemoryStats reports the memory used by a navigation mesh.
+//
+// Byte counts are computed from the capacities of the slices held by the
+// navmesh, so they reflect the memory actually retained, which may be more
+// than the data size of the tiles.
+type MeshMemoryStats struct {
+	TileCount int // Number of loaded tiles.
+	PolyCount int // Number of polygons of the loaded tiles.
+
+	TileBytes   int64 // Tile headers, polygons, vertices and off-mesh connections.
+	LinkBytes   int64 // Polygon links.
+	BvTreeBytes int64 // Bounding volume trees.
+	DetailBytes int64 // Detail meshes, vertices and triangles.
+	MeshBytes   int64 // Tile array and tile lookup of the navmesh itself.
+}
+
+// TotalBytes returns the total number of bytes reported by s.
+func (s MeshMemoryStats) TotalBytes() int64 {
+	return s.TileBytes + s.LinkBytes + s.BvTreeBytes + s.DetailBytes + s.MeshBytes
+}
+
+// MemoryFlags are options of a NavMesh trading query speed for memory, so
+// that more tiles fit in a given memory budget. Queries return the same
+// results with or without them. (See NavMesh.SetMemoryFlags)
+type MemoryFlags uint8
+
+const (
+	// MemoryNoBvTree drops the BV-tree of the tiles. The polygon searches,
+	// such as FindNearestPoly, then test all the polygons of the tiles
+	// overlapping their search box rather than walking the tree, which
+	// costs a time linear in the number of polygons per tile. The tiles
+	// saved with SaveToFile have no BV-tree either.
+	MemoryNoBvTree MemoryFlags = 1 << iota
+
+	// MemoryCompactLinks trims the link pools of the tiles to the links in
+	// use, once a tile has been connected to its neighbours. The pools are
+	// otherwise sized for all the links a tile may have, including the
+	// ones to neighbours that are not loaded yet. A compacted pool grows
+	// when a neighbour tile is added, then is compacted again, which costs
+	// an allocation per neighbour for each added tile.
+	MemoryCompactLinks
+)
+
+// SetMemoryFlags sets the memory saving options of the navmesh, none by
+// default.
+//
+// The options are applied to the loaded tiles, then to each tile as it is
+// added. Like the up axis, they are not part of the navmesh data, so they
+// are set again after loading a navmesh, or before adding its tiles to save
+// the memory of the options from the start. Clearing an option does not
+// restore what has been dropped, such as the BV-trees of the loaded tiles.
+// Like other NavMesh methods, it must not be called while queries are
+// running on the navmesh.
+func (m *NavMesh) SetMemoryFlags(flags MemoryFlags) {
+	m.memory = flags
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		if flags&MemoryNoBvTree != 0 {
+			dropBvTree(tile)
+		}
+		if flags&MemoryCompactLinks != 0 {
+			compactLinks(tile)
+		}
+	}
+}
+
+// MemoryFlags returns the memory saving options of the navmesh.
+func (m *NavMesh) MemoryFlags() MemoryFlags {
+	return m.memory
+}
+
+// dropBvTree removes the BV-tree of tile.
+func dropBvTree(tile *MeshTile) {
+	tile.BvTree = nil
+	tile.Header.BvNodeCount = 0
+}
+
+// compactLinks reallocates the link pool of tile to hold only the links in
+// use, stored by polygon.
+func compactLinks(tile *MeshTile) {
+	var used int
+	for ip := range tile.Polys {
+		for l := tile.Polys[ip].FirstLink; l != nullLink; l = tile.Links[l].Next {
+			used++
+		}
+	}
+	if used == len(tile.Links) && used == cap(tile.Links) {
+		return
+	}
+
+	links := make([]Link, used)
+	var n uint32
+	for ip := range tile.Polys {
+		poly := &tile.Polys[ip]
+		l := poly.FirstLink
+		if l == nullLink {
+			continue
+		}
+		poly.FirstLink = n
+		for ; l != nullLink; l = tile.Links[l].Next {
+			links[n] = tile.Links[l]
+			links[n].Next = n + 1
+			n++
+		}
+		links[n-1].Next = nullLink
+	}
+	tile.Links = links
+	tile.LinksFreeList = nullLink
+	tile.Header.MaxLinkCount = int32(used)
+}
+
+// MemoryStats returns the memory used by the navmesh.
+//
+// It only walks the tile array, without visiting polygons or links, so it is
+// cheap enough to be called periodically, for example to decide when to
+// unload tiles. Like other NavMesh methods, it must not be called while the
+// navmesh is modified.
+func (m *NavMesh) MemoryStats() MeshMemoryStats {
+	var s MeshMemoryStats
+	s.MeshBytes = int64(cap(m.Tiles))*int64(unsafe.Sizeof(MeshTile{})) +
+		int64(cap(m.posLookup))*int64(unsafe.Sizeof(m.posLookup[0]))
+
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		s.TileCount++
+		s.PolyCount += int(tile.Header.PolyCount)
+
+		s.TileBytes += int64(unsafe.Sizeof(*tile.Header)) +
+			int64(cap(tile.Polys))*int64(unsafe.Sizeof(Poly{})) +
+			int64(cap(tile.Verts))*4 +
+			int64(cap(tile.OffMeshCons))*int64(unsafe.Sizeof(OffMeshConnection{}))
+		s.LinkBytes += int64(cap(tile.Links)) * int64(unsafe.Sizeof(Link{}))
+		s.BvTreeBytes += int64(cap(tile.BvTree)) * int64(unsafe.Sizeof(BvNode{}))
+		s.DetailBytes += int64(cap(tile.DetailMeshes))*int64(unsafe.Sizeof(PolyDetail{})) +
+			int64(cap(tile.DetailVerts))*4 +
+			int64(cap(tile.DetailTris))
+	}
+	return s
+}
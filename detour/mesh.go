@@ -4,7 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"math"
 	"os"
 	"unsafe"
@@ -60,10 +60,18 @@ type NavMesh struct {
 	saltBits              uint32        // Number of salt bits in the tile ID.
 	tileBits              uint32        // Number of tile bits in the tile ID.
 	polyBits              uint32        // Number of poly bits in the tile ID.
+	refLayout             PolyRefLayout // Bit layout of the references.
+	tileChanges           uint64        // Number of tiles added or removed.
+	upAxis                UpAxis        // Up axis of the navmesh data.
+	memory                MemoryFlags   // Memory saving options of the tiles.
 }
 
 // Decode reads a tiled navigation mesh from r and returns it.
 //
+// The reference layout of the navmesh, 32 or 64 bits, is detected from the
+// data. Between the 64 bits layouts, PolyRefLayout64 is chosen, unless the
+// tile references are only valid with PolyRefLayoutTrinityCore.
+//
 // returned error will be different from nil in case of failure.
 func Decode(r io.Reader) (*NavMesh, error) {
 	// Read header.
@@ -85,37 +93,43 @@ func Decode(r io.Reader) (*NavMesh, error) {
 		return nil, fmt.Errorf("wrong version: %d", hdr.Version)
 	}
 
+	// The tiles layout depends on the references size, read them all
+	// before detecting it.
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := detectSetLayout(&hdr, data)
+	if err != nil {
+		return nil, err
+	}
+
 	var mesh NavMesh
-	status := mesh.Init(&hdr.Params)
+	status := mesh.InitWithLayout(&hdr.Params, layout)
 	if StatusFailed(status) {
 		return nil, fmt.Errorf("status failed 0x%x", status)
 	}
 
 	// Read tiles.
+	var off int
 	for i := uint32(0); i < hdr.NumTiles; i++ {
-
-		var (
-			tileHdr navMeshTileHeader
-			err     error
-		)
-		err = binary.Read(r, binary.LittleEndian, &tileHdr)
-		if err != nil {
-			return nil, err
+		var tileHdr navMeshTileHeader
+		if len(data)-off < tileHdr.size(layout) {
+			return nil, io.ErrUnexpectedEOF
 		}
+		tileHdr.unserialize(data[off:], layout)
+		off += tileHdr.size(layout)
 
 		if tileHdr.TileRef == 0 || tileHdr.DataSize == 0 {
 			break
 		}
-
-		data := make([]byte, tileHdr.DataSize)
-		if data == nil {
-			break
-		}
-		_, err = r.Read(data)
-		if err != nil {
-			return nil, err
+		if tileHdr.DataSize < 0 || int(tileHdr.DataSize) > len(data)-off {
+			return nil, io.ErrUnexpectedEOF
 		}
-		status, _ := mesh.AddTile(data, tileHdr.TileRef)
+
+		tileData := data[off : off+int(tileHdr.DataSize)]
+		off += int(tileHdr.DataSize)
+		status, _ := mesh.AddTile(tileData, tileHdr.TileRef)
 		if status&Failure != 0 {
 			return nil, fmt.Errorf("couldn't add tile %d(), status: 0x%x", i, status)
 		}
@@ -123,7 +137,58 @@ func Decode(r io.Reader) (*NavMesh, error) {
 	return &mesh, nil
 }
 
+// detectSetLayout detects the reference layout of the tiles of a navmesh set,
+// data being the tiles, as they follow the set header.
+func detectSetLayout(hdr *navMeshSetHeader, data []byte) (PolyRefLayout, error) {
+	if hdr.NumTiles == 0 {
+		return PolyRefLayout32, nil
+	}
+
+	// The tile data, which starts with the tile magic, follows the tile
+	// header which size depends on the references size.
+	little := binary.LittleEndian
+	isTileAt := func(off int) bool {
+		return len(data) >= off+4 && int32(little.Uint32(data[off:])) == navMeshMagic
+	}
+	var th navMeshTileHeader
+	switch {
+	case isTileAt(th.size(PolyRefLayout32)):
+		return PolyRefLayout32, nil
+	case !isTileAt(th.size(PolyRefLayout64)):
+		return 0, fmt.Errorf("unknown tile format")
+	}
+
+	// Both 64 bits layouts have the same format, keep the first one for
+	// which all tile references are valid.
+	for _, layout := range []PolyRefLayout{PolyRefLayout64, PolyRefLayoutTrinityCore} {
+		saltBits, tileBits, polyBits, ok := layout.bits(&hdr.Params)
+		if !ok {
+			continue
+		}
+		valid := true
+		for i, off := uint32(0), 0; valid && i < hdr.NumTiles && len(data)-off >= th.size(layout); i++ {
+			th.unserialize(data[off:], layout)
+			if th.TileRef == 0 || th.DataSize <= 0 {
+				break
+			}
+			ref := uint64(th.TileRef)
+			salt := (ref >> (polyBits + tileBits)) & (1<<saltBits - 1)
+			it := (ref >> polyBits) & (1<<tileBits - 1)
+			ip := ref & (1<<polyBits - 1)
+			valid = salt != 0 && it < uint64(hdr.Params.MaxTiles) && ip == 0
+			off += th.size(layout) + int(th.DataSize)
+		}
+		if valid {
+			return layout, nil
+		}
+	}
+	return PolyRefLayout64, nil
+}
+
 // SaveToFile saves the navigation mesh as a binary file.
+//
+// The file is written in the reference layout of the navmesh, see
+// InitWithLayout.
 func (m *NavMesh) SaveToFile(fn string) error {
 	f, err := os.Create(fn)
 	if err != nil {
@@ -147,31 +212,40 @@ func (m *NavMesh) SaveToFile(fn string) error {
 		return fmt.Errorf("Error writing header: %v", err)
 	}
 
-	// Store tiles.
+	// Store tiles, in the navmesh reference layout.
+	linkSize := m.refLayout.linkSize()
 	for i := int32(0); i < m.MaxTiles; i++ {
 		tile := &m.Tiles[i]
 		if tile.DataSize == 0 {
 			continue
 		}
 
+		size, _ := tile.Header.dataSize(int64(linkSize))
 		var tileHeader navMeshTileHeader
 		tileHeader.TileRef = m.TileRef(tile)
-		tileHeader.DataSize = tile.DataSize
-		if _, err = tileHeader.WriteTo(f); err != nil {
+		tileHeader.DataSize = int32(size)
+		if _, err = tileHeader.writeTo(f, m.refLayout); err != nil {
 			return err
 		}
-		var data []byte = make([]byte, tile.DataSize)
-		// first Serialize the tile header
-		tile.Header.serialize(data)
-		// then the tile itself
-		tile.serialize(data[tile.Header.size():])
-		if _, err = f.Write(data); err != nil {
+		if _, err = f.Write(m.tileData(tile)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// tileData serializes a tile in the navmesh reference layout.
+func (m *NavMesh) tileData(tile *MeshTile) []byte {
+	linkSize := m.refLayout.linkSize()
+	size, _ := tile.Header.dataSize(int64(linkSize))
+	data := make([]byte, size)
+	// first Serialize the tile header
+	tile.Header.serialize(data)
+	// then the tile itself
+	tile.serialize(data[tile.Header.size():], linkSize)
+	return data
+}
+
 // InitForSingleTile set up the navigation mesh for single tile use.
 //
 //  Arguments:
@@ -182,6 +256,20 @@ func (m *NavMesh) SaveToFile(fn string) error {
 // Return The status flags for the operation.
 //  see CreateNavMeshData
 func (m *NavMesh) InitForSingleTile(data []uint8, flags int) Status {
+	return m.InitForSingleTileWithLayout(data, flags, PolyRefLayoutTrinityCore)
+}
+
+// InitForSingleTileWithLayout set up the navigation mesh for single tile use,
+// with the specified reference layout.
+//
+//  Arguments:
+//   data     Data of the new tile. (See: CreateNavMeshData)
+//   flags    The tile flags. (See: TileFlags)
+//   layout   The bit layout of the polygon and tile references.
+//
+// Return The status flags for the operation.
+//  see InitForSingleTile, InitWithLayout
+func (m *NavMesh) InitForSingleTileWithLayout(data []uint8, flags int, layout PolyRefLayout) Status {
 	var header MeshHeader
 	header.unserialize(data)
 
@@ -200,7 +288,7 @@ func (m *NavMesh) InitForSingleTile(data []uint8, flags int) Status {
 	params.MaxTiles = 1
 	params.MaxPolys = uint32(header.PolyCount)
 
-	status := m.Init(&params)
+	status := m.InitWithLayout(&params, layout)
 	if StatusFailed(status) {
 		return status
 	}
@@ -209,13 +297,38 @@ func (m *NavMesh) InitForSingleTile(data []uint8, flags int) Status {
 	return status
 }
 
-// Init initializes the navigation mesh for tiled use.
+// Init initializes the navigation mesh for tiled use, with the TrinityCore
+// 64 bits references.
 //
 //  Arguments:
 //   params  Initialization parameters.
 //
 // Return the status flags for the operation.
+//
+// see InitWithLayout
 func (m *NavMesh) Init(params *NavMeshParams) Status {
+	return m.InitWithLayout(params, PolyRefLayoutTrinityCore)
+}
+
+// InitWithLayout initializes the navigation mesh for tiled use, with the
+// specified reference layout.
+//
+//  Arguments:
+//   params  Initialization parameters.
+//   layout  The bit layout of the polygon and tile references.
+//
+// Return the status flags for the operation.
+//
+// The layout must be the one of the tiles added to the navmesh, with their
+// references, such as the ones passed to AddTile, or stored by the
+// application. Failure|InvalidParam is returned if params do not fit in the
+// layout.
+func (m *NavMesh) InitWithLayout(params *NavMeshParams, layout PolyRefLayout) Status {
+	saltBits, tileBits, polyBits, ok := layout.bits(params)
+	if !ok {
+		return Failure | InvalidParam
+	}
+
 	m.Params = *params
 	m.Orig = d3.NewVec3From(params.Orig[0:3])
 	m.TileWidth = params.TileWidth
@@ -239,22 +352,10 @@ func (m *NavMesh) Init(params *NavMeshParams) Status {
 	}
 
 	// Init ID generator values.
-	// m.tileBits = math32.Ilog2(math32.NextPow2(uint32(params.MaxTiles)))
-	// m.polyBits = math32.Ilog2(math32.NextPow2(uint32(params.MaxPolys)))
-	// // Only allow 31 salt bits, since the salt mask is calculated using 32bit uint and it will overflow.
-	// if 31 < 32-m.tileBits-m.polyBits {
-	// 	m.saltBits = 31
-	// } else {
-	// 	m.saltBits = 32 - m.tileBits - m.polyBits
-	// }
-
-	// if m.saltBits < 10 {
-	// 	return Status(Failure | InvalidParam)
-	// }
-
-	m.polyBits = 31
-	m.tileBits = 21
-	m.saltBits = 12
+	m.refLayout = layout
+	m.saltBits = saltBits
+	m.tileBits = tileBits
+	m.polyBits = polyBits
 
 	return Success
 }
@@ -263,7 +364,6 @@ func (m *NavMesh) Init(params *NavMeshParams) Status {
 //
 //  Arguments:
 //   data      Data for the new tile mesh. (See: CreateNavMeshData)
-//   flags     Tile flags. (See: tileFlags)
 //   lastRef   The desired reference for the tile. (When reloading a tile.)
 //             optional, defaults to 0
 //
@@ -273,19 +373,49 @@ func (m *NavMesh) Init(params *NavMeshParams) Status {
 // The add operation will fail if the data is in the wrong format, the allocated tile
 // space is full, or there is a tile already at the specified reference.
 //
+// If a tile is already loaded at the x, y and layer of the new tile, the
+// existing tile is left untouched and the returned status is
+// Failure|AlreadyOccupied. Use ReplaceTile to overwrite it instead.
+//
 // The lastRef parameter is used to restore a tile with the same tile reference
 // it had previously used. In this case the PolyRef's for the tile will be
 // restored to the same values they were before the tile was
 // removed.
 //
+// The new tile is connected, with polygon and off-mesh connection links, to
+// the tiles already present at the same grid location and at the 8
+// neighbour locations, and these tiles are connected back to it, so tiles can
+// be added in any order.
+//
+// Portal edges are linked to the portal edges of the tiles on the other side
+// which overlap them, horizontally and within WalkableClimb vertically,
+// whatever the tile layers. Stacked layers, such as a cave under the
+// surface, are then joined through the tiles connecting them, a ramp in a
+// neighbour tile for example, and paths go from one layer to the other.
+//
+// The links of a tile are allocated from a pool of MeshHeader.MaxLinkCount
+// links, sized by CreateNavMeshData for one link per polygon edge, plus two
+// per portal edge and per off-mesh connection end. A portal edge may however
+// overlap up to 4 polygons of a neighbour tile, so the pool of a densely
+// connected tile can be too small. It then grows, in this tile and in its
+// neighbours, so that no link is dropped and MaxLinkCount is updated
+// accordingly. The capacity of CreateNavMeshData is enough for most tiles,
+// the growth only costs an allocation on the others.
+//
+// The BV-tree of the tile is dropped and the link pools are compacted
+// according to the memory options of the navmesh. (See SetMemoryFlags)
+//
 // The nav mesh assumes exclusive access to the data passed and will make
 // changes to the dynamic portion of the data. For that reason the data should
 // not be reused in other nav meshes until the tile has been successfully
 // removed from this nav mesh.
 //
-// see CreateNavMeshData, removeTileBvTree
+// see CreateNavMeshData, removeTileBvTree, ReplaceTile
 func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 	var hdr MeshHeader
+	if len(data) < hdr.size() {
+		return Failure | InvalidParam, 0
+	}
 	hdr.unserialize(data)
 
 	// Make sure the data is in right format.
@@ -296,9 +426,34 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 		return Failure | WrongVersion, 0
 	}
 
+	// Make sure the data holds everything the header declares, and that
+	// the tile does not index out of its own data.
+	// Links are rebuilt once the tile is added, only their size matters,
+	// tiles in the other format, such as the ones created by
+	// CreateNavMeshData, are also accepted.
+	linkSize := m.refLayout.linkSize()
+	size, ok := hdr.dataSize(int64(linkSize))
+	if other := link32Size + link64Size - linkSize; ok && int64(len(data)) != size {
+		if osize, _ := hdr.dataSize(int64(other)); int64(len(data)) == osize {
+			linkSize, size = other, osize
+		}
+	}
+	if !ok || int64(len(data)) < size {
+		return Failure | InvalidParam, 0
+	}
+	var parsed MeshTile
+	parsed.unserialize(&hdr, data[hdr.size():], linkSize)
+	parsed.Header = &hdr
+	if errs := m.validateTile(-1, &parsed, false); len(errs) != 0 {
+		return Failure | InvalidParam, 0
+	}
+	if m.memory&MemoryNoBvTree != 0 {
+		dropBvTree(&parsed)
+	}
+
 	// Make sure the location is free.
 	if m.TileAt(hdr.X, hdr.Y, hdr.Layer) != nil {
-		return Failure, 0
+		return Failure | AlreadyOccupied, 0
 	}
 
 	// Allocate a tile.
@@ -313,7 +468,6 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 		// Try to relocate the tile to specific index with same salt.
 		tileIndex := int32(m.decodePolyIDTile(PolyRef(lastRef)))
 		if tileIndex >= m.MaxTiles {
-			log.Fatalln("tileIndex >= m.m_maxTiles", tileIndex, m.MaxTiles)
 			return Failure | OutOfMemory, 0
 		}
 		// Try to find the specific tile id from the free list.
@@ -326,7 +480,6 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 		}
 		// Could not find the correct location.
 		if tile != target {
-			log.Fatalln("couldn't find the correct tile location")
 			return Failure | OutOfMemory, 0
 		}
 		// Remove from freelist
@@ -342,7 +495,6 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 
 	// Make sure we could allocate a tile.
 	if tile == nil {
-		log.Fatalln("couldn't allocate tile")
 		return Failure | OutOfMemory, 0
 	}
 
@@ -351,7 +503,14 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 	tile.Next = m.posLookup[h]
 	m.posLookup[h] = tile
 
-	tile.unserialize(&hdr, data[hdr.size():])
+	tile.Verts = parsed.Verts
+	tile.Polys = parsed.Polys
+	tile.Links = parsed.Links
+	tile.DetailMeshes = parsed.DetailMeshes
+	tile.DetailVerts = parsed.DetailVerts
+	tile.DetailTris = parsed.DetailTris
+	tile.BvTree = parsed.BvTree
+	tile.OffMeshCons = parsed.OffMeshCons
 
 	// If there are no items in the bvtree, reset the tree pointer.
 	if len(tile.BvTree) == 0 {
@@ -359,8 +518,11 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 	}
 
 	// Build links freelist
-	tile.LinksFreeList = 0
-	tile.Links[hdr.MaxLinkCount-1].Next = nullLink
+	tile.LinksFreeList = nullLink
+	if hdr.MaxLinkCount > 0 {
+		tile.LinksFreeList = 0
+		tile.Links[hdr.MaxLinkCount-1].Next = nullLink
+	}
 
 	var i int32
 	for ; i < hdr.MaxLinkCount-1; i++ {
@@ -409,9 +571,127 @@ func (m *NavMesh) AddTile(data []byte, lastRef TileRef) (Status, TileRef) {
 		}
 	}
 
+	if m.memory&MemoryCompactLinks != 0 {
+		// The links to the tile have been allocated in the pools of its
+		// neighbours.
+		compactLinks(tile)
+		nneis = m.TilesAt(hdr.X, hdr.Y, neis, maxNeis)
+		for j = 0; j < nneis; j++ {
+			compactLinks(neis[j])
+		}
+		for i = 0; i < 8; i++ {
+			nneis = m.neighbourTilesAt(hdr.X, hdr.Y, i, neis, maxNeis)
+			for j = 0; j < nneis; j++ {
+				compactLinks(neis[j])
+			}
+		}
+	}
+
+	m.tileChanges++
 	return Success, m.TileRef(tile)
 }
 
+// ReplaceTile adds a tile to the navigation mesh, removing the tile that may
+// already be loaded at the same x, y and layer.
+//
+//  Arguments:
+//   data      Data for the new tile mesh. (See: CreateNavMeshData)
+//
+//  Return values:
+//   st        The status flags for the operation.
+//   ref       The reference of the new tile. (If it was successfully added.)
+//   old       The data of the replaced tile, or nil if the location was free.
+//
+// If the new tile can not be added, the replaced tile is restored with its
+// previous reference and old is nil.
+//
+// see AddTile
+func (m *NavMesh) ReplaceTile(data []byte) (st Status, ref TileRef, old []byte) {
+	var hdr MeshHeader
+	if len(data) < hdr.size() {
+		return Failure | InvalidParam, 0, nil
+	}
+	hdr.unserialize(data)
+
+	oldRef := m.TileRefAt(hdr.X, hdr.Y, hdr.Layer)
+	var oldData []byte
+	if oldRef != 0 {
+		// RemoveTile doesn't keep the tile data, serialize it beforehand.
+		oldData = m.tileData(m.TileByRef(oldRef))
+		if _, st = m.RemoveTile(oldRef); StatusFailed(st) {
+			return st, 0, nil
+		}
+	}
+	if st, ref = m.AddTile(data, 0); StatusFailed(st) {
+		if oldData != nil {
+			m.AddTile(oldData, oldRef)
+		}
+		return st, 0, nil
+	}
+	return st, ref, oldData
+}
+
+// MergeTilesFrom adds the tiles of another navmesh to m.
+//
+//  Arguments:
+//   other     The navmesh which tiles are added to m. It is not modified.
+//
+//  Return values:
+//   added     The number of tiles added.
+//   status    The status flags for the operation.
+//
+// Both navmeshes must share the same tile grid, that is the same origin and
+// tile dimensions, and the tiles of other must not have more polygons than
+// the tiles of m can hold, otherwise the merge fails with InvalidParam. The
+// merge fails with AlreadyOccupied if a tile location of other is used in m,
+// and with OutOfMemory if m does not have enough free tiles. In these cases
+// no tile is added.
+//
+// The added tiles are connected to the tiles of m, including with the
+// off-mesh connections joining them, so that paths can go from the tiles of
+// a navmesh to the other. The tile references of other are not preserved.
+//
+// see AddTile
+func (m *NavMesh) MergeTilesFrom(other *NavMesh) (added int, status Status) {
+	if other == nil ||
+		m.Params.Orig != other.Params.Orig ||
+		m.TileWidth != other.TileWidth || m.TileHeight != other.TileHeight ||
+		other.Params.MaxPolys > 1<<m.polyBits {
+		return 0, Failure | InvalidParam
+	}
+
+	// Check everything fits before modifying m.
+	var free int
+	for tile := m.nextFree; tile != nil; tile = tile.Next {
+		free++
+	}
+	var tiles []*MeshTile
+	for i := range other.Tiles {
+		tile := &other.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		if m.TileAt(tile.Header.X, tile.Header.Y, tile.Header.Layer) != nil {
+			return 0, Failure | AlreadyOccupied
+		}
+		if int(tile.Header.PolyCount) > 1<<m.polyBits {
+			return 0, Failure | InvalidParam
+		}
+		tiles = append(tiles, tile)
+	}
+	if len(tiles) > free {
+		return 0, Failure | OutOfMemory
+	}
+
+	for _, tile := range tiles {
+		if st, _ := m.AddTile(other.tileData(tile), 0); StatusFailed(st) {
+			return added, st
+		}
+		added++
+	}
+	return added, Success
+}
+
 // Removes the specified tile from the navigation mesh.
 //
 //  Arguments:
@@ -489,6 +769,7 @@ func (m *NavMesh) RemoveTile(ref TileRef) (data []uint8, st Status) {
 
 	tile.Header = nil
 	tile.Flags = 0
+	tile.DataSize = 0
 	tile.LinksFreeList = 0
 	tile.Polys = nil
 	tile.Verts = nil
@@ -509,6 +790,7 @@ func (m *NavMesh) RemoveTile(ref TileRef) (data []uint8, st Status) {
 	tile.Next = m.nextFree
 	m.nextFree = tile
 
+	m.tileChanges++
 	return data, Success
 }
 
@@ -612,15 +894,39 @@ func computeTileHash(x, y, mask int32) int32 {
 	return int32(n) & mask
 }
 
+// allocLink allocates a link in the tile link pool, growing the pool if it
+// is full.
 func allocLink(tile *MeshTile) uint32 {
 	if tile.LinksFreeList == nullLink {
-		return nullLink
+		growLinks(tile)
 	}
 	link := tile.LinksFreeList
 	tile.LinksFreeList = tile.Links[link].Next
 	return link
 }
 
+// growLinks grows the link pool of tile by half its size, at least by 4
+// links, and adds the new links to its free list.
+//
+// The link pool is sized at build time from the polygon edges. It may be too
+// small on densely connected tiles, where polygon edges lying on the tile
+// border touch many polygons of the neighbour tiles. Growing it rather than
+// dropping the links keeps the navmesh fully connected.
+func growLinks(tile *MeshTile) {
+	n := len(tile.Links)
+	grow := n / 2
+	if grow < 4 {
+		grow = 4
+	}
+	tile.Links = append(tile.Links, make([]Link, grow)...)
+	for i := n; i < n+grow-1; i++ {
+		tile.Links[i].Next = uint32(i + 1)
+	}
+	tile.Links[n+grow-1].Next = tile.LinksFreeList
+	tile.LinksFreeList = uint32(n)
+	tile.Header.MaxLinkCount = int32(len(tile.Links))
+}
+
 func freeLink(tile *MeshTile, link uint32) {
 	tile.Links[link].Next = tile.LinksFreeList
 	tile.LinksFreeList = link
@@ -886,7 +1192,7 @@ func (m *NavMesh) queryPolygonsInTile(
 			bmin, bmax      [3]uint16
 		)
 		nodeIdx = 0
-		endIdx = tile.Header.BvNodeCount
+		endIdx = tile.bvNodeCount()
 
 		tbmin = d3.NewVec3From(tile.Header.BMin[:])
 		tbmax = d3.NewVec3From(tile.Header.BMax[:])
@@ -918,8 +1224,8 @@ func (m *NavMesh) queryPolygonsInTile(
 
 			if isLeafNode && overlap {
 				if n < maxPolys {
-					n++
 					polys[n] = base | PolyRef(node.I)
+					n++
 				}
 			}
 
@@ -947,19 +1253,19 @@ func (m *NavMesh) queryPolygonsInTile(
 			continue
 		}
 		// Calc polygon bounds.
-		v := tile.Verts[p.Verts[0]*3 : 3]
+		v := tile.Verts[p.Verts[0]*3 : p.Verts[0]*3+3]
 		d3.Vec3(bmin[:]).Assign(v)
 		d3.Vec3(bmax[:]).Assign(v)
 		var j uint8
 		for j = 1; j < p.VertCount; j++ {
-			v = tile.Verts[p.Verts[j]*3 : 3]
+			v = tile.Verts[p.Verts[j]*3 : p.Verts[j]*3+3]
 			d3.Vec3Min(bmin[:], v)
 			d3.Vec3Max(bmax[:], v)
 		}
 		if OverlapBounds(qmin, qmax, bmin[:], bmax[:]) {
 			if n < maxPolys {
-				n++
 				polys[n] = base | PolyRef(i)
+				n++
 			}
 		}
 	}
@@ -982,6 +1288,11 @@ func (m *NavMesh) decodePolyIDPoly(ref PolyRef) uint32 {
 //   [in] pos          The position to check. [(x, y, z)]
 //   [out]closest      The closest point on the polygon. [(x, y, z)]
 //   [out]posOverPoly  True of the position is over the polygon.
+//
+// If pos is over the polygon, closest is pos at the height of the detail
+// mesh. Otherwise closest is the nearest point of the detail mesh boundary
+// edges, so that its height follows the detail mesh along sloped polygon
+// boundaries.
 func (m *NavMesh) closestPointOnPoly(ref PolyRef, pos, closest d3.Vec3, posOverPoly *bool) {
 	var (
 		tile *MeshTile
@@ -990,88 +1301,240 @@ func (m *NavMesh) closestPointOnPoly(ref PolyRef, pos, closest d3.Vec3, posOverP
 
 	m.TileAndPolyByRefUnsafe(ref, &tile, &poly)
 
+	closest.Assign(pos)
+	if h, ok := polyHeight(tile, poly, pos, m.upAxis); ok {
+		closest[m.upAxis.index()] = h
+		if posOverPoly != nil {
+			*posOverPoly = true
+		}
+		return
+	}
+	if posOverPoly != nil {
+		*posOverPoly = false
+	}
+
 	// Off-mesh connections don't have detail polygons.
 	if poly.Type() == polyTypeOffMeshConnection {
 		var (
 			v0, v1    d3.Vec3
 			d0, d1, u float32
 		)
-		v0 = tile.Verts[poly.Verts[0]*3 : 3]
-		v1 = tile.Verts[poly.Verts[1]*3 : 3]
+		v0 = tile.Verts[poly.Verts[0]*3 : poly.Verts[0]*3+3]
+		v1 = tile.Verts[poly.Verts[1]*3 : poly.Verts[1]*3+3]
 		d0 = pos.Dist(v0)
 		d1 = pos.Dist(v1)
-		u = d0 / (d0 + d1)
-		closest = v0.Lerp(v1, u)
-		if posOverPoly != nil {
-			*posOverPoly = false
+		// Both distances are null for a zero-length connection.
+		if d0+d1 > 0 {
+			u = d0 / (d0 + d1)
 		}
+		d3.Vec3Lerp(closest, v0, v1, u)
 		return
 	}
 
-	ip := (uintptr(unsafe.Pointer(poly)) - uintptr(unsafe.Pointer(&tile.Polys[0]))) / unsafe.Sizeof(*poly)
-	pd := &tile.DetailMeshes[uint32(ip)]
+	// Outside poly that is not an off-mesh connection.
+	closestPointOnDetailEdges(tile, poly, pos, closest, true, m.upAxis)
+}
 
-	// Clamp point to be inside the polygon.
-	verts := make([]float32, VertsPerPolygon*3)
-	edged := make([]float32, VertsPerPolygon)
-	edget := make([]float32, VertsPerPolygon)
-	nv := poly.VertCount
-	var i uint8
-	for i = 0; i < nv; i++ {
-		idx := i * 3
-		jdx := poly.Verts[i] * 3
-		copy(verts[idx:], tile.Verts[jdx:jdx+3])
+// polyHeight returns the height of the detail mesh of poly at the location
+// of pos on the horizontal plane of the up axis, or of the polygon
+// triangulation if it has no detail mesh. ok is false if pos is not over the
+// polygon or if the polygon is an off-mesh connection.
+func polyHeight(tile *MeshTile, poly *Poly, pos d3.Vec3, up UpAxis) (h float32, ok bool) {
+	// Off-mesh connections do not have detail polys and getting height
+	// over them does not make sense.
+	if poly.Type() == polyTypeOffMeshConnection {
+		return 0, false
 	}
 
-	closest.Assign(pos)
-	if !distancePtPolyEdgesSqr(pos, verts, int32(nv), edged, edget) {
-		// Point is outside the polygon, clamp to nearest edge.
-		dmin := edged[0]
-		var imin uint8
-		for i = 1; i < nv; i++ {
-			if edged[i] < dmin {
-				dmin = edged[i]
-				imin = i
+	var verts [VertsPerPolygon * 3]float32
+	nv := int32(poly.VertCount)
+	for i := int32(0); i < nv; i++ {
+		idx := poly.Verts[i] * 3
+		up.yUp(verts[i*3:i*3+3], tile.Verts[idx:idx+3])
+	}
+	var pbuf [3]float32
+	p := d3.Vec3(pbuf[:])
+	up.yUp(p, pos)
+	if !pointInPolygon(p, verts[:], nv) {
+		return 0, false
+	}
+
+	// Find height at the location.
+	pd := polyDetail(tile, poly)
+	if pd != nil {
+		var (
+			buf [9]float32
+			tri = [3]d3.Vec3{buf[0:3], buf[3:6], buf[6:9]}
+		)
+		for j := uint32(0); j < uint32(pd.TriCount); j++ {
+			var v [3]d3.Vec3
+			detailTriVerts(tile, poly, pd, j, &v)
+			for k := range v {
+				up.yUp(tri[k], v[k])
+			}
+			if closestHeightPointTriangle(p, tri[0], tri[1], tri[2], &h) {
+				return h, true
 			}
 		}
-		va := d3.NewVec3From(verts[imin*3 : imin*3+3])
-		vidx := ((imin + 1) % nv) * 3
-		vb := d3.NewVec3From(verts[vidx : vidx+3])
-		closest = va.Lerp(vb, edget[imin])
+	} else {
+		// No detail mesh, use the polygon triangulation.
+		for j := int32(2); j < nv; j++ {
+			if closestHeightPointTriangle(p, verts[0:3], verts[(j-1)*3:j*3], verts[j*3:j*3+3], &h) {
+				return h, true
+			}
+		}
+	}
 
-		if posOverPoly != nil {
-			*posOverPoly = false
+	// If all triangle checks failed above (can happen with degenerate
+	// triangles or larger floating point values) the point is on an edge, so
+	// just select closest.
+	closest := d3.NewVec3From(pos)
+	closestPointOnDetailEdges(tile, poly, pos, closest, false, up)
+	return closest[up.index()], true
+}
+
+// polyDetail returns the detail mesh of poly, or nil if it has none.
+//
+// Tiles built by CreateNavMeshData always have detail meshes, made of the
+// polygon triangulation if none was provided, but tiles of other origins may
+// have no detail meshes, or empty ones. Height queries then fall back to the
+// polygon plane.
+func polyDetail(tile *MeshTile, poly *Poly) *PolyDetail {
+	ip := (uintptr(unsafe.Pointer(poly)) - uintptr(unsafe.Pointer(&tile.Polys[0]))) / unsafe.Sizeof(*poly)
+	if int(ip) >= len(tile.DetailMeshes) || tile.DetailMeshes[ip].TriCount == 0 {
+		return nil
+	}
+	return &tile.DetailMeshes[ip]
+}
+
+// detailTriVerts sets v to the vertices of the j-th triangle of the detail
+// mesh pd of poly.
+func detailTriVerts(tile *MeshTile, poly *Poly, pd *PolyDetail, j uint32, v *[3]d3.Vec3) {
+	t := tile.DetailTris[(pd.TriBase+j)*4:]
+	for k := 0; k < 3; k++ {
+		if t[k] < poly.VertCount {
+			idx := uint32(poly.Verts[t[k]]) * 3
+			v[k] = tile.Verts[idx : idx+3]
+		} else {
+			idx := (pd.VertBase + uint32(t[k]-poly.VertCount)) * 3
+			v[k] = tile.DetailVerts[idx : idx+3]
 		}
-	} else {
-		if posOverPoly != nil {
-			*posOverPoly = true
+	}
+}
+
+// closestPointOnDetailEdges sets closest to the point of the detail mesh
+// edges of poly that is the closest to pos, on the horizontal plane of the up
+// axis. If onlyBoundary is true, only the detail edges lying on the polygon
+// boundary are considered.
+//
+// Detail meshes which triangles have no edge flags have no boundary edges,
+// in which case, if onlyBoundary is true, closest is set to the nearest point
+// of the polygon edges, at the height of the detail mesh, if possible. If the
+// polygon has no detail mesh, closest is the nearest point of its edges.
+func closestPointOnDetailEdges(tile *MeshTile, poly *Poly, pos, closest d3.Vec3, onlyBoundary bool, up UpAxis) {
+	const anyBoundaryEdge = detailEdgeBoundary<<0 | detailEdgeBoundary<<2 | detailEdgeBoundary<<4
+
+	pd := polyDetail(tile, poly)
+	if pd == nil {
+		closestPointOnPolyEdges(tile, poly, nil, pos, closest, up)
+		return
+	}
+
+	var (
+		dmin       = float32(math.MaxFloat32)
+		tmin       float32
+		found      bool
+		buf        [12]float32
+		p          = d3.Vec3(buf[0:3])
+		tri        = [3]d3.Vec3{buf[3:6], buf[6:9], buf[9:12]}
+		pmin, pmax [3]float32
+	)
+	up.yUp(p, pos)
+	for i := uint32(0); i < uint32(pd.TriCount); i++ {
+		tris := tile.DetailTris[(pd.TriBase+i)*4:]
+		if onlyBoundary && tris[3]&anyBoundaryEdge == 0 {
+			continue
+		}
+
+		var v [3]d3.Vec3
+		detailTriVerts(tile, poly, pd, i, &v)
+		for k := range v {
+			up.yUp(tri[k], v[k])
+		}
+		for k, j := 0, 2; k < 3; j, k = k, k+1 {
+			if detailTriEdgeFlags(tris[3], j)&detailEdgeBoundary == 0 &&
+				(onlyBoundary || tris[j] < tris[k]) {
+				// Only looking at boundary edges and this is internal, or
+				// this is an inner edge that we will see again or have
+				// already seen.
+				continue
+			}
+
+			var t float32
+			if d := distancePtSegSqr2D(p, tri[j], tri[k], &t); d < dmin {
+				dmin = d
+				tmin = t
+				found = true
+				copy(pmin[:], tri[j])
+				copy(pmax[:], tri[k])
+			}
 		}
 	}
 
-	// Find height at the location.
-	var j uint8
-	for j = 0; j < pd.TriCount; j++ {
-		vidx := (pd.TriBase + uint32(j)) * 4
-		t := tile.DetailTris[vidx : vidx+3]
+	if found {
+		d3.Vec3Lerp(closest, pmin[:], pmax[:], tmin)
+		up.yUp(closest, closest)
+		return
+	}
+	closestPointOnPolyEdges(tile, poly, pd, pos, closest, up)
+}
+
+// closestPointOnPolyEdges sets closest to the point of the edges of poly that
+// is the closest to pos, on the horizontal plane of the up axis, at the
+// height of the detail mesh pd, or of the polygon edge if pd is nil.
+func closestPointOnPolyEdges(tile *MeshTile, poly *Poly, pd *PolyDetail, pos, closest d3.Vec3, up UpAxis) {
+	var (
+		verts        [VertsPerPolygon * 3]float32
+		edged, edget [VertsPerPolygon]float32
+		pbuf         [3]float32
+		p            = d3.Vec3(pbuf[:])
+	)
+	nv := poly.VertCount
+	for i := uint8(0); i < nv; i++ {
+		idx := poly.Verts[i] * 3
+		up.yUp(verts[i*3:i*3+3], tile.Verts[idx:idx+3])
+	}
+	up.yUp(p, pos)
+	distancePtPolyEdgesSqr(p, verts[:], int32(nv), edged[:], edget[:])
+	var imin uint8
+	for i := uint8(1); i < nv; i++ {
+		if edged[i] < edged[imin] {
+			imin = i
+		}
+	}
+	va := d3.Vec3(verts[imin*3 : imin*3+3])
+	vidx := ((imin + 1) % nv) * 3
+	vb := d3.Vec3(verts[vidx : vidx+3])
+	d3.Vec3Lerp(closest, va, vb, edget[imin])
+	if pd != nil {
 		var (
-			v [3]d3.Vec3
-			k int
+			buf [9]float32
+			tri = [3]d3.Vec3{buf[0:3], buf[3:6], buf[6:9]}
 		)
-		for k = 0; k < 3; k++ {
-			if t[k] < poly.VertCount {
-				vidx := poly.Verts[t[k]] * 3
-				v[k] = tile.Verts[vidx : vidx+3]
-			} else {
-				vidx := (pd.VertBase + uint32(t[k]-poly.VertCount)) * 3
-				v[k] = tile.DetailVerts[vidx : vidx+3]
+		for j := uint32(0); j < uint32(pd.TriCount); j++ {
+			var v [3]d3.Vec3
+			detailTriVerts(tile, poly, pd, j, &v)
+			for k := range v {
+				up.yUp(tri[k], v[k])
+			}
+			var h float32
+			if closestHeightPointTriangle(closest, tri[0], tri[1], tri[2], &h) {
+				closest[1] = h
+				break
 			}
-		}
-		var h float32
-		if closestHeightPointTriangle(closest, v[0], v[1], v[2], &h) {
-			closest[1] = h
-			break
 		}
 	}
+	up.yUp(closest, closest)
 }
 
 // TileAndPolyByRefUnsafe returns the tile and polygon for the specified polygon
@@ -1494,6 +1957,52 @@ func (m *NavMesh) neighbourTilesAt(x, y, side int32, tiles []*MeshTile, maxTiles
 	return m.TilesAt(nx, ny, tiles, maxTiles)
 }
 
+// TileChanges returns the number of times a tile has been added to, or
+// removed from, the navmesh.
+//
+// It allows to invalidate the data derived from the navmesh, such as cached
+// paths, when tiles change.
+func (m *NavMesh) TileChanges() uint64 {
+	return m.tileChanges
+}
+
+// BuildAgentParams returns the agent parameters the navmesh tiles have been
+// built with.
+//
+//  Return values:
+//   radius  The agent radius. [Unit: wu]
+//   height  The agent height. [Unit: wu]
+//   climb   The agent maximum traversable ledge. [Unit: wu]
+//   ok      False if no tile is loaded, or if the tiles do not record the
+//           parameters.
+//
+// The parameters are the ones given to CreateNavMeshData, stored in each tile
+// header. If tiles have been built with different parameters, the smallest
+// ones are returned, as they are the ones supported by the whole navmesh.
+//
+// This is advisory metadata: queries do not use it to reject agents, but an
+// agent larger than the returned radius or height may get paths clipping
+// walls or going under low ceilings.
+func (m *NavMesh) BuildAgentParams() (radius, height, climb float32, ok bool) {
+	for i := range m.Tiles {
+		hdr := m.Tiles[i].Header
+		if hdr == nil {
+			continue
+		}
+		if !ok {
+			radius, height, climb, ok = hdr.WalkableRadius, hdr.WalkableHeight, hdr.WalkableClimb, true
+			continue
+		}
+		radius = math32.Min(radius, hdr.WalkableRadius)
+		height = math32.Min(height, hdr.WalkableHeight)
+		climb = math32.Min(climb, hdr.WalkableClimb)
+	}
+	if radius == 0 && height == 0 && climb == 0 {
+		ok = false
+	}
+	return radius, height, climb, ok
+}
+
 // TileRefAt returns the tile reference for the tile at specified grid location.
 //
 //  Arguments:
@@ -1564,6 +2073,51 @@ func (m *NavMesh) TileRef(tile *MeshTile) TileRef {
 	return TileRef(m.encodePolyID(tile.Salt, uint32(it), 0))
 }
 
+// TilesAlongPath collects the distinct tiles crossed by a polygon path.
+//
+//  Arguments:
+//   path  The polygon references of the path.
+//   out   The references of the tiles crossed by the path, in path order.
+//
+//  Return values:
+//   The number of tile references written to out.
+//
+// This allows a streaming provider to pin the tiles a path needs. Invalid
+// polygon references, such as the ones of unloaded tiles, are skipped. At
+// most len(out) tiles are collected, the tiles of the remaining polygons are
+// then ignored.
+func (m *NavMesh) TilesAlongPath(path []PolyRef, out []TileRef) int {
+	var n int
+	for _, ref := range path {
+		if n == len(out) {
+			break
+		}
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(m.TileAndPolyByRef(ref, &tile, &poly)) {
+			continue
+		}
+		tref := m.TileRef(tile)
+		found := false
+		// Paths cross few tiles, and their polygons are grouped by tile.
+		for i := n - 1; i >= 0 && !found; i-- {
+			found = out[i] == tref
+		}
+		if !found {
+			out[n] = tref
+			n++
+		}
+	}
+	return n
+}
+
+// PolyRefLayout returns the bit layout of the navmesh references.
+func (m *NavMesh) PolyRefLayout() PolyRefLayout {
+	return m.refLayout
+}
+
 // IsValidPolyRef checks the validity of a polygon reference.
 func (m *NavMesh) IsValidPolyRef(ref PolyRef) bool {
 	if ref == 0 {
@@ -1583,6 +2137,30 @@ func (m *NavMesh) IsValidPolyRef(ref PolyRef) bool {
 	return true
 }
 
+// CheckPolyRef checks the validity of a polygon reference and returns the
+// reason why it is not valid, or nil.
+//
+// ErrTileNotLoaded is returned if the reference points into an empty tile
+// slot (for example, one which tile has been removed), ErrInvalidPolyRef in
+// any other case.
+func (m *NavMesh) CheckPolyRef(ref PolyRef) error {
+	if ref == 0 {
+		return ErrInvalidPolyRef
+	}
+	var salt, it, ip uint32
+	m.DecodePolyID(ref, &salt, &it, &ip)
+	if it >= uint32(m.MaxTiles) {
+		return ErrInvalidPolyRef
+	}
+	if m.Tiles[it].Header == nil {
+		return ErrTileNotLoaded
+	}
+	if m.Tiles[it].Salt != salt || ip >= uint32(m.Tiles[it].Header.PolyCount) {
+		return ErrInvalidPolyRef
+	}
+	return nil
+}
+
 // TileAndPolyByRef returns the tile and polygon for the specified polygon
 // reference.
 //
@@ -1611,6 +2189,432 @@ func (m *NavMesh) TileAndPolyByRef(ref PolyRef, tile **MeshTile, poly **Poly) St
 	return Success
 }
 
+// SetPolyArea sets the user defined area of a polygon.
+//
+//  Arguments:
+//   ref   The reference of the polygon.
+//   area  The new area id of the polygon. [Limit: < 64]
+//
+// Return the status flags for the operation.
+//
+// The queries read the polygon areas as they run, so they see the new area
+// right away. The navmesh must not be modified while queries are running on
+// other goroutines.
+func (m *NavMesh) SetPolyArea(ref PolyRef, area uint8) Status {
+	if int32(area) >= maxAreas {
+		return Failure | InvalidParam
+	}
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if st := m.TileAndPolyByRef(ref, &tile, &poly); StatusFailed(st) {
+		return st
+	}
+	poly.SetArea(area)
+	return Success
+}
+
+// MarkPolyAreaRegion sets the area of all the polygons overlapping a box,
+// for example to flood a region with water at runtime.
+//
+//  Arguments:
+//   bmin  The minimum bounds of the box. [(x, y, z)]
+//   bmax  The maximum bounds of the box. [(x, y, z)]
+//   area  The new area id of the polygons. [Limit: < 64]
+//
+// Returns the number of polygons which area changed.
+//
+// The polygons are the ones which bounding box overlaps the box, as for
+// FindNearestPoly. Off-mesh connections are left untouched. This is
+// SetPolyArea over a spatial query: it reclassifies the polygons without
+// rebuilding their tiles, and the queries see the new areas right away. 0
+// is returned if area is not a valid area id.
+func (m *NavMesh) MarkPolyAreaRegion(bmin, bmax d3.Vec3, area uint8) int {
+	if int32(area) >= maxAreas || len(bmin) != 3 || len(bmax) != 3 {
+		return 0
+	}
+
+	const maxNeis int32 = 32
+	var (
+		neis    [maxNeis]*MeshTile
+		polys   []PolyRef
+		changed int
+	)
+	minx, miny := m.CalcTileLoc(bmin)
+	maxx, maxy := m.CalcTileLoc(bmax)
+	for y := miny; y <= maxy; y++ {
+		for x := minx; x <= maxx; x++ {
+			nneis := m.TilesAt(x, y, neis[:], maxNeis)
+			for _, tile := range neis[:nneis] {
+				if n := int(tile.Header.PolyCount); len(polys) < n {
+					polys = make([]PolyRef, n)
+				}
+				npolys := m.queryPolygonsInTile(tile, bmin, bmax, polys, int32(len(polys)))
+				for _, ref := range polys[:npolys] {
+					poly := &tile.Polys[m.decodePolyIDPoly(ref)]
+					if poly.Area() != area {
+						poly.SetArea(area)
+						changed++
+					}
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// PolyHasFlags reports whether a polygon carries all the flags of a mask.
+//
+//  Arguments:
+//   ref   The reference of the polygon.
+//   mask  The flags to test.
+//
+//  Return values:
+//   ok    True if all the bits of mask are set in the polygon flags.
+//   st    The status flags for the operation.
+//
+// Use PolyHasAnyFlags to test if at least one of the bits is set. Both
+// functions only differ for masks of several bits: a mask of 0 is always
+// carried by the polygon here, and never by PolyHasAnyFlags. False is
+// returned along with the failure status if ref is not valid.
+func (m *NavMesh) PolyHasFlags(ref PolyRef, mask uint16) (bool, Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if st := m.TileAndPolyByRef(ref, &tile, &poly); StatusFailed(st) {
+		return false, st
+	}
+	return poly.Flags&mask == mask, Success
+}
+
+// PolyHasAnyFlags reports whether a polygon carries at least one of the
+// flags of a mask.
+//
+//  Arguments:
+//   ref   The reference of the polygon.
+//   mask  The flags to test.
+//
+//  Return values:
+//   ok    True if any of the bits of mask is set in the polygon flags.
+//   st    The status flags for the operation.
+//
+// See PolyHasFlags to test if all the bits are set. False is returned along
+// with the failure status if ref is not valid.
+func (m *NavMesh) PolyHasAnyFlags(ref PolyRef, mask uint16) (bool, Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if st := m.TileAndPolyByRef(ref, &tile, &poly); StatusFailed(st) {
+		return false, st
+	}
+	return poly.Flags&mask != 0, Success
+}
+
+// OffMeshConnectionPolyEndPoints returns the endpoints of an off-mesh
+// connection, ordered by the direction of travel.
+//
+//  Arguments:
+//   [in] prevRef   The reference of the polygon before the connection.
+//   [in] polyRef   The reference of the off-mesh connection polygon.
+//   [out]startPos  The start position of the off-mesh connection. [(x, y, z)]
+//   [out]endPos    The end position of the off-mesh connection. [(x, y, z)]
+//
+// Off-mesh connections are stored in the navigation mesh as special
+// 2-vertex polygons with a single edge. At least one of the vertices is
+// expected to be inside a normal polygon. So an off-mesh connection is
+// "entered" from a normal polygon at one of its endpoints. This is the
+// polygon identified by prevRef.
+func (m *NavMesh) OffMeshConnectionPolyEndPoints(prevRef, polyRef PolyRef, startPos, endPos d3.Vec3) Status {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if StatusFailed(m.TileAndPolyByRef(polyRef, &tile, &poly)) {
+		return Failure | InvalidParam
+	}
+
+	// Make sure that the current poly is indeed off-mesh link.
+	if poly.Type() != polyTypeOffMeshConnection {
+		return Failure
+	}
+
+	// Figure out which way to hand out the vertices.
+	idx0, idx1 := 0, 1
+
+	// Find link that points to first vertex.
+	for i := poly.FirstLink; i != nullLink; i = tile.Links[i].Next {
+		if tile.Links[i].Edge == 0 {
+			if tile.Links[i].Ref != prevRef {
+				idx0, idx1 = 1, 0
+			}
+			break
+		}
+	}
+
+	v0 := poly.Verts[idx0] * 3
+	v1 := poly.Verts[idx1] * 3
+	startPos.Assign(tile.Verts[v0 : v0+3])
+	endPos.Assign(tile.Verts[v1 : v1+3])
+	return Success
+}
+
+// ForEachOffMeshConnection calls fn for each off-mesh connection of the
+// loaded tiles, until fn returns false.
+//
+// start and end are the endpoints of the connection, in world coordinates,
+// as given to CreateNavMeshData in OffMeshConVerts: the connection goes from
+// start, in the tile holding the connection, to end, which may lie in a
+// neighbour tile. If bidir is true, the connection can also be traversed from
+// end to start. area and flags are the ones of the connection polygon, and
+// userID the user assigned connection id. start and end can be kept by fn,
+// they are copies of the navmesh data.
+//
+// Connections are visited tile by tile, in the order of the tile array, and
+// in their order within each tile. The navmesh must not be modified by fn.
+func (m *NavMesh) ForEachOffMeshConnection(fn func(start, end d3.Vec3, bidir bool, area uint8, flags uint16, userID uint32) bool) {
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		for j := range tile.OffMeshCons {
+			con := &tile.OffMeshCons[j]
+			poly := &tile.Polys[con.Poly]
+			bidir := uint32(con.Flags)&offMeshConBidir != 0
+			if !fn(d3.NewVec3From(con.Pos[0:3]), d3.NewVec3From(con.Pos[3:6]), bidir, poly.Area(), poly.Flags, con.UserID) {
+				return
+			}
+		}
+	}
+}
+
+// ArePolysAdjacent reports whether there is a direct link between the
+// polygons a and b: an internal edge of a tile, a portal between tiles or an
+// off-mesh connection, a and b being the connection and one of the polygons
+// it lands on.
+//
+// Adjacency is symmetric, even though off-mesh connection links may be
+// one-way: a unidirectional connection is adjacent to the polygons at both of
+// its ends. This only walks the links of a and b, so it is much cheaper than
+// listing the neighbours of a polygon. False is returned if a or b is not a
+// valid polygon reference.
+func (m *NavMesh) ArePolysAdjacent(a, b PolyRef) bool {
+	if !m.IsValidPolyRef(a) || !m.IsValidPolyRef(b) {
+		return false
+	}
+	return m.hasLinkTo(a, b) || m.hasLinkTo(b, a)
+}
+
+// hasLinkTo reports whether from, a valid polygon reference, has a link to
+// the polygon to.
+func (m *NavMesh) hasLinkTo(from, to PolyRef) bool {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	m.TileAndPolyByRefUnsafe(from, &tile, &poly)
+	for i := poly.FirstLink; i != nullLink; i = tile.Links[i].Next {
+		if tile.Links[i].Ref == to {
+			return true
+		}
+	}
+	return false
+}
+
+// PolyBounds returns the world axis-aligned bounding box of a polygon.
+//
+//  Arguments:
+//   [in] ref     The reference of the polygon.
+//   [out]bmin    The minimum bounds of the polygon. [(x, y, z)]
+//   [out]bmax    The maximum bounds of the polygon. [(x, y, z)]
+//
+// The detail mesh vertices are taken into account, so the box is tight
+// even on sloped polygons. Off-mesh connections have no detail mesh and
+// have no surface: Failure|InvalidParam is returned for such polygons.
+func (m *NavMesh) PolyBounds(ref PolyRef) (bmin, bmax d3.Vec3, status Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	status = m.TileAndPolyByRef(ref, &tile, &poly)
+	if StatusFailed(status) {
+		return nil, nil, status
+	}
+	if poly.Type() == polyTypeOffMeshConnection {
+		return nil, nil, Failure | InvalidParam
+	}
+
+	v := poly.Verts[0] * 3
+	bmin = d3.NewVec3From(tile.Verts[v : v+3])
+	bmax = d3.NewVec3From(tile.Verts[v : v+3])
+	for i := uint8(1); i < poly.VertCount; i++ {
+		v = poly.Verts[i] * 3
+		d3.Vec3Min(bmin, tile.Verts[v:v+3])
+		d3.Vec3Max(bmax, tile.Verts[v:v+3])
+	}
+
+	ip := m.decodePolyIDPoly(ref)
+	if len(tile.DetailMeshes) > int(ip) {
+		pd := &tile.DetailMeshes[ip]
+		for i := uint32(0); i < uint32(pd.VertCount); i++ {
+			v := (pd.VertBase + i) * 3
+			d3.Vec3Min(bmin, tile.DetailVerts[v:v+3])
+			d3.Vec3Max(bmax, tile.DetailVerts[v:v+3])
+		}
+	}
+	return bmin, bmax, Success
+}
+
+// GetPolyDetailTris returns the triangles of the detail mesh of a polygon,
+// which describe the actual walkable surface.
+//
+//  Arguments:
+//   [in] ref       The reference of the polygon.
+//   [out]outVerts  The triangles vertices. [(x, y, z) * nverts]
+//   [out]outTris   The triangles, as indices in outVerts. [ntris]
+//
+//  Returns:
+//   nverts  The number of vertices written in outVerts.
+//   ntris   The number of triangles written in outTris.
+//   status  The status flags.
+//
+// The polygon vertices come first in outVerts, followed by the detail
+// vertices. If the polygon has no detail mesh, its triangulation as a fan
+// around its first vertex is returned. Off-mesh connections have no surface:
+// Failure|InvalidParam is returned for such polygons.
+//
+// Vectors of outVerts are allocated if they are too short. If outVerts or
+// outTris is too small, the results are truncated and BufferTooSmall is set
+// in the returned status, the written triangles may then refer to vertices
+// which have not been written.
+func (m *NavMesh) GetPolyDetailTris(ref PolyRef, outVerts []d3.Vec3, outTris [][3]int) (nverts, ntris int, status Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	status = m.TileAndPolyByRef(ref, &tile, &poly)
+	if StatusFailed(status) {
+		return 0, 0, status
+	}
+	if poly.Type() == polyTypeOffMeshConnection {
+		return 0, 0, Failure | InvalidParam
+	}
+
+	status = Success
+	addVert := func(v []float32) {
+		if nverts >= len(outVerts) {
+			status |= BufferTooSmall
+			return
+		}
+		if len(outVerts[nverts]) < 3 {
+			outVerts[nverts] = d3.NewVec3()
+		}
+		outVerts[nverts].Assign(v)
+		nverts++
+	}
+	addTri := func(a, b, c int) {
+		if ntris >= len(outTris) {
+			status |= BufferTooSmall
+			return
+		}
+		outTris[ntris] = [3]int{a, b, c}
+		ntris++
+	}
+
+	for i := uint8(0); i < poly.VertCount; i++ {
+		v := poly.Verts[i] * 3
+		addVert(tile.Verts[v : v+3])
+	}
+
+	ip := m.decodePolyIDPoly(ref)
+	if len(tile.DetailMeshes) <= int(ip) || tile.DetailMeshes[ip].TriCount == 0 {
+		for i := 2; i < int(poly.VertCount); i++ {
+			addTri(0, i-1, i)
+		}
+		return nverts, ntris, status
+	}
+
+	// Detail triangles refer to the polygon vertices first, then to the
+	// detail vertices, which is the order of outVerts.
+	pd := &tile.DetailMeshes[ip]
+	for i := uint32(0); i < uint32(pd.VertCount); i++ {
+		v := (pd.VertBase + i) * 3
+		addVert(tile.DetailVerts[v : v+3])
+	}
+	for i := uint32(0); i < uint32(pd.TriCount); i++ {
+		t := tile.DetailTris[(pd.TriBase+i)*4:]
+		addTri(int(t[0]), int(t[1]), int(t[2]))
+	}
+	return nverts, ntris, status
+}
+
+// PolySlope returns the steepness of a polygon, as the angle in degrees
+// between its average normal and the world up direction.
+//
+// The up direction is given by the navmesh up axis, y by default (see
+// SetUpAxis): 0 is a flat polygon, 90 a vertical one. The average
+// normal is the area weighted sum of the normals of the detail triangles, or
+// of the polygon triangulation if it has no detail mesh, each oriented
+// upward, so that the slope does not depend on the triangles winding.
+// Off-mesh connections have no surface: Failure|InvalidParam is returned for
+// such polygons.
+func (m *NavMesh) PolySlope(ref PolyRef) (degrees float32, status Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	status = m.TileAndPolyByRef(ref, &tile, &poly)
+	if StatusFailed(status) {
+		return 0, status
+	}
+	if poly.Type() == polyTypeOffMeshConnection {
+		return 0, Failure | InvalidParam
+	}
+
+	var n [3]float32
+	up := m.upAxis.index()
+	addTri := func(a, b, c d3.Vec3) {
+		// The cross product length is twice the triangle area.
+		tn := b.Sub(a).Cross(c.Sub(a))
+		if tn[up] < 0 {
+			tn = tn.Scale(-1)
+		}
+		n[0] += tn[0]
+		n[1] += tn[1]
+		n[2] += tn[2]
+	}
+
+	ip := m.decodePolyIDPoly(ref)
+	if len(tile.DetailMeshes) > int(ip) && tile.DetailMeshes[ip].TriCount > 0 {
+		pd := &tile.DetailMeshes[ip]
+		for j := uint32(0); j < uint32(pd.TriCount); j++ {
+			var v [3]d3.Vec3
+			detailTriVerts(tile, poly, pd, j, &v)
+			addTri(v[0], v[1], v[2])
+		}
+	} else {
+		vert := func(i uint8) d3.Vec3 {
+			idx := poly.Verts[i] * 3
+			return tile.Verts[idx : idx+3]
+		}
+		for i := uint8(2); i < poly.VertCount; i++ {
+			addTri(vert(0), vert(i-1), vert(i))
+		}
+	}
+
+	vert := n[up]
+	n[up] = 0
+	horiz := math32.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+	if horiz == 0 && vert == 0 {
+		// Degenerate polygon.
+		return 0, Success
+	}
+	return math32.Atan2(horiz, vert) * 180 / math.Pi, Success
+}
+
 // CalcTileLoc calculates the tile grid location for the specified world
 // position.
 //
@@ -62,6 +62,30 @@ func longestAxis(x, y, z uint16) int {
 	return axis
 }
 
+// bvAxisOrder gives, for each axis, the order in which bvItemLess compares
+// the minimum bounds of items.
+var bvAxisOrder = [3][3]int{{0, 1, 2}, {1, 2, 0}, {2, 0, 1}}
+
+// bvItemLess reports whether a sorts before b along axis.
+//
+// Items are compared by minimum bounds, starting with axis, then by maximum
+// bounds, then by polygon index. This is a total order, so the BV-tree built
+// from a tile, and then the tile data, only depends on its polygons, even
+// with an unstable sort.
+func bvItemLess(a, b *bvItem, axis int) bool {
+	for _, k := range bvAxisOrder[axis] {
+		if a.BMin[k] != b.BMin[k] {
+			return a.BMin[k] < b.BMin[k]
+		}
+	}
+	for k := 0; k < 3; k++ {
+		if a.BMax[k] != b.BMax[k] {
+			return a.BMax[k] < b.BMax[k]
+		}
+	}
+	return a.i < b.i
+}
+
 func subdivide(items []bvItem, nitems, imin, imax int32, curNode *int32, nodes []BvNode) {
 	inum := imax - imin
 	icur := *curNode
@@ -89,149 +113,10 @@ func subdivide(items []bvItem, nitems, imin, imax int32, curNode *int32, nodes [
 			node.BMax[1]-node.BMin[1],
 			node.BMax[2]-node.BMin[2])
 
-		if axis == 0 {
-			// Sort along x-axis
-			sort.Slice(items[imin:imin+inum],
-				func(i, j int) bool {
-					a := items[int(imin)+i]
-					b := items[int(imin)+j]
-
-					if a.BMin[0] < b.BMin[0] {
-						return true
-					}
-					if a.BMin[0] > b.BMin[0] {
-						return false
-					}
-
-					// if x are equal, compare y
-					if a.BMin[1] < b.BMin[1] {
-						return true
-					}
-					if a.BMin[1] > b.BMin[1] {
-						return false
-					}
-
-					// if y are equal, compare z
-					if a.BMin[2] < b.BMin[2] {
-						return true
-					}
-					if a.BMin[2] > b.BMin[2] {
-						return false
-					}
-
-					// compare bmax
-					if a.BMax[0] < b.BMax[0] {
-						return true
-					}
-					if a.BMax[0] > b.BMax[0] {
-						return false
-					}
-					if a.BMax[1] < b.BMax[1] {
-						return true
-					}
-					if a.BMax[1] > b.BMax[1] {
-						return false
-					}
-					if a.BMax[2] < b.BMax[2] {
-						return true
-					}
-					return false
-				})
-		} else if axis == 1 {
-			// Sort along y-axis
-			sort.Slice(items[imin:imin+inum],
-				func(i, j int) bool {
-					a := items[int(imin)+i]
-					b := items[int(imin)+j]
-
-					if a.BMin[1] < b.BMin[1] {
-						return true
-					}
-					if a.BMin[1] > b.BMin[1] {
-						return false
-					}
-
-					// if y are equal, compare z
-					if a.BMin[2] < b.BMin[2] {
-						return true
-					}
-					if a.BMin[2] > b.BMin[2] {
-						return false
-					}
-
-					// if z are equal, compare x
-					if a.BMin[0] < b.BMin[0] {
-						return true
-					}
-					if a.BMin[0] > b.BMin[0] {
-						return false
-					}
-
-					// compare bmax
-					if a.BMax[0] < b.BMax[0] {
-						return true
-					}
-					if a.BMax[0] > b.BMax[0] {
-						return false
-					}
-					if a.BMax[1] < b.BMax[1] {
-						return true
-					}
-					if a.BMax[1] > b.BMax[1] {
-						return false
-					}
-					if a.BMax[2] < b.BMax[2] {
-						return true
-					}
-					return false
-				})
-		} else {
-			// Sort along z-axis
-			sort.Slice(items[imin:imin+inum],
-				func(i, j int) bool {
-					a := items[int(imin)+i]
-					b := items[int(imin)+j]
-
-					if a.BMin[2] < b.BMin[2] {
-						return true
-					}
-					if a.BMin[2] > b.BMin[2] {
-						return false
-					}
-					// if z are equal, compare x
-					if a.BMin[0] < b.BMin[0] {
-						return true
-					}
-					if a.BMin[0] > b.BMin[0] {
-						return false
-					}
-					// if x are equal, compare y
-					if a.BMin[1] < b.BMin[1] {
-						return true
-					}
-					if a.BMin[1] > b.BMin[1] {
-						return false
-					}
-
-					// compare bmax
-					if a.BMax[0] < b.BMax[0] {
-						return true
-					}
-					if a.BMax[0] > b.BMax[0] {
-						return false
-					}
-					if a.BMax[1] < b.BMax[1] {
-						return true
-					}
-					if a.BMax[1] > b.BMax[1] {
-						return false
-					}
-					if a.BMax[2] < b.BMax[2] {
-						return true
-					}
-					return false
-				})
-		}
+		// Sort along the longest axis.
+		sort.Slice(items[imin:imax], func(i, j int) bool {
+			return bvItemLess(&items[int(imin)+i], &items[int(imin)+j], axis)
+		})
 
 		isplit := imin + inum/2
 
@@ -289,7 +174,6 @@ func createBVTree(params *NavMeshCreateParams, nodes []BvNode) int32 {
 			it.BMax[1] = uint16(int32Clamp(int32((bmax[1]-params.BMin[1])*quantFactor), 0, 0xffff))
 			it.BMax[2] = uint16(int32Clamp(int32((bmax[2]-params.BMin[2])*quantFactor), 0, 0xffff))
 		} else {
-			panic("UNTESTED")
 			p := params.Polys[i*params.Nvp*2:]
 			it.BMin[0] = params.Verts[p[0]*3+0]
 			it.BMin[1] = params.Verts[p[0]*3+1]
@@ -454,6 +338,11 @@ type NavMeshCreateParams struct {
 	// to two vertices, at least one of which resides within a navigation mesh
 	// polygon.
 	//
+	// A connection is stored in the tile containing its start point, the
+	// other tiles ignore it. Its end point may lie in a neighbour tile: the
+	// connection is then linked when both tiles are added to the navmesh,
+	// whatever the order. (See NavMesh.AddTile)
+	//
 
 	// Off-mesh connection vertices.
 	// [(ax, ay, az, bx, by, bz) * offMeshConCount] [Unit: wu]
@@ -543,6 +432,9 @@ type NavMeshCreateParams struct {
 //
 // Return true if the tile data was successfully created.
 //
+// The tile data is created in the 32 bits references format, it can be
+// added to navmeshes of any reference layout. (See PolyRefLayout)
+//
 // see NavMesh, NavMesh.AddTile()
 func CreateNavMeshData(params *NavMeshCreateParams) ([]uint8, error) {
 	if params.Nvp > int32(VertsPerPolygon) {
@@ -699,7 +591,7 @@ func CreateNavMeshData(params *NavMeshCreateParams) ([]uint8, error) {
 	headerSize := hdr.size()
 	vertsSize := 4 * 3 * totVertCount
 	polysSize := int(unsafe.Sizeof(Poly{})) * totPolyCount
-	linksSize := int(unsafe.Sizeof(Link{})) * int(maxLinkCount)
+	linksSize := link32Size * int(maxLinkCount)
 	detailMeshesSize := int(unsafe.Sizeof(PolyDetail{})) * int(params.PolyCount)
 	detailVertsSize := 4 * 3 * int(uniqueDetailVertCount)
 	detailTrisSize := 4 * int(detailTriCount)
@@ -719,7 +611,10 @@ func CreateNavMeshData(params *NavMeshCreateParams) ([]uint8, error) {
 	navDMeshes := make([]PolyDetail, params.PolyCount)
 	navDVerts := make([]float32, 3*uniqueDetailVertCount)
 	navDTris := make([]uint8, 4*detailTriCount)
-	navBvtree := make([]BvNode, params.PolyCount*2)
+	var navBvtree []BvNode
+	if params.BuildBvTree {
+		navBvtree = make([]BvNode, params.PolyCount*2)
+	}
 	offMeshCons := make([]OffMeshConnection, storedOffMeshConCount)
 
 	// Fill header
@@ -912,6 +807,7 @@ func CreateNavMeshData(params *NavMeshCreateParams) ([]uint8, error) {
 	buf := make([]byte, dataSize)
 	hdr.serialize(buf)
 	err := serializeTileData(buf[hdr.size():],
+		link32Size,
 		navVerts,
 		navPolys,
 		make([]Link, maxLinkCount),
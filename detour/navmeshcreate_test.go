@@ -0,0 +1,142 @@
+package detour
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// gridParams returns the creation parameters of a tile made of n*n unit
+// square polygons, without detail meshes, at pseudo-random heights. The last
+// polygons are duplicates of the first ones, so that some polygons have the
+// same bounds.
+func gridParams(n int) *NavMeshCreateParams {
+	rng := rand.New(rand.NewSource(1))
+	params := &NavMeshCreateParams{
+		Nvp:            6,
+		WalkableHeight: 2,
+		WalkableRadius: 0.5,
+		WalkableClimb:  0.5,
+		Cs:             0.5,
+		Ch:             0.2,
+		BMax:           [3]float32{float32(n), 4, float32(n)},
+		BuildBvTree:    true,
+	}
+	quad := func(x, z int) []uint16 {
+		return []uint16{
+			uint16(4 * (z*n + x)), uint16(4*(z*n+x) + 1), uint16(4*(z*n+x) + 2), uint16(4*(z*n+x) + 3), 0xffff, 0xffff,
+			0x800f, 0x800f, 0x800f, 0x800f, 0, 0,
+		}
+	}
+	for z := 0; z < n; z++ {
+		for x := 0; x < n; x++ {
+			y := uint16(rng.Intn(20))
+			x0, z0, x1, z1 := uint16(2*x), uint16(2*z), uint16(2*x+2), uint16(2*z+2)
+			params.Verts = append(params.Verts, x0, y, z0, x0, y, z1, x1, y, z1, x1, y, z0)
+			params.Polys = append(params.Polys, quad(x, z)...)
+		}
+	}
+	for i := 0; i < n; i++ {
+		params.Polys = append(params.Polys, quad(i, i)...)
+	}
+	params.VertCount = int32(len(params.Verts) / 3)
+	params.PolyCount = int32(len(params.Polys) / 12)
+	params.PolyFlags = make([]uint16, params.PolyCount)
+	params.PolyAreas = make([]uint8, params.PolyCount)
+	for i := range params.PolyFlags {
+		params.PolyFlags[i] = 1
+	}
+	return params
+}
+
+func TestBVTreeQueries(t *testing.T) {
+	const n = 8
+	navMesh := func(bvTree bool) *NavMesh {
+		params := gridParams(n)
+		params.BuildBvTree = bvTree
+		data, err := CreateNavMeshData(params)
+		checkt(t, err)
+
+		var mesh NavMesh
+		if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+			t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+		}
+		return &mesh
+	}
+	withTree, withoutTree := navMesh(true), navMesh(false)
+	if len(withTree.Tiles[0].BvTree) == 0 {
+		t.Fatal("tile has no BV-tree")
+	}
+	if len(withoutTree.Tiles[0].BvTree) != 0 {
+		t.Fatal("tile has a BV-tree, want none")
+	}
+
+	query := func(mesh *NavMesh, center, extents d3.Vec3) []PolyRef {
+		st, q := NewNavMeshQuery(mesh, 16)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		polys := make([]PolyRef, 2*n*n)
+		count, st := q.queryPolygons6(center, extents, NewStandardQueryFilter(), polys, int32(len(polys)))
+		if StatusFailed(st) {
+			t.Fatalf("queryPolygons failed with status 0x%x", uint32(st))
+		}
+		polys = polys[:count]
+		sort.Slice(polys, func(i, j int) bool { return polys[i] < polys[j] })
+		return polys
+	}
+
+	var found int
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		center := d3.Vec3{rng.Float32() * n, rng.Float32() * 4, rng.Float32() * n}
+		extents := d3.Vec3{rng.Float32() * 2, rng.Float32(), rng.Float32() * 2}
+		got, want := query(withTree, center, extents), query(withoutTree, center, extents)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("center %v extents %v, got polygons %v with the BV-tree, want %v", center, extents, got, want)
+		}
+		found += len(got)
+	}
+	if found == 0 {
+		t.Errorf("queries found no polygons")
+	}
+}
+
+func TestBVTreeDeterministic(t *testing.T) {
+	params := gridParams(8)
+	data1, err := CreateNavMeshData(params)
+	checkt(t, err)
+	data2, err := CreateNavMeshData(params)
+	checkt(t, err)
+	if !bytes.Equal(data1, data2) {
+		t.Errorf("tile data differ for identical input")
+	}
+
+	// Items with equal bounds are sorted the same way whatever their initial
+	// order and the sort algorithm.
+	items := make([]bvItem, 64)
+	for i := range items {
+		items[i] = bvItem{
+			BMin: [3]uint16{uint16(i % 3), uint16(i % 2), 0},
+			BMax: [3]uint16{4, 4, uint16(i % 4)},
+			i:    int32(i),
+		}
+	}
+	for axis := 0; axis < 3; axis++ {
+		shuffled := append([]bvItem(nil), items...)
+		rand.New(rand.NewSource(int64(axis))).Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		stable := append([]bvItem(nil), items...)
+
+		sort.Slice(shuffled, func(i, j int) bool { return bvItemLess(&shuffled[i], &shuffled[j], axis) })
+		sort.SliceStable(stable, func(i, j int) bool { return bvItemLess(&stable[i], &stable[j], axis) })
+		if !reflect.DeepEqual(shuffled, stable) {
+			t.Errorf("axis %d, sorted items depend on their initial order", axis)
+		}
+	}
+}
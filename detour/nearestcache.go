@@ -0,0 +1,96 @@
+package detour
+
+import "github.com/arl/gogeo/f32/d3"
+
+// NearestPolyCache caches the result of FindNearestPoly for an entity, so
+// that the polygon nearest to an entity that has not moved, such as an idle
+// NPC, is not searched again at each tick.
+//
+// A cache is meant for a single entity, it must not be shared between
+// entities nor used by several goroutines at once. The zero value is an empty
+// cache, with a zero threshold.
+type NearestPolyCache struct {
+	// Threshold is the distance the entity can move from the position of the
+	// last search before the nearest polygon is searched again.
+	Threshold float32
+
+	pos d3.Vec3 // Center of the last search, or nil.
+	ref PolyRef // Nearest polygon found by the last search.
+	pt  d3.Vec3 // Nearest point found by the last search.
+}
+
+// NewNearestPolyCache returns an empty cache which searches the nearest
+// polygon again once the entity has moved further than threshold.
+func NewNearestPolyCache(threshold float32) NearestPolyCache {
+	return NearestPolyCache{Threshold: threshold}
+}
+
+// FindNearestPoly finds the polygon nearest to center, or returns the cached
+// one.
+//
+//  Arguments:
+//   q        The query used to search the polygon.
+//   center   The center of the search box, the entity position.
+//   extents  A vector which components represent the
+//            search distance along each axis.
+//   filter   The polygon filter to apply to the query.
+//
+//  Return values:
+//   st       The status flags for the query.
+//   ref      The reference id of the nearest polygon.
+//   pt       The nearest point on the polygon. [(x, y, z)]
+//
+// The result of the last search is returned as long as center is within
+// c.Threshold of the center of that search, and its polygon reference is
+// valid (see NavMesh.IsValidPolyRef) and passes filter. Otherwise this is
+// NavMeshQuery.FindNearestPoly, which result is cached if a polygon has been
+// found. The searches finding no polygon are not cached, so an entity out of
+// the navmesh searches again at each call.
+//
+// The cached result is stale by up to c.Threshold: pt is the nearest point to
+// the center of the last search, not to center, and a nearer polygon may
+// exist at center. Tiles removed from the navmesh invalidate the cached
+// reference, but tiles added near the entity are not noticed until it moves
+// further than c.Threshold, Reset allows to search again in that case, for
+// example when NavMesh.TileChanges changes. extents is not part of the cache
+// either, Reset must be called after changing it.
+//
+// The returned point is owned by the cache: it must not be modified, and is
+// only valid until the next search.
+func (c *NearestPolyCache) FindNearestPoly(q *NavMeshQuery, center, extents d3.Vec3,
+	filter QueryFilter) (st Status, ref PolyRef, pt d3.Vec3) {
+
+	if c.ref != 0 && c.pos.DistSqr(center) <= c.Threshold*c.Threshold {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusSucceed(q.nav.TileAndPolyByRef(c.ref, &tile, &poly)) &&
+			filter != nil && filter.PassFilter(c.ref, tile, poly) {
+			return Success, c.ref, c.pt
+		}
+	}
+
+	st, ref, pt = q.FindNearestPoly(center, extents, filter)
+	if StatusFailed(st) || ref == 0 {
+		c.Reset()
+		return st, ref, pt
+	}
+	if c.pos == nil {
+		c.pos = d3.NewVec3()
+	}
+	c.pos.Assign(center)
+	c.ref, c.pt = ref, pt
+	return st, ref, pt
+}
+
+// Ref returns the cached polygon reference, 0 if the cache is empty.
+func (c *NearestPolyCache) Ref() PolyRef {
+	return c.ref
+}
+
+// Reset empties the cache, so that the next call to FindNearestPoly searches
+// the nearest polygon.
+func (c *NearestPolyCache) Reset() {
+	c.ref, c.pt = 0, nil
+}
@@ -0,0 +1,84 @@
+package detour
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestNearestPolyCache(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	var searches int
+	q.SetQueryObserver(func(op string, dur time.Duration) {
+		if op == "FindNearestPoly" {
+			searches++
+		}
+	})
+	filter := NewStandardQueryFilter()
+	extents := d3.Vec3{1, 4, 1}
+	pos := d3.Vec3{5, 0, 5}
+	_, want, wantPt := q.FindNearestPoly(pos, extents, filter)
+	if want == 0 {
+		t.Fatalf("no polygon found near %v", pos)
+	}
+
+	c := NewNearestPolyCache(0.5)
+	find := func(msg string, pos d3.Vec3, filter QueryFilter, wantSearch bool) PolyRef {
+		searches = 0
+		_, ref, pt := c.FindNearestPoly(q, pos, extents, filter)
+		if searched := searches != 0; searched != wantSearch {
+			t.Errorf("%s: searched = %t, want %t", msg, searched, wantSearch)
+		}
+		if !wantSearch && !pt.Approx(wantPt) {
+			t.Errorf("%s: got point %v, want the cached %v", msg, pt, wantPt)
+		}
+		return ref
+	}
+
+	if ref := find("empty cache", pos, filter, true); ref != want {
+		t.Fatalf("got ref 0x%x, want 0x%x", ref, want)
+	}
+	if ref := find("same position", pos, filter, false); ref != want {
+		t.Errorf("same position: got ref 0x%x, want 0x%x", ref, want)
+	}
+	if ref := find("within threshold", d3.Vec3{5.3, 0, 5.3}, filter, false); ref != want {
+		t.Errorf("within threshold: got ref 0x%x, want 0x%x", ref, want)
+	}
+	find("beyond threshold", d3.Vec3{5.6, 0, 5}, filter, true)
+	find("back", pos, filter, true)
+
+	// A filtered out polygon is searched again.
+	excl := NewStandardQueryFilter()
+	excl.SetExcludeFlags(0xffff)
+	if ref := find("filtered out", pos, excl, true); ref != 0 || c.Ref() != 0 {
+		t.Errorf("filtered out: got ref 0x%x, cached 0x%x, want none", ref, c.Ref())
+	}
+	// Misses are not cached.
+	find("after a miss", pos, filter, true)
+
+	// Reloading the tile invalidates the cached ref.
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	mesh.TileAndPolyByRefUnsafe(want, &tile, &poly)
+	data := mesh.tileData(tile)
+	if _, st := mesh.RemoveTile(mesh.TileRef(tile)); StatusFailed(st) {
+		t.Fatalf("RemoveTile failed with status 0x%x", uint32(st))
+	}
+	if st, _ := mesh.AddTile(data, 0); StatusFailed(st) {
+		t.Fatalf("AddTile failed with status 0x%x", uint32(st))
+	}
+	if ref := find("reloaded tile", pos, filter, true); ref == 0 || ref == want || !mesh.IsValidPolyRef(ref) {
+		t.Errorf("reloaded tile: got ref 0x%x, want a valid ref other than 0x%x", ref, want)
+	}
+
+	c.Reset()
+	find("after Reset", pos, filter, true)
+}
@@ -55,22 +55,26 @@ type Node struct {
 	//Flags uint8
 	Flags NodeFlags
 	ID    PolyRef // Polygon ref the node corresponds to.
-}
-
-func newNode() Node {
-	return Node{
-		Pos: d3.NewVec3(),
-	}
+	idx   uint32  // Index of the node in its pool, plus 1.
 }
 
 const (
 	maxStatesPerNode int32 = 1 << nodeStateBits // number of extra states per node. See Node::state
 )
 
+// nodeChunkSize is the number of nodes a NodePool allocates at once.
+const nodeChunkSize = 64
+
 // NodePool is a pool of nodes, it allocated them and allows
 // them to be reused.
+//
+// Nodes are allocated by chunks, as they are needed by the searches, up to
+// the maximum number of nodes of the pool, and are kept for the following
+// searches. So the memory used by a pool depends on the largest search it
+// has run, not on its maximum number of nodes. Chunks are never moved, which
+// keeps the node pointers valid while the pool grows.
 type NodePool struct {
-	nodes       []Node
+	chunks      [][]Node // allocated nodes, nodeChunkSize per chunk
 	first, next []NodeIndex
 	maxNodes    int32
 	hashSize    int32
@@ -91,26 +95,46 @@ func newNodePool(maxNodes, hashSize int32) *NodePool {
 	assert.True(np.maxNodes > 0 && np.maxNodes <= int32(nullIdx) &&
 		np.maxNodes <= (1<<nodeParentBits)-1, "NodePool, max nodes check failed")
 
-	np.nodes = make([]Node, np.maxNodes)
-	for i := range np.nodes {
-		np.nodes[i] = newNode()
-	}
-	np.next = make([]NodeIndex, np.maxNodes)
 	np.first = make([]NodeIndex, hashSize)
-
-	assert.True(len(np.nodes) > 0, "Nodes should not be empty")
-	assert.True(len(np.next) > 0, "Next should not be empty")
 	assert.True(len(np.first) > 0, "First should not be empty")
 
 	for idx := range np.first {
 		np.first[idx] = nullIdx
 	}
-	for idx := range np.next {
-		np.next[idx] = nullIdx
-	}
 	return np
 }
 
+// node returns the node at index i, which must have been allocated.
+func (np *NodePool) node(i NodeIndex) *Node {
+	return &np.chunks[i/nodeChunkSize][i%nodeChunkSize]
+}
+
+// grow allocates a new chunk of nodes.
+func (np *NodePool) grow() {
+	base := int32(len(np.chunks)) * nodeChunkSize
+	n := np.maxNodes - base
+	if n > nodeChunkSize {
+		n = nodeChunkSize
+	}
+
+	chunk := make([]Node, n)
+	pos := make([]float32, 3*n)
+	for i := range chunk {
+		chunk[i].Pos = pos[3*i : 3*i+3 : 3*i+3]
+		chunk[i].idx = uint32(base) + uint32(i) + 1
+	}
+	np.chunks = append(np.chunks, chunk)
+	np.next = append(np.next, make([]NodeIndex, n)...)
+}
+
+// reserve allocates all the nodes of the pool, so that the searches do not
+// have to.
+func (np *NodePool) reserve() {
+	for int32(len(np.chunks))*nodeChunkSize < np.maxNodes {
+		np.grow()
+	}
+}
+
 // Clear clears the node pool.
 func (np *NodePool) Clear() {
 	assert.True(int(np.hashSize) == len(np.first), "np.HashSize == len(np.First)")
@@ -131,8 +155,8 @@ func (np *NodePool) Node(id PolyRef, state uint8) *Node {
 	i = np.first[bucket]
 	var node *Node
 	for i != nullIdx {
-		if np.nodes[i].ID == id && np.nodes[i].State == state {
-			return &np.nodes[i]
+		if node = np.node(i); node.ID == id && node.State == state {
+			return node
 		}
 		i = np.next[i]
 	}
@@ -140,12 +164,15 @@ func (np *NodePool) Node(id PolyRef, state uint8) *Node {
 	if np.nodeCount >= np.maxNodes {
 		return nil
 	}
+	if int(np.nodeCount) == len(np.next) {
+		np.grow()
+	}
 
 	i = NodeIndex(np.nodeCount)
 	np.nodeCount++
 
 	// Init node
-	node = &np.nodes[i]
+	node = np.node(i)
 	node.PIdx = 0
 	node.Cost = 0
 	node.Total = 0
@@ -165,8 +192,8 @@ func (np *NodePool) FindNode(id PolyRef, state uint8) *Node {
 	bucket := hashRef(id) & uint32(np.hashSize-1)
 	i := np.first[bucket]
 	for i != nullIdx {
-		if np.nodes[i].ID == id && np.nodes[i].State == state {
-			return &np.nodes[i]
+		if node := np.node(i); node.ID == id && node.State == state {
+			return node
 		}
 		i = np.next[i]
 	}
@@ -185,11 +212,11 @@ func (np *NodePool) FindNodes(id PolyRef, nodes []*Node, maxNodes int32) uint32
 	bucket := hashRef(id) & uint32(np.hashSize-1)
 	i := np.first[bucket]
 	for i != nullIdx {
-		if np.nodes[i].ID == id {
+		if node := np.node(i); node.ID == id {
 			if n >= uint32(maxNodes) {
 				return n
 			}
-			nodes[n] = &np.nodes[i]
+			nodes[n] = node
 			n++
 		}
 		i = np.next[i]
@@ -202,9 +229,7 @@ func (np *NodePool) NodeIdx(node *Node) uint32 {
 	if node == nil {
 		return 0
 	}
-
-	ip := (uintptr(unsafe.Pointer(node)) - uintptr(unsafe.Pointer(&np.nodes[0]))) / unsafe.Sizeof(*node)
-	return uint32(ip + 1)
+	return node.idx
 }
 
 // NodeAtIdx returns the node at given index.
@@ -212,7 +237,7 @@ func (np *NodePool) NodeAtIdx(idx int32) *Node {
 	if idx == 0 {
 		return nil
 	}
-	return &np.nodes[idx-1]
+	return np.node(NodeIndex(idx - 1))
 }
 
 // MemUsed returns the number of bytes currently in use in this
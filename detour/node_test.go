@@ -0,0 +1,64 @@
+package detour
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestNodePoolGrowth(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	st, q := NewNavMeshQuery(mesh, 65535)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	if n := len(q.nodePool.chunks); n != 0 {
+		t.Errorf("new node pool has %d chunks, want 0", n)
+	}
+	// A Dijkstra search expands many nodes.
+	q.SetHeuristicScale(0)
+
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+	_, orgRef, orgPt := q.FindNearestPoly(d3.Vec3{5, 0, 10}, extents, filter)
+	_, dstRef, dstPt := q.FindNearestPoly(d3.Vec3{50, 0, 30}, extents, filter)
+
+	findPath := func() ([]PolyRef, []float32) {
+		path := make([]PolyRef, 256)
+		costs := make([]float32, 256)
+		n, st := q.FindPathDetailed(orgRef, dstRef, orgPt, dstPt, filter, path, costs)
+		if st != Success {
+			t.Fatalf("FindPathDetailed returned status 0x%x", uint32(st))
+		}
+		return path[:n], costs[:n]
+	}
+
+	// The first search grows the pool, the second one reuses it.
+	wantPath, wantCosts := findPath()
+	count := q.nodePool.NodeCount()
+	if count <= nodeChunkSize {
+		t.Fatalf("search used %d nodes, want more than a chunk (%d)", count, nodeChunkSize)
+	}
+	chunks := len(q.nodePool.chunks)
+	if want := int((count + nodeChunkSize - 1) / nodeChunkSize); chunks != want {
+		t.Errorf("node pool has %d chunks for %d nodes, want %d", chunks, count, want)
+	}
+
+	path, costs := findPath()
+	if !reflect.DeepEqual(path, wantPath) || !reflect.DeepEqual(costs, wantCosts) {
+		t.Errorf("search with a grown pool found %v %v, want %v %v", path, costs, wantPath, wantCosts)
+	}
+	if n := len(q.nodePool.chunks); n != chunks {
+		t.Errorf("node pool grew to %d chunks for the same search, want %d", n, chunks)
+	}
+
+	// Node indices and pointers remain consistent across chunks.
+	for i := int32(1); i <= count; i++ {
+		if n := q.nodePool.NodeAtIdx(i); q.nodePool.NodeIdx(n) != uint32(i) {
+			t.Fatalf("NodeIdx(NodeAtIdx(%d)) = %d", i, q.nodePool.NodeIdx(n))
+		}
+	}
+}
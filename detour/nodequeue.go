@@ -7,6 +7,12 @@ import (
 	assert "github.com/arl/assertgo"
 )
 
+// nodeQueue is a priority queue of nodes, ordered by increasing total cost.
+//
+// Nodes having the same total cost are ordered by increasing polygon
+// reference, then by state, so that the order in which nodes are popped
+// only depends on the nodes in the queue, not on the order they have been
+// pushed, and searches are reproducible.
 type nodeQueue struct {
 	heap     []*Node
 	capacity int32
@@ -19,16 +25,25 @@ func newnodeQueue(n int32) *nodeQueue {
 	q.capacity = n
 	assert.True(q.capacity > 0, "nodeQueue capacity must be > 0")
 
-	q.heap = make([]*Node, q.capacity+1)
-	assert.True(len(q.heap) > 0, "allocation error")
-
+	// the heap grows as nodes are pushed
 	return q
 }
 
+// nodeLess reports whether a must be popped before b.
+func nodeLess(a, b *Node) bool {
+	if a.Total != b.Total {
+		return a.Total < b.Total
+	}
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+	return a.State < b.State
+}
+
 func (q *nodeQueue) bubbleUp(i int32, node *Node) {
 	parent := (i - 1) / 2
 	// note: (index > 0) means there is a parent
-	for (i > 0) && (q.heap[parent].Total > node.Total) {
+	for (i > 0) && nodeLess(node, q.heap[parent]) {
 		q.heap[i] = q.heap[parent]
 		i = parent
 		parent = (i - 1) / 2
@@ -40,7 +55,7 @@ func (q *nodeQueue) trickleDown(i int32, node *Node) {
 	child := (i * 2) + 1
 	for child < q.size {
 		if ((child + 1) < q.size) &&
-			(q.heap[child].Total > q.heap[child+1].Total) {
+			nodeLess(q.heap[child+1], q.heap[child]) {
 			child++
 		}
 		q.heap[i] = q.heap[child]
@@ -50,6 +65,16 @@ func (q *nodeQueue) trickleDown(i int32, node *Node) {
 	q.bubbleUp(i, node)
 }
 
+// reserve allocates the heap for the capacity of the queue, so that pushing
+// nodes does not have to.
+func (q *nodeQueue) reserve() {
+	if cap(q.heap) < int(q.capacity) {
+		heap := make([]*Node, len(q.heap), q.capacity)
+		copy(heap, q.heap)
+		q.heap = heap
+	}
+}
+
 func (q *nodeQueue) clear() {
 	q.size = 0
 }
@@ -66,6 +91,9 @@ func (q *nodeQueue) pop() *Node {
 }
 
 func (q *nodeQueue) push(node *Node) {
+	if int(q.size) == len(q.heap) {
+		q.heap = append(q.heap, nil)
+	}
 	q.size++
 	q.bubbleUp(q.size-1, node)
 }
@@ -0,0 +1,34 @@
+package detour
+
+import "testing"
+
+func TestNodeQueueTieBreak(t *testing.T) {
+	nodes := []Node{
+		{Total: 2, ID: 1},
+		{Total: 1, ID: 9},
+		{Total: 1, ID: 3},
+		{Total: 1, ID: 3, State: 1},
+		{Total: 1, ID: 5},
+		{Total: 0.5, ID: 7},
+	}
+	want := []struct {
+		id    PolyRef
+		state uint8
+	}{{7, 0}, {3, 0}, {3, 1}, {5, 0}, {9, 0}, {1, 0}}
+
+	// Whatever the push order, nodes are popped in the same order.
+	orders := [][]int{{0, 1, 2, 3, 4, 5}, {5, 4, 3, 2, 1, 0}, {3, 0, 4, 1, 5, 2}}
+	for _, order := range orders {
+		q := newnodeQueue(int32(len(nodes)))
+		for _, i := range order {
+			q.push(&nodes[i])
+		}
+		for i, w := range want {
+			n := q.pop()
+			if n.ID != w.id || n.State != w.state {
+				t.Errorf("push order %v: pop %d is (%d, %d), want (%d, %d)",
+					order, i, n.ID, n.State, w.id, w.state)
+			}
+		}
+	}
+}
@@ -2,7 +2,6 @@ package detour
 
 import (
 	"fmt"
-	"log"
 	"reflect"
 	"testing"
 
@@ -10,7 +9,6 @@ import (
 )
 
 func TestOffMeshConnections(t *testing.T) {
-	t.Skip("Work in progress, disabled for now")
 	var (
 		mesh *NavMesh
 		err  error
@@ -193,7 +191,6 @@ func TestOffMeshConnections(t *testing.T) {
 			t.Fatal("straightPath start is not flagged StraightPathStart")
 		}
 
-		fmt.Println("StraightPathCount == ", straightPathCount)
 		if (straightPathFlags[straightPathCount-1] & StraightPathEnd) == 0 {
 			t.Fatal("straightPath end is not flagged StraightPathEnd")
 		}
@@ -202,12 +199,674 @@ func TestOffMeshConnections(t *testing.T) {
 			t.Fatalf("found path and wanted path do not have the same length (%d != %d)", straightPathCount, len(tt.wantStraightPath))
 		}
 		for i := 0; i < straightPathCount; i++ {
-			log.Printf("straightPath[%d].Flags = 0x%x\n", i, straightPathFlags[i])
-		}
-		for i := 0; i < pathCount; i++ {
 			if !straightPath[i].Approx(tt.wantStraightPath[i]) {
 				t.Errorf("straightPath[%d] = %v, want %v", i, straightPath[i], tt.wantStraightPath[i])
 			}
 		}
+
+		// The off-mesh connection is crossed from its start to its end point.
+		for i := 0; i < straightPathCount; i++ {
+			if straightPathFlags[i]&StraightPathOffMeshConnection == 0 {
+				continue
+			}
+			startPos, endPos := d3.NewVec3(), d3.NewVec3()
+			st = mesh.OffMeshConnectionPolyEndPoints(straightPathRefs[i-1], straightPathRefs[i], startPos, endPos)
+			if StatusFailed(st) {
+				t.Fatal("OffMeshConnectionPolyEndPoints failed:", st)
+			}
+			if !startPos.Approx(straightPath[i]) || !endPos.Approx(straightPath[i+1]) {
+				t.Errorf("off-mesh connection endpoints are %v %v, want %v %v", startPos, endPos, straightPath[i], straightPath[i+1])
+			}
+		}
+
+		// Without the ability to jump, the path can not use the connection.
+		filter.SetExcludeFlags(uint16(samplePolyFlagsJump))
+		pathCount, st = query.FindPath(orgRef, dstRef, org, dst, filter, path)
+		if StatusFailed(st) {
+			t.Fatal("query.FindPath failed:", st)
+		}
+		for _, ref := range path[:pathCount] {
+			var (
+				tile *MeshTile
+				poly *Poly
+			)
+			mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+			if poly.Type() == polyTypeOffMeshConnection {
+				t.Errorf("path goes through off-mesh connection 0x%x, want it excluded", ref)
+			}
+		}
+	}
+}
+
+func TestFindPathExcluding(t *testing.T) {
+	mesh, err := loadTestNavMesh("offmeshcons.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	extents := d3.NewVec3XYZ(2, 2, 2)
+
+	_, orgRef, org := query.FindNearestPoly(d3.Vec3{-19.460140, 4.234787, -4.727699}, extents, filter)
+	_, dstRef, dst := query.FindNearestPoly(d3.Vec3{-1.402759, -0.000092, -2.314920}, extents, filter)
+
+	find := func(forbidden map[PolyRef]struct{}) ([]PolyRef, Status) {
+		path := make([]PolyRef, 100)
+		n, st := query.FindPathExcluding(orgRef, dstRef, org, dst, filter, forbidden, path)
+		if StatusFailed(st) {
+			t.Fatalf("FindPathExcluding failed with status 0x%x", uint32(st))
+		}
+		return path[:n], st
+	}
+
+	// Without forbidden polygons, the path takes the off-mesh connection.
+	offMeshPath := []PolyRef{0x600029, 0x60003d, 0x600034}
+	if path, st := find(nil); st != Success || !reflect.DeepEqual(path, offMeshPath) {
+		t.Fatalf("with no forbidden polys, got path %#x and status 0x%x, want %#x", path, uint32(st), offMeshPath)
+	}
+
+	// The end polygon can only be reached through the off-mesh connection,
+	// forbidding it gives a partial path.
+	forbidden := map[PolyRef]struct{}{0x60003d: {}}
+	path, st := find(forbidden)
+	if !StatusDetail(st, PartialResult) || len(path) < 3 {
+		t.Fatalf("with forbidden off-mesh connection, got path %#x and status 0x%x, want a partial path", path, uint32(st))
+	}
+
+	// Then forbid a polygon of that path.
+	forbidden[path[len(path)/2]] = struct{}{}
+	path, _ = find(forbidden)
+	for _, ref := range path {
+		if _, ok := forbidden[ref]; ok {
+			t.Errorf("path %#x goes through forbidden poly %#x", path, ref)
+		}
+	}
+
+	// A forbidden end polygon is not reached.
+	if path, st := find(map[PolyRef]struct{}{dstRef: {}}); !StatusDetail(st, PartialResult) || path[len(path)-1] == dstRef {
+		t.Errorf("with forbidden end poly, got path %#x and status 0x%x, want a partial path", path, uint32(st))
+	}
+}
+
+func TestNextCorner(t *testing.T) {
+	mesh, err := loadTestNavMesh("offmeshcons.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	extents := d3.NewVec3XYZ(2, 2, 2)
+
+	_, orgRef, org := query.FindNearestPoly(d3.Vec3{-19.460140, 4.234787, -4.727699}, extents, filter)
+	_, dstRef, dst := query.FindNearestPoly(d3.Vec3{-1.402759, -0.000092, -2.314920}, extents, filter)
+
+	path := make([]PolyRef, 100)
+	pathCount, st := query.FindPath(orgRef, dstRef, org, dst, filter, path)
+	if StatusFailed(st) {
+		t.Fatalf("FindPath failed with status 0x%x", uint32(st))
+	}
+	path = path[:pathCount]
+
+	straightPath := make([]d3.Vec3, 100)
+	for i := range straightPath {
+		straightPath[i] = d3.NewVec3()
+	}
+	straightPathFlags := make([]uint8, 100)
+	straightPathRefs := make([]PolyRef, 100)
+	n, st := query.FindStraightPath(org, dst, path, straightPath, straightPathFlags, straightPathRefs, 0)
+	if StatusFailed(st) {
+		t.Fatalf("FindStraightPath failed with status 0x%x", uint32(st))
+	}
+
+	// Following the straight path, each corner is the next one.
+	var offMesh bool
+	for i := 0; i < n-1; i++ {
+		// Start the corridor at the polygon entered at the current corner.
+		j := 0
+		if i > 0 {
+			for j < len(path) && path[j] != straightPathRefs[i] {
+				j++
+			}
+			if j == len(path) {
+				t.Fatalf("straight path ref 0x%x is not in the path", straightPathRefs[i])
+			}
+		}
+
+		corner, flags, ref, st := query.NextCorner(path[j:], straightPath[i], dst, 0)
+		if StatusFailed(st) {
+			t.Fatalf("NextCorner from corner %d failed with status 0x%x", i, uint32(st))
+		}
+		if !corner.Approx(straightPath[i+1]) {
+			t.Errorf("NextCorner from corner %d = %v, want %v", i, corner, straightPath[i+1])
+		}
+		if flags != straightPathFlags[i+1] || ref != straightPathRefs[i+1] {
+			t.Errorf("NextCorner from corner %d, got flags 0x%x and ref 0x%x, want 0x%x and 0x%x",
+				i, flags, ref, straightPathFlags[i+1], straightPathRefs[i+1])
+		}
+		offMesh = offMesh || flags&StraightPathOffMeshConnection != 0
+	}
+	if !offMesh {
+		t.Errorf("no corner is flagged as an off-mesh connection")
+	}
+
+	// With a short look-ahead, the corner can not be the end of the path.
+	_, flags, _, st := query.NextCorner(path, org, dst, 1)
+	if StatusFailed(st) {
+		t.Fatalf("NextCorner with look-ahead failed with status 0x%x", uint32(st))
+	}
+	if flags&StraightPathEnd != 0 {
+		t.Errorf("NextCorner with look-ahead 1 returned the end of the path")
+	}
+
+	if _, _, _, st := query.NextCorner(nil, org, dst, 0); !StatusFailed(st) {
+		t.Errorf("NextCorner with an empty path succeeded, want failure")
+	}
+}
+
+// straddlingTileParams returns the creation parameters of the tile (tx, 0) of
+// a navmesh of 4x4 tiles, holding a single 2.5x3 square polygon, and an
+// off-mesh connection from the tile (0, 0) to the tile (1, 0).
+func straddlingTileParams(tx int32) *NavMeshCreateParams {
+	return &NavMeshCreateParams{
+		// x in [0.5, 3] or [5, 7.5], z in [0.5, 3.5]
+		Verts:     []uint16{1, 0, 1, 1, 0, 7, 6, 0, 7, 6, 0, 1},
+		VertCount: 4,
+		Polys: []uint16{
+			0, 1, 2, 3, 0xffff, 0xffff,
+			0xffff, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff,
+		},
+		PolyFlags:        []uint16{1},
+		PolyAreas:        []uint8{0},
+		PolyCount:        1,
+		Nvp:              6,
+		OffMeshConVerts:  []float32{2.5, 0, 2, 6, 0, 2},
+		OffMeshConRad:    []float32{0.5},
+		OffMeshConFlags:  []uint16{1},
+		OffMeshConAreas:  []uint8{0},
+		OffMeshConDir:    []uint8{1},
+		OffMeshConUserID: []uint32{1},
+		OffMeshConCount:  1,
+		WalkableHeight:   2,
+		WalkableRadius:   0.5,
+		WalkableClimb:    0.5,
+		TileX:            tx,
+		BMin:             [3]float32{4 * float32(tx), 0, 0},
+		BMax:             [3]float32{4*float32(tx) + 4, 2, 4},
+		Cs:               0.5,
+		Ch:               0.2,
+		BuildBvTree:      true,
+	}
+}
+
+func TestOffMeshConnectionAcrossTiles(t *testing.T) {
+	data := make([][]byte, 2)
+	for tx := range data {
+		var err error
+		data[tx], err = CreateNavMeshData(straddlingTileParams(int32(tx)))
+		checkt(t, err)
+	}
+
+	// The path between the tiles polygons goes through the off-mesh
+	// connection, in both directions.
+	checkPath := func(mesh *NavMesh, order string) {
+		st, q := NewNavMeshQuery(mesh, 100)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		filter := NewStandardQueryFilter()
+		ext := d3.Vec3{0.5, 1, 0.5}
+		a, b := d3.Vec3{1, 0, 2}, d3.Vec3{7, 0, 2}
+		for _, pts := range [][2]d3.Vec3{{a, b}, {b, a}} {
+			_, orgRef, org := q.FindNearestPoly(pts[0], ext, filter)
+			_, dstRef, dst := q.FindNearestPoly(pts[1], ext, filter)
+			if orgRef == 0 || dstRef == 0 {
+				t.Fatalf("%s, no polygon found at %v or %v", order, pts[0], pts[1])
+			}
+
+			path := make([]PolyRef, 10)
+			n, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+			if StatusFailed(st) || st&PartialResult != 0 {
+				t.Errorf("%s, FindPath(%v, %v) status 0x%x, want a complete path", order, pts[0], pts[1], uint32(st))
+				continue
+			}
+			if n != 3 {
+				t.Errorf("%s, FindPath(%v, %v) = %v, want 3 polygons", order, pts[0], pts[1], path[:n])
+				continue
+			}
+			var (
+				tile *MeshTile
+				poly *Poly
+			)
+			mesh.TileAndPolyByRefUnsafe(path[1], &tile, &poly)
+			if poly.Type() != polyTypeOffMeshConnection {
+				t.Errorf("%s, FindPath(%v, %v) does not use the off-mesh connection", order, pts[0], pts[1])
+			}
+		}
+	}
+
+	for _, order := range [][]int{{0, 1}, {1, 0}} {
+		var mesh NavMesh
+		st := mesh.Init(&NavMeshParams{TileWidth: 4, TileHeight: 4, MaxTiles: 4, MaxPolys: 4})
+		if StatusFailed(st) {
+			t.Fatalf("Init failed with status 0x%x", uint32(st))
+		}
+		for _, tx := range order {
+			if st, _ := mesh.AddTile(data[tx], 0); StatusFailed(st) {
+				t.Fatalf("AddTile(%d) failed with status 0x%x", tx, uint32(st))
+			}
+		}
+		checkPath(&mesh, fmt.Sprintf("load order %v", order))
+
+		// Reloading the landing tile restores the link.
+		if _, st := mesh.RemoveTile(mesh.TileRefAt(1, 0, 0)); StatusFailed(st) {
+			t.Fatalf("RemoveTile failed with status 0x%x", uint32(st))
+		}
+		if st, _ := mesh.AddTile(data[1], 0); StatusFailed(st) {
+			t.Fatalf("AddTile failed with status 0x%x", uint32(st))
+		}
+		checkPath(&mesh, fmt.Sprintf("load order %v, reloaded", order))
+	}
+}
+
+func TestOffMeshConnectionCost(t *testing.T) {
+	// U-shaped corridor: x in [0, 1] and [4, 5], joined at z in [4, 5]. The
+	// off-mesh connection jumps across the U.
+	params := &NavMeshCreateParams{
+		Verts: []uint16{
+			0, 0, 0, 0, 0, 10, 2, 0, 10, 2, 0, 8, 2, 0, 0,
+			8, 0, 10, 8, 0, 8,
+			8, 0, 0, 10, 0, 10, 10, 0, 0,
+		},
+		VertCount: 10,
+		Polys: []uint16{
+			0, 1, 2, 3, 4, 0xffff, 0x800f, 0x800f, 1, 0x800f, 0x800f, 0,
+			3, 2, 5, 6, 0xffff, 0xffff, 0, 0x800f, 2, 0x800f, 0, 0,
+			7, 6, 5, 8, 9, 0xffff, 0x800f, 1, 0x800f, 0x800f, 0x800f, 0,
+		},
+		PolyFlags:        []uint16{1, 1, 1},
+		PolyAreas:        []uint8{0, 0, 0},
+		PolyCount:        3,
+		Nvp:              6,
+		OffMeshConVerts:  []float32{0.5, 0, 0.5, 4.5, 0, 0.5},
+		OffMeshConRad:    []float32{0.5},
+		OffMeshConFlags:  []uint16{1},
+		OffMeshConAreas:  []uint8{0},
+		OffMeshConDir:    []uint8{1},
+		OffMeshConUserID: []uint32{1},
+		OffMeshConCount:  1,
+		WalkableHeight:   2,
+		WalkableRadius:   0.5,
+		WalkableClimb:    0.5,
+		BMax:             [3]float32{5, 2, 5},
+		Cs:               0.5,
+		Ch:               0.2,
+		BuildBvTree:      true,
+	}
+	data, err := CreateNavMeshData(params)
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	st, q := NewNavMeshQuery(&mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+
+	filter := NewStandardQueryFilter()
+	if cost := filter.OffMeshConnectionCost(); cost != 0 {
+		t.Fatalf("default off-mesh connection cost = %v, want 0", cost)
+	}
+	ext := d3.Vec3{0.5, 1, 0.5}
+	_, orgRef, org := q.FindNearestPoly(d3.Vec3{0.5, 0, 0.5}, ext, filter)
+	_, dstRef, dst := q.FindNearestPoly(d3.Vec3{4.5, 0, 0.5}, ext, filter)
+	if orgRef == 0 || dstRef == 0 {
+		t.Fatal("start or end polygon not found")
+	}
+
+	jumps := func(cost float32) bool {
+		filter.SetOffMeshConnectionCost(cost)
+		path := make([]PolyRef, 10)
+		n, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+		if st != Success {
+			t.Fatalf("off-mesh connection cost %v, FindPath status 0x%x, want 0x%x", cost, uint32(st), uint32(Success))
+		}
+		for _, ref := range path[:n] {
+			var (
+				tile *MeshTile
+				poly *Poly
+			)
+			mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+			if poly.Type() == polyTypeOffMeshConnection {
+				return true
+			}
+		}
+		if n != 3 {
+			t.Fatalf("off-mesh connection cost %v, path %#x, want to go through the 3 polygons", cost, path[:n])
+		}
+		return false
+	}
+
+	// The jump is 4 long, walking around the U is about 11 long.
+	for _, tt := range []struct {
+		cost  float32
+		jumps bool
+	}{
+		{0, true},
+		{5, true},
+		{10, false},
+		{100, false},
+	} {
+		if got := jumps(tt.cost); got != tt.jumps {
+			t.Errorf("off-mesh connection cost %v, path jumps = %t, want %t", tt.cost, got, tt.jumps)
+		}
+	}
+}
+
+// twoJumpsParams returns the creation parameters of a tile made of three
+// 1x1 square polygons in a row, along x, joined by two off-mesh
+// connections.
+func twoJumpsParams() *NavMeshCreateParams {
+	return &NavMeshCreateParams{
+		Verts: []uint16{
+			0, 0, 0, 0, 0, 2, 2, 0, 2, 2, 0, 0,
+			4, 0, 0, 4, 0, 2, 6, 0, 2, 6, 0, 0,
+			8, 0, 0, 8, 0, 2, 10, 0, 2, 10, 0, 0,
+		},
+		VertCount: 12,
+		Polys: []uint16{
+			0, 1, 2, 3, 0xffff, 0xffff, 0x800f, 0x800f, 0x800f, 0x800f, 0, 0,
+			4, 5, 6, 7, 0xffff, 0xffff, 0x800f, 0x800f, 0x800f, 0x800f, 0, 0,
+			8, 9, 10, 11, 0xffff, 0xffff, 0x800f, 0x800f, 0x800f, 0x800f, 0, 0,
+		},
+		PolyFlags:        []uint16{1, 1, 1},
+		PolyAreas:        []uint8{0, 0, 0},
+		PolyCount:        3,
+		Nvp:              6,
+		OffMeshConVerts:  []float32{0.8, 0, 0.5, 2.2, 0, 0.5, 2.8, 0, 0.5, 4.2, 0, 0.5},
+		OffMeshConRad:    []float32{0.3, 0.3},
+		OffMeshConFlags:  []uint16{1, 1},
+		OffMeshConAreas:  []uint8{0, 0},
+		OffMeshConDir:    []uint8{0, 0},
+		OffMeshConUserID: []uint32{1, 2},
+		OffMeshConCount:  2,
+		WalkableHeight:   2,
+		WalkableRadius:   0.5,
+		WalkableClimb:    0.5,
+		BMax:             [3]float32{5, 2, 1},
+		Cs:               0.5,
+		Ch:               0.2,
+		BuildBvTree:      true,
+	}
+}
+
+func TestStraightPathStopAtOffMesh(t *testing.T) {
+	params := twoJumpsParams()
+	data, err := CreateNavMeshData(params)
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	st, q := NewNavMeshQuery(&mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+
+	filter := NewStandardQueryFilter()
+	ext := d3.Vec3{0.2, 1, 0.2}
+	_, orgRef, org := q.FindNearestPoly(d3.Vec3{0.2, 0, 0.5}, ext, filter)
+	_, dstRef, dst := q.FindNearestPoly(d3.Vec3{4.8, 0, 0.5}, ext, filter)
+	path := make([]PolyRef, 10)
+	n, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+	if st != Success || n != 5 {
+		t.Fatalf("FindPath returned %#x and status 0x%x, want 5 polygons", path[:n], uint32(st))
+	}
+	path = path[:n]
+
+	straight := make([]d3.Vec3, 10)
+	for i := range straight {
+		straight[i] = d3.NewVec3()
+	}
+	flags := make([]uint8, 10)
+	refs := make([]PolyRef, 10)
+	countJumps := func(cnt int) int {
+		var jumps int
+		for _, f := range flags[:cnt] {
+			if f&StraightPathOffMeshConnection != 0 {
+				jumps++
+			}
+		}
+		return jumps
+	}
+
+	cnt, st := q.FindStraightPath(org, dst, path, straight, flags, refs, 0)
+	if st != Success || countJumps(cnt) != 2 {
+		t.Fatalf("FindStraightPath returned %d points, flags %v, status 0x%x, want 2 jumps", cnt, flags[:cnt], uint32(st))
+	}
+
+	cnt, st = q.FindStraightPath(org, dst, path, straight, flags, refs, int32(StraightPathStopAtOffMesh))
+	if st != Success|PartialResult {
+		t.Errorf("with StraightPathStopAtOffMesh, status 0x%x, want 0x%x", uint32(st), uint32(Success|PartialResult))
+	}
+	if cnt != 2 || countJumps(cnt) != 1 || flags[cnt-1] != StraightPathOffMeshConnection {
+		t.Fatalf("with StraightPathStopAtOffMesh, got %d points, flags %v, want to end at the first jump", cnt, flags[:cnt])
+	}
+	if refs[cnt-1] != path[1] {
+		t.Errorf("last point ref = 0x%x, want the first off-mesh connection 0x%x", refs[cnt-1], path[1])
+	}
+	if want := (d3.Vec3{0.8, 0, 0.5}); !straight[cnt-1].Approx(want) {
+		t.Errorf("last point = %v, want the off-mesh connection start %v", straight[cnt-1], want)
+	}
+
+	// The rest of the path, from the end of the first jump, stops at the
+	// second one.
+	cnt, st = q.FindStraightPath(d3.Vec3{2.2, 0, 0.5}, dst, path[2:], straight, flags, refs, int32(StraightPathStopAtOffMesh))
+	if st != Success|PartialResult || refs[cnt-1] != path[3] {
+		t.Errorf("from the first jump end, got refs %#x and status 0x%x, want to stop at 0x%x", refs[:cnt], uint32(st), path[3])
+	}
+}
+
+func TestForEachOffMeshConnection(t *testing.T) {
+	var mesh NavMesh
+	st := mesh.Init(&NavMeshParams{TileWidth: 4, TileHeight: 4, MaxTiles: 4, MaxPolys: 4})
+	if StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", uint32(st))
+	}
+	for tx := int32(0); tx < 2; tx++ {
+		params := straddlingTileParams(tx)
+		params.OffMeshConAreas[0] = 3
+		params.OffMeshConFlags[0] = 0x10
+		params.OffMeshConUserID[0] = 42
+		data, err := CreateNavMeshData(params)
+		checkt(t, err)
+		if st, _ := mesh.AddTile(data, 0); StatusFailed(st) {
+			t.Fatalf("AddTile(%d) failed with status 0x%x", tx, uint32(st))
+		}
+	}
+
+	// The connection is only stored in the tile holding its start.
+	var n int
+	mesh.ForEachOffMeshConnection(func(start, end d3.Vec3, bidir bool, area uint8, flags uint16, userID uint32) bool {
+		n++
+		if !start.Approx(d3.Vec3{2.5, 0, 2}) || !end.Approx(d3.Vec3{6, 0, 2}) {
+			t.Errorf("connection from %v to %v, want from [2.5 0 2] to [6 0 2]", start, end)
+		}
+		if !bidir || area != 3 || flags != 0x10 || userID != 42 {
+			t.Errorf("got bidir %t, area %d, flags 0x%x, user id %d, want true, 3, 0x10, 42", bidir, area, flags, userID)
+		}
+		return true
+	})
+	if n != 1 {
+		t.Errorf("visited %d connections, want 1", n)
+	}
+
+	data, err := CreateNavMeshData(twoJumpsParams())
+	checkt(t, err)
+	var jumps NavMesh
+	if st := jumps.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	var ids []uint32
+	jumps.ForEachOffMeshConnection(func(start, end d3.Vec3, bidir bool, area uint8, flags uint16, userID uint32) bool {
+		if bidir {
+			t.Errorf("connection %d is bidirectional", userID)
+		}
+		ids = append(ids, userID)
+		return true
+	})
+	if !reflect.DeepEqual(ids, []uint32{1, 2}) {
+		t.Errorf("visited connections %v, want [1 2]", ids)
+	}
+
+	// Returning false stops the iteration.
+	n = 0
+	jumps.ForEachOffMeshConnection(func(start, end d3.Vec3, bidir bool, area uint8, flags uint16, userID uint32) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("visited %d connections after returning false, want 1", n)
+	}
+}
+
+func TestFindNearestOffMeshConnection(t *testing.T) {
+	newQuery := func(params *NavMeshCreateParams) *NavMeshQuery {
+		data, err := CreateNavMeshData(params)
+		checkt(t, err)
+		var mesh NavMesh
+		if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+			t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+		}
+		st, q := NewNavMeshQuery(&mesh, 100)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		return q
+	}
+
+	// Jumps from x=0.8 to 2.2 and from x=2.8 to 4.2, the first one being
+	// bidirectional in the second mesh.
+	params := twoJumpsParams()
+	params.OffMeshConFlags[1] = 2
+	q := newQuery(params)
+	params = twoJumpsParams()
+	params.OffMeshConFlags[1] = 2
+	params.OffMeshConDir[0] = 1
+	bidirq := newQuery(params)
+
+	filter := NewStandardQueryFilter()
+	noJump2 := NewStandardQueryFilter()
+	noJump2.SetExcludeFlags(2)
+	tests := []struct {
+		msg        string
+		q          *NavMeshQuery
+		pos        d3.Vec3
+		radius     float32
+		filter     QueryFilter
+		start, end d3.Vec3 // nil if none is found
+	}{
+		{"near a start", q, d3.Vec3{1, 0, 0.5}, 1, filter, d3.Vec3{0.8, 0, 0.5}, d3.Vec3{2.2, 0, 0.5}},
+		{"near the end of a one-way jump", q, d3.Vec3{2.3, 0, 0.5}, 1, filter, d3.Vec3{2.8, 0, 0.5}, d3.Vec3{4.2, 0, 0.5}},
+		{"near the end of a bidirectional jump", bidirq, d3.Vec3{2.3, 0, 0.5}, 1, filter, d3.Vec3{2.2, 0, 0.5}, d3.Vec3{0.8, 0, 0.5}},
+		{"filtered out", q, d3.Vec3{2.3, 0, 0.5}, 1, noJump2, nil, nil},
+		{"out of radius", q, d3.Vec3{1.5, 0, 0.5}, 0.5, filter, nil, nil},
+		{"radius included", q, d3.Vec3{1.3, 0, 0.5}, 0.5, filter, d3.Vec3{0.8, 0, 0.5}, d3.Vec3{2.2, 0, 0.5}},
+	}
+	for _, tt := range tests {
+		start, end, ref, st := tt.q.FindNearestOffMeshConnection(tt.pos, tt.radius, tt.filter)
+		if st != Success {
+			t.Errorf("%s: got status 0x%x, want 0x%x", tt.msg, uint32(st), uint32(Success))
+			continue
+		}
+		if tt.start == nil {
+			if ref != 0 || start != nil || end != nil {
+				t.Errorf("%s: got 0x%x from %v to %v, want none", tt.msg, ref, start, end)
+			}
+			continue
+		}
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(tt.q.nav.TileAndPolyByRef(ref, &tile, &poly)) || poly.Type() != polyTypeOffMeshConnection {
+			t.Errorf("%s: got ref 0x%x, want an off-mesh connection", tt.msg, ref)
+			continue
+		}
+		if !start.Approx(tt.start) || !end.Approx(tt.end) {
+			t.Errorf("%s: got from %v to %v, want from %v to %v", tt.msg, start, end, tt.start, tt.end)
+		}
+	}
+
+	if _, _, _, st := q.FindNearestOffMeshConnection(d3.Vec3{1, 0, 0.5}, -1, filter); st != Failure|InvalidParam {
+		t.Errorf("with a negative radius, got status 0x%x, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+	}
+}
+
+func TestArePolysAdjacent(t *testing.T) {
+	data, err := CreateNavMeshData(twoJumpsParams())
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+
+	// Polygons 0, 1 and 2 are the squares, 3 and 4 the one-way connections
+	// from 0 to 1 and from 1 to 2.
+	base := mesh.polyRefBase(&mesh.Tiles[0])
+	for _, tt := range []struct {
+		a, b PolyRef
+		want bool
+	}{
+		{0, 3, true},
+		{3, 1, true},
+		{1, 4, true},
+		{4, 2, true},
+		{0, 1, false},
+		{0, 4, false},
+		{3, 4, false},
+		{0, 0, false},
+	} {
+		a, b := base|tt.a, base|tt.b
+		if got := mesh.ArePolysAdjacent(a, b); got != tt.want {
+			t.Errorf("ArePolysAdjacent(%d, %d) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+		if got := mesh.ArePolysAdjacent(b, a); got != tt.want {
+			t.Errorf("ArePolysAdjacent(%d, %d) = %t, want %t", tt.b, tt.a, got, tt.want)
+		}
+	}
+	if mesh.ArePolysAdjacent(0, base|3) || mesh.ArePolysAdjacent(base|3, base|10) {
+		t.Errorf("ArePolysAdjacent = true with an invalid ref")
+	}
+
+	// Consecutive path polygons are adjacent, across tile portals too.
+	mesh2, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh2, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	ext := d3.Vec3{1, 4, 1}
+	_, orgRef, org := q.FindNearestPoly(d3.Vec3{5, 0, 5}, ext, filter)
+	_, dstRef, dst := q.FindNearestPoly(d3.Vec3{30, 0, 20}, ext, filter)
+	path := make([]PolyRef, 100)
+	n, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+	if StatusFailed(st) || n < 2 {
+		t.Fatalf("FindPath returned %#x and status 0x%x", path[:n], uint32(st))
+	}
+	var crossed bool
+	for i := 1; i < n; i++ {
+		if !mesh2.ArePolysAdjacent(path[i-1], path[i]) {
+			t.Errorf("path polygons 0x%x and 0x%x are not adjacent", path[i-1], path[i])
+		}
+		crossed = crossed || mesh2.decodePolyIDTile(path[i-1]) != mesh2.decodePolyIDTile(path[i])
+	}
+	if !crossed {
+		t.Errorf("path %#x does not cross any tile portal", path[:n])
 	}
 }
@@ -1,12 +1,19 @@
 package detour
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
 )
 
 func checkt(t *testing.T, err error) {
@@ -232,3 +239,1452 @@ func TestFindPathSpecialCases(t *testing.T) {
 		}
 	}
 }
+
+func TestFindPathSamePoly(t *testing.T) {
+	mesh := denseDetailMesh(t)
+	st, query := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	ref := mesh.polyRefBase(&mesh.Tiles[0])
+
+	tests := []struct {
+		msg      string
+		org, dst d3.Vec3
+		want     int // number of straight path points
+	}{
+		{"different positions", d3.Vec3{0.5, 0, 0.5}, d3.Vec3{1.5, 0, 1.2}, 2},
+		{"same position", d3.Vec3{0.5, 0, 0.5}, d3.Vec3{0.5, 0, 0.5}, 1},
+	}
+	for _, tt := range tests {
+		// The path is the polygon alone, without any search.
+		query.EnablePathTrace(true)
+		path := make([]PolyRef, 10)
+		costs := make([]float32, 10)
+		n, st := query.FindPathDetailed(ref, ref, tt.org, tt.dst, filter, path, costs)
+		if st != Success || n != 1 || path[0] != ref || costs[0] != 0 {
+			t.Errorf("%s: got path %v, costs %v, status 0x%x, want [0x%x], [0], 0x%x",
+				tt.msg, path[:n], costs[:n], uint32(st), ref, uint32(Success))
+		}
+		if tr := query.LastPathTrace(); len(tr) != 0 {
+			t.Errorf("%s: %d nodes expanded, want none", tt.msg, len(tr))
+		}
+		query.EnablePathTrace(false)
+
+		// The straight path goes from the start to the end position.
+		straight := make([]d3.Vec3, 10)
+		for i := range straight {
+			straight[i] = d3.NewVec3()
+		}
+		flags := make([]uint8, 10)
+		refs := make([]PolyRef, 10)
+		count, st := query.FindStraightPath(tt.org, tt.dst, path[:1], straight, flags, refs, int32(StraightPathEndRef))
+		if st != Success || count != tt.want {
+			t.Fatalf("%s: got %d straight path points, status 0x%x, want %d, 0x%x",
+				tt.msg, count, uint32(st), tt.want, uint32(Success))
+		}
+		if !straight[0].Approx(tt.org) || !straight[count-1].Approx(tt.dst) {
+			t.Errorf("%s: straight path goes from %v to %v, want from %v to %v",
+				tt.msg, straight[0], straight[count-1], tt.org, tt.dst)
+		}
+		if want := StraightPathStart; count == 2 && flags[0] != want {
+			t.Errorf("%s: got start flags 0x%x, want 0x%x", tt.msg, flags[0], want)
+		}
+		if want := StraightPathEnd; flags[count-1]&want == 0 {
+			t.Errorf("%s: got end flags 0x%x, want 0x%x", tt.msg, flags[count-1], want)
+		}
+		for i := 0; i < count; i++ {
+			if refs[i] != ref {
+				t.Errorf("%s: got point %d ref 0x%x, want 0x%x", tt.msg, i, refs[i], ref)
+			}
+		}
+	}
+}
+
+func TestStraightPathCost(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	path := make([]PolyRef, 100)
+	pathCount, st := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if StatusFailed(st) {
+		t.Fatalf("query.FindPath failed with 0x%x\n", st)
+	}
+	path = path[:pathCount]
+
+	straightPath := make([]d3.Vec3, 100)
+	for i := range straightPath {
+		straightPath[i] = d3.NewVec3()
+	}
+	straightPathRefs := make([]PolyRef, 100)
+	count, st := query.FindStraightPath(org, dst, path, straightPath, nil, straightPathRefs, int32(StraightPathAreaCrossings))
+	if StatusFailed(st) {
+		t.Fatalf("query.FindStraightPath failed with 0x%x\n", st)
+	}
+	straightPath = straightPath[:count]
+	straightPathRefs = straightPathRefs[:count]
+
+	var length float32
+	for i := 0; i+1 < count; i++ {
+		length += straightPath[i].Dist(straightPath[i+1])
+	}
+
+	// With unit area costs, the cost is the euclidean length.
+	if got := query.StraightPathCost(path, straightPath, straightPathRefs, filter); math32.Abs(got-length) > 1e-3 {
+		t.Errorf("got cost %f with unit area costs, want %f", got, length)
+	}
+
+	// Doubling all area costs doubles the cost.
+	for i := int32(0); i < maxAreas; i++ {
+		filter.SetAreaCost(i, 2)
+	}
+	if got := query.StraightPathCost(path, straightPath, straightPathRefs, filter); math32.Abs(got-2*length) > 1e-3 {
+		t.Errorf("got cost %f with doubled area costs, want %f", got, 2*length)
+	}
+}
+
+func TestPathCostAreas(t *testing.T) {
+	data, err := CreateNavMeshData(sliverParams())
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	tile := &mesh.Tiles[0]
+	tile.Polys[1].SetArea(1)
+	tile.Polys[2].SetArea(2)
+	base := mesh.polyRefBase(tile)
+
+	st, query := NewNavMeshQuery(&mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	filter.SetAreaCost(1, 3)
+	filter.SetAreaCost(2, 5)
+
+	org, dst := d3.Vec3{0.5, 0, 1}, d3.Vec3{3.5, 0, 1}
+	path := make([]PolyRef, 10)
+	costs := make([]float32, 10)
+	pathCount, st := query.FindPathDetailed(base, base|2, org, dst, filter, path, costs)
+	if StatusFailed(st) || pathCount != 3 {
+		t.Fatalf("FindPathDetailed returned %d polygons, status 0x%x, want 3", pathCount, uint32(st))
+	}
+	path = path[:pathCount]
+
+	// Each step is costed by the area of the polygon it leaves.
+	for i, want := range []float32{0, 1.5 * 1, 1.5*1 + 0.01*3 + 1.49*5} {
+		if math32.Abs(costs[i]-want) > 1e-3 {
+			t.Errorf("costs[%d] = %f, want %f", i, costs[i], want)
+		}
+	}
+
+	straight := make([]d3.Vec3, 10)
+	for i := range straight {
+		straight[i] = d3.NewVec3()
+	}
+	refs := make([]PolyRef, 10)
+	count, st := query.FindStraightPath(org, dst, path, straight, nil, refs, int32(StraightPathAreaCrossings))
+	if StatusFailed(st) || count != 4 {
+		t.Fatalf("FindStraightPath returned %d points, status 0x%x, want 4", count, uint32(st))
+	}
+
+	// Each segment is costed by the area of the polygon it lies in, the
+	// polygon entered at its first point, so the total is the one of
+	// FindPathDetailed.
+	got := query.StraightPathCost(path, straight[:count], refs[:count], filter)
+	if want := float32(1.5*1 + 0.01*3 + 1.49*5); math32.Abs(got-want) > 1e-3 {
+		t.Errorf("StraightPathCost = %f, want %f", got, want)
+	}
+}
+
+func TestFindStraightPathAreas(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	path := make([]PolyRef, 100)
+	pathCount, st := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if StatusFailed(st) || pathCount < 3 {
+		t.Fatalf("query.FindPath failed with 0x%x, or path is too short\n", st)
+	}
+	path = path[:pathCount]
+
+	// Change the area of the polygons of the second half of the path.
+	const area = 5
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	for _, ref := range path[pathCount/2:] {
+		mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+		poly.SetArea(area)
+	}
+
+	straightPath := make([]d3.Vec3, 100)
+	for i := range straightPath {
+		straightPath[i] = d3.NewVec3()
+	}
+	refs := make([]PolyRef, 100)
+	areas := make([]uint8, 100)
+	count, st := query.FindStraightPathAreas(org, dst, path, straightPath, nil, refs, areas, int32(StraightPathAreaCrossings))
+	if StatusFailed(st) {
+		t.Fatalf("query.FindStraightPathAreas failed with 0x%x\n", st)
+	}
+
+	for i := 0; i < count; i++ {
+		ref := refs[i]
+		if ref == 0 {
+			ref = path[pathCount-1]
+		}
+		mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+		if areas[i] != poly.Area() {
+			t.Errorf("point %d, got area %d, want %d", i, areas[i], poly.Area())
+		}
+	}
+	if areas[0] == area || areas[count-1] != area {
+		t.Errorf("got areas %v, want the path to start out of area %d, and end in it", areas[:count], area)
+	}
+
+	// Without refs, the areas are the same.
+	areas2 := make([]uint8, 100)
+	count2, _ := query.FindStraightPathAreas(org, dst, path, straightPath, nil, nil, areas2, int32(StraightPathAreaCrossings))
+	if !reflect.DeepEqual(areas[:count], areas2[:count2]) {
+		t.Errorf("without refs, got areas %v, want %v", areas2[:count2], areas[:count])
+	}
+
+	if _, st := query.FindStraightPathAreas(org, dst, path, straightPath, nil, refs, areas[:1], 0); !StatusFailed(st) {
+		t.Errorf("want failure with a too small areas slice, got 0x%x", uint32(st))
+	}
+}
+
+func TestFindStraightPathBufferTooSmall(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	path := make([]PolyRef, 100)
+	pathCount, st := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if StatusFailed(st) {
+		t.Fatalf("query.FindPath failed with 0x%x\n", st)
+	}
+	path = path[:pathCount]
+
+	straightPath := func(n int) []d3.Vec3 {
+		s := make([]d3.Vec3, n)
+		for i := range s {
+			s[i] = d3.NewVec3()
+		}
+		return s
+	}
+
+	// Adding a vertex at each crossing gives more vertices than corners.
+	options := int32(StraightPathAllCrossings)
+	full, st := query.FindStraightPath(org, dst, path, straightPath(100), nil, nil, options)
+	if st != Success {
+		t.Fatalf("query.FindStraightPath returned status 0x%x, want 0x%x", st, Success)
+	}
+
+	// An exactly fitting buffer is not too small.
+	count, st := query.FindStraightPath(org, dst, path, straightPath(full), nil, nil, options)
+	if st != Success || count != full {
+		t.Errorf("with exact buffer, got (%d, 0x%x), want (%d, 0x%x)", count, st, full, Success)
+	}
+
+	// A smaller buffer truncates the path.
+	for _, n := range []int{1, full / 2, full - 1} {
+		count, st = query.FindStraightPath(org, dst, path, straightPath(n), nil, nil, options)
+		if !StatusSucceed(st) || !StatusDetail(st, BufferTooSmall) {
+			t.Errorf("with buffer of %d, got status 0x%x, want BufferTooSmall detail", n, st)
+		}
+		if count != n {
+			t.Errorf("with buffer of %d, got %d vertices, want %d", n, count, n)
+		}
+	}
+}
+
+func TestFindStraightPathFrom(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	ext := d3.Vec3{1, 4, 1}
+	org, dst := d3.Vec3{5, 0, 5}, d3.Vec3{30, 0, 20}
+	_, orgRef, orgPt := q.FindNearestPoly(org, ext, filter)
+	_, dstRef, dstPt := q.FindNearestPoly(dst, ext, filter)
+	path := make([]PolyRef, 256)
+	npath, st := q.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if StatusFailed(st) {
+		t.Fatalf("FindPath failed with status 0x%x", uint32(st))
+	}
+	path = path[:npath]
+
+	buffers := func(n int) ([]d3.Vec3, []uint8, []PolyRef) {
+		pts := make([]d3.Vec3, n)
+		for i := range pts {
+			pts[i] = d3.NewVec3()
+		}
+		return pts, make([]uint8, n), make([]PolyRef, n)
+	}
+	wantPts, wantFlags, wantRefs := buffers(256)
+	nwant, st := q.FindStraightPath(orgPt, dstPt, path, wantPts, wantFlags, wantRefs, 0)
+	if st != Success || nwant < 4 {
+		t.Fatalf("FindStraightPath = %d points, status 0x%x, want a few points", nwant, uint32(st))
+	}
+
+	for _, n := range []int{2, 3, 5, nwant + 1} {
+		var (
+			gotPts   []d3.Vec3
+			gotFlags []uint8
+			gotRefs  []PolyRef
+			nchunks  int
+		)
+		pts, flags, refs := buffers(n)
+		idx, pos := 0, d3.NewVec3From(orgPt)
+		for {
+			count, next, st := q.FindStraightPathFrom(path, idx, pos, dstPt, pts, flags, refs, 0)
+			if StatusFailed(st) {
+				t.Fatalf("buffer of %d: chunk %d failed with status 0x%x", n, nchunks, uint32(st))
+			}
+			nchunks++
+			for i := 0; i < count; i++ {
+				gotPts = append(gotPts, d3.NewVec3From(pts[i]))
+			}
+			gotFlags = append(gotFlags, flags[:count]...)
+			gotRefs = append(gotRefs, refs[:count]...)
+			if next == -1 {
+				break
+			}
+			if st != Success {
+				t.Errorf("buffer of %d: chunk %d got status 0x%x, want success", n, nchunks, uint32(st))
+			}
+			if count == 0 || next < idx || nchunks > nwant {
+				t.Fatalf("buffer of %d: chunk %d made no progress (%d points, next index %d)", n, nchunks, count, next)
+			}
+			idx, pos = next, d3.NewVec3From(pts[count])
+		}
+
+		if len(gotPts) != nwant {
+			t.Fatalf("buffer of %d: %d points in %d chunks, want %d", n, len(gotPts), nchunks, nwant)
+		}
+		for i := range gotPts {
+			if !gotPts[i].Approx(wantPts[i]) || gotFlags[i] != wantFlags[i] || gotRefs[i] != wantRefs[i] {
+				t.Errorf("buffer of %d: point %d = %v, 0x%x, 0x%x, want %v, 0x%x, 0x%x", n, i,
+					gotPts[i], gotFlags[i], gotRefs[i], wantPts[i], wantFlags[i], wantRefs[i])
+			}
+		}
+		if n > nwant && nchunks != 1 {
+			t.Errorf("buffer of %d: %d chunks, want 1", n, nchunks)
+		}
+	}
+
+	pts, flags, refs := buffers(1)
+	if _, _, st := q.FindStraightPathFrom(path, 0, orgPt, dstPt, pts, flags, refs, 0); !StatusFailed(st) {
+		t.Errorf("buffer of 1: got status 0x%x, want a failure", uint32(st))
+	}
+	pts, flags, refs = buffers(4)
+	if _, _, st := q.FindStraightPathFrom(path, len(path), orgPt, dstPt, pts, flags, refs, 0); !StatusFailed(st) {
+		t.Errorf("start index out of the path: got status 0x%x, want a failure", uint32(st))
+	}
+}
+
+// sliverParams returns the creation parameters of a tile made of three
+// polygons in a row along x, the middle one being a sliver 0.01 unit wide,
+// so that the crossings of its edges are nearly equal.
+func sliverParams() *NavMeshCreateParams {
+	return &NavMeshCreateParams{
+		Verts: []uint16{
+			0, 0, 0, 0, 0, 200, 200, 0, 200, 200, 0, 0,
+			201, 0, 200, 201, 0, 0, 400, 0, 200, 400, 0, 0,
+		},
+		VertCount: 8,
+		Polys: []uint16{
+			0, 1, 2, 3, 0x800f, 0x800f, 1, 0x800f,
+			3, 2, 4, 5, 0, 0x800f, 2, 0x800f,
+			5, 4, 6, 7, 1, 0x800f, 0x800f, 0x800f,
+		},
+		PolyFlags:      []uint16{1, 1, 1},
+		PolyAreas:      []uint8{0, 0, 0},
+		PolyCount:      3,
+		Nvp:            4,
+		WalkableHeight: 2,
+		WalkableRadius: 0.5,
+		WalkableClimb:  0.5,
+		BMax:           [3]float32{4, 2, 2},
+		Cs:             0.01,
+		Ch:             0.2,
+		BuildBvTree:    true,
+	}
+}
+
+func TestStraightPathEpsilon(t *testing.T) {
+	data, err := CreateNavMeshData(sliverParams())
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	st, q := NewNavMeshQuery(&mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+
+	base := mesh.polyRefBase(&mesh.Tiles[0])
+	path := []PolyRef{base, base | 1, base | 2}
+	org, dst := d3.Vec3{0.5, 0, 1}, d3.Vec3{3.5, 0, 1}
+	straight := make([]d3.Vec3, 10)
+	for i := range straight {
+		straight[i] = d3.NewVec3()
+	}
+	flags := make([]uint8, 10)
+	refs := make([]PolyRef, 10)
+
+	for _, tt := range []struct {
+		eps  float32
+		xs   []float32
+		refs []PolyRef
+	}{
+		// By default, the crossings of the sliver edges are both kept.
+		{0, []float32{0.5, 2, 2.01, 3.5}, []PolyRef{base, base | 1, base | 2, 0}},
+		{0.001, []float32{0.5, 2, 2.01, 3.5}, []PolyRef{base, base | 1, base | 2, 0}},
+		{0.05, []float32{0.5, 2, 3.5}, []PolyRef{base, base | 2, 0}},
+		// The start and the end are never merged away.
+		{10, []float32{0.5, 3.5}, []PolyRef{base, 0}},
+	} {
+		q.SetStraightPathEpsilon(tt.eps)
+		n, st := q.FindStraightPath(org, dst, path, straight, flags, refs, int32(StraightPathAllCrossings))
+		if st != Success {
+			t.Fatalf("eps %v, FindStraightPath returned status 0x%x", tt.eps, uint32(st))
+		}
+		if n != len(tt.xs) {
+			t.Errorf("eps %v, got %d vertices %v, want %d", tt.eps, n, straight[:n], len(tt.xs))
+			continue
+		}
+		for i, x := range tt.xs {
+			if !math32.Approx(straight[i][0], x) || refs[i] != tt.refs[i] {
+				t.Errorf("eps %v, vertex %d = %v in 0x%x, want x = %v in 0x%x", tt.eps, i, straight[i], refs[i], x, tt.refs[i])
+			}
+		}
+		if flags[0] != StraightPathStart || flags[n-1] != StraightPathEnd {
+			t.Errorf("eps %v, got flags %v, want start and end flags", tt.eps, flags[:n])
+		}
+	}
+
+	q.SetStraightPathEpsilon(-1)
+	if eps := q.StraightPathEpsilon(); eps != 0 {
+		t.Errorf("negative epsilon set to %v, want 0", eps)
+	}
+}
+
+func TestFindPathDetailed(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	want := make([]PolyRef, 100)
+	wantCount, _ := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, want)
+
+	path := make([]PolyRef, 100)
+	costs := make([]float32, 100)
+	pathCount, st := query.FindPathDetailed(orgRef, dstRef, orgPt, dstPt, filter, path, costs)
+	if StatusFailed(st) {
+		t.Fatalf("query.FindPathDetailed failed with 0x%x\n", st)
+	}
+	if !reflect.DeepEqual(want[:wantCount], path[:pathCount]) {
+		t.Fatalf("FindPathDetailed path is %#v, want %#v", path[:pathCount], want[:wantCount])
+	}
+
+	if costs[0] != 0 {
+		t.Errorf("costs[0] = %f, want 0", costs[0])
+	}
+	for i := 1; i < pathCount; i++ {
+		if costs[i] <= costs[i-1] {
+			t.Errorf("costs[%d] = %f, want more than costs[%d] = %f", i, costs[i], i-1, costs[i-1])
+		}
+	}
+	// With unit area costs, the path can't be shorter than a straight line.
+	if last := costs[pathCount-1]; last < orgPt.Dist(dstPt) {
+		t.Errorf("total cost %f is less than the distance %f", last, orgPt.Dist(dstPt))
+	}
+
+	// Too short costs slice
+	if _, st = query.FindPathDetailed(orgRef, dstRef, orgPt, dstPt, filter, path, costs[:1]); st != Failure|InvalidParam {
+		t.Errorf("with short costs, got status 0x%x, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+	}
+}
+
+func TestSetHeuristicScale(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	if s := query.HeuristicScale(); s != HScale {
+		t.Fatalf("default heuristic scale is %f, want %f", s, HScale)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	path := make([]PolyRef, 100)
+	costs := make([]float32, 100)
+	prevNodes := int32(math.MaxInt32)
+	for _, s := range []float32{0, HScale, 2, 10} {
+		query.SetHeuristicScale(s)
+		n, st := query.FindPathDetailed(orgRef, dstRef, orgPt, dstPt, filter, path, costs)
+		if StatusFailed(st) || StatusDetail(st, PartialResult) {
+			t.Fatalf("scale %f: FindPathDetailed failed with 0x%x", s, uint32(st))
+		}
+		if path[n-1] != dstRef {
+			t.Fatalf("scale %f: path ends at 0x%x, want 0x%x", s, path[n-1], dstRef)
+		}
+		nodes := query.nodePool.nodeCount
+		if nodes > prevNodes {
+			t.Errorf("scale %f: %d nodes expanded, more than %d with a lower scale", s, nodes, prevNodes)
+		}
+		prevNodes = nodes
+	}
+}
+
+func TestSetMaxSearchNodes(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	if n := query.MaxSearchNodes(); n != 0 {
+		t.Fatalf("default max search nodes = %d, want 0", n)
+	}
+	filter := NewStandardQueryFilter()
+	extents := d3.NewVec3XYZ(1, 4, 1)
+	_, orgRef, org := query.FindNearestPoly(d3.Vec3{5, 0, 5}, extents, filter)
+	_, dstRef, dst := query.FindNearestPoly(d3.Vec3{30, 0, 20}, extents, filter)
+
+	want := make([]PolyRef, 100)
+	wantCount, st := query.FindPath(orgRef, dstRef, org, dst, filter, want)
+	if st != Success {
+		t.Fatalf("without limit, FindPath status 0x%x, want 0x%x", uint32(st), uint32(Success))
+	}
+
+	path := make([]PolyRef, 100)
+
+	// A large enough limit does not change the path.
+	query.SetMaxSearchNodes(1000)
+	n, st := query.FindPath(orgRef, dstRef, org, dst, filter, path)
+	if st != Success || !reflect.DeepEqual(path[:n], want[:wantCount]) {
+		t.Errorf("with a large limit, got path %#x and status 0x%x, want %#x and 0x%x", path[:n], uint32(st), want[:wantCount], uint32(Success))
+	}
+
+	// A small limit gives a partial path toward the end.
+	query.SetMaxSearchNodes(5)
+	n, st = query.FindPath(orgRef, dstRef, org, dst, filter, path)
+	if StatusFailed(st) || !StatusDetail(st, PartialResult) || !StatusDetail(st, OutOfNodes) {
+		t.Fatalf("with a small limit, got status 0x%x, want a partial result out of nodes", uint32(st))
+	}
+	if n < 2 || n >= wantCount || path[0] != orgRef {
+		t.Fatalf("with a small limit, got path %#x, want a partial path from 0x%x", path[:n], orgRef)
+	}
+
+	// The partial path is usable: it leads to the polygon the nearest to the
+	// end found so far.
+	last := d3.NewVec3()
+	if st := query.ClosestPointOnPoly(path[n-1], dst, last, nil); StatusFailed(st) {
+		t.Fatalf("ClosestPointOnPoly failed with status 0x%x", uint32(st))
+	}
+	straight := make([]d3.Vec3, 32)
+	for i := range straight {
+		straight[i] = d3.NewVec3()
+	}
+	flags := make([]uint8, len(straight))
+	refs := make([]PolyRef, len(straight))
+	ns, st := query.FindStraightPath(org, last, path[:n], straight, flags, refs, 0)
+	if StatusFailed(st) || ns < 2 {
+		t.Fatalf("FindStraightPath on the partial path failed with status 0x%x", uint32(st))
+	}
+	if l := last.Dist(dst); l >= org.Dist(dst) {
+		t.Errorf("partial path ends %f away from the end, not closer than the start", l)
+	}
+
+	query.SetMaxSearchNodes(-1)
+	if n := query.MaxSearchNodes(); n != 0 {
+		t.Errorf("max search nodes = %d after setting -1, want 0", n)
+	}
+}
+
+func TestSetQueryObserver(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	var ops []string
+	query.SetQueryObserver(func(op string, dur time.Duration) {
+		if dur < 0 {
+			t.Errorf("%s: negative duration %v", op, dur)
+		}
+		ops = append(ops, op)
+	})
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+	path := make([]PolyRef, 100)
+	n, _ := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	straight := make([]d3.Vec3, 100)
+	for i := range straight {
+		straight[i] = d3.NewVec3()
+	}
+	query.FindStraightPath(orgPt, dstPt, path[:n], straight, nil, nil, 0)
+
+	want := []string{"FindNearestPoly", "FindNearestPoly", "FindPath", "FindStraightPath"}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("observed %v, want %v", ops, want)
+	}
+
+	query.SetQueryObserver(nil)
+	query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if len(ops) != len(want) {
+		t.Errorf("observer called after having been removed")
+	}
+}
+
+func BenchmarkHeuristicScale(b *testing.B) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		b.Fatalf("query creation failed with status 0x%x\n", uint32(st))
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	path := make([]PolyRef, 256)
+	for _, s := range []float32{0, HScale, 1.5, 2, 5} {
+		b.Run(fmt.Sprintf("scale=%g", s), func(b *testing.B) {
+			query.SetHeuristicScale(s)
+			var nodes int32
+			for i := 0; i < b.N; i++ {
+				query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+				nodes = query.nodePool.nodeCount
+			}
+			b.ReportMetric(float64(nodes), "nodes/op")
+		})
+	}
+}
+
+func TestReachableSet(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	path := make([]PolyRef, 100)
+	costs := make([]float32, 100)
+	n, _ := query.FindPathDetailed(orgRef, dstRef, orgPt, dstPt, filter, path, costs)
+
+	// Reach the middle of the path.
+	maxCost := costs[n/2]
+	set, st := query.ReachableSet(orgRef, orgPt, maxCost, filter)
+	if st != Success {
+		t.Fatalf("ReachableSet returned status 0x%x, want Success", uint32(st))
+	}
+	if c, ok := set[orgRef]; !ok || c != 0 {
+		t.Errorf("start poly cost is %f (in set: %t), want 0", c, ok)
+	}
+	for ref, c := range set {
+		if c > maxCost {
+			t.Errorf("poly 0x%x cost %f is more than maxCost %f", ref, c, maxCost)
+		}
+	}
+	for i := 0; i < n; i++ {
+		c, ok := set[path[i]]
+		switch {
+		case costs[i] <= maxCost && !ok:
+			t.Errorf("path poly 0x%x (cost %f) is not in the set", path[i], costs[i])
+		case ok && c > costs[i]+1e-3:
+			t.Errorf("path poly 0x%x cost is %f, want at most %f", path[i], c, costs[i])
+		}
+	}
+	if _, ok := set[dstRef]; ok {
+		t.Errorf("end poly 0x%x should not be reachable", dstRef)
+	}
+
+	// Limited set holds the cheapest polys.
+	const limit = 5
+	lset, st := query.ReachableSetLimit(orgRef, orgPt, maxCost, filter, limit)
+	if !StatusSucceed(st) || !StatusDetail(st, BufferTooSmall) {
+		t.Fatalf("ReachableSetLimit returned status 0x%x, want BufferTooSmall", uint32(st))
+	}
+	if len(lset) != limit {
+		t.Fatalf("limited set has %d polys, want %d", len(lset), limit)
+	}
+	var maxIn float32
+	for ref, c := range lset {
+		if set[ref] != c {
+			t.Errorf("poly 0x%x cost %f in limited set, want %f", ref, c, set[ref])
+		}
+		maxIn = math32.Max(maxIn, c)
+	}
+	for ref, c := range set {
+		if _, ok := lset[ref]; !ok && c < maxIn {
+			t.Errorf("poly 0x%x (cost %f) is cheaper than limited set polys but missing", ref, c)
+		}
+	}
+
+	if _, st = query.ReachableSet(orgRef, orgPt, -1, filter); st != Failure|InvalidParam {
+		t.Errorf("with negative maxCost, got status 0x%x, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+	}
+}
+
+func TestCostField(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	_, orgRef, orgPt := query.FindNearestPoly(d3.Vec3{37.298489, -1.776901, 11.652311}, d3.NewVec3XYZ(2, 4, 2), filter)
+
+	const maxCost = 10
+	field := query.CostField(orgRef, orgPt, maxCost, filter)
+	set, _ := query.ReachableSet(orgRef, orgPt, maxCost, filter)
+	if len(field) < 2 || !reflect.DeepEqual(field, set) {
+		t.Fatalf("CostField returned %d polygons, want the %d of the reachable set", len(field), len(set))
+	}
+	if field := query.CostField(orgRef, orgPt, -1, filter); field != nil {
+		t.Errorf("CostField with negative maxCost = %v, want nil", field)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCostFieldGeoJSON(&buf, mesh, field, nil); err != nil {
+		t.Fatalf("WriteCostFieldGeoJSON failed: %v", err)
+	}
+	var fc struct {
+		Type     string
+		Features []struct {
+			Type     string
+			Geometry struct {
+				Type        string
+				Coordinates [][][2]float64
+			}
+			Properties struct {
+				Ref  string
+				Cost float32
+				Fill string
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid GeoJSON: %v", err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != len(field) {
+		t.Fatalf("got %s of %d features, want a FeatureCollection of %d", fc.Type, len(fc.Features), len(field))
+	}
+	for _, f := range fc.Features {
+		r, err := strconv.ParseUint(f.Properties.Ref, 0, 64)
+		checkt(t, err)
+		ref := PolyRef(r)
+		if f.Properties.Cost != field[ref] {
+			t.Errorf("poly 0x%x cost = %f, want %f", ref, f.Properties.Cost, field[ref])
+		}
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+		ring := f.Geometry.Coordinates[0]
+		if f.Geometry.Type != "Polygon" || len(ring) != int(poly.VertCount)+1 || ring[0] != ring[len(ring)-1] {
+			t.Errorf("poly 0x%x geometry is %s %v, want a closed ring of %d vertices", ref, f.Geometry.Type, ring, poly.VertCount)
+		}
+		if ref == orgRef && f.Properties.Fill != "#00ff00" {
+			t.Errorf("start poly fill = %s, want #00ff00", f.Properties.Fill)
+		}
+	}
+
+	// Projected coordinates.
+	buf.Reset()
+	scale := func(pos d3.Vec3) (float64, float64) { return 2 * float64(pos[0]), -float64(pos[2]) }
+	if err := WriteCostFieldGeoJSON(&buf, mesh, map[PolyRef]float32{orgRef: 0}, scale); err != nil {
+		t.Fatalf("WriteCostFieldGeoJSON failed: %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid GeoJSON: %v", err)
+	}
+	bmin, bmax, _ := mesh.PolyBounds(orgRef)
+	for _, pt := range fc.Features[0].Geometry.Coordinates[0] {
+		if pt[0] < 2*float64(bmin[0])-1e-3 || pt[0] > 2*float64(bmax[0])+1e-3 ||
+			pt[1] < -float64(bmax[2])-1e-3 || pt[1] > -float64(bmin[2])+1e-3 {
+			t.Errorf("projected point %v out of the projected polygon bounds", pt)
+		}
+	}
+}
+
+func TestCostFieldGeoJSONRefs(t *testing.T) {
+	data, err := CreateNavMeshData(sliverParams())
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTileWithLayout(data, 0, PolyRefLayoutTrinityCore); StatusFailed(st) {
+		t.Fatalf("InitForSingleTileWithLayout failed with status 0x%x", uint32(st))
+	}
+	base := mesh.polyRefBase(&mesh.Tiles[0])
+	if base <= math.MaxUint32 {
+		t.Fatalf("reference base 0x%x fits on 32 bits", base)
+	}
+
+	// 64 bits references are written in full.
+	field := map[PolyRef]float32{base: 0, base | 1: 1, base | 2: 2}
+	var buf bytes.Buffer
+	if err := WriteCostFieldGeoJSON(&buf, &mesh, field, nil); err != nil {
+		t.Fatalf("WriteCostFieldGeoJSON failed: %v", err)
+	}
+	var fc struct {
+		Features []struct {
+			Properties struct {
+				Ref  string
+				Cost float32
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid GeoJSON: %v", err)
+	}
+	if len(fc.Features) != len(field) {
+		t.Fatalf("got %d features, want %d", len(fc.Features), len(field))
+	}
+	for i, f := range fc.Features {
+		if want := fmt.Sprintf("0x%x", uint64(base|PolyRef(i))); f.Properties.Ref != want {
+			t.Errorf("feature %d ref = %s, want %s", i, f.Properties.Ref, want)
+		}
+		if f.Properties.Cost != float32(i) {
+			t.Errorf("feature %d cost = %f, want %d", i, f.Properties.Cost, i)
+		}
+	}
+}
+
+func TestFindPathDeterministic(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	want := make([]PolyRef, 256)
+	wantCount, _ := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, want)
+
+	path := make([]PolyRef, 256)
+	for i := 0; i < 100; i++ {
+		// Leave the query in a different state before each run.
+		if i%2 == 1 {
+			query.FindPath(dstRef, orgRef, dstPt, orgPt, filter, path)
+		}
+		n, st := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+		if StatusFailed(st) {
+			t.Fatalf("run %d: FindPath failed with 0x%x", i, uint32(st))
+		}
+		if !reflect.DeepEqual(path[:n], want[:wantCount]) {
+			t.Fatalf("run %d: path is %#v, want %#v", i, path[:n], want[:wantCount])
+		}
+	}
+}
+
+func TestPathTrace(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+	path := make([]PolyRef, 100)
+
+	if tr := query.LastPathTrace(); tr != nil {
+		t.Errorf("LastPathTrace() = %v when disabled, want nil", tr)
+	}
+
+	query.EnablePathTrace(true)
+	n, st := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if st != Success {
+		t.Fatalf("FindPath returned status 0x%x", uint32(st))
+	}
+	tr := query.LastPathTrace()
+	if len(tr) < n {
+		t.Fatalf("got %d traced nodes, want at least the %d path polys", len(tr), n)
+	}
+	if tr[0].Ref != orgRef || tr[0].Parent != -1 || tr[0].ParentRef != 0 || tr[0].Cost != 0 {
+		t.Errorf("first traced node = %+v, want the start node", tr[0])
+	}
+	for i, node := range tr[1:] {
+		if node.Parent < 0 || node.Parent > i || tr[node.Parent].Ref != node.ParentRef {
+			t.Fatalf("traced node %d has invalid parent %d (ref 0x%x)", i+1, node.Parent, node.ParentRef)
+		}
+		if node.Cost < tr[node.Parent].Cost || node.Heuristic < 0 {
+			t.Errorf("traced node %d has cost %v and heuristic %v, parent cost is %v",
+				i+1, node.Cost, node.Heuristic, tr[node.Parent].Cost)
+		}
+	}
+
+	// The search tree branch of the end node is the path.
+	var branch []PolyRef
+	for i := len(tr) - 1; i >= 0; i = tr[i].Parent {
+		branch = append([]PolyRef{tr[i].Ref}, branch...)
+	}
+	if !reflect.DeepEqual(branch, path[:n]) {
+		t.Errorf("traced branch of the end node is %v, want path %v", branch, path[:n])
+	}
+
+	query.EnablePathTrace(false)
+	query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if tr := query.LastPathTrace(); tr != nil {
+		t.Errorf("LastPathTrace() = %v after disabling, want nil", tr)
+	}
+}
+
+func TestLastSearchParents(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+	path := make([]PolyRef, 100)
+
+	query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if parents := query.LastSearchParents(); parents != nil {
+		t.Errorf("LastSearchParents() = %v when disabled, want nil", parents)
+	}
+
+	query.EnablePathTrace(true)
+	n, st := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+	if st != Success {
+		t.Fatalf("FindPath returned status 0x%x", uint32(st))
+	}
+	parents := query.LastSearchParents()
+	if len(parents) <= n {
+		t.Errorf("got %d polys in the search tree, want more than the %d path polys", len(parents), n)
+	}
+	if parent, ok := parents[orgRef]; !ok || parent != 0 {
+		t.Errorf("parent of the start poly = 0x%x (present: %v), want 0", parent, ok)
+	}
+	for i := 1; i < n; i++ {
+		if parents[path[i]] != path[i-1] {
+			t.Errorf("parent of path poly %d = 0x%x, want 0x%x", i, parents[path[i]], path[i-1])
+		}
+	}
+	for ref, parent := range parents {
+		if _, ok := parents[parent]; parent != 0 && !ok {
+			t.Errorf("parent 0x%x of poly 0x%x is not in the search tree", parent, ref)
+		}
+	}
+
+	// The next search starts a new tree.
+	query.FindPath(orgRef, orgRef, orgPt, orgPt, filter, path)
+	if want := map[PolyRef]PolyRef{orgRef: 0}; !reflect.DeepEqual(query.LastSearchParents(), want) {
+		t.Errorf("LastSearchParents() = %v after a single poly search, want %v", query.LastSearchParents(), want)
+	}
+}
+
+func TestSimplifyPath(t *testing.T) {
+	// Nearly straight on the xz-plane, whatever the heights.
+	straight := []d3.Vec3{{0, 0, 0}, {1, 1, 0.05}, {2, 0.5, -0.05}, {3, 2, 0.02}, {4, 0, 0}}
+	zigzag := []d3.Vec3{{0, 0, 0}, {1, 0, 1}, {2, 0, 0}, {3, 0, 1}, {4, 0, 0}}
+
+	simplifyTests := []struct {
+		msg     string
+		path    []d3.Vec3
+		epsilon float32
+		want    []d3.Vec3
+	}{
+		{"empty", nil, 0.1, nil},
+		{"single point", straight[:1], 0.1, straight[:1]},
+		{"nearly straight", straight, 0.1, []d3.Vec3{straight[0], straight[4]}},
+		{"nearly straight, tiny epsilon", straight, 0.01, straight},
+		{"zigzag", zigzag, 0.5, zigzag},
+		{"zigzag, large epsilon", zigzag, 2, []d3.Vec3{zigzag[0], zigzag[4]}},
+	}
+	for _, tt := range simplifyTests {
+		got := SimplifyPath(tt.path, tt.epsilon)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s, SimplifyPath() = %v, want %v", tt.msg, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !got[i].Approx(tt.want[i]) {
+				t.Errorf("%s, SimplifyPath() = %v, want %v", tt.msg, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestClosestPointOnPath(t *testing.T) {
+	path := []d3.Vec3{{0, 0, 0}, {4, 0, 0}, {4, 0, 4}, {4, 2, 8}}
+
+	closestTests := []struct {
+		msg    string
+		path   []d3.Vec3
+		pos    d3.Vec3
+		want   d3.Vec3
+		segIdx int
+		t      float32
+	}{
+		{"on first segment", path, d3.Vec3{1, 0, 1}, d3.Vec3{1, 0, 0}, 0, 0.25},
+		{"before start", path, d3.Vec3{-2, 1, 0}, d3.Vec3{0, 0, 0}, 0, 0},
+		{"on second segment", path, d3.Vec3{6, 0, 3}, d3.Vec3{4, 0, 3}, 1, 0.75},
+		{"on sloped segment", path, d3.Vec3{4, 1, 6}, d3.Vec3{4, 1, 6}, 2, 0.5},
+		{"after end", path, d3.Vec3{4, 2, 10}, d3.Vec3{4, 2, 8}, 2, 1},
+		{"single point", path[:1], d3.Vec3{1, 2, 3}, d3.Vec3{0, 0, 0}, 0, 0},
+		{"same points", []d3.Vec3{{1, 0, 1}, {1, 0, 1}}, d3.Vec3{2, 0, 2}, d3.Vec3{1, 0, 1}, 0, 0},
+	}
+	for _, tt := range closestTests {
+		pt, segIdx, u := ClosestPointOnPath(tt.path, tt.pos)
+		if !pt.Approx(tt.want) || segIdx != tt.segIdx || math32.Abs(u-tt.t) > 1e-5 {
+			t.Errorf("%s, ClosestPointOnPath(%v) = %v, %d, %v, want %v, %d, %v",
+				tt.msg, tt.pos, pt, segIdx, u, tt.want, tt.segIdx, tt.t)
+		}
+	}
+
+	if pt, segIdx, _ := ClosestPointOnPath(nil, d3.Vec3{1, 2, 3}); pt != nil || segIdx != -1 {
+		t.Errorf("ClosestPointOnPath(nil) = %v, %d, want nil, -1", pt, segIdx)
+	}
+}
+
+func TestResamplePath(t *testing.T) {
+	resampleTests := []struct {
+		msg     string
+		path    []d3.Vec3
+		spacing float32
+		count   int
+	}{
+		{"exact multiple", []d3.Vec3{{0, 0, 0}, {10, 0, 0}}, 2, 6},
+		{"shorter last interval", []d3.Vec3{{0, 0, 0}, {10, 0, 0}}, 3, 5},
+		{"across segments", []d3.Vec3{{0, 0, 0}, {3, 0, 0}, {3, 0, 4}, {3, 3, 4}}, 1, 11},
+		{"spacing longer than path", []d3.Vec3{{0, 0, 0}, {1, 0, 0}}, 5, 2},
+	}
+	for _, tt := range resampleTests {
+		res := ResamplePath(tt.path, tt.spacing)
+		if len(res) != tt.count {
+			t.Errorf("%s, got %d points, want %d: %v", tt.msg, len(res), tt.count, res)
+			continue
+		}
+		if !res[0].Approx(tt.path[0]) {
+			t.Errorf("%s, first point = %v, want %v", tt.msg, res[0], tt.path[0])
+		}
+		end := tt.path[len(tt.path)-1]
+		if !res[len(res)-1].Approx(end) {
+			t.Errorf("%s, last point = %v, want %v", tt.msg, res[len(res)-1], end)
+		}
+	}
+
+	// On a straight path, every interval but the last is spacing long.
+	res := ResamplePath([]d3.Vec3{{0, 0, 0}, {0, 0, 10}}, 3)
+	for i := 1; i < len(res)-1; i++ {
+		if d := res[i-1].Dist(res[i]); math32.Abs(d-3) > 1e-4 {
+			t.Errorf("interval %d is %v long, want 3", i-1, d)
+		}
+	}
+	if d := res[len(res)-2].Dist(res[len(res)-1]); math32.Abs(d-1) > 1e-4 {
+		t.Errorf("last interval is %v long, want 1", d)
+	}
+
+	if res := ResamplePath([]d3.Vec3{{1, 2, 3}}, 1); len(res) != 1 || !res[0].Approx(d3.Vec3{1, 2, 3}) {
+		t.Errorf("single point path, got %v, want [[1 2 3]]", res)
+	}
+}
+
+func TestPathTravelTime(t *testing.T) {
+	data, err := CreateNavMeshData(sliverParams())
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	tile := &mesh.Tiles[0]
+	tile.Polys[1].SetArea(1)
+	tile.Polys[2].SetArea(2)
+	base := mesh.polyRefBase(tile)
+
+	straight := []d3.Vec3{{0.5, 0, 1}, {2, 0, 1}, {2.01, 0, 1}, {3.5, 0, 1}}
+	refs := []PolyRef{base, base | 1, base | 2, 0}
+
+	var speeds SpeedTable
+	speeds[0], speeds[1], speeds[2] = 1, 0.5, 2
+	if got, want := PathTravelTime(straight, refs, speeds, &mesh), float32(1.5/1+0.01/0.5+1.49/2); math32.Abs(got-want) > 1e-4 {
+		t.Errorf("PathTravelTime = %v, want %v", got, want)
+	}
+
+	// Impassable areas, invalid or missing references.
+	speeds[1] = 0
+	if got := PathTravelTime(straight, refs, speeds, &mesh); !math32.IsInf(got, 1) {
+		t.Errorf("with a zero speed, PathTravelTime = %v, want +Inf", got)
+	}
+	speeds[1] = 0.5
+	if got := PathTravelTime(straight, []PolyRef{base, base | 10, base | 2, 0}, speeds, &mesh); !math32.IsInf(got, 1) {
+		t.Errorf("with an invalid ref, PathTravelTime = %v, want +Inf", got)
+	}
+	if got := PathTravelTime(straight, refs[:2], speeds, &mesh); !math32.IsInf(got, 1) {
+		t.Errorf("with missing refs, PathTravelTime = %v, want +Inf", got)
+	}
+	if got := PathTravelTime(straight[:1], refs[:1], speeds, &mesh); got != 0 {
+		t.Errorf("single point path, PathTravelTime = %v, want 0", got)
+	}
+}
+
+func TestFindPathInTiles(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	extents := d3.NewVec3XYZ(1, 4, 1)
+
+	// From the tile (0, 0) to the tile (3, 2).
+	_, orgRef, org := query.FindNearestPoly(d3.Vec3{5, 0, 5}, extents, filter)
+	_, dstRef, dst := query.FindNearestPoly(d3.Vec3{30, 0, 20}, extents, filter)
+
+	want := make([]PolyRef, 100)
+	wantCount, st := query.FindPath(orgRef, dstRef, org, dst, filter, want)
+	if st != Success {
+		t.Fatalf("FindPath status 0x%x, want 0x%x", uint32(st), uint32(Success))
+	}
+
+	path := make([]PolyRef, 100)
+	n, st := query.FindPathInTiles(orgRef, dstRef, org, dst, filter, func(tx, ty int32) bool { return true }, path)
+	if st != Success || !reflect.DeepEqual(path[:n], want[:wantCount]) {
+		t.Errorf("with all tiles allowed, got path %#x and status 0x%x, want %#x and 0x%x", path[:n], uint32(st), want[:wantCount], uint32(Success))
+	}
+
+	// Only the start tile and its neighbours.
+	near := func(tx, ty int32) bool { return tx <= 1 && ty <= 1 }
+	n, st = query.FindPathInTiles(orgRef, dstRef, org, dst, filter, near, path)
+	if StatusFailed(st) || !StatusDetail(st, PartialResult) {
+		t.Fatalf("with near tiles allowed, got status 0x%x, want a partial result", uint32(st))
+	}
+	if n == 0 || path[0] != orgRef {
+		t.Fatalf("with near tiles allowed, path %#x does not start at 0x%x", path[:n], orgRef)
+	}
+	for _, ref := range path[:n] {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+		if !near(tile.Header.X, tile.Header.Y) {
+			t.Errorf("path %#x goes through poly 0x%x of tile (%d,%d)", path[:n], ref, tile.Header.X, tile.Header.Y)
+		}
+	}
+}
+
+func TestRaycastPathCost(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, query := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	org := d3.Vec3{40.389084, 7.797607, 17.144299}
+	dst := d3.Vec3{45.965542, 7.797607, 14.355331}
+	_, orgRef, org := query.FindNearestPoly(org, d3.NewVec3XYZ(2, 4, 2), filter)
+
+	raycast := func(options int) RaycastHit {
+		// Start with a dirty hit, the call resets it.
+		hit := RaycastHit{T: 0.5, PathCost: 100, Path: make([]PolyRef, 16), MaxPath: 16}
+		if st := query.Raycast(orgRef, org, dst, filter, options, &hit, 0); StatusFailed(st) {
+			t.Fatalf("Raycast failed with status 0x%x", uint32(st))
+		}
+		if hit.T != math.MaxFloat32 {
+			t.Fatalf("Raycast hit a wall at t = %f, want an unobstructed ray", hit.T)
+		}
+		return hit
+	}
+
+	if hit := raycast(0); hit.PathCost != 0 {
+		t.Errorf("without RaycastUseCosts, path cost = %f, want 0", hit.PathCost)
+	}
+
+	// With unit area costs, the cost is about the ray length.
+	hit := raycast(RaycastUseCosts)
+	if hit.PathCount < 2 {
+		t.Fatalf("ray visits %d polygons, want several", hit.PathCount)
+	}
+	cost := hit.PathCost
+	if dist := org.Dist(dst); cost < dist || cost > 1.1*dist {
+		t.Errorf("path cost = %f, want about the ray length %f", cost, dist)
+	}
+
+	// Make the area of a visited polygon expensive.
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	mesh.TileAndPolyByRefUnsafe(hit.Path[hit.PathCount-1], &tile, &poly)
+	filter.SetAreaCost(int32(poly.Area()), 10)
+	if hit := raycast(RaycastUseCosts); hit.PathCost <= cost {
+		t.Errorf("with an expensive area, path cost = %f, want more than %f", hit.PathCost, cost)
+	}
+}
+
+type costOverlayFunc func(ref PolyRef, pos d3.Vec3) float32
+
+func (f costOverlayFunc) ExtraCost(ref PolyRef, pos d3.Vec3) float32 { return f(ref, pos) }
+
+func TestCostOverlay(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	ext := d3.Vec3{1, 4, 1}
+	_, orgRef, org := q.FindNearestPoly(d3.Vec3{5, 0, 5}, ext, filter)
+	_, dstRef, dst := q.FindNearestPoly(d3.Vec3{30, 0, 20}, ext, filter)
+
+	findPath := func() []PolyRef {
+		path := make([]PolyRef, 256)
+		n, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+		if st != Success {
+			t.Fatalf("FindPath failed with status 0x%x", uint32(st))
+		}
+		return path[:n]
+	}
+	want := findPath()
+	if len(want) < 3 {
+		t.Fatalf("got a %d polygons path, want at least 3", len(want))
+	}
+
+	// Null and negative costs do not change the path.
+	for _, c := range []float32{0, -1000} {
+		c := c
+		q.SetCostOverlay(costOverlayFunc(func(PolyRef, d3.Vec3) float32 { return c }))
+		if got := findPath(); !reflect.DeepEqual(got, want) {
+			t.Errorf("with overlay cost %v, got path %v, want %v", c, got, want)
+		}
+	}
+
+	// Dangerous polygons are avoided, unless they are chokepoints.
+	var danger PolyRef
+	q.SetCostOverlay(costOverlayFunc(func(ref PolyRef, pos d3.Vec3) float32 {
+		if ref == danger {
+			return 1000
+		}
+		return 0
+	}))
+	if q.CostOverlay() == nil {
+		t.Fatalf("CostOverlay() = nil, want the overlay")
+	}
+	var avoided int
+	costs := make([]float32, 256)
+	path := make([]PolyRef, 256)
+	for _, danger = range want[1 : len(want)-1] {
+		n, st := q.FindPathDetailed(orgRef, dstRef, org, dst, filter, path, costs)
+		if st != Success || path[n-1] != dstRef {
+			t.Fatalf("FindPathDetailed returned status 0x%x, end 0x%x, want a full path", uint32(st), path[n-1])
+		}
+		crossed := false
+		for _, ref := range path[:n] {
+			crossed = crossed || ref == danger
+		}
+		switch {
+		case !crossed && costs[n-1] >= 1000:
+			t.Errorf("path avoiding poly 0x%x costs %v, want less than the overlay cost", danger, costs[n-1])
+		case crossed && costs[n-1] < 1000:
+			t.Errorf("path through poly 0x%x costs %v, want at least the overlay cost", danger, costs[n-1])
+		case !crossed:
+			avoided++
+		}
+	}
+	if avoided == 0 {
+		t.Errorf("no path polygon has been avoided")
+	}
+
+	q.SetCostOverlay(nil)
+	if got := findPath(); !reflect.DeepEqual(got, want) {
+		t.Errorf("without overlay, got path %v, want %v", got, want)
+	}
+}
+
+func TestFindStraightPathRefs(t *testing.T) {
+	straightPathRefsTests := []struct {
+		mesh     string
+		org, dst d3.Vec3
+	}{
+		{"mesh2.bin", d3.Vec3{5, 0, 5}, d3.Vec3{30, 0, 20}},
+		{"mesh1.bin", d3.Vec3{37.298489, -1.776901, 11.652311}, d3.Vec3{42.457218, 7.797607, 17.778244}},
+		{"offmeshcons.bin", d3.Vec3{-19.460140, 4.234787, -4.727699}, d3.Vec3{-1.402759, -0.000092, -2.314920}},
+	}
+	for _, tt := range straightPathRefsTests {
+		mesh, err := loadTestNavMesh(tt.mesh)
+		checkt(t, err)
+		st, q := NewNavMeshQuery(mesh, 1000)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		filter := NewStandardQueryFilter()
+		ext := d3.Vec3{2, 4, 2}
+		_, orgRef, org := q.FindNearestPoly(tt.org, ext, filter)
+		_, dstRef, dst := q.FindNearestPoly(tt.dst, ext, filter)
+		path := make([]PolyRef, 256)
+		npath, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+		if StatusFailed(st) {
+			t.Fatalf("%s: FindPath failed with status 0x%x", tt.mesh, uint32(st))
+		}
+		path = path[:npath]
+
+		straight := make([]d3.Vec3, 256)
+		for i := range straight {
+			straight[i] = d3.NewVec3()
+		}
+		refs := make([]PolyRef, 256)
+
+		// As in Detour, the end point has no reference by default.
+		n, st := q.FindStraightPath(org, dst, path, straight, nil, refs, 0)
+		if StatusFailed(st) || n < 2 || refs[n-1] != 0 {
+			t.Errorf("%s: without StraightPathEndRef, got %d points, end point ref 0x%x, status 0x%x, want a zero ref",
+				tt.mesh, n, refs[n-1], uint32(st))
+		}
+
+		for _, options := range []int32{int32(StraightPathEndRef), int32(StraightPathAllCrossings | StraightPathEndRef)} {
+			n, st := q.FindStraightPath(org, dst, path, straight, nil, refs, options)
+			if StatusFailed(st) || n < 2 {
+				t.Fatalf("%s: FindStraightPath returned %d points, status 0x%x", tt.mesh, n, uint32(st))
+			}
+			if refs[n-1] != path[len(path)-1] {
+				t.Errorf("%s, options %d: end point ref 0x%x, want the last path poly 0x%x", tt.mesh, options, refs[n-1], path[len(path)-1])
+			}
+			// Each point lies on its polygon, inside or on its boundary.
+			closest := d3.NewVec3()
+			for i, pt := range straight[:n] {
+				if !mesh.IsValidPolyRef(refs[i]) {
+					t.Errorf("%s, options %d: point %d ref 0x%x is not valid", tt.mesh, options, i, refs[i])
+					continue
+				}
+				q.ClosestPointOnPolyBoundary(refs[i], pt, closest)
+				if d := math32.Hypot(closest[0]-pt[0], closest[2]-pt[2]); d > 1e-3 {
+					t.Errorf("%s, options %d: point %d %v is %v away from its poly 0x%x", tt.mesh, options, i, pt, d, refs[i])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package detour
+
+import (
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// PathQueueRef is a reference to a path request of a PathQueue.
+type PathQueueRef uint32
+
+const (
+	// PathQueueInvalid is the invalid path request reference, returned by
+	// PathQueue.Request when the queue is full.
+	PathQueueInvalid PathQueueRef = 0
+
+	// PathQueueSize is the maximum number of path requests a PathQueue holds
+	// at the same time.
+	PathQueueSize = 8
+
+	// number of updates a finished request is kept in the queue, waiting for
+	// its result to be read, before being dropped.
+	pathQueueMaxKeepAlive = 2
+)
+
+// pathQuery is a path request of a PathQueue.
+type pathQuery struct {
+	ref              PathQueueRef
+	startPos, endPos d3.Vec3
+	startRef, endRef PolyRef
+	path             []PolyRef // result
+	npath            int
+	status           Status // 0 if the query has not been started yet
+	keepAlive        int
+	filter           QueryFilter
+}
+
+// A PathQueue runs path requests asynchronously.
+//
+// Path requests are queued with Request, then processed by the successive
+// calls to Update, which limit the number of search iterations, so that the
+// cost of path finding can be spread over several frames. Once complete, the
+// result of a request is read with GetPathResult.
+//
+// A PathQueue uses its own NavMeshQuery to perform the searches, with the
+// sliced path finding functions.
+type PathQueue struct {
+	queue       [PathQueueSize]pathQuery
+	nextHandle  PathQueueRef
+	maxPathSize int32
+	queueHead   int
+	navQuery    *NavMeshQuery
+}
+
+// NewPathQueue initializes a path queue.
+//
+//  Arguments:
+//   nav                 The navmesh on which paths are searched.
+//   maxPathSize         The maximum number of polygons of a path result.
+//                       [Limit: > 0]
+//   maxSearchNodeCount  The maximum number of search nodes. (See
+//                       NewNavMeshQuery)
+//
+// Return the status flags for the initialization of the path queue and the
+// path queue.
+func NewPathQueue(nav *NavMesh, maxPathSize, maxSearchNodeCount int32) (Status, *PathQueue) {
+	if maxPathSize <= 0 {
+		return Failure | InvalidParam, nil
+	}
+
+	st, navQuery := NewNavMeshQuery(nav, maxSearchNodeCount)
+	if StatusFailed(st) {
+		return st, nil
+	}
+
+	pq := &PathQueue{
+		nextHandle:  1,
+		maxPathSize: maxPathSize,
+		navQuery:    navQuery,
+	}
+	for i := range pq.queue {
+		q := &pq.queue[i]
+		q.ref = PathQueueInvalid
+		q.startPos = d3.NewVec3()
+		q.endPos = d3.NewVec3()
+		q.path = make([]PolyRef, maxPathSize)
+	}
+	return Success, pq
+}
+
+// NavMeshQuery returns the query object used by the path queue.
+func (pq *PathQueue) NavMeshQuery() *NavMeshQuery {
+	return pq.navQuery
+}
+
+// Update processes the queued path requests.
+//
+//  Arguments:
+//   maxIters  The maximum number of search iterations to perform, for all
+//             the requests.
+//
+// Requests are processed in turn, the request being processed when maxIters
+// is reached continues at the next update. A completed request which result
+// has not been read with GetPathResult after a few updates is dropped.
+func (pq *PathQueue) Update(maxIters int) {
+	// Update path request until there is nothing to update
+	// or upto maxIters pathfinder iterations has been consumed.
+	iterCount := maxIters
+
+	for i := 0; i < PathQueueSize; i++ {
+		q := &pq.queue[pq.queueHead%PathQueueSize]
+
+		// Skip inactive requests.
+		if q.ref == PathQueueInvalid {
+			pq.queueHead++
+			continue
+		}
+
+		// Handle completed request.
+		if StatusSucceed(q.status) || StatusFailed(q.status) {
+			// If the path result has not been read in few frames, free the slot.
+			q.keepAlive++
+			if q.keepAlive > pathQueueMaxKeepAlive {
+				q.ref = PathQueueInvalid
+				q.status = 0
+			}
+
+			pq.queueHead++
+			continue
+		}
+
+		// Handle query start.
+		if q.status == 0 {
+			q.status = pq.navQuery.InitSlicedFindPath(q.startRef, q.endRef, q.startPos, q.endPos, q.filter, 0)
+		}
+		// Handle query in progress.
+		if StatusInProgress(q.status) {
+			var iters int
+			q.status = pq.navQuery.UpdateSlicedFindPath(iterCount, &iters)
+			iterCount -= iters
+		}
+		if StatusSucceed(q.status) {
+			q.npath, q.status = pq.navQuery.FinalizeSlicedFindPath(q.path, int(pq.maxPathSize))
+		}
+
+		if iterCount <= 0 {
+			break
+		}
+
+		pq.queueHead++
+	}
+}
+
+// Request queues a path request.
+//
+//  Arguments:
+//   startRef  The reference id of the start polygon.
+//   endRef    The reference id of the end polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   endPos    A position within the end polygon. [(x, y, z)]
+//   filter    The polygon filter to apply to the query.
+//
+// Returns the reference of the request, or PathQueueInvalid if the queue is
+// full.
+//
+// filter is kept by the path queue until the request is complete, it must not
+// be modified in the meantime.
+func (pq *PathQueue) Request(startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter) PathQueueRef {
+
+	// Find empty slot
+	slot := -1
+	for i := range pq.queue {
+		if pq.queue[i].ref == PathQueueInvalid {
+			slot = i
+			break
+		}
+	}
+	// Could not find slot.
+	if slot == -1 {
+		return PathQueueInvalid
+	}
+
+	ref := pq.nextHandle
+	pq.nextHandle++
+	if pq.nextHandle == PathQueueInvalid {
+		pq.nextHandle++
+	}
+
+	q := &pq.queue[slot]
+	q.ref = ref
+	q.startPos.Assign(startPos)
+	q.startRef = startRef
+	q.endPos.Assign(endPos)
+	q.endRef = endRef
+
+	q.status = 0
+	q.npath = 0
+	q.filter = filter
+	q.keepAlive = 0
+
+	return ref
+}
+
+// RequestStatus returns the status of a path request.
+//
+// The status is InProgress while the request is waiting or being processed,
+// Success or Failure once it is complete. Failure is also returned if there
+// is no such request.
+func (pq *PathQueue) RequestStatus(ref PathQueueRef) Status {
+	for i := range pq.queue {
+		if pq.queue[i].ref == ref {
+			if pq.queue[i].status == 0 {
+				return InProgress
+			}
+			return pq.queue[i].status
+		}
+	}
+	return Failure
+}
+
+// GetPathResult returns the result of a completed path request, and removes
+// it from the queue.
+//
+//  Arguments:
+//   ref   The reference of the request.
+//   path  The slice filled with the polygons of the path. (Start to end.)
+//
+//  Returns:
+//   pathCount The number of polygons copied into path.
+//   st        The status flags of the request.
+//
+// If the request is not complete yet, InProgress is returned and the request
+// stays in the queue. If path is too small, the result is truncated to
+// len(path) polygons and BufferTooSmall is set in the returned status.
+// Failure is returned if there is no such request, in which case the result
+// may have been dropped because it was not read soon enough, see Update.
+func (pq *PathQueue) GetPathResult(ref PathQueueRef, path []PolyRef) (pathCount int, st Status) {
+	for i := range pq.queue {
+		q := &pq.queue[i]
+		if q.ref != ref {
+			continue
+		}
+		if StatusFailed(q.status) {
+			// Free request for reuse.
+			q.ref = PathQueueInvalid
+			q.status = 0
+			return 0, Failure
+		}
+		if !StatusSucceed(q.status) {
+			return 0, InProgress
+		}
+		details := q.status & StatusDetailMask
+		// Free request for reuse.
+		q.ref = PathQueueInvalid
+		q.status = 0
+		// Copy path
+		n := copy(path, q.path[:q.npath])
+		if n < q.npath {
+			details |= BufferTooSmall
+		}
+		return n, Success | details
+	}
+	return 0, Failure
+}
@@ -0,0 +1,97 @@
+package detour
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestPathQueue(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, pq := NewPathQueue(mesh, 256, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("path queue creation failed with status 0x%x", uint32(st))
+	}
+	query := pq.NavMeshQuery()
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	dst := d3.Vec3{42.457218, 7.797607, 17.778244}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	_, orgRef, orgPt := query.FindNearestPoly(org, extents, filter)
+	_, dstRef, dstPt := query.FindNearestPoly(dst, extents, filter)
+
+	want := make([]PolyRef, 256)
+	wantCount, _ := query.FindPath(orgRef, dstRef, orgPt, dstPt, filter, want)
+
+	ref := pq.Request(orgRef, dstRef, orgPt, dstPt, filter)
+	if ref == PathQueueInvalid {
+		t.Fatalf("Request returned an invalid ref")
+	}
+
+	// Process the request a few iterations at a time.
+	path := make([]PolyRef, 256)
+	var updates int
+	for StatusInProgress(pq.RequestStatus(ref)) {
+		if updates++; updates > 100 {
+			t.Fatalf("request still in progress after %d updates", updates)
+		}
+		if _, st := pq.GetPathResult(ref, path); st != InProgress {
+			t.Fatalf("GetPathResult of an in progress request returned 0x%x, want InProgress", uint32(st))
+		}
+		pq.Update(2)
+	}
+	if updates < 2 {
+		t.Errorf("request completed in %d update, want it spread over several", updates)
+	}
+
+	n, st := pq.GetPathResult(ref, path)
+	if st != Success {
+		t.Fatalf("GetPathResult returned status 0x%x, want Success", uint32(st))
+	}
+	if !reflect.DeepEqual(path[:n], want[:wantCount]) {
+		t.Errorf("path is %#v, want %#v", path[:n], want[:wantCount])
+	}
+	// The result has been read, the request is gone.
+	if _, st := pq.GetPathResult(ref, path); st != Failure {
+		t.Errorf("second GetPathResult returned 0x%x, want Failure", uint32(st))
+	}
+
+	// Full queue.
+	var refs []PathQueueRef
+	for i := 0; i < PathQueueSize; i++ {
+		ref := pq.Request(orgRef, dstRef, orgPt, dstPt, filter)
+		if ref == PathQueueInvalid {
+			t.Fatalf("Request %d returned an invalid ref", i)
+		}
+		refs = append(refs, ref)
+	}
+	if ref := pq.Request(orgRef, dstRef, orgPt, dstPt, filter); ref != PathQueueInvalid {
+		t.Fatalf("Request on a full queue returned 0x%x, want PathQueueInvalid", ref)
+	}
+
+	// Results not read are dropped after a few updates.
+	for i := 0; i < 100 && StatusInProgress(pq.RequestStatus(refs[len(refs)-1])); i++ {
+		pq.Update(1000)
+	}
+	n, st = pq.GetPathResult(refs[0], path[:3])
+	if st != Success|BufferTooSmall || n != 3 {
+		t.Errorf("GetPathResult with short path returned (%d, 0x%x), want (3, 0x%x)",
+			n, uint32(st), uint32(Success|BufferTooSmall))
+	}
+	for i := 0; i <= pathQueueMaxKeepAlive+1; i++ {
+		pq.Update(1000)
+	}
+	for _, ref := range refs[1:] {
+		if st := pq.RequestStatus(ref); st != Failure {
+			t.Errorf("request 0x%x status is 0x%x after keep alive, want Failure", ref, uint32(st))
+		}
+	}
+	if ref := pq.Request(orgRef, dstRef, orgPt, dstPt, filter); ref == PathQueueInvalid {
+		t.Errorf("Request returned an invalid ref, after the queue has been freed")
+	}
+}
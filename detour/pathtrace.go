@@ -0,0 +1,105 @@
+package detour
+
+import "github.com/arl/gogeo/f32/d3"
+
+// PathTraceNode is a node expanded by the search of FindPath.
+//
+// see NavMeshQuery.EnablePathTrace
+type PathTraceNode struct {
+	Ref       PolyRef // Reference of the expanded polygon.
+	Pos       d3.Vec3 // Position of the node, where the search entered the polygon.
+	Cost      float32 // Cost from the start position to Pos. (g)
+	Heuristic float32 // Estimated cost from Pos to the end position. (h)
+
+	// Index in the trace of the expansion of the parent node, or -1 for the
+	// start node.
+	Parent int
+	// Reference of the parent polygon, or 0 for the start node.
+	ParentRef PolyRef
+}
+
+// pathTrace records the nodes expanded by a path search.
+type pathTrace struct {
+	nodes   []PathTraceNode
+	idx     map[uint32]int      // node pool index -> index of its last expansion
+	parents map[PolyRef]PolyRef // polygon -> parent polygon in the search tree
+}
+
+// reset prepares the trace for a new search from startRef.
+func (t *pathTrace) reset(startRef PolyRef) {
+	t.nodes = t.nodes[:0]
+	t.idx = make(map[uint32]int)
+	t.parents = map[PolyRef]PolyRef{startRef: 0}
+}
+
+// EnablePathTrace enables, or disables, the recording of the nodes expanded
+// by the A* search of FindPath, and of the methods based on it, such as
+// FindPathDetailed.
+//
+// The trace is meant for debugging, for example to display the search
+// frontier in order to tune query filter costs. There's no allocation for it
+// while it's disabled, the default.
+//
+// see LastPathTrace
+func (q *NavMeshQuery) EnablePathTrace(enable bool) {
+	if !enable {
+		q.trace = nil
+	} else if q.trace == nil {
+		q.trace = &pathTrace{}
+	}
+}
+
+// LastPathTrace returns the nodes expanded by the last path search, in the
+// order of their expansion, or nil if the path trace is disabled.
+//
+// A node is expanded again if the search finds a cheaper way to reach it,
+// after it has been expanded, so a polygon may appear several times. The
+// Parent index of each node allows to rebuild the search tree.
+//
+// The returned slice is only valid until the next path search.
+//
+// see EnablePathTrace
+func (q *NavMeshQuery) LastPathTrace() []PathTraceNode {
+	if q.trace == nil {
+		return nil
+	}
+	return q.trace.nodes
+}
+
+// LastSearchParents returns the search tree of the last path search, as the
+// parent of each polygon reached by the search, or nil if the path trace is
+// disabled.
+//
+// The parent of the start polygon is 0. Unlike LastPathTrace, which only has
+// the expanded nodes, this also has the polygons left in the open list when
+// the search stopped, with the parent they had at that time, so it is the
+// whole tree the search has built, of which the returned path is a branch.
+// When a polygon has been reached from several tile sides, its parent is the
+// last one the search set.
+//
+// The returned map is only valid until the next path search.
+//
+// see EnablePathTrace
+func (q *NavMeshQuery) LastSearchParents() map[PolyRef]PolyRef {
+	if q.trace == nil {
+		return nil
+	}
+	return q.trace.parents
+}
+
+// traceNode records the expansion of node in the path trace.
+func (q *NavMeshQuery) traceNode(node *Node) {
+	n := PathTraceNode{
+		Ref:       node.ID,
+		Pos:       d3.NewVec3From(node.Pos),
+		Cost:      node.Cost,
+		Heuristic: node.Total - node.Cost,
+		Parent:    -1,
+	}
+	if node.PIdx != 0 {
+		n.ParentRef = q.nodePool.NodeAtIdx(int32(node.PIdx)).ID
+		n.Parent = q.trace.idx[node.PIdx]
+	}
+	q.trace.idx[q.nodePool.NodeIdx(node)] = len(q.trace.nodes)
+	q.trace.nodes = append(q.trace.nodes, n)
+}
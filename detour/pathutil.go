@@ -0,0 +1,202 @@
+package detour
+
+import (
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// SimplifyPath simplifies a polyline, such as a straight path, with the
+// Ramer-Douglas-Peucker algorithm.
+//
+//  Arguments:
+//   path     The points of the polyline. [(x, y, z) * len(path)]
+//   epsilon  The maximum distance, on the xz-plane, between a removed point
+//            and the simplified polyline.
+//
+// Returns the points of path which are kept, in the same order. The first
+// and last points are always kept.
+//
+// The simplification only removes points: the kept points, heights
+// included, are the ones of path, so points on the navmesh remain on it. The
+// returned slice shares its points with path.
+func SimplifyPath(path []d3.Vec3, epsilon float32) []d3.Vec3 {
+	if len(path) <= 2 {
+		return append([]d3.Vec3(nil), path...)
+	}
+
+	keep := make([]bool, len(path))
+	keep[0], keep[len(path)-1] = true, true
+	simplifyPath(path, 0, len(path)-1, epsilon*epsilon, keep)
+
+	var simple []d3.Vec3
+	for i, k := range keep {
+		if k {
+			simple = append(simple, path[i])
+		}
+	}
+	return simple
+}
+
+// simplifyPath marks in keep the points of path between first and last
+// which are farther than sqrt(epsSqr) from the simplified polyline.
+func simplifyPath(path []d3.Vec3, first, last int, epsSqr float32, keep []bool) {
+	var (
+		maxd float32
+		maxi int
+		t    float32
+	)
+	for i := first + 1; i < last; i++ {
+		if d := distancePtSegSqr2D(path[i], path[first], path[last], &t); d > maxd {
+			maxd, maxi = d, i
+		}
+	}
+	if maxd <= epsSqr {
+		return
+	}
+	keep[maxi] = true
+	simplifyPath(path, first, maxi, epsSqr, keep)
+	simplifyPath(path, maxi, last, epsSqr, keep)
+}
+
+// ClosestPointOnPath returns the point of a polyline, such as a straight
+// path, the nearest to a position.
+//
+//  Arguments:
+//   path     The points of the polyline. [(x, y, z) * len(path)]
+//   pos      The position. [(x, y, z)]
+//
+//  Return values:
+//   pt       The nearest point of path, a new vector.
+//   segIdx   The index of the segment of pt, from path[segIdx] to
+//            path[segIdx+1].
+//   t        The parametric position of pt on the segment. [0 <= t <= 1]
+//
+// Distances are measured in 3D. If several segments are at the same
+// distance, the first one is returned. With a single point path, pt is that
+// point and segIdx and t are 0. With an empty path, pt is nil and segIdx is
+// -1.
+func ClosestPointOnPath(path []d3.Vec3, pos d3.Vec3) (pt d3.Vec3, segIdx int, t float32) {
+	switch len(path) {
+	case 0:
+		return nil, -1, 0
+	case 1:
+		return d3.NewVec3From(path[0]), 0, 0
+	}
+
+	var best float32
+	for i := 0; i < len(path)-1; i++ {
+		p, q := path[i], path[i+1]
+		pq := q.Sub(p)
+		var u float32
+		if d := pq.Dot(pq); d > 0 {
+			u = pq.Dot(pos.Sub(p)) / d
+			if u < 0 {
+				u = 0
+			} else if u > 1 {
+				u = 1
+			}
+		}
+		c := p.Lerp(q, u)
+		if d := c.DistSqr(pos); i == 0 || d < best {
+			best, pt, segIdx, t = d, c, i, u
+		}
+	}
+	return pt, segIdx, t
+}
+
+// ResamplePath returns points evenly spaced along a polyline, such as a
+// straight path.
+//
+//  Arguments:
+//   path     The points of the polyline. [(x, y, z) * len(path)]
+//   spacing  The distance, along the polyline, between consecutive
+//            returned points. [Limit: > 0]
+//
+// The first returned point is the start of path, then there is one point
+// every spacing units, interpolated on the segments of path. The last point
+// is always the end of path, so the last interval may be shorter than
+// spacing. Distances are measured in 3D.
+//
+// The returned points are new vectors. If spacing is not positive, or path
+// has less than 2 points, a copy of path is returned.
+func ResamplePath(path []d3.Vec3, spacing float32) []d3.Vec3 {
+	if spacing <= 0 || len(path) < 2 {
+		res := make([]d3.Vec3, len(path))
+		for i := range path {
+			res[i] = d3.NewVec3From(path[i])
+		}
+		return res
+	}
+
+	res := []d3.Vec3{d3.NewVec3From(path[0])}
+	// Distance left to walk before the next point.
+	left := spacing
+	for i := 0; i < len(path)-1; i++ {
+		p, q := path[i], path[i+1]
+		segLen := p.Dist(q)
+		var walked float32
+		for segLen-walked >= left {
+			walked += left
+			left = spacing
+			res = append(res, p.Lerp(q, walked/segLen))
+		}
+		left -= segLen - walked
+	}
+
+	// Keep the endpoint exactly, replacing the last point if it only differs
+	// by rounding errors.
+	end := d3.NewVec3From(path[len(path)-1])
+	if spacing-left < spacing*1e-3 && len(res) > 1 {
+		res[len(res)-1] = end
+	} else {
+		res = append(res, end)
+	}
+	return res
+}
+
+// A SpeedTable holds the movement speed of an agent on each polygon area,
+// indexed by area id.
+type SpeedTable [64]float32
+
+// PathTravelTime returns the time it takes to follow a straight path, each
+// segment being traveled at the speed of the area of the polygon it enters.
+//
+//  Arguments:
+//   straight      The straight path points, as returned by FindStraightPath.
+//   straightRefs  The polygon references of each straight path point, as
+//                 returned by FindStraightPath. [Length: >= len(straight)]
+//   speeds        The movement speed on each area.
+//   m             The navmesh the polygons belong to.
+//
+// The segment going from straight[i] to straight[i+1] is traveled at the
+// speed of the area of straightRefs[i], the polygon entered at straight[i].
+// Zero or negative speeds make an area impassable: the travel time of a
+// path crossing it, as well as the one of a path which polygon references
+// are missing or not valid anymore, is +Inf.
+//
+// This is a time, where StraightPathCost is a cost, though a filter which
+// area costs are the inverse of the speeds gives the same result. Like for
+// StraightPathCost, use the StraightPathAreaCrossings option with
+// FindStraightPath so that each segment lies on a single area.
+func PathTravelTime(straight []d3.Vec3, straightRefs []PolyRef, speeds SpeedTable, m *NavMesh) float32 {
+	if len(straightRefs) < len(straight) {
+		return math32.Inf(1)
+	}
+
+	var time float32
+	for i := 0; i+1 < len(straight); i++ {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(m.TileAndPolyByRef(straightRefs[i], &tile, &poly)) {
+			return math32.Inf(1)
+		}
+		speed := speeds[poly.Area()]
+		if !(speed > 0) {
+			return math32.Inf(1)
+		}
+		time += straight[i].Dist(straight[i+1]) / speed
+	}
+	return time
+}
@@ -1,9 +1,13 @@
 package detour
 
 import (
+	"math"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
 )
 
 func TestCalcPolyCenter(t *testing.T) {
@@ -88,3 +92,1205 @@ func TestFindNearestPolySpecialCases(t *testing.T) {
 		}
 	}
 }
+
+// clusteredPoints returns n points scattered on a grid around center.
+func clusteredPoints(center d3.Vec3, n int) []d3.Vec3 {
+	pts := make([]d3.Vec3, n)
+	for i := range pts {
+		pts[i] = d3.NewVec3XYZ(
+			center[0]+float32(i%10)*0.2,
+			center[1],
+			center[2]+float32((i/10)%10)*0.2)
+	}
+	return pts
+}
+
+func TestFindNearestPolys(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	f := NewStandardQueryFilter()
+	ext := d3.NewVec3XYZ(2, 4, 2)
+
+	// the last point is far from any poly
+	centers := append(clusteredPoints(d3.Vec3{37.298489, -1.776901, 11.652311}, 100), d3.Vec3{1000, 0, 1000})
+	refs := make([]PolyRef, len(centers))
+	pts := make([]d3.Vec3, len(centers))
+
+	st = q.FindNearestPolys(centers, ext, f, refs, pts)
+	if StatusFailed(st) {
+		t.Fatalf("FindNearestPolys failed with status 0x%x", st)
+	}
+
+	for i, c := range centers {
+		st, wantRef, wantPt := q.FindNearestPoly(c, ext, f)
+		if StatusFailed(st) {
+			t.Fatalf("FindNearestPoly failed with status 0x%x", st)
+		}
+		if refs[i] != wantRef {
+			t.Errorf("centers[%d], want ref 0x%x, got 0x%x", i, wantRef, refs[i])
+		}
+		if wantRef != 0 && !pts[i].Approx(wantPt) {
+			t.Errorf("centers[%d], want point %v, got %v", i, wantPt, pts[i])
+		}
+	}
+	if refs[len(refs)-1] != 0 {
+		t.Errorf("want ref 0 for a point far from any poly, got 0x%x", refs[len(refs)-1])
+	}
+
+	if st = q.FindNearestPolys(centers, ext, f, refs[:1], pts); st != Failure|InvalidParam {
+		t.Errorf("want status 0x%x with short output slices, got 0x%x", Failure|InvalidParam, st)
+	}
+}
+
+func TestFindNearestPolyArr(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	f := NewStandardQueryFilter()
+	ext := [3]float32{2, 4, 2}
+
+	centers := append(clusteredPoints(d3.Vec3{37.298489, -1.776901, 11.652311}, 100), d3.Vec3{1000, 0, 1000})
+	for _, c := range centers {
+		wantSt, wantRef, wantPt := q.FindNearestPoly(c, ext[:], f)
+		st, ref, pt := q.FindNearestPolyArr([3]float32{c[0], c[1], c[2]}, ext, f)
+		if st != wantSt || ref != wantRef {
+			t.Errorf("FindNearestPolyArr(%v) = 0x%x, 0x%x, want 0x%x, 0x%x", c, uint32(st), ref, uint32(wantSt), wantRef)
+		}
+		if wantRef != 0 && !d3.Vec3(pt[:]).Approx(wantPt) {
+			t.Errorf("FindNearestPolyArr(%v) point = %v, want %v", c, pt, wantPt)
+		}
+	}
+
+	center := [3]float32{centers[0][0], centers[0][1], centers[0][2]}
+	if allocs := testing.AllocsPerRun(100, func() { q.FindNearestPolyArr(center, ext, f) }); allocs != 0 {
+		t.Errorf("FindNearestPolyArr does %v allocations, want 0", allocs)
+	}
+}
+
+func TestFindNearestPolyNoSideEffects(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	f := NewStandardQueryFilter()
+	ext := d3.Vec3{2, 4, 2}
+	centers := clusteredPoints(d3.Vec3{37.298489, -1.776901, 11.652311}, 50)
+
+	// FindNearestPoly keeps its state local, so that it can run while
+	// another search uses the query.
+	want := make([]PolyRef, len(centers))
+	for i, c := range centers {
+		_, want[i], _ = q.FindNearestPoly(c, ext, f)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, c := range centers {
+				if _, ref, _ := q.FindNearestPoly(c, ext, f); ref != want[i] {
+					t.Errorf("FindNearestPoly(%v) = 0x%x, want 0x%x", c, ref, want[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkFindNearestPolys(b *testing.B) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, q := NewNavMeshQuery(mesh, 100)
+	f := NewStandardQueryFilter()
+	ext := d3.NewVec3XYZ(2, 4, 2)
+	centers := clusteredPoints(d3.Vec3{37.298489, -1.776901, 11.652311}, 1000)
+	refs := make([]PolyRef, len(centers))
+	pts := make([]d3.Vec3, len(centers))
+
+	b.Run("batch", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			q.FindNearestPolys(centers, ext, f, refs, pts)
+		}
+	})
+	b.Run("loop", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i, c := range centers {
+				_, refs[i], pts[i] = q.FindNearestPoly(c, ext, f)
+			}
+		}
+	})
+}
+
+func BenchmarkFindNearestPolyArr(b *testing.B) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, q := NewNavMeshQuery(mesh, 100)
+	f := NewStandardQueryFilter()
+	center := d3.Vec3{37.298489, -1.776901, 11.652311}
+	ext := d3.NewVec3XYZ(2, 4, 2)
+
+	b.Run("Vec3", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			q.FindNearestPoly(center, ext, f)
+		}
+	})
+	b.Run("array", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			q.FindNearestPolyArr([3]float32{37.298489, -1.776901, 11.652311}, [3]float32{2, 4, 2}, f)
+		}
+	})
+}
+
+// nearestPolys returns the polygons nearest to pts, and pts shifted up
+// and sideways so that they need to be projected on them.
+func nearestPolys(tb testing.TB, q *NavMeshQuery, pts []d3.Vec3) ([]PolyRef, []d3.Vec3) {
+	refs := make([]PolyRef, len(pts))
+	if st := q.FindNearestPolys(pts, d3.NewVec3XYZ(2, 4, 2), NewStandardQueryFilter(), refs, make([]d3.Vec3, len(pts))); StatusFailed(st) {
+		tb.Fatalf("FindNearestPolys failed with status 0x%x", uint32(st))
+	}
+	shifted := make([]d3.Vec3, len(pts))
+	for i, pt := range pts {
+		shifted[i] = d3.NewVec3XYZ(pt[0]+0.5, pt[1]+1, pt[2]-0.5)
+	}
+	return refs, shifted
+}
+
+func TestClosestPointsOnPolys(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+
+	refs, pts := nearestPolys(t, q, clusteredPoints(d3.Vec3{37.298489, -1.776901, 11.652311}, 100))
+	// the last reference is invalid
+	refs = append(refs, 0)
+	pts = append(pts, d3.NewVec3XYZ(1000, 0, 1000))
+
+	out := make([]d3.Vec3, len(refs))
+	st = q.ClosestPointsOnPolys(refs, pts, out)
+	if st != Success|InvalidParam {
+		t.Fatalf("want status 0x%x with an invalid ref, got 0x%x", uint32(Success|InvalidParam), uint32(st))
+	}
+
+	for i, ref := range refs[:len(refs)-1] {
+		want := d3.NewVec3()
+		if st := q.ClosestPointOnPoly(ref, pts[i], want, nil); StatusFailed(st) {
+			t.Fatalf("ClosestPointOnPoly(0x%x) failed with status 0x%x", ref, uint32(st))
+		}
+		if !out[i].Approx(want) {
+			t.Errorf("refs[%d], want point %v, got %v", i, want, out[i])
+		}
+	}
+	if last := out[len(out)-1]; !last.Approx(pts[len(pts)-1]) {
+		t.Errorf("want invalid ref point left unchanged %v, got %v", pts[len(pts)-1], last)
+	}
+
+	if st = q.ClosestPointsOnPolys(refs[:len(refs)-1], pts, out); st != Success {
+		t.Errorf("want status 0x%x with valid refs, got 0x%x", uint32(Success), uint32(st))
+	}
+	if st = q.ClosestPointsOnPolys(refs, pts, out[:1]); st != Failure|InvalidParam {
+		t.Errorf("want status 0x%x with a short output slice, got 0x%x", uint32(Failure|InvalidParam), uint32(st))
+	}
+}
+
+func BenchmarkClosestPointsOnPolys(b *testing.B) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, q := NewNavMeshQuery(mesh, 100)
+	refs, pts := nearestPolys(b, q, clusteredPoints(d3.Vec3{37.298489, -1.776901, 11.652311}, 1000))
+	out := make([]d3.Vec3, len(refs))
+	for i := range out {
+		out[i] = d3.NewVec3()
+	}
+
+	b.Run("batch", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			q.ClosestPointsOnPolys(refs, pts, out)
+		}
+	})
+	b.Run("loop", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i, ref := range refs {
+				q.ClosestPointOnPoly(ref, pts[i], out[i], nil)
+			}
+		}
+	})
+}
+
+func TestPolyBounds(t *testing.T) {
+	mesh, err := loadTestNavMesh("offmeshcons.bin")
+	checkt(t, err)
+
+	var nOffMesh int
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		base := mesh.polyRefBase(tile)
+		for ip := range tile.Polys {
+			poly := &tile.Polys[ip]
+			ref := base | PolyRef(ip)
+			bmin, bmax, st := mesh.PolyBounds(ref)
+			if poly.Type() == polyTypeOffMeshConnection {
+				nOffMesh++
+				if !StatusFailed(st) {
+					t.Errorf("poly 0x%x is an off-mesh connection, want failure status, got 0x%x", ref, st)
+				}
+				continue
+			}
+			if StatusFailed(st) {
+				t.Fatalf("PolyBounds(0x%x) failed with status 0x%x", ref, st)
+			}
+
+			pd := &tile.DetailMeshes[ip]
+			for j := uint32(0); j < uint32(pd.VertCount); j++ {
+				v := d3.Vec3(tile.DetailVerts[(pd.VertBase+j)*3:][:3])
+				for k := 0; k < 3; k++ {
+					if v[k] < bmin[k] || v[k] > bmax[k] {
+						t.Errorf("poly 0x%x, detail vertex %v is outside of bounds %v %v", ref, v, bmin, bmax)
+					}
+				}
+			}
+		}
+	}
+	if nOffMesh == 0 {
+		t.Errorf("want off-mesh connections in test mesh")
+	}
+
+	if _, _, st := mesh.PolyBounds(0); !StatusFailed(st) {
+		t.Errorf("want failure status for ref 0, got 0x%x", st)
+	}
+}
+
+// slopedEdgeParams returns the creation parameters of a single polygon tile,
+// a 2x2 square at y=0 which detail mesh has a vertex at (1, 1, 0.05), raising
+// the middle of its z=0 boundary, slightly inside the polygon.
+func slopedEdgeParams() *NavMeshCreateParams {
+	return &NavMeshCreateParams{
+		Verts:     []uint16{0, 0, 0, 0, 0, 4, 4, 0, 4, 4, 0, 0},
+		VertCount: 4,
+		Polys: []uint16{
+			0, 1, 2, 3, 0xffff, 0xffff,
+			0xffff, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff,
+		},
+		PolyFlags:        []uint16{1},
+		PolyAreas:        []uint8{0},
+		PolyCount:        1,
+		Nvp:              6,
+		DetailMeshes:     []int32{0, 5, 0, 3},
+		DetailVerts:      []float32{0, 0, 0, 0, 0, 2, 2, 0, 2, 2, 0, 0, 1, 1, 0.05},
+		DetailVertsCount: 5,
+		DetailTris: []uint8{
+			0, 1, 4, 1 | 1<<4,
+			1, 2, 4, 1,
+			2, 3, 4, 1 | 1<<2,
+		},
+		DetailTriCount: 3,
+		BMin:           [3]float32{0, 0, 0},
+		BMax:           [3]float32{2, 2, 2},
+		WalkableHeight: 2,
+		WalkableRadius: 0.5,
+		WalkableClimb:  0.5,
+		Cs:             0.5,
+		Ch:             0.2,
+		BuildBvTree:    true,
+	}
+}
+
+// slopedEdgeMesh returns a navmesh made of the slopedEdgeParams tile.
+func slopedEdgeMesh(t *testing.T) *NavMesh {
+	data, err := CreateNavMeshData(slopedEdgeParams())
+	checkt(t, err)
+
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	return &mesh
+}
+
+func TestClosestPointOnPolyDetailEdges(t *testing.T) {
+	mesh := slopedEdgeMesh(t)
+	st, q := NewNavMeshQuery(mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	ref := mesh.polyRefBase(&mesh.Tiles[0])
+
+	// Expected projection on the detail edge from (1, 1, 0.05) to (0, 0, 0).
+	pos := d3.Vec3{0.8, 5, -1}
+	va, vb := d3.Vec3{1, 1, 0.05}, d3.Vec3{0, 0, 0}
+	var tseg float32
+	distancePtSegSqr2D(pos, va, vb, &tseg)
+	want := d3.NewVec3()
+	d3.Vec3Lerp(want, va, vb, tseg)
+
+	closest := d3.NewVec3()
+	posOverPoly := true
+	if st := q.ClosestPointOnPoly(ref, pos, closest, &posOverPoly); StatusFailed(st) {
+		t.Fatalf("ClosestPointOnPoly failed with status 0x%x", uint32(st))
+	}
+	if posOverPoly {
+		t.Errorf("posOverPoly is true for a position outside of the polygon")
+	}
+	if d := closest.Dist(want); d > 1e-4 {
+		t.Errorf("got closest point %v, want %v on the detail edge", closest, want)
+	}
+
+	// Over the polygon, the height is the one of the detail mesh.
+	pos = d3.Vec3{1, 5, 1}
+	if st := q.ClosestPointOnPoly(ref, pos, closest, &posOverPoly); StatusFailed(st) {
+		t.Fatalf("ClosestPointOnPoly failed with status 0x%x", uint32(st))
+	}
+	want = d3.Vec3{1, 1 / 1.95, 1}
+	if !posOverPoly {
+		t.Errorf("posOverPoly is false for a position over the polygon")
+	}
+	if d := closest.Dist(want); d > 1e-4 {
+		t.Errorf("got closest point %v, want %v", closest, want)
+	}
+
+	// The boundary version only uses the polygon edges.
+	pos = d3.Vec3{0.8, 0, -1}
+	if st := q.ClosestPointOnPolyBoundary(ref, pos, closest); StatusFailed(st) {
+		t.Fatalf("ClosestPointOnPolyBoundary failed with status 0x%x", uint32(st))
+	}
+	if d := closest.Dist(d3.Vec3{0.8, 0, 0}); d > 1e-4 {
+		t.Errorf("got closest boundary point %v, want %v", closest, d3.Vec3{0.8, 0, 0})
+	}
+}
+
+func TestGetPolyDetailTris(t *testing.T) {
+	mesh := slopedEdgeMesh(t)
+	ref := mesh.polyRefBase(&mesh.Tiles[0])
+
+	verts := make([]d3.Vec3, 8)
+	tris := make([][3]int, 8)
+	nverts, ntris, st := mesh.GetPolyDetailTris(ref, verts, tris)
+	if st != Success {
+		t.Fatalf("GetPolyDetailTris failed with status 0x%x", uint32(st))
+	}
+	wantVerts := []d3.Vec3{{0, 0, 0}, {0, 0, 2}, {2, 0, 2}, {2, 0, 0}, {1, 1, 0.05}}
+	wantTris := [][3]int{{0, 1, 4}, {1, 2, 4}, {2, 3, 4}}
+	if nverts != len(wantVerts) || ntris != len(wantTris) {
+		t.Fatalf("got %d verts and %d tris, want %d and %d", nverts, ntris, len(wantVerts), len(wantTris))
+	}
+	for i := range wantVerts {
+		if verts[i].Dist(wantVerts[i]) > 1e-4 {
+			t.Errorf("vertex %d is %v, want %v", i, verts[i], wantVerts[i])
+		}
+	}
+	if !reflect.DeepEqual(tris[:ntris], wantTris) {
+		t.Errorf("got tris %v, want %v", tris[:ntris], wantTris)
+	}
+
+	// Truncated results.
+	nverts, ntris, st = mesh.GetPolyDetailTris(ref, verts[:2], tris[:1])
+	if nverts != 2 || ntris != 1 || st != Success|BufferTooSmall {
+		t.Errorf("with small buffers, got %d verts, %d tris and status 0x%x", nverts, ntris, uint32(st))
+	}
+
+	// Without detail mesh, the polygon is returned as a fan.
+	mesh.Tiles[0].DetailMeshes[0].TriCount = 0
+	nverts, ntris, st = mesh.GetPolyDetailTris(ref, verts, tris)
+	if st != Success || nverts != 4 {
+		t.Fatalf("without detail mesh, got %d verts and status 0x%x", nverts, uint32(st))
+	}
+	if want := [][3]int{{0, 1, 2}, {0, 2, 3}}; !reflect.DeepEqual(tris[:ntris], want) {
+		t.Errorf("without detail mesh, got tris %v, want %v", tris[:ntris], want)
+	}
+
+	if _, _, st := mesh.GetPolyDetailTris(0, verts, tris); !StatusFailed(st) {
+		t.Errorf("want failure status for ref 0, got 0x%x", uint32(st))
+	}
+}
+
+func TestPolySlope(t *testing.T) {
+	mesh := slopedEdgeMesh(t)
+	tile := &mesh.Tiles[0]
+	ref := mesh.polyRefBase(tile)
+
+	slope := func() float32 {
+		deg, st := mesh.PolySlope(ref)
+		if st != Success {
+			t.Fatalf("PolySlope failed with status 0x%x", uint32(st))
+		}
+		return deg
+	}
+
+	// The raised detail vertex makes the polygon not flat.
+	if deg := slope(); deg <= 1 || deg >= 45 {
+		t.Errorf("got slope %f for the detail mesh, want in ]1, 45[", deg)
+	}
+
+	// Without detail mesh, the polygon is flat.
+	tile.DetailMeshes[0].TriCount = 0
+	if deg := slope(); math32.Abs(deg) > 1e-3 {
+		t.Errorf("got slope %f for a flat polygon, want 0", deg)
+	}
+
+	// Raise the z=2 side to make a 45 degrees ramp.
+	tile.Verts[1*3+1] = 2
+	tile.Verts[2*3+1] = 2
+	if deg := slope(); math32.Abs(deg-45) > 1e-3 {
+		t.Errorf("got slope %f for a ramp, want 45", deg)
+	}
+
+	mesh, err := loadTestNavMesh("offmeshcons.bin")
+	checkt(t, err)
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		for ip := range tile.Polys {
+			if tile.Polys[ip].Type() != polyTypeOffMeshConnection {
+				continue
+			}
+			ref := mesh.polyRefBase(tile) | PolyRef(ip)
+			if _, st := mesh.PolySlope(ref); !StatusFailed(st) {
+				t.Errorf("poly 0x%x is an off-mesh connection, want failure status, got 0x%x", ref, uint32(st))
+			}
+		}
+	}
+}
+
+func TestNearestBoundaryPoint(t *testing.T) {
+	mesh := slopedEdgeMesh(t)
+	// Flatten the detail mesh.
+	mesh.Tiles[0].DetailVerts[1] = 0
+
+	st, q := NewNavMeshQuery(mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+
+	tests := []struct {
+		pos        d3.Vec3
+		radius     float32
+		wantPt     d3.Vec3
+		wantNormal d3.Vec3
+		wantDist   float32
+	}{
+		{d3.Vec3{0.5, 0, 1}, 1, d3.Vec3{0, 0, 1}, d3.Vec3{1, 0, 0}, 0.5},
+		{d3.Vec3{1.2, 0, 0.3}, 1, d3.Vec3{1.2, 0, 0}, d3.Vec3{0, 0, 1}, 0.3},
+		{d3.Vec3{1.5, 0.5, 1.9}, 1, d3.Vec3{1.5, 0, 2}, d3.Vec3{0, 0, -1}, 0.1},
+	}
+	for _, tt := range tests {
+		pt, normal, dist, st := q.NearestBoundaryPoint(tt.pos, tt.radius, filter)
+		if st != Success {
+			t.Errorf("pos %v, NearestBoundaryPoint failed with status 0x%x", tt.pos, uint32(st))
+			continue
+		}
+		if pt.Dist(tt.wantPt) > 1e-4 || normal.Dist(tt.wantNormal) > 1e-4 || math32.Abs(dist-tt.wantDist) > 1e-4 {
+			t.Errorf("pos %v, got pt %v, normal %v and dist %f, want %v, %v and %f",
+				tt.pos, pt, normal, dist, tt.wantPt, tt.wantNormal, tt.wantDist)
+		}
+	}
+
+	// No wall within radius.
+	if _, _, _, st := q.NearestBoundaryPoint(d3.Vec3{1, 0, 1}, 0.5, filter); !StatusFailed(st) {
+		t.Errorf("with no wall within radius, want failure status, got 0x%x", uint32(st))
+	}
+	// Not near the navmesh.
+	if _, _, _, st := q.NearestBoundaryPoint(d3.Vec3{10, 0, 10}, 1, filter); !StatusFailed(st) {
+		t.Errorf("far from the navmesh, want failure status, got 0x%x", uint32(st))
+	}
+}
+
+func TestDetectSteps(t *testing.T) {
+	mesh := slopedEdgeMesh(t)
+	st, q := NewNavMeshQuery(mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	ref := mesh.polyRefBase(&mesh.Tiles[0])
+
+	// The points are flat but the detail mesh rises to 1 near (1, 0.05).
+	path := []d3.Vec3{{0.2, 0, 1.8}, {1, 0, 0.1}, {1.8, 0, 1.8}, {1.9, 0, 1.9}}
+	refs := []PolyRef{ref, ref, ref, 0}
+
+	detectStepsTests := []struct {
+		minStep float32
+		want    []int
+	}{
+		{0.5, []int{0, 1}},
+		{0.05, []int{0, 1, 2}},
+		{2, nil},
+	}
+	for _, tt := range detectStepsTests {
+		if got := q.DetectSteps(path, refs, tt.minStep); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DetectSteps(minStep=%v) = %v, want %v", tt.minStep, got, tt.want)
+		}
+	}
+
+	if got := q.DetectSteps(path, refs[:2], 0.5); got != nil {
+		t.Errorf("DetectSteps with missing refs = %v, want nil", got)
+	}
+}
+
+func TestPathHasClearance(t *testing.T) {
+	mesh := slopedEdgeMesh(t)
+	// Flatten the detail mesh.
+	mesh.Tiles[0].DetailVerts[1] = 0
+
+	st, q := NewNavMeshQuery(mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	ref := mesh.polyRefBase(&mesh.Tiles[0])
+
+	// The first segment is 0.5 away from the walls of the [0, 2] square, the
+	// second gets 0.3 away. The tile is eroded by 0.5.
+	path := []d3.Vec3{{0.5, 0, 1}, {1.5, 0, 1}, {1.5, 0, 0.3}}
+	refs := []PolyRef{ref, ref, 0}
+
+	pathHasClearanceTests := []struct {
+		radius  float32
+		clear   bool
+		failIdx int
+	}{
+		{0.5, true, -1},
+		{0.75, true, -1},
+		{0.9, false, 1},
+		{1.1, false, 0},
+	}
+	for _, tt := range pathHasClearanceTests {
+		clear, failIdx := q.PathHasClearance(path, refs, tt.radius, filter)
+		if clear != tt.clear || failIdx != tt.failIdx {
+			t.Errorf("PathHasClearance(radius=%v) = %t, %d, want %t, %d", tt.radius, clear, failIdx, tt.clear, tt.failIdx)
+		}
+	}
+
+	if clear, failIdx := q.PathHasClearance(path, refs[:2], 0.5, filter); clear || failIdx != 0 {
+		t.Errorf("PathHasClearance with missing refs = %t, %d, want false, 0", clear, failIdx)
+	}
+	// Segments which can't be followed on the navmesh do not clear.
+	if clear, failIdx := q.PathHasClearance(path, []PolyRef{ref, 0, 0}, 0.5, filter); clear || failIdx != 1 {
+		t.Errorf("PathHasClearance with an invalid ref = %t, %d, want false, 1", clear, failIdx)
+	}
+}
+
+func TestPathHasClearanceLayers(t *testing.T) {
+	// A 4x4 floor at height 0, under an unconnected 0.4 wide bridge at height
+	// 0.4, crossing the floor along x.
+	data, err := CreateNavMeshData(&NavMeshCreateParams{
+		Verts: []uint16{
+			0, 0, 0, 0, 0, 40, 40, 0, 40, 40, 0, 0,
+			0, 2, 18, 0, 2, 22, 40, 2, 22, 40, 2, 18,
+		},
+		VertCount: 8,
+		Polys: []uint16{
+			0, 1, 2, 3, 0xffff, 0xffff, 0xffff, 0xffff,
+			4, 5, 6, 7, 0xffff, 0xffff, 0xffff, 0xffff,
+		},
+		PolyFlags:      []uint16{1, 1},
+		PolyAreas:      []uint8{0, 0},
+		PolyCount:      2,
+		Nvp:            4,
+		WalkableHeight: 2,
+		WalkableRadius: 0.5,
+		WalkableClimb:  0.5,
+		BMax:           [3]float32{4, 0.4, 4},
+		Cs:             0.1,
+		Ch:             0.2,
+		BuildBvTree:    true,
+	})
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	st, q := NewNavMeshQuery(&mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	floor := mesh.polyRefBase(&mesh.Tiles[0])
+
+	// The path runs on the floor, 0.5 away from its walls, right under the
+	// bridge, which walls are 0.2 away but vertically nearer to the path
+	// points. They are not the walls of the floor.
+	path := []d3.Vec3{{0.5, 0.25, 2}, {3.5, 0.25, 2}}
+	if clear, failIdx := q.PathHasClearance(path, []PolyRef{floor, 0}, 1, filter); !clear || failIdx != -1 {
+		t.Errorf("PathHasClearance under the bridge = %t, %d, want true, -1", clear, failIdx)
+	}
+	if clear, failIdx := q.PathHasClearance(path, []PolyRef{floor | 1, 0}, 1, filter); clear || failIdx != 0 {
+		t.Errorf("PathHasClearance on the bridge = %t, %d, want false, 0", clear, failIdx)
+	}
+}
+
+func TestSnapToMesh(t *testing.T) {
+	// A 4x4 floor at height 0, under a 2x4 ledge at height 1.
+	data, err := CreateNavMeshData(&NavMeshCreateParams{
+		Verts: []uint16{
+			0, 0, 0, 0, 0, 8, 8, 0, 8, 8, 0, 0,
+			0, 10, 0, 0, 10, 8, 4, 10, 8, 4, 10, 0,
+		},
+		VertCount: 8,
+		Polys: []uint16{
+			0, 1, 2, 3, 0x800f, 0x800f, 0x800f, 0x800f,
+			4, 5, 6, 7, 0x800f, 0x800f, 0x800f, 0x800f,
+		},
+		PolyFlags:      []uint16{1, 1},
+		PolyAreas:      []uint8{0, 0},
+		PolyCount:      2,
+		Nvp:            4,
+		WalkableHeight: 2,
+		WalkableRadius: 0.5,
+		WalkableClimb:  0.5,
+		BMax:           [3]float32{4, 2, 4},
+		Cs:             0.5,
+		Ch:             0.1,
+		BuildBvTree:    true,
+	})
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	st, q := NewNavMeshQuery(&mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	floor, ledge := mesh.polyRefBase(&mesh.Tiles[0]), mesh.polyRefBase(&mesh.Tiles[0])|1
+
+	// FindNearestPoly snaps on the ledge above.
+	pos := d3.Vec3{1, 0.6, 1}
+	if _, ref, _ := q.FindNearestPoly(pos, d3.Vec3{1, 1, 1}, filter); ref != ledge {
+		t.Fatalf("FindNearestPoly(%v) = 0x%x, want the ledge, 0x%x", pos, ref, ledge)
+	}
+
+	snapToMeshTests := []struct {
+		pos         d3.Vec3
+		maxHoriz    float32
+		maxVert     float32
+		wantRef     PolyRef
+		wantSnapped d3.Vec3
+	}{
+		{pos, 1, 1, floor, d3.Vec3{1, 0, 1}},
+		{d3.Vec3{1, 0.95, 1}, 1, 1, ledge, d3.Vec3{1, 1, 1}},
+		{d3.Vec3{4.3, 0.1, 1}, 1, 1, floor, d3.Vec3{4, 0, 1}},
+		{d3.Vec3{3, 0.5, 1}, 1, 1, floor, d3.Vec3{3, 0, 1}},
+		{d3.Vec3{2.2, 0.9, 1}, 1, 1, ledge, d3.Vec3{2, 1, 1}},
+	}
+	for _, tt := range snapToMeshTests {
+		snapped, ref, st := q.SnapToMesh(tt.pos, tt.maxHoriz, tt.maxVert, filter)
+		if st != Success || ref != tt.wantRef || snapped.Dist(tt.wantSnapped) > 1e-3 {
+			t.Errorf("SnapToMesh(%v) = %v, 0x%x, 0x%x, want %v, 0x%x, 0x%x",
+				tt.pos, snapped, ref, uint32(st), tt.wantSnapped, tt.wantRef, uint32(Success))
+		}
+	}
+
+	// A lower vertical weight snaps on the horizontally nearest surface.
+	q.SetSnapVerticalWeight(0.1)
+	if _, ref, _ := q.SnapToMesh(d3.Vec3{2.2, 0.9, 1}, 1, 1, filter); ref != floor {
+		t.Errorf("with a low vertical weight, SnapToMesh snapped to 0x%x, want the floor, 0x%x", ref, floor)
+	}
+	q.SetSnapVerticalWeight(0)
+
+	// Nothing within the limits.
+	for _, pos := range []d3.Vec3{{5, 0, 1}, {1, -1, 1}} {
+		if snapped, ref, st := q.SnapToMesh(pos, 0.5, 0.5, filter); st != Failure || ref != 0 || snapped != nil {
+			t.Errorf("SnapToMesh(%v) = %v, 0x%x, 0x%x, want not found", pos, snapped, ref, uint32(st))
+		}
+	}
+}
+
+func TestPolyContaining(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh1.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+
+	org := d3.Vec3{37.298489, -1.776901, 11.652311}
+	_, wantRef, onMesh := q.FindNearestPoly(org, d3.Vec3{2, 4, 2}, filter)
+	if wantRef == 0 {
+		t.Fatalf("no poly found near %v", org)
+	}
+
+	polyContainingTests := []struct {
+		msg     string
+		pos     d3.Vec3
+		vExtent float32
+		want    PolyRef
+	}{
+		{"on mesh", onMesh, 0.1, wantRef},
+		{"above mesh, within extent", onMesh.Add(d3.Vec3{0, 1, 0}), 1.5, wantRef},
+		{"above mesh, out of extent", onMesh.Add(d3.Vec3{0, 1, 0}), 0.5, 0},
+		{"off mesh", d3.Vec3{-100, 0, -100}, 10, 0},
+	}
+	for _, tt := range polyContainingTests {
+		ref, st := q.PolyContaining(tt.pos, tt.vExtent, filter)
+		if st != Success || ref != tt.want {
+			t.Errorf("%s, PolyContaining(%v, %v) = 0x%x, 0x%x, want 0x%x, 0x%x",
+				tt.msg, tt.pos, tt.vExtent, ref, uint32(st), tt.want, uint32(Success))
+		}
+	}
+
+	// A point next to the mesh, past a border edge of wantRef at z=6.8, is
+	// snapped by FindNearestPoly, but isn't contained by any polygon.
+	near := d3.Vec3{40.4, 0.2, 6.3}
+	if _, ref, _ := q.FindNearestPoly(near, d3.Vec3{2, 100, 2}, filter); ref == 0 {
+		t.Fatalf("FindNearestPoly(%v) found no poly", near)
+	}
+	if ref, st := q.PolyContaining(near, 100, filter); st != Success || ref != 0 {
+		t.Errorf("PolyContaining(%v) = 0x%x, 0x%x, want 0, 0x%x", near, ref, uint32(st), uint32(Success))
+	}
+
+	if _, st := q.PolyContaining(onMesh, -1, filter); st != Failure|InvalidParam {
+		t.Errorf("PolyContaining with negative extent returned status 0x%x, want 0x%x",
+			uint32(st), uint32(Failure|InvalidParam))
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	// A single square polygon of 2 units over the xz-plane, at heights
+	// within 0.1 of 0.
+	mesh := denseDetailMesh(t)
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+
+	coverageTests := []struct {
+		msg        string
+		bmin, bmax d3.Vec3
+		samples    int
+		want       float32
+	}{
+		{"over the polygon", d3.Vec3{0, -1, 0}, d3.Vec3{2, 1, 2}, 10, 1},
+		{"half over the polygon", d3.Vec3{0, -1, 0}, d3.Vec3{4, 1, 2}, 10, 0.5},
+		{"beside the polygon", d3.Vec3{3, -1, 0}, d3.Vec3{5, 1, 2}, 10, 0},
+		{"above the polygon", d3.Vec3{0, 1, 0}, d3.Vec3{2, 2, 2}, 10, 0},
+		{"no samples", d3.Vec3{0, -1, 0}, d3.Vec3{2, 1, 2}, 0, 0},
+		{"inverted bounds", d3.Vec3{2, -1, 0}, d3.Vec3{0, 1, 2}, 10, 0},
+	}
+	for _, tt := range coverageTests {
+		if got := q.Coverage(tt.bmin, tt.bmax, tt.samples, filter); got != tt.want {
+			t.Errorf("%s, Coverage(%v, %v, %d) = %v, want %v", tt.msg, tt.bmin, tt.bmax, tt.samples, got, tt.want)
+		}
+	}
+
+	// A longer vertical range reaches the polygon under the region.
+	q.SetCoverageVerticalRange(3)
+	if got := q.Coverage(d3.Vec3{0, 1, 0}, d3.Vec3{2, 2, 2}, 10, filter); got != 1 {
+		t.Errorf("with a vertical range of 3, coverage above the polygon = %v, want 1", got)
+	}
+	if got := q.Config().CoverageVerticalRange; got != 3 {
+		t.Errorf("configured vertical range = %v, want 3", got)
+	}
+
+	// Filtered out polygons do not cover the region.
+	excl := NewStandardQueryFilter()
+	excl.SetExcludeFlags(0xffff)
+	if got := q.Coverage(d3.Vec3{0, -1, 0}, d3.Vec3{2, 1, 2}, 10, excl); got != 0 {
+		t.Errorf("coverage with all polygons excluded = %v, want 0", got)
+	}
+}
+
+func TestFindNearestPolyOfArea(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	center, ext := d3.Vec3{30, 0, 20}, d3.Vec3{5, 2, 5}
+
+	st, nearest, _ := q.FindNearestPoly(center, ext, filter)
+	if StatusFailed(st) || nearest == 0 {
+		t.Fatalf("FindNearestPoly found no poly, status 0x%x", uint32(st))
+	}
+
+	// Change the area of another polygon of the search box.
+	polys := make([]PolyRef, 64)
+	n, st := q.queryPolygons6(center, ext, filter, polys, int32(len(polys)))
+	if StatusFailed(st) {
+		t.Fatalf("queryPolygons failed with status 0x%x", uint32(st))
+	}
+	var other PolyRef
+	for _, ref := range polys[:n] {
+		if ref != nearest {
+			other = ref
+			break
+		}
+	}
+	if other == 0 {
+		t.Fatal("a single polygon in the search box")
+	}
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	mesh.TileAndPolyByRefUnsafe(other, &tile, &poly)
+	const water = 5
+	poly.SetArea(water)
+
+	ref, pt, st := q.FindNearestPolyOfArea(center, ext, water, filter)
+	if st != Success || ref != other {
+		t.Errorf("FindNearestPolyOfArea(water) = 0x%x, 0x%x, want 0x%x, 0x%x", ref, uint32(st), other, uint32(Success))
+	}
+	if pt == nil {
+		t.Errorf("FindNearestPolyOfArea(water) returned no point")
+	}
+
+	// No polygon of the area.
+	ref, pt, st = q.FindNearestPolyOfArea(center, ext, water+1, filter)
+	if st != Success || ref != 0 || pt != nil {
+		t.Errorf("FindNearestPolyOfArea(%d) = 0x%x, %v, 0x%x, want 0, nil, 0x%x", water+1, ref, pt, uint32(st), uint32(Success))
+	}
+}
+
+// noDetailMesh returns a navmesh made of a single square polygon, sloped
+// along x, which tile has no detail mesh.
+func noDetailMesh(t *testing.T) *NavMesh {
+	params := &NavMeshCreateParams{
+		// x and z in [0, 2], y = x
+		Verts:     []uint16{0, 0, 0, 0, 0, 4, 4, 10, 4, 4, 10, 0},
+		VertCount: 4,
+		Polys: []uint16{
+			0, 1, 2, 3, 0xffff, 0xffff,
+			0xffff, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff,
+		},
+		PolyFlags:      []uint16{1},
+		PolyAreas:      []uint8{0},
+		PolyCount:      1,
+		Nvp:            6,
+		BMax:           [3]float32{2, 0, 2},
+		WalkableHeight: 2,
+		WalkableRadius: 0.5,
+		WalkableClimb:  0.5,
+		Cs:             0.5,
+		Ch:             0.2,
+		BuildBvTree:    true,
+	}
+	data, err := CreateNavMeshData(params)
+	checkt(t, err)
+
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+
+	// CreateNavMeshData always creates detail meshes, remove them.
+	tile := &mesh.Tiles[0]
+	tile.DetailMeshes, tile.DetailVerts, tile.DetailTris = nil, nil, nil
+	tile.Header.DetailMeshCount, tile.Header.DetailVertCount, tile.Header.DetailTriCount = 0, 0, 0
+	if errs := mesh.Validate(); errs != nil {
+		t.Fatalf("invalid navmesh: %v", errs)
+	}
+	return &mesh
+}
+
+func TestDegeneratePolyQueries(t *testing.T) {
+	isFinite := func(v d3.Vec3) bool {
+		for _, f := range v {
+			if f != f || math32.IsInf(f, 0) {
+				return false
+			}
+		}
+		return true
+	}
+
+	tests := []struct {
+		name   string
+		mesh   func(*testing.T) *NavMesh
+		modify func(tile *MeshTile)
+	}{
+		{"collinear detail triangle", slopedEdgeMesh, func(tile *MeshTile) {
+			// The detail vertex on the x=0 edge flattens the first triangle.
+			copy(tile.DetailVerts[0:3], []float32{0, 0.5, 1})
+		}},
+		{"duplicate detail vertex", slopedEdgeMesh, func(tile *MeshTile) {
+			copy(tile.DetailVerts[0:3], tile.Verts[0:3])
+		}},
+		{"sliver detail triangle", slopedEdgeMesh, func(tile *MeshTile) {
+			copy(tile.DetailVerts[0:3], []float32{1e-7, 0.5, 1})
+		}},
+		{"flipped sliver detail triangle", slopedEdgeMesh, func(tile *MeshTile) {
+			copy(tile.DetailVerts[0:3], []float32{-1e-5, 0.5, 1})
+		}},
+		{"zero-area polygon", noDetailMesh, func(tile *MeshTile) {
+			// All vertices on the z=x line.
+			for i := 0; i < len(tile.Verts); i += 3 {
+				tile.Verts[i+2] = tile.Verts[i]
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := tt.mesh(t)
+			tile := &mesh.Tiles[0]
+			tt.modify(tile)
+			ref := mesh.polyRefBase(tile)
+			st, q := NewNavMeshQuery(mesh, 16)
+			if StatusFailed(st) {
+				t.Fatalf("query creation failed with status 0x%x", uint32(st))
+			}
+
+			bmin, bmax, _ := mesh.PolyBounds(ref)
+			closest := d3.NewVec3()
+			for x := float32(-0.5); x <= 2.5; x += 0.125 {
+				for z := float32(-0.5); z <= 2.5; z += 0.125 {
+					pos := d3.Vec3{x, 1, z}
+					if st := q.ClosestPointOnPoly(ref, pos, closest, nil); st != Success || !isFinite(closest) {
+						t.Fatalf("ClosestPointOnPoly(%v) = %v, status 0x%x, want a finite point", pos, closest, uint32(st))
+					}
+					if closest[1] < bmin[1]-1e-3 || closest[1] > bmax[1]+1e-3 {
+						t.Errorf("ClosestPointOnPoly(%v) = %v, want a height in [%v, %v]", pos, closest, bmin[1], bmax[1])
+					}
+					if st := q.ClosestPointOnPolyBoundary(ref, pos, closest); st != Success || !isFinite(closest) {
+						t.Fatalf("ClosestPointOnPolyBoundary(%v) = %v, status 0x%x, want a finite point", pos, closest, uint32(st))
+					}
+					if h, st := q.PolyHeight(ref, pos); StatusSucceed(st) && !isFinite(d3.Vec3{0, h, 0}) {
+						t.Fatalf("PolyHeight(%v) = %v, want a finite height", pos, h)
+					}
+					if _, _, pt := q.FindNearestPoly(pos, d3.Vec3{1, 2, 1}, NewStandardQueryFilter()); !isFinite(pt) {
+						t.Fatalf("FindNearestPoly(%v) = %v, want a finite point", pos, pt)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("zero-length off-mesh connection", func(t *testing.T) {
+		mesh, err := loadTestNavMesh("offmeshcons.bin")
+		checkt(t, err)
+		st, q := NewNavMeshQuery(mesh, 16)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		var n int
+		for i := range mesh.Tiles {
+			tile := &mesh.Tiles[i]
+			if tile.Header == nil {
+				continue
+			}
+			for ip := range tile.Polys {
+				poly := &tile.Polys[ip]
+				if poly.Type() != polyTypeOffMeshConnection {
+					continue
+				}
+				n++
+				v0 := tile.Verts[poly.Verts[0]*3 : poly.Verts[0]*3+3]
+				copy(tile.Verts[poly.Verts[1]*3:poly.Verts[1]*3+3], v0)
+
+				ref := mesh.polyRefBase(tile) | PolyRef(ip)
+				closest := d3.NewVec3()
+				if st := q.ClosestPointOnPoly(ref, d3.NewVec3From(v0), closest, nil); st != Success || !closest.Approx(v0) {
+					t.Errorf("ClosestPointOnPoly(%v) = %v, status 0x%x, want the connection end point", v0, closest, uint32(st))
+				}
+			}
+		}
+		if n == 0 {
+			t.Fatal("no off-mesh connection found")
+		}
+	})
+}
+
+// denseDetailMesh returns a navmesh made of a single 2x2 flat square
+// polygon, which detail mesh is a 11x11 grid of bumps, of 200 triangles.
+func denseDetailMesh(t testing.TB) *NavMesh {
+	const k = 11
+	params := slopedEdgeParams()
+	params.DetailVerts = make([]float32, 0, k*k*3)
+	params.DetailTris = make([]uint8, 0, (k-1)*(k-1)*2*4)
+
+	// The polygon vertices come first.
+	idx := make([]int, k*k)
+	corners := [...]int{0, k - 1, k*k - 1, k * (k - 1)}
+	for i := range idx {
+		idx[i] = -1
+	}
+	for i, c := range corners {
+		idx[c] = i
+	}
+	next := len(corners)
+	for i := range idx {
+		if idx[i] < 0 {
+			idx[i] = next
+			next++
+		}
+	}
+	verts := make([]float32, k*k*3)
+	for x := 0; x < k; x++ {
+		for z := 0; z < k; z++ {
+			fx, fz := 2*float32(x)/(k-1), 2*float32(z)/(k-1)
+			v := verts[idx[x*k+z]*3:]
+			v[0], v[1], v[2] = fx, 0.1*math32.Sin(math.Pi*fx)*math32.Sin(math.Pi*fz), fz
+		}
+	}
+	params.DetailVerts = verts
+	for x := 0; x < k-1; x++ {
+		for z := 0; z < k-1; z++ {
+			a, b, c, d := idx[x*k+z], idx[x*k+z+1], idx[(x+1)*k+z+1], idx[(x+1)*k+z]
+			params.DetailTris = append(params.DetailTris,
+				uint8(a), uint8(b), uint8(c), 0,
+				uint8(a), uint8(c), uint8(d), 0)
+		}
+	}
+	params.DetailVertsCount = k * k
+	params.DetailTriCount = (k - 1) * (k - 1) * 2
+	params.DetailMeshes = []int32{0, k * k, 0, int32(params.DetailTriCount)}
+
+	data, err := CreateNavMeshData(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+	return &mesh
+}
+
+func TestPolyHeightApprox(t *testing.T) {
+	mesh := denseDetailMesh(t)
+	st, q := NewNavMeshQuery(mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	ref := mesh.polyRefBase(&mesh.Tiles[0])
+	bmin, bmax, _ := mesh.PolyBounds(ref)
+	bound := bmax[1] - bmin[1]
+
+	var maxErr float32
+	for x := float32(0); x <= 2; x += 0.07 {
+		for z := float32(0); z <= 2; z += 0.07 {
+			pos := d3.Vec3{x, 1, z}
+			exact, st := q.PolyHeight(ref, pos)
+			if st != Success {
+				t.Fatalf("PolyHeight(%v) failed with status 0x%x", pos, uint32(st))
+			}
+			approx, st := q.PolyHeightApprox(ref, pos)
+			if st != Success {
+				t.Fatalf("PolyHeightApprox(%v) failed with status 0x%x", pos, uint32(st))
+			}
+			if math32.Abs(approx) > 1e-5 {
+				t.Errorf("PolyHeightApprox(%v) = %f, want the flat polygon height 0", pos, approx)
+			}
+			if d := math32.Abs(exact - approx); d > maxErr {
+				maxErr = d
+			}
+		}
+	}
+	if maxErr > bound || maxErr < 0.05 {
+		t.Errorf("got max error %f, want at least 0.05, the bumps height, within the bound %f", maxErr, bound)
+	}
+
+	if _, st := q.PolyHeightApprox(ref, d3.Vec3{3, 0, 1}); st != Failure|InvalidParam {
+		t.Errorf("got status 0x%x outside of the polygon, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+	}
+
+	// Without detail mesh, both heights are the same.
+	mesh = noDetailMesh(t)
+	_, q = NewNavMeshQuery(mesh, 16)
+	ref = mesh.polyRefBase(&mesh.Tiles[0])
+	for _, pos := range []d3.Vec3{{0.5, 3, 1.5}, {1.8, -1, 0.2}, {1, 0, 1}} {
+		exact, _ := q.PolyHeight(ref, pos)
+		if approx, st := q.PolyHeightApprox(ref, pos); st != Success || !math32.Approx(approx, exact) {
+			t.Errorf("PolyHeightApprox(%v) = %f, status 0x%x, want %f", pos, approx, uint32(st), exact)
+		}
+	}
+}
+
+func BenchmarkPolyHeight(b *testing.B) {
+	mesh := denseDetailMesh(b)
+	_, q := NewNavMeshQuery(mesh, 16)
+	ref := mesh.polyRefBase(&mesh.Tiles[0])
+	pos := d3.Vec3{1.93, 1, 1.87}
+
+	b.Run("exact", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			q.PolyHeight(ref, pos)
+		}
+	})
+	b.Run("approx", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			q.PolyHeightApprox(ref, pos)
+		}
+	})
+}
+
+func TestHeightQueriesWithoutDetailMesh(t *testing.T) {
+	mesh := noDetailMesh(t)
+	st, q := NewNavMeshQuery(mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	_, ref, _ := q.FindNearestPoly(d3.Vec3{1, 1, 1}, d3.Vec3{0.5, 2, 0.5}, NewStandardQueryFilter())
+	if ref == 0 {
+		t.Fatal("polygon not found")
+	}
+
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+
+	tests := []struct {
+		pos, want d3.Vec3
+		over      bool
+	}{
+		{d3.Vec3{0.5, 3, 1.5}, d3.Vec3{0.5, 0.5, 1.5}, true},
+		{d3.Vec3{1.8, -1, 0.2}, d3.Vec3{1.8, 1.8, 0.2}, true},
+		{d3.Vec3{3, 0, 1}, d3.Vec3{2, 2, 1}, false},
+		{d3.Vec3{1, 0, -1}, d3.Vec3{1, 1, 0}, false},
+	}
+	for _, tt := range tests {
+		h, ok := polyHeight(tile, poly, tt.pos, UpAxisY)
+		if ok != tt.over || (ok && !math32.Approx(h, tt.want[1])) {
+			t.Errorf("polyHeight(%v) = %f, %t, want %f, %t", tt.pos, h, ok, tt.want[1], tt.over)
+		}
+
+		closest := d3.NewVec3()
+		var over bool
+		if st := q.ClosestPointOnPoly(ref, tt.pos, closest, &over); st != Success {
+			t.Fatalf("ClosestPointOnPoly(%v) status 0x%x", tt.pos, uint32(st))
+		}
+		if over != tt.over || !closest.Approx(tt.want) {
+			t.Errorf("ClosestPointOnPoly(%v) = %v, %t, want %v, %t", tt.pos, closest, over, tt.want, tt.over)
+		}
+
+		if st := q.ClosestPointOnPolyBoundary(ref, tt.pos, closest); st != Success {
+			t.Fatalf("ClosestPointOnPolyBoundary(%v) status 0x%x", tt.pos, uint32(st))
+		}
+		if !tt.over && !closest.Approx(tt.want) {
+			t.Errorf("ClosestPointOnPolyBoundary(%v) = %v, want %v", tt.pos, closest, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package detour
+
+import "github.com/arl/gogeo/f32/d3"
+
+// PolyHandle is a reference to a location of the navmesh that survives the
+// reloading of its tile.
+//
+// A PolyRef holds the salt of its tile, which is bumped each time the tile
+// is removed, so that the references to a streamed out tile are detected as
+// invalid (see NavMesh.IsValidPolyRef). A PolyHandle keeps the world
+// position of the location along with the last known reference of its
+// polygon, and finds the polygon again at that position when the reference
+// has been invalidated.
+type PolyHandle struct {
+	Pos d3.Vec3 // World position of the location. [(x, y, z)]
+	Ref PolyRef // Last known reference of the polygon at Pos, or 0.
+}
+
+// NewPolyHandle returns a handle to the location pos, on the polygon ref.
+//
+// ref may be 0, in which case the polygon is looked for at the first call
+// to Resolve.
+func NewPolyHandle(pos d3.Vec3, ref PolyRef) PolyHandle {
+	return PolyHandle{Pos: d3.NewVec3From(pos), Ref: ref}
+}
+
+// Resolve returns the reference of the polygon of the handle location.
+//
+//  Arguments:
+//   q       The query used to search the polygon.
+//   filter  The polygon filter to apply to the query.
+//
+//  Return values:
+//   ref     The reference of the polygon at h.Pos.
+//   ok      False if no polygon has been found at h.Pos, or if filter is
+//           nil.
+//
+// The last known reference is returned as long as it is valid and passes
+// filter. Otherwise the polygon nearest to h.Pos is searched with
+// FindNearestPoly, within the extents of q (see NavMeshQuery.Extents), and
+// h.Ref is updated with the result, 0 if none has been found.
+//
+// The polygon found again is the one of the reloaded tile that lies at h.Pos,
+// which is not the same polygon if the tile has been rebuilt with another
+// layout, if polygons of other tiles or layers are nearer to h.Pos than the
+// polygon of the location, as it happens on stacked floors, bridges or tile
+// borders once the tile of the location is unloaded, or if the polygon no
+// longer passes filter. Positions set on the polygon surface, such as the
+// points returned by the queries, are the least ambiguous.
+func (h *PolyHandle) Resolve(q *NavMeshQuery, filter QueryFilter) (PolyRef, bool) {
+	if filter == nil {
+		return 0, false
+	}
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if h.Ref != 0 && StatusSucceed(q.nav.TileAndPolyByRef(h.Ref, &tile, &poly)) &&
+		filter.PassFilter(h.Ref, tile, poly) {
+		return h.Ref, true
+	}
+
+	st, ref, _ := q.FindNearestPoly(h.Pos, q.Extents(), filter)
+	if StatusFailed(st) {
+		ref = 0
+	}
+	h.Ref = ref
+	return ref, ref != 0
+}
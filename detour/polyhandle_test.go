@@ -0,0 +1,73 @@
+package detour
+
+import (
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestPolyHandleResolve(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+
+	st, ref, pt := q.FindNearestPoly(d3.Vec3{5, 0, 5}, d3.Vec3{1, 4, 1}, filter)
+	if StatusFailed(st) || ref == 0 {
+		t.Fatalf("FindNearestPoly failed with status 0x%x, ref 0x%x", uint32(st), ref)
+	}
+	h := NewPolyHandle(pt, ref)
+	if got, ok := h.Resolve(q, filter); !ok || got != ref {
+		t.Errorf("Resolve() = 0x%x, %t, want the cached ref 0x%x", got, ok, ref)
+	}
+
+	// Reloading the tile bumps its salt.
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+	data := mesh.tileData(tile)
+	if _, st := mesh.RemoveTile(mesh.TileRef(tile)); StatusFailed(st) {
+		t.Fatalf("RemoveTile failed with status 0x%x", uint32(st))
+	}
+	if st, _ := mesh.AddTile(data, 0); StatusFailed(st) {
+		t.Fatalf("AddTile failed with status 0x%x", uint32(st))
+	}
+	if mesh.IsValidPolyRef(ref) {
+		t.Fatalf("ref 0x%x is still valid after reloading its tile", ref)
+	}
+
+	got, ok := h.Resolve(q, filter)
+	if !ok || !mesh.IsValidPolyRef(got) || got == ref {
+		t.Fatalf("Resolve() = 0x%x, %t after reloading the tile, want a new valid ref", got, ok)
+	}
+	if mesh.decodePolyIDPoly(got) != mesh.decodePolyIDPoly(ref) || mesh.decodePolyIDTile(got) != mesh.decodePolyIDTile(ref) {
+		t.Errorf("Resolve() = 0x%x, want the reloaded ref of poly 0x%x", got, ref)
+	}
+	if h.Ref != got {
+		t.Errorf("handle ref is 0x%x after Resolve, want 0x%x", h.Ref, got)
+	}
+
+	// Filtered out polygons are snapped again.
+	mesh.TileAndPolyByRefUnsafe(got, &tile, &poly)
+	excl := NewStandardQueryFilter()
+	excl.SetExcludeFlags(poly.Flags)
+	if ref, _ := h.Resolve(q, excl); ref == got {
+		t.Errorf("Resolve() = 0x%x, which does not pass the filter", ref)
+	}
+
+	off := NewPolyHandle(d3.Vec3{-100, 0, -100}, 0)
+	if ref, ok := off.Resolve(q, filter); ok || ref != 0 || off.Ref != 0 {
+		t.Errorf("Resolve() = 0x%x, %t off the navmesh, want 0, false and a 0 handle ref", ref, ok)
+	}
+
+	// A nil filter does not resolve, and keeps the handle ref.
+	h = NewPolyHandle(pt, got)
+	if ref, ok := h.Resolve(q, nil); ok || ref != 0 || h.Ref != got {
+		t.Errorf("Resolve(nil) = 0x%x, %t, handle ref 0x%x, want 0, false and 0x%x", ref, ok, h.Ref, got)
+	}
+}
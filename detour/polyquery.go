@@ -1,13 +1,22 @@
 package detour
 
 import (
-	"log"
 	"math"
 
 	"github.com/arl/gogeo/f32/d3"
 	"github.com/arl/math32"
 )
 
+// polyQueryBatchSize is the maximum number of polygons passed to each call
+// of polyQuery.process.
+const polyQueryBatchSize = 32
+
+// polyBatch holds a batch of polygons passed to polyQuery.process.
+type polyBatch struct {
+	refs  [polyQueryBatchSize]PolyRef
+	polys [polyQueryBatchSize]*Poly
+}
+
 // Provides custom polygon query behavior.
 // Used by NavMeshQuery.queryPolygons.
 type polyQuery interface {
@@ -22,6 +31,7 @@ type findNearestPolyQuery struct {
 	query              *NavMeshQuery
 	center             d3.Vec3
 	nearestDistanceSqr float32
+	nearestHeightDiff  float32 // vertical distance to nearestPoint
 	nearestRef         PolyRef
 	nearestPoint       d3.Vec3
 }
@@ -31,6 +41,7 @@ func newFindNearestPolyQuery(query *NavMeshQuery, center d3.Vec3) *findNearestPo
 		query:              query,
 		center:             center,
 		nearestDistanceSqr: math.MaxFloat32,
+		nearestHeightDiff:  math.MaxFloat32,
 		nearestRef:         0,
 		nearestPoint:       d3.NewVec3(),
 	}
@@ -51,8 +62,9 @@ func (q *findNearestPolyQuery) process(tile *MeshTile, polys []*Poly, refs []Pol
 		// If a point is directly over a polygon and closer than
 		// climb height, favor that instead of straight line nearest point.
 		diff := q.center.Sub(closestPtPoly)
+		up := q.query.nav.upAxis.index()
 		if posOverPoly {
-			d = math32.Abs(diff[1]) - tile.Header.WalkableClimb
+			d = math32.Abs(diff[up]) - tile.Header.WalkableClimb
 			if d > 0 {
 				d = d * d
 			} else {
@@ -62,10 +74,14 @@ func (q *findNearestPolyQuery) process(tile *MeshTile, polys []*Poly, refs []Pol
 			d = diff.LenSqr()
 		}
 
-		if d < q.nearestDistanceSqr {
+		// Polygons stacked closer than climb height, such as tile layers,
+		// are all at a null distance, favor the vertically nearest one.
+		dy := math32.Abs(diff[up])
+		if d < q.nearestDistanceSqr || d == q.nearestDistanceSqr && dy < q.nearestHeightDiff {
 			q.nearestPoint.Assign(closestPtPoly)
 
 			q.nearestDistanceSqr = d
+			q.nearestHeightDiff = dy
 			q.nearestRef = ref
 		}
 	}
@@ -97,7 +113,83 @@ func (q *collectPolysQuery) process(tile *MeshTile, polys []*Poly, refs []PolyRe
 		toCopy = numLeft
 	}
 
-	log.Fatal("untested")
 	copy(q.polys[q.numCollected:], refs[0:toCopy])
 	q.numCollected += toCopy
 }
+
+type containingPolyQuery struct {
+	up       UpAxis
+	pos      d3.Vec3
+	vExtent  float32
+	bestRef  PolyRef
+	bestDist float32 // vertical distance to the detail mesh of bestRef
+}
+
+func newContainingPolyQuery(up UpAxis, pos d3.Vec3, vExtent float32) *containingPolyQuery {
+	return &containingPolyQuery{
+		up:       up,
+		pos:      pos,
+		vExtent:  vExtent,
+		bestDist: math.MaxFloat32,
+	}
+}
+
+func (q *containingPolyQuery) process(tile *MeshTile, polys []*Poly, refs []PolyRef, count int32) {
+	for i := int32(0); i < count; i++ {
+		h, ok := polyHeight(tile, polys[i], q.pos, q.up)
+		if !ok {
+			continue
+		}
+		if d := math32.Abs(h - q.pos[q.up.index()]); d <= q.vExtent && d < q.bestDist {
+			q.bestDist = d
+			q.bestRef = refs[i]
+		}
+	}
+}
+
+type snapPolyQuery struct {
+	query              *NavMeshQuery
+	pos                d3.Vec3
+	maxHoriz, maxVert  float32
+	bestScore          float32
+	bestRef            PolyRef
+	bestPoint, closest d3.Vec3
+}
+
+func newSnapPolyQuery(query *NavMeshQuery, pos d3.Vec3, maxHoriz, maxVert float32) *snapPolyQuery {
+	return &snapPolyQuery{
+		query:     query,
+		pos:       pos,
+		maxHoriz:  maxHoriz,
+		maxVert:   maxVert,
+		bestScore: math.MaxFloat32,
+		bestPoint: d3.NewVec3(),
+		closest:   d3.NewVec3(),
+	}
+}
+
+func (q *snapPolyQuery) process(tile *MeshTile, polys []*Poly, refs []PolyRef, count int32) {
+	w := q.query.SnapVerticalWeight()
+	up := q.query.nav.upAxis.index()
+	for i := int32(0); i < count; i++ {
+		q.query.nav.closestPointOnPoly(refs[i], q.pos, q.closest, nil)
+		diff := q.closest.Sub(q.pos)
+		dy := diff[up]
+		diff[up] = 0
+		dh := diff.LenSqr()
+		if dh > q.maxHoriz*q.maxHoriz || math32.Abs(dy) > q.maxVert {
+			continue
+		}
+
+		// Surfaces above pos, such as ledges, are further penalized.
+		dv := dy * w
+		if dy > 0 {
+			dv *= 2
+		}
+		if score := dh + dv*dv; score < q.bestScore {
+			q.bestScore = score
+			q.bestRef = refs[i]
+			q.bestPoint.Assign(q.closest)
+		}
+	}
+}
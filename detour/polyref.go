@@ -0,0 +1,95 @@
+package detour
+
+import "github.com/arl/math32"
+
+// PolyRefLayout is the bit layout of the polygon and tile references of a
+// navigation mesh.
+//
+// A reference is made of 3 fields: the salt of the tile, the index of the
+// tile and the index of the polygon in the tile. Detour can be compiled to
+// use 32 or 64 bits references (with DT_POLYREF64), which changes the size
+// of these fields, as well as the binary format of the navmesh data, as it
+// holds references. Navmesh data must be loaded with the layout it has been
+// created with, else the references decode to invalid tiles or salts.
+type PolyRefLayout uint8
+
+const (
+	// PolyRefLayout32 is the layout of 32 bits references, the default Detour
+	// build and the one of RecastDemo navmesh files, such as the ones in the
+	// testdata directory.
+	//
+	// The bit count of the tile and polygon fields are the minimum required
+	// for NavMeshParams.MaxTiles and MaxPolys, the salt gets the remaining
+	// bits, and must get at least 10 of them.
+	PolyRefLayout32 PolyRefLayout = iota
+
+	// PolyRefLayout64 is the layout of 64 bits references, of Detour built
+	// with DT_POLYREF64: 16 salt bits, 28 tile bits and 20 polygon bits.
+	PolyRefLayout64
+
+	// PolyRefLayoutTrinityCore is the layout of 64 bits references of the
+	// Detour version modified by TrinityCore: 12 salt bits, 21 tile bits and
+	// 31 polygon bits. This is the layout of the .mmap and .mmtile files
+	// produced by the TrinityCore mmaps generator, loaded by cmd/wow.
+	PolyRefLayoutTrinityCore
+)
+
+// Size, in bytes, of a serialized link, in the navmesh data.
+const (
+	link32Size = 12
+	link64Size = 16
+)
+
+func (l PolyRefLayout) String() string {
+	switch l {
+	case PolyRefLayout32:
+		return "32 bits"
+	case PolyRefLayout64:
+		return "64 bits"
+	case PolyRefLayoutTrinityCore:
+		return "64 bits (TrinityCore)"
+	}
+	return "unknown layout"
+}
+
+// is64 reports whether references of the layout are stored on 64 bits.
+func (l PolyRefLayout) is64() bool {
+	return l != PolyRefLayout32
+}
+
+// linkSize returns the size of a link, as stored in the navmesh data.
+func (l PolyRefLayout) linkSize() int {
+	if l.is64() {
+		return link64Size
+	}
+	return link32Size
+}
+
+// bits returns the bit count of each reference field, for the specified
+// navmesh parameters. ok is false if the layout is unknown or if the
+// parameters leave too few salt bits.
+func (l PolyRefLayout) bits(params *NavMeshParams) (saltBits, tileBits, polyBits uint32, ok bool) {
+	switch l {
+	case PolyRefLayout32:
+		tileBits = math32.Ilog2(math32.NextPow2(params.MaxTiles))
+		polyBits = math32.Ilog2(math32.NextPow2(params.MaxPolys))
+		// Only allow 31 salt bits, since the salt mask is calculated using
+		// 32bit uint and it will overflow.
+		if tileBits+polyBits > 22 {
+			return 0, 0, 0, false
+		}
+		saltBits = 32 - tileBits - polyBits
+		if saltBits > 31 {
+			saltBits = 31
+		}
+		return saltBits, tileBits, polyBits, true
+	case PolyRefLayout64:
+		saltBits, tileBits, polyBits = 16, 28, 20
+	case PolyRefLayoutTrinityCore:
+		saltBits, tileBits, polyBits = 12, 21, 31
+	default:
+		return 0, 0, 0, false
+	}
+	ok = uint64(params.MaxTiles) <= 1<<tileBits && uint64(params.MaxPolys) <= 1<<polyBits
+	return saltBits, tileBits, polyBits, ok
+}
@@ -0,0 +1,172 @@
+package detour
+
+import (
+	"github.com/arl/math32"
+)
+
+// A ProximityGrid is a spatial hash grid storing items by their bounds on the
+// xz-plane, in order to quickly find the items near a location.
+//
+// Items are added after clearing the grid, then queried, typically once per
+// frame for moving items such as agents. An item is stored in each cell its
+// bounds overlaps, so the cell size should be close to the size of the items,
+// or of the query boxes: for agents of radius r, a cell size of about 3*r
+// and a pool size of 4 times the number of agents are good choices.
+type ProximityGrid struct {
+	cellSize    float32
+	invCellSize float32
+
+	pool     []proximityItem
+	poolHead int
+
+	buckets []int32 // first item of each bucket, or -1
+
+	bounds [4]int32
+}
+
+// proximityItem is an item stored in a grid cell.
+type proximityItem struct {
+	id   int
+	x, y int32
+	next int32 // next item in the bucket, or -1
+}
+
+// NewProximityGrid creates a proximity grid.
+//
+//  Arguments:
+//   poolSize  The maximum number of item cells the grid can hold, an item
+//             uses one per cell it overlaps. [Limit: > 0]
+//   cellSize  The size of the grid cells. [Limit: > 0]
+//
+// Return the status flags and the proximity grid.
+func NewProximityGrid(poolSize int, cellSize float32) (Status, *ProximityGrid) {
+	if poolSize <= 0 || !(cellSize > 0) {
+		return Failure | InvalidParam, nil
+	}
+	g := &ProximityGrid{
+		cellSize:    cellSize,
+		invCellSize: 1.0 / cellSize,
+		pool:        make([]proximityItem, poolSize),
+		buckets:     make([]int32, math32.NextPow2(uint32(poolSize))),
+	}
+	g.Clear()
+	return Success, g
+}
+
+// Clear removes all the items from the grid.
+func (g *ProximityGrid) Clear() {
+	for i := range g.buckets {
+		g.buckets[i] = -1
+	}
+	g.poolHead = 0
+	g.bounds = [4]int32{0xffff, 0xffff, -0xffff, -0xffff}
+}
+
+// AddItem adds an item to the grid.
+//
+//  Arguments:
+//   id          The item id, returned by QueryItems.
+//   minx, miny  The minimum bounds of the item, on the xz-plane.
+//   maxx, maxy  The maximum bounds of the item, on the xz-plane.
+//
+// The item is added to every cell its bounds overlaps. Once the pool is
+// full, the item cells that do not fit are silently dropped.
+func (g *ProximityGrid) AddItem(id int, minx, miny, maxx, maxy float32) {
+	iminx := int32(math32.Floor(minx * g.invCellSize))
+	iminy := int32(math32.Floor(miny * g.invCellSize))
+	imaxx := int32(math32.Floor(maxx * g.invCellSize))
+	imaxy := int32(math32.Floor(maxy * g.invCellSize))
+
+	g.bounds[0] = math32.MinInt32(g.bounds[0], iminx)
+	g.bounds[1] = math32.MinInt32(g.bounds[1], iminy)
+	if imaxx > g.bounds[2] {
+		g.bounds[2] = imaxx
+	}
+	if imaxy > g.bounds[3] {
+		g.bounds[3] = imaxy
+	}
+
+	for y := iminy; y <= imaxy; y++ {
+		for x := iminx; x <= imaxx; x++ {
+			if g.poolHead >= len(g.pool) {
+				return
+			}
+			h := g.hashPos(x, y)
+			idx := int32(g.poolHead)
+			g.poolHead++
+			g.pool[idx] = proximityItem{id: id, x: x, y: y, next: g.buckets[h]}
+			g.buckets[h] = idx
+		}
+	}
+}
+
+// QueryItems finds the items overlapping the cells of the specified bounds.
+//
+//  Arguments:
+//   minx, miny  The minimum bounds of the query, on the xz-plane.
+//   maxx, maxy  The maximum bounds of the query, on the xz-plane.
+//   out         The slice filled with the ids of the items found.
+//
+// Returns the number of ids written in out, each id appears once. The items
+// are found by cell, so they may be near but outside of the query bounds.
+// If out is too small, the search stops once it is full.
+func (g *ProximityGrid) QueryItems(minx, miny, maxx, maxy float32, out []int) int {
+	iminx := int32(math32.Floor(minx * g.invCellSize))
+	iminy := int32(math32.Floor(miny * g.invCellSize))
+	imaxx := int32(math32.Floor(maxx * g.invCellSize))
+	imaxy := int32(math32.Floor(maxy * g.invCellSize))
+
+	var n int
+	for y := iminy; y <= imaxy; y++ {
+		for x := iminx; x <= imaxx; x++ {
+			for idx := g.buckets[g.hashPos(x, y)]; idx != -1; idx = g.pool[idx].next {
+				item := &g.pool[idx]
+				if item.x != x || item.y != y {
+					continue
+				}
+				// Check if the id exists already.
+				var found bool
+				for _, id := range out[:n] {
+					if id == item.id {
+						found = true
+						break
+					}
+				}
+				if !found {
+					if n >= len(out) {
+						return n
+					}
+					out[n] = item.id
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// ItemCountAt returns the number of items in the grid cell at x, y.
+func (g *ProximityGrid) ItemCountAt(x, y int32) int {
+	var n int
+	for idx := g.buckets[g.hashPos(x, y)]; idx != -1; idx = g.pool[idx].next {
+		if item := &g.pool[idx]; item.x == x && item.y == y {
+			n++
+		}
+	}
+	return n
+}
+
+// Bounds returns the bounds of the items added since the last Clear, in
+// cells. [(minx, miny, maxx, maxy)]
+func (g *ProximityGrid) Bounds() [4]int32 {
+	return g.bounds
+}
+
+// CellSize returns the size of the grid cells.
+func (g *ProximityGrid) CellSize() float32 {
+	return g.cellSize
+}
+
+func (g *ProximityGrid) hashPos(x, y int32) int32 {
+	return ((x * 73856093) ^ (y * 19349663)) & int32(len(g.buckets)-1)
+}
@@ -0,0 +1,106 @@
+package detour
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/arl/math32"
+)
+
+func TestProximityGrid(t *testing.T) {
+	if st, _ := NewProximityGrid(0, 1); st != Failure|InvalidParam {
+		t.Errorf("NewProximityGrid with zero pool returned 0x%x, want InvalidParam", uint32(st))
+	}
+	st, g := NewProximityGrid(64, 1)
+	if StatusFailed(st) {
+		t.Fatalf("NewProximityGrid failed with 0x%x", uint32(st))
+	}
+
+	g.AddItem(1, 0.2, 0.2, 0.8, 0.8)   // cell (0,0)
+	g.AddItem(2, 0.5, 0.5, 1.5, 1.5)   // cells (0,0) to (1,1)
+	g.AddItem(3, -2.5, 3.5, -2.2, 3.8) // cell (-3,3)
+
+	if n := g.ItemCountAt(0, 0); n != 2 {
+		t.Errorf("ItemCountAt(0, 0) = %d, want 2", n)
+	}
+	if n := g.ItemCountAt(1, 1); n != 1 {
+		t.Errorf("ItemCountAt(1, 1) = %d, want 1", n)
+	}
+	if b, want := g.Bounds(), [4]int32{-3, 0, 1, 3}; b != want {
+		t.Errorf("Bounds() = %v, want %v", b, want)
+	}
+
+	tests := []struct {
+		minx, miny, maxx, maxy float32
+		want                   []int
+	}{
+		{0, 0, 0.1, 0.1, []int{1, 2}},
+		{1.1, 1.1, 5, 5, []int{2}},
+		{-3, 0, 2, 4, []int{1, 2, 3}},
+		{10, 10, 11, 11, nil},
+	}
+	out := make([]int, 8)
+	for _, tt := range tests {
+		n := g.QueryItems(tt.minx, tt.miny, tt.maxx, tt.maxy, out)
+		got := append([]int(nil), out[:n]...)
+		sort.Ints(got)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+			t.Errorf("QueryItems(%v, %v, %v, %v) = %v, want %v",
+				tt.minx, tt.miny, tt.maxx, tt.maxy, got, tt.want)
+		}
+	}
+
+	// Too small output.
+	if n := g.QueryItems(-3, 0, 2, 4, out[:2]); n != 2 {
+		t.Errorf("QueryItems with 2 ids output returned %d ids", n)
+	}
+
+	g.Clear()
+	if n := g.QueryItems(-3, 0, 2, 4, out); n != 0 {
+		t.Errorf("QueryItems after Clear returned %d ids, want 0", n)
+	}
+}
+
+func BenchmarkProximityGrid(b *testing.B) {
+	const radius = 0.6
+	for _, nagents := range []int{100, 500, 1000} {
+		// Agents spread with a constant density, of one per 25 square units.
+		size := math32.Sqrt(float32(nagents) * 25)
+		rnd := rand.New(rand.NewSource(1))
+		pos := make([][2]float32, nagents)
+		for i := range pos {
+			pos[i] = [2]float32{rnd.Float32() * size, rnd.Float32() * size}
+		}
+		const queryRange = radius * 4
+		out := make([]int, 32)
+
+		b.Run(fmt.Sprintf("grid/agents=%d", nagents), func(b *testing.B) {
+			_, g := NewProximityGrid(nagents*4, radius*3)
+			for i := 0; i < b.N; i++ {
+				g.Clear()
+				for id, p := range pos {
+					g.AddItem(id, p[0]-radius, p[1]-radius, p[0]+radius, p[1]+radius)
+				}
+				for _, p := range pos {
+					g.QueryItems(p[0]-queryRange, p[1]-queryRange, p[0]+queryRange, p[1]+queryRange, out)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("scan/agents=%d", nagents), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, p := range pos {
+					var n int
+					for id, q := range pos {
+						dx, dy := q[0]-p[0], q[1]-p[1]
+						if n < len(out) && dx*dx+dy*dy < queryRange*queryRange {
+							out[n] = id
+							n++
+						}
+					}
+				}
+			}
+		})
+	}
+}
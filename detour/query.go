@@ -3,7 +3,7 @@ package detour
 import (
 	"log"
 	"math"
-	"unsafe"
+	"time"
 
 	assert "github.com/arl/assertgo"
 	"github.com/arl/gogeo/f32"
@@ -12,7 +12,7 @@ import (
 )
 
 const (
-	// HScale is the search heuristic scale.
+	// HScale is the default search heuristic scale.
 	HScale float32 = 0.999
 
 	// Raycast should calculate movement cost along the ray and fill
@@ -50,7 +50,8 @@ type RaycastHit struct {
 	// The maximum number of polygons the @p path array can hold.
 	MaxPath int
 
-	//  The cost of the path until hit.
+	// The cost of the path until hit, only computed with the RaycastUseCosts
+	// option.
 	PathCost float32
 }
 
@@ -65,6 +66,12 @@ type RaycastHit struct {
 // change to the closed list. No impact on an in-progress sliced path query.
 // Etc.). When that is the case it will be clearly stated in the method comment.
 //
+// A NavMeshQuery is not safe for concurrent use: the path searches use its
+// node pools and open list, and FindNearestPolyArr reuses its scratch
+// buffers, so that it does not allocate. Without side effects only means that
+// these methods can be interleaved with a sliced path query. Concurrent
+// queries need one NavMeshQuery per goroutine. (See NavMeshQueryPool)
+//
 // Walls and portals: A wall is a polygon segment that is considered impassable.
 // A portal is a passable segment between polygons. A portal may be treated as a
 // wall based on the QueryFilter used for a query.
@@ -76,6 +83,20 @@ type NavMeshQuery struct {
 	tinyNodePool *NodePool  // Pointer to small node pool.
 	nodePool     *NodePool  // Pointer to node pool.
 	openList     *nodeQueue // Pointer to open list queue.
+	hScale       float32    // Search heuristic scale.
+	maxSearch    int        // Maximum number of nodes expanded by FindPath, or 0.
+	extents      d3.Vec3    // Configured nearest polygon search extents, or nil.
+	pathEps      float32    // Distance below which straight path vertices are merged, or 0.
+	snapWeight   float32    // Vertical distance weight of SnapToMesh, or 0.
+	coverRange   float32    // Vertical search range of Coverage, or 0.
+	observer     QueryObserver
+	overlay      CostOverlay // Transient polygon costs of the path searches, or nil.
+	trace        *pathTrace  // Nodes expanded by the last FindPath, or nil.
+
+	// Scratch buffers of FindNearestPolyArr, so that it does not allocate.
+	nearest   findNearestPolyQuery // Nearest polygon query.
+	centerBuf [3]float32           // Search center.
+	batch     polyBatch            // Polygon batches.
 }
 
 type queryData struct {
@@ -108,6 +129,9 @@ func newQueryData() queryData {
 // Must be the first function called after construction, before other
 // functions are used.
 // This function can be used multiple times.
+//
+// The search nodes are allocated as the queries need them, up to maxNodes,
+// so a large maxNodes only costs memory for the queries which use it.
 func NewNavMeshQuery(nav *NavMesh, maxNodes int32) (Status, *NavMeshQuery) {
 	if maxNodes > int32(nullIdx) || maxNodes > int32(1<<nodeParentBits)-1 {
 		return Failure | InvalidParam, nil
@@ -115,6 +139,9 @@ func NewNavMeshQuery(nav *NavMesh, maxNodes int32) (Status, *NavMeshQuery) {
 
 	q := &NavMeshQuery{}
 	q.nav = nav
+	q.hScale = HScale
+	q.nearest.query = q
+	q.nearest.nearestPoint = d3.NewVec3()
 
 	if q.nodePool == nil || q.nodePool.MaxNodes() < maxNodes {
 		if q.nodePool != nil {
@@ -152,6 +179,221 @@ func NewNavMeshQuery(nav *NavMesh, maxNodes int32) (Status, *NavMeshQuery) {
 	return Success, q
 }
 
+// Warmup allocates the nodes and the open list of the query, up to its
+// maximum number of nodes, so that the first searches run as fast as the
+// following ones.
+//
+// The node pools and the open list otherwise grow as the searches need
+// them, so that the memory of a query depends on the largest search it has
+// run. Warmup allocates them at once, which suits servers that would rather
+// pay this cost at startup than on the first requests. It is a no-op on a
+// query already warmed up, or which has already run a search as large as
+// its node pool.
+func (q *NavMeshQuery) Warmup() {
+	q.nodePool.reserve()
+	q.tinyNodePool.reserve()
+	q.openList.reserve()
+}
+
+// SetHeuristicScale sets the scale applied to the heuristic of the A* searches
+// run by FindPath, FindPathDetailed and the sliced path finding functions.
+//
+// The default, HScale, keeps the heuristic admissible and the paths optimal.
+// Greater values make the search greedier: fewer nodes are expanded, which
+// is faster and less likely to run out of nodes on long paths, but the paths
+// found are not guaranteed to be the cheapest anymore, by a factor up to the
+// scale. Values lower than HScale expand more nodes, down to 0 which turns
+// the search into a Dijkstra search.
+//
+// The scale of a sliced path find is read at each update, so it should not
+// change while a sliced query is in progress.
+func (q *NavMeshQuery) SetHeuristicScale(s float32) {
+	q.hScale = s
+}
+
+// HeuristicScale returns the scale applied to the search heuristic.
+//
+// see SetHeuristicScale
+func (q *NavMeshQuery) HeuristicScale() float32 {
+	return q.hScale
+}
+
+// SetMaxSearchNodes sets the maximum number of nodes a single FindPath may
+// expand, 0 or less meaning no limit, the default.
+//
+// This bounds the worst case duration of a path search, independently of
+// the node pool size, which bounds its memory. When the limit is reached the
+// search stops and the returned path is the partial path to the polygon the
+// nearest to the end found so far, with the PartialResult and OutOfNodes
+// details set in the status.
+//
+// The limit applies to FindPath, FindPathDetailed and the methods built upon
+// them, such as FindPathExcluding. Sliced path queries are bounded by their
+// iteration count instead.
+func (q *NavMeshQuery) SetMaxSearchNodes(n int) {
+	if n < 0 {
+		n = 0
+	}
+	q.maxSearch = n
+}
+
+// MaxSearchNodes returns the maximum number of nodes a single FindPath may
+// expand, or 0 if there is no limit.
+//
+// see SetMaxSearchNodes
+func (q *NavMeshQuery) MaxSearchNodes() int {
+	return q.maxSearch
+}
+
+// SetStraightPathEpsilon sets the distance below which consecutive vertices
+// of the straight paths are merged, 0 or less meaning that only equal
+// vertices are merged, the default.
+//
+// The funnel algorithm may emit vertices a tiny distance apart, for example
+// when crossing thin polygons with StraightPathAllCrossings or when the
+// funnel apex moves by a floating-point rounding error. Merging them avoids
+// a simplification pass on the straight path. A vertex is merged into the
+// previous one, keeping the position of the previous vertex. The first and
+// last vertices are never merged away: a vertex close to the start is only
+// dropped if it has no flags, and the end replaces the vertex it is close
+// to, unless that is the start. So the straight path keeps its exact start
+// and end positions, and its off-mesh connections.
+func (q *NavMeshQuery) SetStraightPathEpsilon(eps float32) {
+	if eps < 0 {
+		eps = 0
+	}
+	q.pathEps = eps
+}
+
+// StraightPathEpsilon returns the distance below which consecutive vertices
+// of the straight paths are merged, or 0 if only equal vertices are.
+//
+// see SetStraightPathEpsilon
+func (q *NavMeshQuery) StraightPathEpsilon() float32 {
+	return q.pathEps
+}
+
+// DefaultSnapVerticalWeight is the default weight of the vertical distance
+// in SnapToMesh.
+const DefaultSnapVerticalWeight = 4
+
+// SetSnapVerticalWeight sets the weight of the vertical distance relative to
+// the horizontal distance in SnapToMesh, 0 or less meaning
+// DefaultSnapVerticalWeight.
+//
+// The higher the weight, the more SnapToMesh favors the surfaces at the
+// height of the position over the horizontally nearest ones.
+func (q *NavMeshQuery) SetSnapVerticalWeight(w float32) {
+	if w < 0 {
+		w = 0
+	}
+	q.snapWeight = w
+}
+
+// SnapVerticalWeight returns the weight of the vertical distance in
+// SnapToMesh.
+//
+// see SetSnapVerticalWeight
+func (q *NavMeshQuery) SnapVerticalWeight() float32 {
+	if q.snapWeight == 0 {
+		return DefaultSnapVerticalWeight
+	}
+	return q.snapWeight
+}
+
+// SetCoverageVerticalRange sets the distance below the top of the region
+// within which Coverage looks for the navmesh surface, 0 or less meaning the
+// height of the region, the default.
+//
+// A range larger than the region height counts the samples over a surface
+// lying below the region, such as the floor under a region sampled at the
+// height of the agents.
+func (q *NavMeshQuery) SetCoverageVerticalRange(r float32) {
+	if r < 0 {
+		r = 0
+	}
+	q.coverRange = r
+}
+
+// CoverageVerticalRange returns the vertical search range of Coverage, or 0
+// if it is the height of the sampled region.
+//
+// see SetCoverageVerticalRange
+func (q *NavMeshQuery) CoverageVerticalRange() float32 {
+	return q.coverRange
+}
+
+// A QueryObserver is called after some NavMeshQuery methods, with the name
+// of the method, such as "FindPath", and its execution time.
+type QueryObserver func(op string, dur time.Duration)
+
+// SetQueryObserver sets the function called after each FindPath,
+// FindStraightPath and FindNearestPoly call, with the method name and its
+// execution time. A nil observer, the default, disables the observation, at
+// no cost other than a nil check.
+//
+// The observer is called on the goroutine running the query, which allows,
+// for example, to record metrics per query type, or to find the queries run
+// with the labels set with runtime/pprof.Do. It must not run queries on q.
+func (q *NavMeshQuery) SetQueryObserver(obs QueryObserver) {
+	q.observer = obs
+}
+
+// observe calls the query observer for the op method, which started at
+// start.
+func (q *NavMeshQuery) observe(op string, start time.Time) {
+	q.observer(op, time.Since(start))
+}
+
+// A CostOverlay adds a transient cost to the polygons entered by the path
+// searches, on top of the costs of their QueryFilter.
+//
+// Where the filter costs depend on the static properties of the polygons,
+// such as their area, an overlay is meant for dynamic, spatial costs, for
+// example a danger cost around the enemies of a unit, updated each frame, so
+// that the unit routes around them.
+type CostOverlay interface {
+	// ExtraCost returns the cost to add for entering the polygon ref at pos,
+	// the position of the search node in the polygon, which is the midpoint
+	// of the edge through which the polygon is entered. Negative costs are
+	// ignored, as the search requires costs that do not decrease along a
+	// path.
+	ExtraCost(ref PolyRef, pos d3.Vec3) float32
+}
+
+// SetCostOverlay sets the overlay which costs are added to the filter costs
+// by FindPath, FindPathDetailed and the sliced path searches, for each
+// polygon entered by the search, start polygon excepted. A nil overlay, the
+// default, leaves the paths unchanged.
+//
+// The overlay is applied during the search, as part of the cost of each
+// node, so the path is the cheapest one with the overlay costs, rather than
+// the default path rescored. As the overlay must not change during a
+// search, a sliced path search must be finalized before changing it, or the
+// values it returns.
+func (q *NavMeshQuery) SetCostOverlay(overlay CostOverlay) {
+	q.overlay = overlay
+}
+
+// CostOverlay returns the cost overlay of the path searches, or nil.
+//
+// see SetCostOverlay
+func (q *NavMeshQuery) CostOverlay() CostOverlay {
+	return q.overlay
+}
+
+// extraCost returns the cost overlay cost of entering ref at pos, 0 if there
+// is no overlay.
+func (q *NavMeshQuery) extraCost(ref PolyRef, pos d3.Vec3) float32 {
+	if q.overlay == nil {
+		return 0
+	}
+	if c := q.overlay.ExtraCost(ref, pos); c > 0 {
+		return c
+	}
+	return 0
+}
+
 // FindPath finds a path from the start polygon to the end polygon.
 //
 //  Arguments:
@@ -175,20 +417,160 @@ func NewNavMeshQuery(nav *NavMesh, maxNodes int32) (Status, *NavMeshQuery) {
 // The start and end positions are used to calculate traversal costs.
 // (The y-values impact the result.)
 //
-// Note: this method may be used by multiple clients without side effects.
+// If startRef is endRef, the path is that polygon alone, returned without
+// any search, however far apart startPos and endPos are.
+//
+// The search is deterministic: nodes of equal cost are expanded in
+// increasing polygon reference order, so the same query on the same navmesh
+// always returns the same path, whatever the queries run before.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
 func (q *NavMeshQuery) FindPath(
 	startRef, endRef PolyRef,
 	startPos, endPos d3.Vec3,
 	filter QueryFilter,
 	path []PolyRef) (pathCount int, st Status) {
+
+	if q.observer != nil {
+		defer q.observe("FindPath", time.Now())
+	}
+	return q.FindPathDetailed(startRef, endRef, startPos, endPos, filter, path, nil)
+}
+
+// FindPathExcluding finds a path from the start polygon to the end polygon,
+// which does not go through the polygons of a set.
+//
+//  Arguments:
+//   startRef, endRef, startPos, endPos, filter
+//              See FindPath.
+//   forbidden  The polygons the path can not go through.
+//   path       See FindPath.
+//
+//  Returns:
+//   pathCount the number of polygons in the found path slice.
+//   st        status code (may be a partial result)
+//
+// The forbidden polygons are rejected when they are reached by the search,
+// as if they were not passing filter, including off-mesh connections and
+// the polygons they lead to. This allows to avoid some polygons without
+// modifying their flags in the navmesh. The search starts from startRef,
+// even if it is forbidden. If endRef is forbidden, the path is partial and
+// ends at the polygon the nearest to it. With an empty or nil forbidden set,
+// FindPathExcluding is FindPath.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) FindPathExcluding(
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	forbidden map[PolyRef]struct{},
+	path []PolyRef) (pathCount int, st Status) {
+
+	if len(forbidden) != 0 && filter != nil {
+		filter = forbiddenPolysFilter{QueryFilter: filter, forbidden: forbidden}
+	}
+	return q.FindPath(startRef, endRef, startPos, endPos, filter, path)
+}
+
+// FindPathInTiles finds a path from the start polygon to the end polygon,
+// only going through the polygons of a set of tiles.
+//
+//  Arguments:
+//   startRef, endRef, startPos, endPos, filter
+//              See FindPath.
+//   allowed    Reports whether the tile at (tx, ty), in the navmesh tile
+//              grid, can be traversed.
+//   path       See FindPath.
+//
+//  Returns:
+//   pathCount the number of polygons in the found path slice.
+//   st        status code (may be a partial result)
+//
+// The search does not expand to the polygons of the tiles for which allowed
+// returns false, as if they were not passing filter. This bounds the cost of
+// short searches around an agent, for example to the tile it stands on and
+// its neighbours, whatever the navmesh size. The off-mesh connections of
+// allowed tiles do not lead the path out of them either.
+//
+// The search starts from startRef, even if its tile is not allowed. If the
+// tile of endRef is not allowed, or if endRef can not be reached from
+// startRef without leaving the allowed tiles, the path is partial and ends
+// at the polygon the nearest to endRef, and PartialResult is set in st.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) FindPathInTiles(
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	allowed func(tx, ty int32) bool,
+	path []PolyRef) (pathCount int, st Status) {
+
+	if allowed != nil && filter != nil {
+		filter = tilesFilter{QueryFilter: filter, allowed: allowed}
+	}
+	return q.FindPath(startRef, endRef, startPos, endPos, filter, path)
+}
+
+// FindPathDetailed finds a path from the start polygon to the end polygon,
+// and the cost to reach each polygon of the path.
+//
+//  Arguments:
+//   startRef  The reference id of the start polygon.
+//   endRef    The reference id of the end polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   endPos    A position within the end polygon. [(x, y, z)]
+//   filter    The polygon filter to apply to the query.
+//   path      This slice will be filled with an ordered list of polygon
+//             references representing the path. (Start to end.)
+//   costs     This slice will be filled with the accumulated cost to reach
+//             each polygon of path. [Length: >= len(path)]
+//
+//  Returns:
+//   pathCount the number of polygons in the found path slice.
+//   st        status code (may be a partial result)
+//
+// This is FindPath, also returning the costs computed by the search. costs[i]
+// is the cost to go from startPos to the point where the path enters
+// path[i], as returned by filter.Cost for each traversed polygon, plus the
+// cost overlay if any (see SetCostOverlay). Each step is costed by the area
+// of the polygon it leaves, as in Detour, so the area of path[i] only counts
+// from costs[i+1] on, or in the last cost for the end polygon. So costs[0] is
+// zero and, if the end polygon has been reached, the last cost is the total
+// cost of the path, up to endPos.
+//
+// costs may be nil, in which case this is the same as FindPath.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) FindPathDetailed(
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	path []PolyRef,
+	costs []float32) (pathCount int, st Status) {
 	// Validate input
 	if !q.nav.IsValidPolyRef(startRef) || !q.nav.IsValidPolyRef(endRef) ||
-		len(startPos) < 3 || len(endPos) < 3 || filter == nil || path == nil || len(path) == 0 {
+		len(startPos) < 3 || len(endPos) < 3 || filter == nil || path == nil || len(path) == 0 ||
+		(costs != nil && len(costs) < len(path)) {
 		return pathCount, Failure | InvalidParam
 	}
 
+	if q.trace != nil {
+		q.trace.reset(startRef)
+	}
+
 	if startRef == endRef {
 		path[0] = startRef
+		if costs != nil {
+			costs[0] = 0
+		}
 		return 1, Success
 	}
 
@@ -203,7 +585,7 @@ func (q *NavMeshQuery) FindPath(
 	startNode.Pos.Assign(startPos)
 	startNode.PIdx = 0
 	startNode.Cost = 0
-	startNode.Total = startPos.Dist(endPos) * HScale
+	startNode.Total = startPos.Dist(endPos) * q.hScale
 	startNode.ID = startRef
 	startNode.Flags = nodeOpen
 	q.openList.push(startNode)
@@ -212,12 +594,23 @@ func (q *NavMeshQuery) FindPath(
 	lastBestNodeCost = startNode.Total
 
 	outOfNodes := false
+	var expanded int
 
 	for !q.openList.empty() {
+		// Stop searching once the search budget is spent.
+		if q.maxSearch > 0 && expanded >= q.maxSearch {
+			outOfNodes = true
+			break
+		}
+		expanded++
+
 		// Remove node from open list and put it in closed list.
 		bestNode := q.openList.pop()
 		bestNode.Flags &= ^nodeOpen
 		bestNode.Flags |= nodeClosed
+		if q.trace != nil {
+			q.traceNode(bestNode)
+		}
 
 		// Reached the goal, stop searching.
 		if bestNode.ID == endRef {
@@ -320,8 +713,9 @@ func (q *NavMeshQuery) FindPath(
 					bestRef, bestTile, bestPoly,
 					neighbourRef, neighbourTile, neighbourPoly)
 				cost = bestNode.Cost + curCost
-				heuristic = neighbourNode.Pos.Dist(endPos) * HScale
+				heuristic = neighbourNode.Pos.Dist(endPos) * q.hScale
 			}
+			cost += q.extraCost(neighbourRef, neighbourNode.Pos)
 
 			total := cost + heuristic
 
@@ -340,6 +734,9 @@ func (q *NavMeshQuery) FindPath(
 			neighbourNode.Flags = (neighbourNode.Flags & NodeFlags(^NodeFlags(nodeClosed)))
 			neighbourNode.Cost = cost
 			neighbourNode.Total = total
+			if q.trace != nil {
+				q.trace.parents[neighbourRef] = bestRef
+			}
 
 			if (neighbourNode.Flags & nodeOpen) != 0 {
 				// Already in open, update node location.
@@ -358,7 +755,7 @@ func (q *NavMeshQuery) FindPath(
 		}
 	}
 
-	pathCount, status := q.pathToNode(lastBestNode, path)
+	pathCount, status := q.pathToNode(lastBestNode, path, costs)
 
 	if lastBestNode.ID != endRef {
 		status |= PartialResult
@@ -371,110 +768,709 @@ func (q *NavMeshQuery) FindPath(
 	return pathCount, status
 }
 
-// Vertex flags returned by NavMeshQuery.FindStraightPath.
-const (
-	// The vertex is the start position in the path.
-	StraightPathStart uint8 = 0x01
-	// The vertex is the end position in the path.
-	StraightPathEnd uint8 = 0x02
-	// The vertex is the start of an off-mesh connection.
-	StraightPathOffMeshConnection uint8 = 0x04
-)
+// ReachableSet finds all the polygons reachable from the start polygon
+// within a cost budget.
+//
+//  Arguments:
+//   startRef  The reference id of the start polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   maxCost   The maximum cost to reach a polygon. [Limit: >= 0]
+//   filter    The polygon filter to apply to the query.
+//
+//  Returns:
+//   set       The reachable polygons, with the cost to reach each of them.
+//   st        The status flags for the query.
+//
+// This is ReachableSetLimit without limit on the set size, see
+// ReachableSetLimit.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) ReachableSet(
+	startRef PolyRef,
+	startPos d3.Vec3,
+	maxCost float32,
+	filter QueryFilter) (set map[PolyRef]float32, st Status) {
 
-// Options for NavMeshQuery.FindStraightPath.
-const (
-	// Add a vertex at every polygon edge crossing where area changes.
-	StraightPathAreaCrossings uint8 = 0x01
-	// Add a vertex at every polygon edge crossing.
-	StraightPathAllCrossings uint8 = 0x02
-)
+	return q.ReachableSetLimit(startRef, startPos, maxCost, filter, 0)
+}
 
-// FindStraightPath finds the straight path from the start to the end position
-// within the polygon corridor
+// ReachableSetLimit finds the polygons reachable from the start polygon
+// within a cost budget, up to a maximum number of polygons.
 //
 //  Arguments:
-//   startPos          Path start position. [(x, y, z)]
-//   endPos            Path end position. [(x, y, z)]
-//   path              An array of polygon references that represent the
-//                     path corridor.
-//   straightPath      Points describing the straight path
-//                     [Length: == straightPathCount].
-//   straightPathFlags Flags describing each point.
-//                     (See: StraightPathFlags)
-//   straightPathRefs  The reference id of the polygon that is being
-//                     entered at each point.
-//   options           Query options. (see: StraightPathOptions)
+//   startRef  The reference id of the start polygon.
+//   startPos  A position within the start polygon. [(x, y, z)]
+//   maxCost   The maximum cost to reach a polygon. [Limit: >= 0]
+//   filter    The polygon filter to apply to the query.
+//   maxPolys  The maximum number of polygons in the set, zero or less means
+//             no limit other than the node pool size.
 //
-// Returns The status flags for the query and the number of point in the
-// straight path.
+//  Returns:
+//   set       The reachable polygons, with the cost to reach each of them.
+//   st        The status flags for the query.
 //
-// The straightPath, straightPathFlags and straightPathRefs slices must already
-// be allocated and contain the same number of elements.
+// The search is a Dijkstra expansion from startPos, the cost to reach a
+// polygon is the cost, as returned by filter.Cost, to go from startPos to the
+// point where the cheapest path enters the polygon. So the start polygon
+// cost is zero, and a polygon belongs to the set if that cost is not more
+// than maxCost.
 //
-// Note: this method may be used by multiple clients without side effects.
-func (q *NavMeshQuery) FindStraightPath(
-	startPos, endPos d3.Vec3,
-	path []PolyRef,
-	straightPath []d3.Vec3,
-	straightPathFlags []uint8,
-	straightPathRefs []PolyRef,
-	options int32) (straightPathCount int, st Status) {
+// The polygons are added to the set in increasing cost order. If the set
+// reaches maxPolys polygons, the search stops and the returned status has
+// the BufferTooSmall detail set: the set then holds the maxPolys cheapest
+// polygons to reach. If the search runs out of nodes, the returned status
+// has the OutOfNodes detail set and some reachable polygons may be missing.
+//
+// The size of the set, and of the memory used by the search, grows with the
+// reachable area, that is roughly as the square of maxCost on open ground.
+// The search is bounded by the number of nodes of the query (see
+// NewNavMeshQuery), maxPolys bounds the set further.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) ReachableSetLimit(
+	startRef PolyRef,
+	startPos d3.Vec3,
+	maxCost float32,
+	filter QueryFilter,
+	maxPolys int) (set map[PolyRef]float32, st Status) {
 
-	// parameter check
-	if len(straightPath) == 0 {
-		return 0, Failure | InvalidParam
-	}
-	if len(path) == 0 {
-		return 0, Failure | InvalidParam
+	// Validate input
+	if !q.nav.IsValidPolyRef(startRef) || len(startPos) < 3 || filter == nil ||
+		maxCost < 0 || math32.IsNaN(maxCost) {
+		return nil, Failure | InvalidParam
 	}
 
-	var (
-		stat  Status
-		count int
-	)
+	q.nodePool.Clear()
+	q.openList.clear()
 
-	// TODO: Should this be callers responsibility?
-	closestStartPos := d3.NewVec3()
-	if StatusFailed(q.ClosestPointOnPolyBoundary(path[0], startPos, closestStartPos)) {
-		return 0, Failure | InvalidParam
-	}
+	startNode := q.nodePool.Node(startRef, 0)
+	startNode.Pos.Assign(startPos)
+	startNode.PIdx = 0
+	startNode.Cost = 0
+	startNode.Total = 0
+	startNode.ID = startRef
+	startNode.Flags = nodeOpen
+	q.openList.push(startNode)
 
-	closestEndPos := d3.NewVec3()
-	if StatusFailed(q.ClosestPointOnPolyBoundary(path[len(path)-1], endPos, closestEndPos)) {
-		return 0, Failure | InvalidParam
-	}
+	set = make(map[PolyRef]float32)
+	st = Success
 
-	// Add start point.
-	stat = q.appendVertex(closestStartPos, StraightPathStart, path[0],
-		straightPath, straightPathFlags, straightPathRefs,
-		&count)
-	if stat != InProgress {
-		//fmt.Println("FindStraightPath returns", stat, count)
-		return count, stat
-	}
+	for !q.openList.empty() {
+		// Remove node from open list and put it in closed list.
+		bestNode := q.openList.pop()
+		bestNode.Flags &= ^nodeOpen
+		bestNode.Flags |= nodeClosed
 
-	if len(path) > 1 {
-		portalApex := d3.NewVec3From(closestStartPos)
-		portalLeft := d3.NewVec3From(portalApex)
-		portalRight := d3.NewVec3From(portalApex)
+		// A polygon may have several nodes, one per side it is entered from,
+		// keep the cheapest.
+		bestRef := bestNode.ID
+		if cost, ok := set[bestRef]; !ok {
+			if maxPolys > 0 && len(set) >= maxPolys {
+				st |= BufferTooSmall
+				break
+			}
+			set[bestRef] = bestNode.Cost
+		} else if bestNode.Cost < cost {
+			set[bestRef] = bestNode.Cost
+		}
+
+		// Get current poly and tile.
+		// The API input has been cheked already, skip checking internal data.
 		var (
-			apexIndex     int
-			leftIndex     int
-			rightIndex    int
-			leftPolyType  uint8
-			rightPolyType uint8
+			bestTile *MeshTile
+			bestPoly *Poly
 		)
+		q.nav.TileAndPolyByRefUnsafe(bestRef, &bestTile, &bestPoly)
 
-		leftPolyRef := path[0]
-		rightPolyRef := path[0]
-
-		for i := 0; i < len(path); i++ {
-			left := d3.NewVec3()
-			right := d3.NewVec3()
-			var toType uint8
-
-			if i+1 < len(path) {
-				var fromType uint8 // fromType is ignored.
+		// Get parent poly and tile.
+		var (
+			parentRef  PolyRef
+			parentTile *MeshTile
+			parentPoly *Poly
+		)
+		if bestNode.PIdx != 0 {
+			parentRef = q.nodePool.NodeAtIdx(int32(bestNode.PIdx)).ID
+		}
+		if parentRef != 0 {
+			q.nav.TileAndPolyByRefUnsafe(parentRef, &parentTile, &parentPoly)
+		}
+
+		for i := bestPoly.FirstLink; i != nullLink; i = bestTile.Links[i].Next {
+			neighbourRef := bestTile.Links[i].Ref
+
+			// Skip invalid ids and do not expand back to where we came from.
+			if neighbourRef == 0 || neighbourRef == parentRef {
+				continue
+			}
+
+			// Get neighbour poly and tile.
+			// The API input has been cheked already, skip checking internal data.
+			var (
+				neighbourTile *MeshTile
+				neighbourPoly *Poly
+			)
+			q.nav.TileAndPolyByRefUnsafe(neighbourRef, &neighbourTile, &neighbourPoly)
+
+			if !filter.PassFilter(neighbourRef, neighbourTile, neighbourPoly) {
+				continue
+			}
+
+			// deal explicitly with crossing tile boundaries
+			var crossSide uint8
+			if bestTile.Links[i].Side != 0xff {
+				crossSide = bestTile.Links[i].Side >> 1
+			}
+
+			// get the node
+			neighbourNode := q.nodePool.Node(neighbourRef, crossSide)
+			if neighbourNode == nil {
+				st |= OutOfNodes
+				continue
+			}
+
+			// If the node is visited the first time, calculate node position.
+			if neighbourNode.Flags == 0 {
+				status := q.edgeMidPoint(bestRef, bestPoly, bestTile,
+					neighbourRef, neighbourPoly, neighbourTile,
+					neighbourNode.Pos[:])
+				if StatusFailed(status) {
+					log.Println("getEdgeMidPoint failed:", status)
+				}
+			}
+
+			cost := bestNode.Cost + filter.Cost(bestNode.Pos[:], neighbourNode.Pos[:],
+				parentRef, parentTile, parentPoly,
+				bestRef, bestTile, bestPoly,
+				neighbourRef, neighbourTile, neighbourPoly)
+
+			// Out of budget, skip.
+			if cost > maxCost {
+				continue
+			}
+			// The node is already visited, and the new result is worse, skip.
+			if (neighbourNode.Flags&(nodeOpen|nodeClosed)) != 0 && cost >= neighbourNode.Total {
+				continue
+			}
+
+			// Add or update the node.
+			neighbourNode.PIdx = q.nodePool.NodeIdx(bestNode)
+			neighbourNode.ID = neighbourRef
+			neighbourNode.Flags = (neighbourNode.Flags & NodeFlags(^NodeFlags(nodeClosed)))
+			neighbourNode.Cost = cost
+			neighbourNode.Total = cost
+
+			if (neighbourNode.Flags & nodeOpen) != 0 {
+				// Already in open, update node location.
+				q.openList.modify(neighbourNode)
+			} else {
+				// Put the node in open list.
+				neighbourNode.Flags |= nodeOpen
+				q.openList.push(neighbourNode)
+			}
+		}
+	}
+
+	return set, st
+}
+
+// NearestBoundaryPoint finds the nearest point of the navmesh boundary, that
+// is of the walls, to a position.
+//
+//  Arguments:
+//   pos        The position to search from. [(x, y, z)]
+//   maxRadius  The radius of the search. [Limit: > 0]
+//   filter     The polygon filter to apply to the query.
+//
+//  Returns:
+//   pt         The nearest boundary point. [(x, y, z)]
+//   normal     The normalized vector from pt to the search center.
+//              [(x, y, z)]
+//   dist       The distance from the search center to pt.
+//   st         The status flags for the query.
+//
+// The search center is the nearest point of the navmesh to pos, within
+// maxRadius in all directions. From the polygon containing it, the
+// polygons reachable within maxRadius are visited, and their edges which do
+// not lead to a polygon passing filter are the walls. Distances are measured
+// on the xz-plane.
+//
+// Failure is returned if pos is not near the navmesh or if there is no wall
+// within maxRadius of the search center. If the search runs out of nodes,
+// the returned status has the OutOfNodes detail set and the point found may
+// not be the nearest. normal is zero if the search center lies on the wall.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) NearestBoundaryPoint(
+	pos d3.Vec3,
+	maxRadius float32,
+	filter QueryFilter) (pt, normal d3.Vec3, dist float32, st Status) {
+
+	// Validate input
+	if len(pos) < 3 || filter == nil || !(maxRadius > 0) || math32.IsInf(maxRadius, 1) {
+		return nil, nil, 0, Failure | InvalidParam
+	}
+
+	extents := d3.NewVec3XYZ(maxRadius, maxRadius, maxRadius)
+	st, startRef, centerPos := q.FindNearestPoly(pos, extents, filter)
+	if StatusFailed(st) {
+		return nil, nil, 0, st
+	}
+	if startRef == 0 {
+		return nil, nil, 0, Failure
+	}
+
+	hitPos, dist, found, st := q.nearestWall(startRef, centerPos, maxRadius, filter)
+	if !found {
+		return nil, nil, 0, Failure | (st & StatusDetailMask)
+	}
+
+	// Calc hit normal.
+	normal = centerPos.Sub(hitPos)
+	if normal.Len() > 0.00001 {
+		normal.Normalize()
+	} else {
+		normal = d3.NewVec3()
+	}
+	return hitPos, normal, dist, st
+}
+
+// nearestWall finds the wall nearest to centerPos, within maxRadius on the
+// xz-plane, visiting the polygons reachable from startRef, the polygon
+// containing centerPos. found is false if there is no such wall. The status
+// has the OutOfNodes detail set if the search ran out of nodes.
+func (q *NavMeshQuery) nearestWall(
+	startRef PolyRef,
+	centerPos d3.Vec3,
+	maxRadius float32,
+	filter QueryFilter) (hitPos d3.Vec3, dist float32, found bool, st Status) {
+
+	q.nodePool.Clear()
+	q.openList.clear()
+
+	startNode := q.nodePool.Node(startRef, 0)
+	startNode.Pos.Assign(centerPos)
+	startNode.PIdx = 0
+	startNode.Cost = 0
+	startNode.Total = 0
+	startNode.ID = startRef
+	startNode.Flags = nodeOpen
+	q.openList.push(startNode)
+
+	radiusSqr := maxRadius * maxRadius
+	hitPos = d3.NewVec3()
+	st = Success
+
+	for !q.openList.empty() {
+		bestNode := q.openList.pop()
+		bestNode.Flags &= ^nodeOpen
+		bestNode.Flags |= nodeClosed
+
+		// Get poly and tile.
+		// The API input has been cheked already, skip checking internal data.
+		bestRef := bestNode.ID
+		var (
+			bestTile *MeshTile
+			bestPoly *Poly
+		)
+		q.nav.TileAndPolyByRefUnsafe(bestRef, &bestTile, &bestPoly)
+
+		// Get parent poly and tile.
+		var parentRef PolyRef
+		if bestNode.PIdx != 0 {
+			parentRef = q.nodePool.NodeAtIdx(int32(bestNode.PIdx)).ID
+		}
+
+		// Hit test walls.
+		nv := int(bestPoly.VertCount)
+		for i, j := 0, nv-1; i < nv; j, i = i, i+1 {
+			// Skip non-solid edges.
+			if bestPoly.Neis[j]&extLink != 0 {
+				// Tile border.
+				solid := true
+				for k := bestPoly.FirstLink; k != nullLink; k = bestTile.Links[k].Next {
+					link := &bestTile.Links[k]
+					if int(link.Edge) == j {
+						if link.Ref != 0 {
+							var (
+								neiTile *MeshTile
+								neiPoly *Poly
+							)
+							q.nav.TileAndPolyByRefUnsafe(link.Ref, &neiTile, &neiPoly)
+							if filter.PassFilter(link.Ref, neiTile, neiPoly) {
+								solid = false
+							}
+						}
+						break
+					}
+				}
+				if !solid {
+					continue
+				}
+			} else if bestPoly.Neis[j] != 0 {
+				// Internal edge
+				idx := uint32(bestPoly.Neis[j] - 1)
+				ref := q.nav.polyRefBase(bestTile) | PolyRef(idx)
+				if filter.PassFilter(ref, bestTile, &bestTile.Polys[idx]) {
+					continue
+				}
+			}
+
+			// Calc distance to the edge.
+			vj := d3.Vec3(bestTile.Verts[bestPoly.Verts[j]*3 : bestPoly.Verts[j]*3+3])
+			vi := d3.Vec3(bestTile.Verts[bestPoly.Verts[i]*3 : bestPoly.Verts[i]*3+3])
+			var tseg float32
+			distSqr := distancePtSegSqr2D(centerPos, vj, vi, &tseg)
+
+			// Edge is too far, skip.
+			if distSqr > radiusSqr {
+				continue
+			}
+
+			// Hit wall, update radius.
+			radiusSqr = distSqr
+			found = true
+			d3.Vec3Lerp(hitPos, vj, vi, tseg)
+		}
+
+		for i := bestPoly.FirstLink; i != nullLink; i = bestTile.Links[i].Next {
+			link := &bestTile.Links[i]
+			neighbourRef := link.Ref
+
+			// Skip invalid neighbours and do not follow back to parent.
+			if neighbourRef == 0 || neighbourRef == parentRef {
+				continue
+			}
+
+			// Expand to neighbour.
+			var (
+				neighbourTile *MeshTile
+				neighbourPoly *Poly
+			)
+			q.nav.TileAndPolyByRefUnsafe(neighbourRef, &neighbourTile, &neighbourPoly)
+
+			// Skip off-mesh connections.
+			if neighbourPoly.Type() == polyTypeOffMeshConnection {
+				continue
+			}
+
+			// Calc distance to the edge.
+			va := d3.Vec3(bestTile.Verts[bestPoly.Verts[link.Edge]*3:][:3])
+			vb := d3.Vec3(bestTile.Verts[bestPoly.Verts[(link.Edge+1)%bestPoly.VertCount]*3:][:3])
+			var tseg float32
+			distSqr := distancePtSegSqr2D(centerPos, va, vb, &tseg)
+
+			// If the circle is not touching the next polygon, skip it.
+			if distSqr > radiusSqr {
+				continue
+			}
+
+			if !filter.PassFilter(neighbourRef, neighbourTile, neighbourPoly) {
+				continue
+			}
+
+			var crossSide uint8
+			if link.Side != 0xff {
+				crossSide = link.Side >> 1
+			}
+			neighbourNode := q.nodePool.Node(neighbourRef, crossSide)
+			if neighbourNode == nil {
+				st |= OutOfNodes
+				continue
+			}
+			if neighbourNode.Flags&nodeClosed != 0 {
+				continue
+			}
+
+			// Cost
+			if neighbourNode.Flags == 0 {
+				q.edgeMidPoint(bestRef, bestPoly, bestTile,
+					neighbourRef, neighbourPoly, neighbourTile,
+					neighbourNode.Pos[:])
+			}
+			total := bestNode.Total + bestNode.Pos.Dist(neighbourNode.Pos)
+
+			// The node is already in open list and the new result is worse, skip.
+			if neighbourNode.Flags&nodeOpen != 0 && total >= neighbourNode.Total {
+				continue
+			}
+
+			neighbourNode.ID = neighbourRef
+			neighbourNode.Flags &= NodeFlags(^NodeFlags(nodeClosed))
+			neighbourNode.PIdx = q.nodePool.NodeIdx(bestNode)
+			neighbourNode.Total = total
+
+			if neighbourNode.Flags&nodeOpen != 0 {
+				q.openList.modify(neighbourNode)
+			} else {
+				neighbourNode.Flags |= nodeOpen
+				q.openList.push(neighbourNode)
+			}
+		}
+	}
+
+	return hitPos, math32.Sqrt(radiusSqr), found, st
+}
+
+// Vertex flags returned by NavMeshQuery.FindStraightPath.
+const (
+	// The vertex is the start position in the path.
+	StraightPathStart uint8 = 0x01
+	// The vertex is the end position in the path.
+	StraightPathEnd uint8 = 0x02
+	// The vertex is the start of an off-mesh connection.
+	StraightPathOffMeshConnection uint8 = 0x04
+)
+
+// Options for NavMeshQuery.FindStraightPath.
+const (
+	// Add a vertex at every polygon edge crossing where area changes.
+	StraightPathAreaCrossings uint8 = 0x01
+	// Add a vertex at every polygon edge crossing.
+	StraightPathAllCrossings uint8 = 0x02
+	// Stop at the start of the first off-mesh connection.
+	StraightPathStopAtOffMesh uint8 = 0x04
+	// Give the end point the reference of the last polygon of the path,
+	// rather than 0 as Detour does.
+	StraightPathEndRef uint8 = 0x08
+)
+
+// FindStraightPath finds the straight path from the start to the end position
+// within the polygon corridor
+//
+//  Arguments:
+//   startPos          Path start position. [(x, y, z)]
+//   endPos            Path end position. [(x, y, z)]
+//   path              An array of polygon references that represent the
+//                     path corridor.
+//   straightPath      Points describing the straight path
+//                     [Length: == straightPathCount].
+//   straightPathFlags Flags describing each point.
+//                     (See: StraightPathFlags)
+//   straightPathRefs  The reference id of the polygon that is being
+//                     entered at each point, which the point lies on.
+//   options           Query options. (see: StraightPathOptions)
+//
+// Returns The status flags for the query and the number of point in the
+// straight path.
+//
+// The straightPath, straightPathFlags and straightPathRefs slices must already
+// be allocated and contain the same number of elements.
+//
+// Each point has the reference of the polygon it lies on, inside or on the
+// boundary: the first polygon of path for the start point, and the polygon
+// entered at the point for the following ones, which lie on the edge shared
+// with the previous polygon. As in Detour, the end point has a zero
+// reference. With the StraightPathEndRef option, it has the reference of the
+// last polygon of path, so that the polygon of each point can be queried, for
+// its area or flags for example.
+//
+// If the straight path has more points than straightPath can hold, the path
+// is truncated at len(straightPath) points and the returned status has the
+// BufferTooSmall detail set. The caller can then retry with a bigger buffer.
+// Options adding points at polygon crossings may return many more points
+// than there are polygons in path. Consecutive vertices closer than the
+// straight path epsilon of the query are merged. (See SetStraightPathEpsilon)
+// So the straight path of a single polygon path, as FindPath returns when the
+// start and end polygons are the same, has exactly the start and end points,
+// or only the end point if both positions are merged.
+//
+// With the StraightPathStopAtOffMesh option, the straight path stops at the
+// first off-mesh connection of path: its last point is the start of the
+// connection, flagged with StraightPathOffMeshConnection, which ref is the
+// off-mesh connection polygon. The returned status then has the
+// PartialResult detail set, like a path stopped at an invalid polygon, the
+// last point flags telling both cases apart. This allows agents to traverse
+// the connection, then to compute the straight path of the rest of path.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindStraightPath(
+	startPos, endPos d3.Vec3,
+	path []PolyRef,
+	straightPath []d3.Vec3,
+	straightPathFlags []uint8,
+	straightPathRefs []PolyRef,
+	options int32) (straightPathCount int, st Status) {
+
+	if q.observer != nil {
+		defer q.observe("FindStraightPath", time.Now())
+	}
+
+	// parameter check
+	if len(straightPath) == 0 {
+		return 0, Failure | InvalidParam
+	}
+	if len(path) == 0 {
+		return 0, Failure | InvalidParam
+	}
+	return q.findStraightPath(startPos, endPos, path, 0,
+		straightPath, straightPathFlags, straightPathRefs, options)
+}
+
+// FindStraightPathFrom computes a chunk of the straight path of a polygon
+// corridor, resuming from a point of it, so that a long straight path can be
+// paged through with a small buffer.
+//
+//  Arguments:
+//   path        The polygon corridor. [Length: > startIdx]
+//   startIdx    The index in path of the polygon the chunk starts from, 0 for
+//               the first chunk, then the index returned by the previous
+//               chunk.
+//   startPos    The position the chunk starts from, the path start position
+//               for the first chunk, then straightPath[count] of the
+//               previous chunk. [(x, y, z)]
+//   endPos      Path end position. [(x, y, z)]
+//   straightPath, straightPathFlags, options
+//               See FindStraightPath. [Length: >= 2]
+//   straightPathRefs
+//               The reference id of the polygon that is being entered at
+//               each point. Unlike FindStraightPath, it is required, to
+//               find the polygon the next chunk starts from.
+//               [Length: >= len(straightPath)]
+//
+//  Return values:
+//   count       The number of points of the chunk.
+//   nextIdx     The startIdx of the next chunk, or -1 for the last chunk.
+//   st          The status flags for the query.
+//
+// A chunk which is not the last one holds its last point back, leaving it
+// in straightPath[count], as it may still be merged with the points that
+// follow it: it starts the next chunk. Without the options adding points at
+// polygon crossings, the chunks concatenation is the straight path
+// FindStraightPath computes with a buffer big enough. With them, the funnel
+// is restarted at the crossing a chunk starts from, the points that follow
+// may differ.
+//
+// The status of the last chunk is the one FindStraightPath would return, with
+// the PartialResult detail set if the straight path has been stopped before
+// the end of the corridor.
+//
+// startPos is used as is for the chunks following the first one: it must be
+// copied before reusing straightPath, which may overwrite it.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindStraightPathFrom(
+	path []PolyRef,
+	startIdx int,
+	startPos, endPos d3.Vec3,
+	straightPath []d3.Vec3,
+	straightPathFlags []uint8,
+	straightPathRefs []PolyRef,
+	options int32) (count, nextIdx int, st Status) {
+
+	if q.observer != nil {
+		defer q.observe("FindStraightPathFrom", time.Now())
+	}
+
+	if len(straightPath) < 2 || len(straightPathRefs) < len(straightPath) {
+		return 0, -1, Failure | InvalidParam
+	}
+	if startIdx < 0 || startIdx >= len(path) {
+		return 0, -1, Failure | InvalidParam
+	}
+	count, st = q.findStraightPath(d3.NewVec3From(startPos), endPos, path, startIdx,
+		straightPath, straightPathFlags, straightPathRefs, options)
+	if StatusFailed(st) || !StatusDetail(st, BufferTooSmall) {
+		return count, -1, st
+	}
+
+	// Hold the last point back, the next chunk starts from it.
+	count--
+	for i := startIdx; i < len(path); i++ {
+		if path[i] == straightPathRefs[count] {
+			return count, i, Success
+		}
+	}
+	return 0, -1, Failure | InvalidParam
+}
+
+// findStraightPath computes the straight path of the corridor path, from the
+// polygon at index startIdx. The start position is clamped on the first
+// polygon of path if startIdx is 0, otherwise it is the apex the funnel is
+// restarted from.
+func (q *NavMeshQuery) findStraightPath(
+	startPos, endPos d3.Vec3,
+	path []PolyRef,
+	startIdx int,
+	straightPath []d3.Vec3,
+	straightPathFlags []uint8,
+	straightPathRefs []PolyRef,
+	options int32) (straightPathCount int, st Status) {
+
+	var (
+		stat  Status
+		count int
+	)
+
+	// TODO: Should this be callers responsibility?
+	closestStartPos := d3.NewVec3()
+	startFlags := StraightPathStart
+	if startIdx == 0 {
+		if StatusFailed(q.ClosestPointOnPolyBoundary(path[0], startPos, closestStartPos)) {
+			return 0, Failure | InvalidParam
+		}
+	} else {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(q.nav.TileAndPolyByRef(path[startIdx], &tile, &poly)) {
+			return 0, Failure | InvalidParam
+		}
+		closestStartPos.Assign(startPos)
+		startFlags = 0
+		if poly.Type() == polyTypeOffMeshConnection {
+			startFlags = StraightPathOffMeshConnection
+		}
+	}
+
+	closestEndPos := d3.NewVec3()
+	if StatusFailed(q.ClosestPointOnPolyBoundary(path[len(path)-1], endPos, closestEndPos)) {
+		return 0, Failure | InvalidParam
+	}
+
+	// Add start point.
+	stat = q.appendVertex(closestStartPos, startFlags, path[startIdx],
+		straightPath, straightPathFlags, straightPathRefs,
+		&count)
+	if stat != InProgress {
+		//fmt.Println("FindStraightPath returns", stat, count)
+		return count, stat
+	}
+
+	if len(path) > startIdx+1 {
+		portalApex := d3.NewVec3From(closestStartPos)
+		portalLeft := d3.NewVec3From(portalApex)
+		portalRight := d3.NewVec3From(portalApex)
+		var (
+			apexIndex     int
+			leftIndex     int
+			rightIndex    int
+			leftPolyType  uint8
+			rightPolyType uint8
+		)
+		if startIdx > 0 {
+			// The start point has been the apex of the portal entering
+			// path[startIdx].
+			apexIndex = startIdx - 1
+			leftIndex, rightIndex = apexIndex, apexIndex
+		}
+
+		leftPolyRef := path[startIdx]
+		rightPolyRef := path[startIdx]
+
+		for i := startIdx; i < len(path); i++ {
+			left := d3.NewVec3()
+			right := d3.NewVec3()
+			var toType uint8
+
+			if i+1 < len(path) {
+				var fromType uint8 // fromType is ignored.
 
 				// Next portal.
 				if StatusFailed(q.portalPoints6(path[i], path[i+1], left, right, &fromType, &toType)) {
@@ -493,14 +1489,15 @@ func (q *NavMeshQuery) FindStraightPath(
 							&count, options)
 					}
 
-					// Ignore status return value as we're just about to return anyway.
-					q.appendVertex(closestEndPos, 0, path[i],
-						straightPath, straightPathFlags, straightPathRefs,
-						&count)
-
 					stat = Success | PartialResult
 					if count >= len(straightPath) {
+						// No room left for the end point.
 						stat |= BufferTooSmall
+					} else {
+						// Ignore status return value as we're just about to return anyway.
+						q.appendVertex(closestEndPos, 0, path[i],
+							straightPath, straightPathFlags, straightPathRefs,
+							&count)
 					}
 					//fmt.Println("FindStraightPath 2 returns", stat, count)
 					return count, stat
@@ -553,6 +1550,9 @@ func (q *NavMeshQuery) FindStraightPath(
 						flags = StraightPathOffMeshConnection
 					}
 					ref := leftPolyRef
+					if ref == 0 && options&int32(StraightPathEndRef) != 0 {
+						ref = path[len(path)-1]
+					}
 
 					// Append or update vertex
 					stat = q.appendVertex(portalApex, flags, ref,
@@ -562,6 +1562,9 @@ func (q *NavMeshQuery) FindStraightPath(
 						//fmt.Println("FindStraightPath 4 returns", stat, count)
 						return count, stat
 					}
+					if flags == StraightPathOffMeshConnection && (options&int32(StraightPathStopAtOffMesh)) != 0 {
+						return count, Success | PartialResult
+					}
 
 					portalLeft.Assign(portalApex)
 					portalRight.Assign(portalApex)
@@ -608,6 +1611,9 @@ func (q *NavMeshQuery) FindStraightPath(
 						flags = StraightPathOffMeshConnection
 					}
 					ref := rightPolyRef
+					if ref == 0 && options&int32(StraightPathEndRef) != 0 {
+						ref = path[len(path)-1]
+					}
 
 					// Append or update vertex
 					stat = q.appendVertex(portalApex, flags, ref,
@@ -617,6 +1623,9 @@ func (q *NavMeshQuery) FindStraightPath(
 						//fmt.Println("FindStraightPath 6 returns", stat, count)
 						return count, stat
 					}
+					if flags == StraightPathOffMeshConnection && (options&int32(StraightPathStopAtOffMesh)) != 0 {
+						return count, Success | PartialResult
+					}
 
 					portalLeft.Assign(portalApex)
 					portalRight.Assign(portalApex)
@@ -643,17 +1652,425 @@ func (q *NavMeshQuery) FindStraightPath(
 		}
 	}
 
-	// Ignore status return value as we're just about to return anyway.
-	q.appendVertex(closestEndPos, StraightPathEnd, 0,
+	// There is always room left for the end point here, appendVertex would
+	// have reported BufferTooSmall otherwise.
+	var endRef PolyRef
+	if options&int32(StraightPathEndRef) != 0 {
+		endRef = path[len(path)-1]
+	}
+	q.appendVertex(closestEndPos, StraightPathEnd, endRef,
 		straightPath, straightPathFlags, straightPathRefs,
 		&count)
 
 	stat = Success
-	if count >= len(straightPath) {
-		stat |= BufferTooSmall
+	//fmt.Println("FindStraightPath 8 returns", stat, count)
+	return count, stat
+}
+
+// StraightPathCost returns the length of a straight path, where each segment
+// is weighted by the traversal cost of the polygon it lies in.
+//
+//  Arguments:
+//   path          The polygon corridor the straight path has been computed
+//                 from.
+//   straight      The straight path points, as returned by FindStraightPath.
+//   straightRefs  The polygon references of each straight path point, as
+//                 returned by FindStraightPath.
+//   filter        The polygon filter used to compute the segment costs.
+//
+// The segment going from straight[i] to straight[i+1] is costed with
+// filter.Cost, using straightRefs[i] as the current polygon, that is the
+// polygon entered at straight[i], which the segment crosses. The polygon
+// entered at straight[i+1], the destination of the segment, only counts from
+// the next segment on. This is the costing of FindPathDetailed, where each
+// step is costed by the polygon it leaves. For that reason straightRefs must
+// have been filled by FindStraightPath, and should contain
+// at least as many elements as straight. A zero reference is replaced by the
+// last polygon of path. The plain segment length is used when the polygon
+// reference is not valid anymore.
+//
+// Note: the polygon entered at a vertex is not necessarily the only one a
+// segment crosses. Use the StraightPathAreaCrossings option with
+// FindStraightPath in order to have one vertex at each area change, so that
+// each segment is weighted by the cost of the area it really crosses.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) StraightPathCost(path []PolyRef, straight []d3.Vec3, straightRefs []PolyRef, filter QueryFilter) float32 {
+	if filter == nil || len(straightRefs) < len(straight) {
+		return 0
+	}
+
+	refAt := func(i int) PolyRef {
+		if straightRefs[i] == 0 && len(path) > 0 {
+			return path[len(path)-1]
+		}
+		return straightRefs[i]
+	}
+
+	var (
+		cost     float32
+		prevRef  PolyRef
+		prevTile *MeshTile
+		prevPoly *Poly
+	)
+	for i := 0; i+1 < len(straight); i++ {
+		var (
+			curTile, nextTile *MeshTile
+			curPoly, nextPoly *Poly
+		)
+		curRef := refAt(i)
+		if StatusFailed(q.nav.TileAndPolyByRef(curRef, &curTile, &curPoly)) {
+			cost += straight[i].Dist(straight[i+1])
+			prevRef, prevTile, prevPoly = 0, nil, nil
+			continue
+		}
+		nextRef := refAt(i + 1)
+		if StatusFailed(q.nav.TileAndPolyByRef(nextRef, &nextTile, &nextPoly)) {
+			nextRef, nextTile, nextPoly = 0, nil, nil
+		}
+
+		cost += filter.Cost(straight[i], straight[i+1],
+			prevRef, prevTile, prevPoly,
+			curRef, curTile, curPoly,
+			nextRef, nextTile, nextPoly)
+		prevRef, prevTile, prevPoly = curRef, curTile, curPoly
+	}
+	return cost
+}
+
+// FindStraightPathAreas is FindStraightPath, also returning the area of each
+// straight path point.
+//
+//  Arguments:
+//   startPos, endPos, path, straightPath, straightPathFlags,
+//   straightPathRefs, options
+//                      See FindStraightPath.
+//   straightPathAreas  The area id of each point. [Length: >= len(straightPath)]
+//
+// Returns The status flags for the query and the number of point in the
+// straight path.
+//
+// The area of a point is the area of the polygon entered at that point, as
+// returned in straightPathRefs, so it is the area of the segment starting at
+// that point. The end point has no polygon reference, unless the
+// StraightPathEndRef option is set, its area is the one of the last polygon
+// of path. The area is only sampled at the points: use the
+// StraightPathAreaCrossings option in order to have one point at each area
+// change, so that each segment really lies on a single area.
+//
+// straightPathRefs may be nil, in which case the references are computed but
+// not returned.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindStraightPathAreas(
+	startPos, endPos d3.Vec3,
+	path []PolyRef,
+	straightPath []d3.Vec3,
+	straightPathFlags []uint8,
+	straightPathRefs []PolyRef,
+	straightPathAreas []uint8,
+	options int32) (straightPathCount int, st Status) {
+
+	if len(straightPathAreas) < len(straightPath) {
+		return 0, Failure | InvalidParam
+	}
+	if len(straightPathRefs) < len(straightPath) {
+		straightPathRefs = make([]PolyRef, len(straightPath))
+	}
+
+	straightPathCount, st = q.FindStraightPath(startPos, endPos, path,
+		straightPath, straightPathFlags, straightPathRefs, options)
+	if StatusFailed(st) {
+		return straightPathCount, st
+	}
+
+	for i := 0; i < straightPathCount; i++ {
+		ref := straightPathRefs[i]
+		if ref == 0 {
+			ref = path[len(path)-1]
+		}
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) {
+			return straightPathCount, Failure | InvalidParam
+		}
+		straightPathAreas[i] = poly.Area()
+	}
+	return straightPathCount, st
+}
+
+// DetectSteps returns the indices of the straight path segments that climb
+// or descend a step.
+//
+//  Arguments:
+//   path     The straight path points, as returned by FindStraightPath.
+//   refs     The polygon reference of each point. (See: FindStraightPath)
+//            [Length: >= len(path)]
+//   minStep  The minimum height difference of a step.
+//
+// Returns the index i of each segment [path[i], path[i+1]] which end points
+// heights differ by more than minStep.
+//
+// The heights are the ones of the detail meshes, at the xz location of the
+// points, rather than the heights of the points themselves, which lie on the
+// polygon meshes. The height of a point is sampled on its polygon, or else on
+// the polygon of the previous point, as straight path points lie on polygon
+// boundaries and the end point has no polygon reference, unless the
+// StraightPathEndRef option is set. It falls back to the point height if
+// both fail.
+//
+// Only the end points of the segments are sampled: a segment running over
+// several steps reports their cumulated height. Use the
+// StraightPathAllCrossings option in order to have a point at each polygon
+// edge.
+func (q *NavMeshQuery) DetectSteps(path []d3.Vec3, refs []PolyRef, minStep float32) []int {
+	if len(refs) < len(path) {
+		return nil
+	}
+
+	heightOver := func(ref PolyRef, pos d3.Vec3) (float32, bool) {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) {
+			return 0, false
+		}
+		return polyHeight(tile, poly, pos, q.nav.upAxis)
+	}
+	heightAt := func(i int) float32 {
+		if h, ok := heightOver(refs[i], path[i]); ok {
+			return h
+		}
+		if i > 0 {
+			if h, ok := heightOver(refs[i-1], path[i]); ok {
+				return h
+			}
+		}
+		return path[i][q.nav.upAxis.index()]
+	}
+
+	var steps []int
+	if len(path) == 0 {
+		return steps
+	}
+	prev := heightAt(0)
+	for i := 1; i < len(path); i++ {
+		cur := heightAt(i)
+		if math32.Abs(cur-prev) > minStep {
+			steps = append(steps, i-1)
+		}
+		prev = cur
+	}
+	return steps
+}
+
+// clearanceMaxPolys is the maximum number of polygons a straight path segment
+// is followed through by PathHasClearance.
+const clearanceMaxPolys = 256
+
+// PathHasClearance checks that an agent of the given radius can follow a
+// straight path without getting closer to the walls than its radius.
+//
+//  Arguments:
+//   path    The straight path points. (See FindStraightPath)
+//   refs    The straight path polygon references. (See FindStraightPath)
+//           [Length: >= len(path)]
+//   radius  The radius of the agent.
+//   filter  The polygon filter to apply to the query.
+//
+//  Return values:
+//   clear    True if the agent clears the walls along the whole path.
+//   failIdx  The index i of the first segment [path[i], path[i+1]] where it
+//            does not, or -1.
+//
+// The distance to the walls is sampled every quarter of radius along each
+// segment, searching the walls from the polygon under each sample, which is
+// followed from refs[i] through the polygons the segment crosses, so that
+// the walls of overlapping layers, such as bridges, are not considered. The
+// clearance at a point is the distance to the navmesh boundary plus the
+// radius the tile has been eroded by at build time (the WalkableRadius of
+// the tile header), as the navmesh walls already lie that far from the
+// obstacles. So a path computed on a mesh eroded for radius clears, while a
+// wider agent fails where the path hugs a corner. Points where no wall is
+// found within radius are clear. Segments which can't be followed on the
+// navmesh, for example because of an invalid polygon reference, do not clear.
+// Off-mesh connections, which are not walked, are not sampled.
+//
+// If refs is too short, false and 0 are returned.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) PathHasClearance(path []d3.Vec3, refs []PolyRef, radius float32, filter QueryFilter) (clear bool, failIdx int) {
+	if len(refs) < len(path) {
+		return false, 0
+	}
+	if !(radius > 0) || len(path) == 0 {
+		return true, -1
+	}
+	if filter == nil {
+		return false, 0
+	}
+
+	step := radius / 4
+	// polyAt returns the tile and polygon of ref, nil if ref is not valid.
+	polyAt := func(ref PolyRef) (*MeshTile, *Poly) {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusFailed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) {
+			return nil, nil
+		}
+		return tile, poly
+	}
+	// clearAt reports whether pos, over the polygon ref, is clear.
+	clearAt := func(pos d3.Vec3, ref PolyRef) bool {
+		tile, _ := polyAt(ref)
+		if tile == nil {
+			return false
+		}
+		maxRadius := radius - tile.Header.WalkableRadius
+		if !(maxRadius > 0) {
+			return true
+		}
+		_, dist, found, _ := q.nearestWall(ref, pos, maxRadius, filter)
+		return !found || dist >= maxRadius
+	}
+
+	if len(path) == 1 {
+		if !clearAt(path[0], refs[0]) {
+			return false, 0
+		}
+		return true, -1
+	}
+
+	var (
+		hit     RaycastHit
+		crossed [clearanceMaxPolys]PolyRef
+		pos     = d3.NewVec3()
+	)
+	hit.Path = crossed[:]
+	hit.MaxPath = len(crossed)
+	for i := 0; i+1 < len(path); i++ {
+		if _, poly := polyAt(refs[i]); poly != nil && poly.Type() == polyTypeOffMeshConnection {
+			continue
+		}
+		if StatusFailed(q.Raycast(refs[i], path[i], path[i+1], filter, 0, &hit, 0)) || hit.PathCount == 0 {
+			return false, i
+		}
+		polys := hit.Path[:hit.PathCount]
+
+		length := path[i].Dist(path[i+1])
+		n := int(math32.Ceil(length / step))
+		if n < 1 {
+			n = 1
+		}
+		// The end of a segment is sampled with the next one, but for the
+		// last segment.
+		last := n - 1
+		if i+2 == len(path) {
+			last = n
+		}
+		var k int
+		for j := 0; j <= last; j++ {
+			d3.Vec3Lerp(pos, path[i], path[i+1], float32(j)/float32(n))
+			// Follow the crossed polygons up to the one under pos. If the
+			// ray stopped short of the segment end, the remaining samples
+			// keep the last polygon.
+			for m := k; m < len(polys); m++ {
+				if tile, poly := polyAt(polys[m]); tile != nil {
+					if _, ok := polyHeight(tile, poly, pos, q.nav.upAxis); ok {
+						k = m
+						break
+					}
+				}
+			}
+			if !clearAt(pos, polys[k]) {
+				return false, i
+			}
+		}
+	}
+	return true, -1
+}
+
+// NextCorner returns the next corner of the straight path, the point an agent
+// following path should steer toward.
+//
+//  Arguments:
+//   path       An array of polygon references that represent the path
+//              corridor, starting at the polygon of the agent.
+//   startPos   The agent position. [(x, y, z)]
+//   endPos     The path end position. [(x, y, z)]
+//   lookAhead  The maximum number of polygons of path to consider, or 0 for
+//              all of them.
+//
+//  Return values:
+//   corner     The next corner.
+//   flags      The flags of the corner. (See: StraightPathFlags)
+//   ref        The reference id of the polygon that is being entered at the
+//              corner.
+//   status     The status flags for the query.
+//
+// It runs FindStraightPath on the first lookAhead polygons of path, stopping
+// at the first corner, which is cheaper than computing the whole straight path
+// at each tick of a steering behavior. The corner has the
+// StraightPathOffMeshConnection flag set if it is the start of an off-mesh
+// connection, and the StraightPathEnd flag set if it is endPos.
+//
+// When path is truncated at lookAhead polygons and there is no corner in
+// them, the returned corner is endPos clamped to the last considered polygon.
+//
+// NextCorner assumes that path starts at the polygon containing startPos,
+// i.e. that the corridor has been updated to the current agent position.
+func (q *NavMeshQuery) NextCorner(path []PolyRef, startPos, endPos d3.Vec3, lookAhead int) (corner d3.Vec3, flags uint8, ref PolyRef, status Status) {
+	if len(path) == 0 || lookAhead < 0 {
+		return nil, 0, 0, Failure | InvalidParam
+	}
+	truncated := lookAhead > 0 && lookAhead < len(path)
+	if truncated {
+		path = path[:lookAhead]
+	}
+
+	// The start point, the next corner, and a spare point, so that the
+	// corner is not skipped if it is too close to the start.
+	var (
+		straight = []d3.Vec3{d3.NewVec3(), d3.NewVec3(), d3.NewVec3()}
+		sflags   [3]uint8
+		srefs    [3]PolyRef
+	)
+	n, st := q.FindStraightPath(startPos, endPos, path, straight, sflags[:], srefs[:], 0)
+	if StatusFailed(st) {
+		return nil, 0, 0, st
+	}
+
+	// Skip the start point, and corners too close to it.
+	i := 0
+	for i+1 < n {
+		i++
+		if math32.Sqr(straight[i][0]-startPos[0])+math32.Sqr(straight[i][2]-startPos[2]) > math32.Sqr(0.01) {
+			break
+		}
+	}
+	corner, flags, ref = straight[i], sflags[i], srefs[i]
+	end := flags&StraightPathEnd != 0
+	if truncated {
+		// The end of the truncated corridor is not the end of the path.
+		flags &^= StraightPathEnd
+	}
+	if !end {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		if StatusSucceed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) && poly.Type() == polyTypeOffMeshConnection {
+			flags |= StraightPathOffMeshConnection
+		}
 	}
-	//fmt.Println("FindStraightPath 8 returns", stat, count)
-	return count, stat
+	return corner, flags, ref, Success | (st & PartialResult)
 }
 
 // appendPortals appends intermediate portal points to a straight path.
@@ -730,13 +2147,33 @@ func (q *NavMeshQuery) appendVertex(
 	straightPathRefs []PolyRef,
 	straightPathCount *int) Status {
 
-	if (*straightPathCount) > 0 && pos.Approx(straightPath[*straightPathCount-1]) {
+	n := *straightPathCount
+	equal := n > 0 && pos.Approx(straightPath[n-1])
+	if !equal && n > 0 && q.pathEps > 0 && pos.Dist(straightPath[n-1]) <= q.pathEps {
+		// The vertices are close, merge them, without merging away the
+		// start or the end.
+		switch {
+		case n == 1 && flags == 0:
+			// Keep the start as is.
+			return InProgress
+		case n == 1:
+			// Keep both the start and the flagged vertex.
+		case flags == StraightPathEnd:
+			// The end replaces the previous vertex.
+			straightPath[n-1].Assign(pos)
+			equal = true
+		default:
+			equal = true
+		}
+	}
+
+	if equal {
 		// The vertices are equal, update flags and poly.
 		if len(straightPathFlags) > 0 {
-			straightPathFlags[*straightPathCount-1] = flags
+			straightPathFlags[n-1] = flags
 		}
 		if len(straightPathRefs) > 0 {
-			straightPathRefs[*straightPathCount-1] = ref
+			straightPathRefs[n-1] = ref
 		}
 	} else {
 		// Append new vertex.
@@ -749,15 +2186,15 @@ func (q *NavMeshQuery) appendVertex(
 		}
 		(*straightPathCount)++
 
-		// If there is no space to append more vertices, return.
-		if (*straightPathCount) >= len(straightPath) {
-			return Success | BufferTooSmall
-		}
-
 		// If reached end of path, return.
 		if flags == StraightPathEnd {
 			return Success
 		}
+
+		// If there is no space to append more vertices, return.
+		if (*straightPathCount) >= len(straightPath) {
+			return Success | BufferTooSmall
+		}
 	}
 	return InProgress
 }
@@ -828,7 +2265,6 @@ func (q *NavMeshQuery) portalPoints8(
 		// Find link that points to first vertex.
 		for i := fromPoly.FirstLink; i != nullLink; i = fromTile.Links[i].Next {
 			if fromTile.Links[i].Ref == to {
-				// TODO: AR, repass here and test
 				v := fromTile.Links[i].Edge
 				vidx := fromPoly.Verts[v] * 3
 				copy(left, fromTile.Verts[vidx:vidx+3])
@@ -842,9 +2278,8 @@ func (q *NavMeshQuery) portalPoints8(
 	if toPoly.Type() == polyTypeOffMeshConnection {
 		for i := toPoly.FirstLink; i != nullLink; i = toTile.Links[i].Next {
 			if toTile.Links[i].Ref == from {
-				// TODO: AR, repass here and test
 				v := toTile.Links[i].Edge
-				vidx := fromPoly.Verts[v] * 3
+				vidx := toPoly.Verts[v] * 3
 				copy(left, toTile.Verts[vidx:vidx+3])
 				copy(right, toTile.Verts[vidx:vidx+3])
 				return Success
@@ -882,7 +2317,8 @@ func (q *NavMeshQuery) portalPoints8(
 // pathToNode gets the path leading to the specified end node.
 func (q *NavMeshQuery) pathToNode(
 	endNode *Node,
-	path []PolyRef) (pathCount int, st Status) {
+	path []PolyRef,
+	costs []float32) (pathCount int, st Status) {
 
 	var (
 		curNode *Node
@@ -913,6 +2349,9 @@ func (q *NavMeshQuery) pathToNode(
 		assert.True(int(i) < len(path), "i:%d should be < len(path):%d", i, len(path))
 
 		path[i] = curNode.ID
+		if costs != nil {
+			costs[i] = curNode.Cost
+		}
 		curNode = q.nodePool.NodeAtIdx(int32(curNode.PIdx))
 	}
 
@@ -935,7 +2374,17 @@ func (q *NavMeshQuery) pathToNode(
 // (Most accurate.)
 //
 // pos does not have to be within the bounds of the polygon or navigation mesh.
-// See ClosestPointOnPolyBoundary() for a limited but faster option.
+// If pos is not over the polygon, closest is the nearest point of the detail
+// mesh edges lying on the polygon boundary, so the returned height follows
+// the detail mesh, even on sloped boundaries.
+// See ClosestPointOnPolyBoundary() for a limited but faster option, using
+// the polygon edges only.
+//
+// If the tile has no detail mesh for the polygon, the height is interpolated
+// on the triangulation of the polygon (a fan around its first vertex). This
+// is only as accurate as the polygon mesh: the surface is not followed
+// between the polygon vertices, so on uneven ground the height error is not
+// bounded as it is with a detail mesh, built within a maximum sample error.
 //
 // Note: this method may be used by multiple clients without side effects.
 func (q *NavMeshQuery) ClosestPointOnPoly(ref PolyRef, pos, closest d3.Vec3, posOverPoly *bool) Status {
@@ -952,91 +2401,58 @@ func (q *NavMeshQuery) ClosestPointOnPoly(ref PolyRef, pos, closest d3.Vec3, pos
 		return Failure | InvalidParam
 	}
 
-	// Off-mesh connections don't have detail polygons.
-	if poly.Type() == polyTypeOffMeshConnection {
-		var (
-			v0, v1    d3.Vec3
-			d0, d1, u float32
-		)
-		vidx := poly.Verts[0] * 3
-		v0 = tile.Verts[vidx : vidx+3]
-		vidx = poly.Verts[1] * 3
-		v1 = tile.Verts[vidx : vidx+3]
-		d0 = pos.Dist(v0)
-		d1 = pos.Dist(v1)
-		u = d0 / (d0 + d1)
-		d3.Vec3Lerp(closest, v0, v1, u)
-		if posOverPoly != nil {
-			*posOverPoly = false
-		}
-		return Success
-	}
+	q.nav.closestPointOnPoly(ref, pos, closest, posOverPoly)
+	return Success
+}
 
-	ip := (uintptr(unsafe.Pointer(poly)) - uintptr(unsafe.Pointer(&tile.Polys[0]))) / unsafe.Sizeof(*poly)
-	pd := &tile.DetailMeshes[uint32(ip)]
+// ClosestPointsOnPolys finds the closest point on each of the specified
+// polygons.
+//
+//  Arguments:
+//   refs  The reference ids of the polygons.
+//   pts   The positions to project on each polygon. [Length: >= len(refs)]
+//   out   Receives the closest point on each polygon.
+//         [Length: >= len(refs)]
+//
+//  Return values:
+//   The status flags for the query.
+//
+// This is equivalent to calling ClosestPointOnPoly for each polygon, without
+// the per-call overhead, which matters when snapping many positions, for
+// example all the agents of a crowd, at each update.
+//
+// Results are index-aligned with refs. If a reference is invalid, the
+// corresponding input position is copied as is to out and InvalidParam is
+// set in the returned status, which is otherwise a success. Nil elements of
+// out are allocated as needed.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) ClosestPointsOnPolys(refs []PolyRef, pts, out []d3.Vec3) Status {
+	assert.True(q.nav != nil, "NavMesh should not be nil")
 
-	// Clamp point to be inside the polygon.
-	verts := make([]float32, VertsPerPolygon*3)
-	edged := make([]float32, VertsPerPolygon)
-	edget := make([]float32, VertsPerPolygon)
-	nv := poly.VertCount
-	var i uint8
-	for i = 0; i < nv; i++ {
-		idx := i * 3
-		jdx := poly.Verts[i] * 3
-		copy(verts[idx:idx+3], tile.Verts[jdx:jdx+3])
+	// parameter check
+	if len(pts) < len(refs) || len(out) < len(refs) {
+		return Failure | InvalidParam
 	}
-
-	closest.Assign(pos)
-	if !distancePtPolyEdgesSqr(pos, verts, int32(nv), edged, edget) {
-		// Point is outside the polygon, clamp to nearest edge.
-		dmin := edged[0]
-		var imin uint8
-		for i = 1; i < nv; i++ {
-			if edged[i] < dmin {
-				dmin = edged[i]
-				imin = i
-			}
-		}
-		idx := imin * 3
-		va := verts[idx : idx+3]
-		idx = ((imin + 1) % nv) * 3
-		vb := verts[idx : idx+3]
-		d3.Vec3Lerp(closest, va, vb, edget[imin])
-
-		if posOverPoly != nil {
-			*posOverPoly = false
-		}
-	} else {
-		if posOverPoly != nil {
-			*posOverPoly = true
+	for i := range refs {
+		if len(pts[i]) != 3 || (out[i] != nil && len(out[i]) != 3) {
+			return Failure | InvalidParam
 		}
 	}
 
-	// Find height at the location.
-	var j uint8
-	var idx int
-	for j = 0; j < pd.TriCount; j++ {
-		idx = int((pd.TriBase + uint32(j)) * 4)
-		t := tile.DetailTris[idx : idx+3]
-		v := make([][]float32, 3)
-		var k int
-		for k = 0; k < 3; k++ {
-			if t[k] < poly.VertCount {
-				idx = int(poly.Verts[t[k]] * 3)
-				v[k] = tile.Verts[idx : idx+3]
-			} else {
-				idx = int((pd.VertBase + uint32(t[k]-poly.VertCount)) * 3)
-				v[k] = tile.DetailVerts[idx : idx+3]
-			}
+	status := Status(Success)
+	for i, ref := range refs {
+		if out[i] == nil {
+			out[i] = d3.NewVec3()
 		}
-		var h float32
-		if closestHeightPointTriangle(closest, v[0], v[1], v[2], &h) {
-			closest[1] = h
-			break
+		if !q.nav.IsValidPolyRef(ref) {
+			out[i].Assign(pts[i])
+			status |= InvalidParam
+			continue
 		}
+		q.nav.closestPointOnPoly(ref, pts[i], out[i], nil)
 	}
-	return Success
+	return status
 }
 
 // ClosestPointOnPolyBoundary uses the detail polygons to find the surface
@@ -1092,6 +2508,114 @@ func (q *NavMeshQuery) ClosestPointOnPolyBoundary(ref PolyRef, pos, closest d3.V
 	return Success
 }
 
+// PolyHeight returns the height of a polygon at a position, using its detail
+// mesh.
+//
+//  Arguments:
+//   ref  The reference id of the polygon.
+//   pos  A position within the footprint of the polygon. [(x, y, z)]
+//
+//  Return values:
+//   height  The height of the polygon at pos, along the up axis of the
+//           navmesh. (See NavMesh.SetUpAxis)
+//   status  The status flags for the query.
+//
+// Failure|InvalidParam is returned if ref is invalid or if pos is not over
+// the polygon. For off-mesh connections, the height is interpolated between
+// the end points, from the horizontal distance of pos to them.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) PolyHeight(ref PolyRef, pos d3.Vec3) (height float32, status Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if len(pos) != 3 || StatusFailed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) {
+		return 0, Failure | InvalidParam
+	}
+
+	up := q.nav.upAxis
+	if poly.Type() == polyTypeOffMeshConnection {
+		var (
+			pbuf, buf0, buf1 [3]float32
+			p, v0, v1        = d3.Vec3(pbuf[:]), d3.Vec3(buf0[:]), d3.Vec3(buf1[:])
+			t                float32
+		)
+		up.yUp(p, pos)
+		up.yUp(v0, tile.Verts[poly.Verts[0]*3:poly.Verts[0]*3+3])
+		up.yUp(v1, tile.Verts[poly.Verts[1]*3:poly.Verts[1]*3+3])
+		distancePtSegSqr2D(p, v0, v1, &t)
+		return v0[1] + (v1[1]-v0[1])*t, Success
+	}
+
+	if h, ok := polyHeight(tile, poly, pos, up); ok {
+		return h, Success
+	}
+	return 0, Failure | InvalidParam
+}
+
+// PolyHeightApprox returns the approximate height of a polygon at a
+// position, ignoring its detail mesh.
+//
+//  Arguments:
+//   ref  The reference id of the polygon.
+//   pos  A position within the footprint of the polygon. [(x, y, z)]
+//
+//  Return values:
+//   height  The height of the polygon triangulation at pos, along the up
+//           axis of the navmesh. (See NavMesh.SetUpAxis)
+//   status  The status flags for the query.
+//
+// This is PolyHeight, interpolating the height over the triangle fan of the
+// polygon vertices instead of searching the detail triangles, which is much
+// faster on highly subdivided detail meshes, for uses such as the cosmetic
+// ground snapping of distant agents. As both heights lie within the heights
+// of the detail mesh vertices, the error is at most the height span of the
+// polygon, as returned by NavMesh.PolyBounds. For a mesh built by Recast, the
+// detail mesh only deviates from the polygon where the ground deviates from
+// it by more than the detail sample max error of the build, so the error is
+// small on smooth ground, and the largest on bumpy terrain or stairs.
+//
+// Failure|InvalidParam is returned if ref is invalid or if pos is not over
+// the polygon. Off-mesh connections are handled as by PolyHeight.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) PolyHeightApprox(ref PolyRef, pos d3.Vec3) (height float32, status Status) {
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	if len(pos) != 3 || StatusFailed(q.nav.TileAndPolyByRef(ref, &tile, &poly)) {
+		return 0, Failure | InvalidParam
+	}
+	if poly.Type() == polyTypeOffMeshConnection {
+		return q.PolyHeight(ref, pos)
+	}
+
+	// The triangle fan covers the convex polygon, no need to check first
+	// that pos is inside the polygon.
+	var (
+		pbuf, buf0, buf1, buf2 [3]float32
+		p, v0, v1, v2          = d3.Vec3(pbuf[:]), d3.Vec3(buf0[:]), d3.Vec3(buf1[:]), d3.Vec3(buf2[:])
+	)
+	up := q.nav.upAxis
+	vert := func(dst d3.Vec3, i uint8) {
+		idx := poly.Verts[i] * 3
+		up.yUp(dst, tile.Verts[idx:idx+3])
+	}
+	up.yUp(p, pos)
+	vert(v0, 0)
+	vert(v2, 1)
+	for i := uint8(2); i < poly.VertCount; i++ {
+		v1, v2 = v2, v1
+		vert(v2, i)
+		if closestHeightPointTriangle(p, v0, v1, v2, &height) {
+			return height, Success
+		}
+	}
+	return 0, Failure | InvalidParam
+}
+
 // FindNearestPoly finds the polygon nearest to the specified center point.
 //
 //  Arguments:
@@ -1109,12 +2633,21 @@ func (q *NavMeshQuery) ClosestPointOnPolyBoundary(ref PolyRef, pos, closest d3.V
 // will be 'Success', but ref will be zero. So if in doubt, check ref before
 // using pt.
 //
+// A polygon directly under or over center, within the climb height of its
+// tile, is favored over the polygons that are nearer in straight line. If
+// several of them are, such as polygons of stacked tile layers, the
+// vertically nearest one is returned.
+//
 // Note: this method may be used by multiple clients without side effects.
 func (q *NavMeshQuery) FindNearestPoly(center, extents d3.Vec3,
 	filter QueryFilter) (st Status, ref PolyRef, pt d3.Vec3) {
 
 	assert.True(q.nav != nil, "Nav should not be nil")
 
+	if q.observer != nil {
+		defer q.observe("FindNearestPoly", time.Now())
+	}
+
 	query := newFindNearestPolyQuery(q, center)
 	st = q.queryPolygons4(center, extents, filter, query)
 	if StatusFailed(st) {
@@ -1130,6 +2663,368 @@ func (q *NavMeshQuery) FindNearestPoly(center, extents d3.Vec3,
 	return
 }
 
+// FindNearestPolyArr is FindNearestPoly, with arrays in place of the
+// d3.Vec3 slices.
+//
+// As d3.Vec3 are slices, the vectors passed to and returned by the queries
+// are usually allocated on the heap. FindNearestPolyArr does not allocate,
+// for the hottest paths under heavy query load: it reuses scratch buffers of
+// the query instead. FindNearestPoly remains the convenient default, without
+// side effects.
+//
+// Note: this method may be used by multiple clients without side effects, but
+// not concurrently, as it uses the search nodes or the scratch buffers of the
+// query. (See NavMeshQuery)
+func (q *NavMeshQuery) FindNearestPolyArr(center, halfExtents [3]float32, filter QueryFilter) (st Status, ref PolyRef, pt [3]float32) {
+	if q.observer != nil {
+		defer q.observe("FindNearestPoly", time.Now())
+	}
+
+	q.centerBuf = center
+	query := &q.nearest
+	query.center = q.centerBuf[:]
+	query.nearestDistanceSqr = math.MaxFloat32
+	query.nearestHeightDiff = math.MaxFloat32
+	query.nearestRef = 0
+
+	st = q.queryPolygonsBatch(query.center, halfExtents[:], filter, query, &q.batch)
+	if StatusFailed(st) {
+		return st, 0, pt
+	}
+	if ref = query.nearestRef; ref != 0 {
+		copy(pt[:], query.nearestPoint)
+	}
+	return Success, ref, pt
+}
+
+// FindNearestPolyOfArea finds the polygon of an area nearest to the specified
+// center point.
+//
+//  Arguments:
+//   center       The center of the search box.
+//   halfExtents  A vector which components represent the search distance
+//                along each axis.
+//   area         The area id of the polygons to consider.
+//   filter       The polygon filter to apply to the query.
+//
+//  Return values:
+//   ref          The reference id of the nearest polygon of area.
+//   pt           The nearest point on the polygon. [(x, y, z)]
+//   status       The status flags for the query.
+//
+// This is FindNearestPoly, only considering the polygons of area which pass
+// filter. If there is no such polygon in the search box, the returned status
+// is Success, ref is zero and pt is nil.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindNearestPolyOfArea(center, halfExtents d3.Vec3, area uint8, filter QueryFilter) (ref PolyRef, pt d3.Vec3, status Status) {
+	if filter == nil {
+		return 0, nil, Failure | InvalidParam
+	}
+	status, ref, pt = q.FindNearestPoly(center, halfExtents, areaFilter{QueryFilter: filter, area: area})
+	return ref, pt, status
+}
+
+// FindNearestPolys finds the polygons nearest to each of the specified center
+// points.
+//
+//  Arguments:
+//   centers      The centers of the search boxes.
+//   halfExtents  A vector which components represent the search distance
+//                along each axis, the same for all centers.
+//   filter       The polygon filter to apply to the query.
+//   outRefs      Receives the reference id of the nearest polygon of each
+//                center. [Length: >= len(centers)]
+//   outPts       Receives the nearest point on the polygon of each center.
+//                [Length: >= len(centers)]
+//
+//  Return values:
+//   The status flags for the query.
+//
+// This is equivalent to calling FindNearestPoly for each center, though
+// consecutive centers that touch the same tiles share the tile lookup, so
+// ordering the centers by spatial proximity makes the batch faster.
+//
+// Results are index-aligned with centers. If the search box of a center does
+// not intersect any polygon, the corresponding reference is set to zero and
+// the corresponding point is left untouched. Nil elements of outPts are
+// allocated as needed.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindNearestPolys(centers []d3.Vec3, halfExtents d3.Vec3,
+	filter QueryFilter, outRefs []PolyRef, outPts []d3.Vec3) Status {
+
+	// parameter check
+	if len(halfExtents) != 3 || filter == nil ||
+		len(outRefs) < len(centers) || len(outPts) < len(centers) {
+		return Failure | InvalidParam
+	}
+	for _, center := range centers {
+		if len(center) != 3 {
+			return Failure | InvalidParam
+		}
+	}
+
+	const maxNeis int32 = 32
+	var (
+		neis                       [maxNeis]*MeshTile
+		tiles                      []*MeshTile
+		cminx, cminy, cmaxx, cmaxy int32
+	)
+
+	var batch polyBatch
+	query := newFindNearestPolyQuery(q, nil)
+	for i, center := range centers {
+		bmin := center.Sub(halfExtents)
+		bmax := center.Add(halfExtents)
+
+		// Find tiles the query touches, unless they are the same than the
+		// previous center.
+		minx, miny := q.nav.CalcTileLoc(bmin)
+		maxx, maxy := q.nav.CalcTileLoc(bmax)
+		if i == 0 || minx != cminx || miny != cminy || maxx != cmaxx || maxy != cmaxy {
+			tiles = tiles[:0]
+			for y := miny; y <= maxy; y++ {
+				for x := minx; x <= maxx; x++ {
+					nneis := q.nav.TilesAt(x, y, neis[:], maxNeis)
+					tiles = append(tiles, neis[:nneis]...)
+				}
+			}
+			cminx, cminy, cmaxx, cmaxy = minx, miny, maxx, maxy
+		}
+
+		query.center = center
+		query.nearestDistanceSqr = math.MaxFloat32
+		query.nearestHeightDiff = math.MaxFloat32
+		query.nearestRef = 0
+		for _, tile := range tiles {
+			q.queryPolygonsInTile(tile, bmin, bmax, filter, query, &batch)
+		}
+
+		outRefs[i] = query.nearestRef
+		if query.nearestRef != 0 {
+			if len(outPts[i]) < 3 {
+				outPts[i] = d3.NewVec3()
+			}
+			outPts[i].Assign(query.nearestPoint)
+		}
+	}
+	return Success
+}
+
+// PolyContaining finds the polygon containing a point.
+//
+//  Arguments:
+//   pos      The point to locate. [(x, y, z)]
+//   vExtent  The maximum vertical distance between pos and the polygon.
+//   filter   The polygon filter to apply to the query.
+//
+//  Return values:
+//   ref      The reference id of the polygon containing pos.
+//   status   The status flags for the query.
+//
+// Unlike FindNearestPoly, which snaps to the nearest polygon in a search box,
+// PolyContaining only returns a polygon which footprint on the horizontal
+// plane contains pos, and which detail mesh height at pos is within vExtent
+// of the height of pos. If several polygons do, the vertically nearest one is
+// returned. If none does, pos is off the navmesh and ref is 0, with a Success
+// status.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) PolyContaining(pos d3.Vec3, vExtent float32, filter QueryFilter) (ref PolyRef, status Status) {
+	if len(pos) != 3 || vExtent < 0 || filter == nil {
+		return 0, Failure | InvalidParam
+	}
+
+	query := newContainingPolyQuery(q.nav.upAxis, pos, vExtent)
+	status = q.queryPolygons4(pos, q.nav.upAxis.extents(0, vExtent), filter, query)
+	if StatusFailed(status) {
+		return 0, status
+	}
+	return query.bestRef, Success
+}
+
+// SnapToMesh snaps a position which drifted off the navmesh back onto it,
+// preferring the floor under the position.
+//
+//  Arguments:
+//   pos       The position to snap. [(x, y, z)]
+//   maxHoriz  The maximum horizontal distance to the snapped position.
+//   maxVert   The maximum vertical distance to the snapped position.
+//   filter    The polygon filter to apply to the query.
+//
+//  Return values:
+//   snapped   The snapped position, on the polygon ref. [(x, y, z)]
+//   ref       The reference id of the polygon snapped to.
+//   status    The status flags for the query.
+//
+// The snapped position is the closest point of the polygons within maxHoriz
+// and maxVert of pos, the vertical distance being weighted more than the
+// horizontal one (see SetSnapVerticalWeight), and twice more for the
+// surfaces above pos. Unlike FindNearestPoly, which favors any polygon right
+// over or under pos, this keeps an entity on its floor rather than snapping
+// it on a ledge above, or on the floor of a stair below.
+//
+// If no polygon lies within the limits, the status is Failure, snapped is
+// nil and ref is 0.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) SnapToMesh(pos d3.Vec3, maxHoriz, maxVert float32, filter QueryFilter) (snapped d3.Vec3, ref PolyRef, status Status) {
+	if len(pos) != 3 || filter == nil || maxHoriz < 0 || maxVert < 0 ||
+		math32.IsInf(maxHoriz, 1) || math32.IsInf(maxVert, 1) {
+		return nil, 0, Failure | InvalidParam
+	}
+
+	query := newSnapPolyQuery(q, pos, maxHoriz, maxVert)
+	status = q.queryPolygons4(pos, q.nav.upAxis.extents(maxHoriz, maxVert), filter, query)
+	if StatusFailed(status) {
+		return nil, 0, status
+	}
+	if query.bestRef == 0 {
+		return nil, 0, Failure
+	}
+	return query.bestPoint, query.bestRef, Success
+}
+
+// Coverage returns the fraction of a region which is covered by the
+// navmesh.
+//
+//  Arguments:
+//   bmin     The minimum bounds of the region. [(x, y, z)]
+//   bmax     The maximum bounds of the region. [(x, y, z)]
+//   samples  The number of samples along each horizontal axis.
+//   filter   The polygon filter to apply to the query.
+//
+// The region is sampled with a grid of samples x samples vertical rays, at
+// the center of the grid cells, cast down from the top of the region over
+// the vertical search range of the query (see SetCoverageVerticalRange). A
+// sample is covered if a polygon passing filter contains it, as for
+// PolyContaining, at a height within that range. The fraction of covered
+// samples is returned, 0 if the arguments are not valid.
+//
+// A coverage below 1 over a region meant to be walkable locates holes in
+// the navmesh, where agents would get stuck.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) Coverage(bmin, bmax d3.Vec3, samples int, filter QueryFilter) float32 {
+	if len(bmin) != 3 || len(bmax) != 3 || samples <= 0 || filter == nil {
+		return 0
+	}
+	for i := 0; i < 3; i++ {
+		if bmin[i] > bmax[i] {
+			return 0
+		}
+	}
+
+	up := q.nav.upAxis.index()
+	u, v := (up+1)%3, (up+2)%3
+	vrange := q.coverRange
+	if vrange == 0 {
+		vrange = bmax[up] - bmin[up]
+	}
+
+	pos := d3.NewVec3()
+	pos[up] = bmax[up] - vrange/2
+	du := (bmax[u] - bmin[u]) / float32(samples)
+	dv := (bmax[v] - bmin[v]) / float32(samples)
+	var covered int
+	for i := 0; i < samples; i++ {
+		pos[u] = bmin[u] + (float32(i)+0.5)*du
+		for j := 0; j < samples; j++ {
+			pos[v] = bmin[v] + (float32(j)+0.5)*dv
+			if ref, _ := q.PolyContaining(pos, vrange/2, filter); ref != 0 {
+				covered++
+			}
+		}
+	}
+	return float32(covered) / float32(samples*samples)
+}
+
+// FindNearestOffMeshConnection finds the off-mesh connection which entry
+// point is the nearest to a position.
+//
+//  Arguments:
+//   pos       The position to search from. [(x, y, z)]
+//   radius    The maximum distance between pos and the entry point.
+//   filter    The polygon filter to apply to the query.
+//
+//  Return values:
+//   startPos  The entry point of the connection. [(x, y, z)]
+//   endPos    The exit point of the connection. [(x, y, z)]
+//   ref       The reference id of the off-mesh connection polygon.
+//   status    The status flags for the query.
+//
+// startPos and endPos are given in the direction of travel: startPos is
+// where the connection is entered, endPos where it lands. A one-way
+// connection is only entered at its start, as given to CreateNavMeshData,
+// while a bidirectional connection is entered at the endpoint nearest to
+// pos, in which case startPos is the end of the connection and endPos its
+// start. The endpoints are the ones snapped onto the navmesh, as returned by
+// NavMesh.OffMeshConnectionPolyEndPoints.
+//
+// Only the connections passing filter, and landing on the navmesh at both
+// ends, are considered. The distance is the 3D distance from pos to the
+// entry point, radius included. If no connection is entered within radius
+// of pos, ref is 0 and the endpoints are nil, with a Success status.
+//
+// All the off-mesh connections of the loaded tiles are scanned, which is
+// cheap as there are usually few of them.
+//
+// Note: this method may be used by multiple clients without side effects.
+func (q *NavMeshQuery) FindNearestOffMeshConnection(pos d3.Vec3, radius float32, filter QueryFilter) (startPos, endPos d3.Vec3, ref PolyRef, status Status) {
+	if len(pos) != 3 || !(radius >= 0) || math32.IsInf(radius, 1) || filter == nil {
+		return nil, nil, 0, Failure | InvalidParam
+	}
+
+	bestDist := radius * radius
+	var bestStart, bestEnd d3.Vec3
+	for i := range q.nav.Tiles {
+		tile := &q.nav.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		base := q.nav.polyRefBase(tile)
+		for j := range tile.OffMeshCons {
+			con := &tile.OffMeshCons[j]
+			poly := &tile.Polys[con.Poly]
+			polyRef := base | PolyRef(con.Poly)
+			if !filter.PassFilter(polyRef, tile, poly) {
+				continue
+			}
+
+			// The connection links to the polygons it lands on, through its
+			// edge 0 at its start and 1 at its end.
+			var linked [2]bool
+			for l := poly.FirstLink; l != nullLink; l = tile.Links[l].Next {
+				if e := tile.Links[l].Edge; e < 2 {
+					linked[e] = true
+				}
+			}
+			if !linked[0] || !linked[1] {
+				continue
+			}
+
+			v0, v1 := poly.Verts[0]*3, poly.Verts[1]*3
+			ends := [2]d3.Vec3{tile.Verts[v0 : v0+3], tile.Verts[v1 : v1+3]}
+			nentries := 1
+			if uint32(con.Flags)&offMeshConBidir != 0 {
+				nentries = 2
+			}
+			for k := 0; k < nentries; k++ {
+				if d := pos.DistSqr(ends[k]); d < bestDist || ref == 0 && d == bestDist {
+					bestDist = d
+					bestStart, bestEnd = ends[k], ends[1-k]
+					ref = polyRef
+				}
+			}
+		}
+	}
+	if ref == 0 {
+		return nil, nil, 0, Success
+	}
+	return d3.NewVec3From(bestStart), d3.NewVec3From(bestEnd), ref, Success
+}
+
 // queryPolygons6 finds polygons that overlap the search box.
 //
 //  Arguments:
@@ -1194,6 +3089,16 @@ func (q *NavMeshQuery) queryPolygons4(
 	center, extents d3.Vec3,
 	filter QueryFilter,
 	query polyQuery) Status {
+	return q.queryPolygonsBatch(center, extents, filter, query, new(polyBatch))
+}
+
+// queryPolygonsBatch is queryPolygons4, filling the polygon batches passed
+// to query in batch.
+func (q *NavMeshQuery) queryPolygonsBatch(
+	center, extents d3.Vec3,
+	filter QueryFilter,
+	query polyQuery,
+	batch *polyBatch) Status {
 	// parameter check
 	if len(center) != 3 || len(extents) != 3 || filter == nil || query == nil {
 		return Failure | InvalidParam
@@ -1213,63 +3118,70 @@ func (q *NavMeshQuery) queryPolygons4(
 		for x := minx; x <= maxx; x++ {
 			nneis := q.nav.TilesAt(x, y, neis, maxNeis)
 			for j := int32(0); j < nneis; j++ {
-				q.queryPolygonsInTile(neis[j], bmin[:], bmax[:], filter, query)
+				q.queryPolygonsInTile(neis[j], bmin[:], bmax[:], filter, query, batch)
 			}
 		}
 	}
 	return Success
 }
 
+// quantQueryBox returns the box [qmin, qmax], clamped to the bounds of tile
+// and quantized like the tile BV-tree nodes.
+func quantQueryBox(tile *MeshTile, qmin, qmax []float32) (bmin, bmax [3]uint16) {
+	tbmin := tile.Header.BMin
+	tbmax := tile.Header.BMax
+	qfac := tile.Header.BvQuantFactor
+
+	// Clamp query box to world box.
+	minx := f32.Clamp(qmin[0], tbmin[0], tbmax[0]) - tbmin[0]
+	miny := f32.Clamp(qmin[1], tbmin[1], tbmax[1]) - tbmin[1]
+	minz := f32.Clamp(qmin[2], tbmin[2], tbmax[2]) - tbmin[2]
+	maxx := f32.Clamp(qmax[0], tbmin[0], tbmax[0]) - tbmin[0]
+	maxy := f32.Clamp(qmax[1], tbmin[1], tbmax[1]) - tbmin[1]
+	maxz := f32.Clamp(qmax[2], tbmin[2], tbmax[2]) - tbmin[2]
+	// Quantize
+	bmin[0] = uint16(qfac*minx) & 0xfffe
+	bmin[1] = uint16(qfac*miny) & 0xfffe
+	bmin[2] = uint16(qfac*minz) & 0xfffe
+	bmax[0] = uint16(qfac*maxx+1) | 1
+	bmax[1] = uint16(qfac*maxy+1) | 1
+	bmax[2] = uint16(qfac*maxz+1) | 1
+	return bmin, bmax
+}
+
 // queryPolygonsInTile queries polygons within a tile.
+//
+// The polygons are those which quantized bounds overlap the quantized query
+// box, as stored in the BV-tree. Tiles without BV-tree compute the quantized
+// bounds of each polygon, so that the result is the same with or without the
+// tree. The polygons are passed to query in batch.
 func (q *NavMeshQuery) queryPolygonsInTile(
 	tile *MeshTile,
 	qmin, qmax []float32,
 	filter QueryFilter,
-	query polyQuery) {
+	query polyQuery,
+	batch *polyBatch) {
 
 	assert.True(q.nav != nil, "navmesh should not be nill")
-	batchSize := int32(32)
+	const batchSize = polyQueryBatchSize
 
-	polyRefs := make([]PolyRef, batchSize)
-	polys := make([]*Poly, batchSize)
+	polyRefs := batch.refs[:]
+	polys := batch.polys[:]
 	var n int32
 
-	if len(tile.BvTree) > 0 {
+	bmin, bmax := quantQueryBox(tile, qmin, qmax)
+	base := q.nav.polyRefBase(tile)
 
+	if len(tile.BvTree) > 0 {
 		var (
 			node            *BvNode
 			nodeIdx, endIdx int32
-			tbmin, tbmax    d3.Vec3
-			qfac            float32
 		)
 
 		nodeIdx = 0
-		endIdx = tile.Header.BvNodeCount
-
-		tbmin = d3.NewVec3From(tile.Header.BMin[:])
-		tbmax = d3.NewVec3From(tile.Header.BMax[:])
-		qfac = tile.Header.BvQuantFactor
-
-		// Calculate quantized box
-		var bmin, bmax [3]uint16
-
-		// Clamp query box to world box.
-		minx := f32.Clamp(qmin[0], tbmin[0], tbmax[0]) - tbmin[0]
-		miny := f32.Clamp(qmin[1], tbmin[1], tbmax[1]) - tbmin[1]
-		minz := f32.Clamp(qmin[2], tbmin[2], tbmax[2]) - tbmin[2]
-		maxx := f32.Clamp(qmax[0], tbmin[0], tbmax[0]) - tbmin[0]
-		maxy := f32.Clamp(qmax[1], tbmin[1], tbmax[1]) - tbmin[1]
-		maxz := f32.Clamp(qmax[2], tbmin[2], tbmax[2]) - tbmin[2]
-		// Quantize
-		bmin[0] = uint16(qfac*minx) & 0xfffe
-		bmin[1] = uint16(qfac*miny) & 0xfffe
-		bmin[2] = uint16(qfac*minz) & 0xfffe
-		bmax[0] = uint16(qfac*maxx+1) | 1
-		bmax[1] = uint16(qfac*maxy+1) | 1
-		bmax[2] = uint16(qfac*maxz+1) | 1
+		endIdx = tile.bvNodeCount()
 
 		// Traverse tree
-		base := q.nav.polyRefBase(tile)
 		// TODO: probably need to use an index or unsafe.Pointer here
 		for nodeIdx < endIdx {
 			node = &tile.BvTree[nodeIdx]
@@ -1299,16 +3211,12 @@ func (q *NavMeshQuery) queryPolygonsInTile(
 			}
 		}
 	} else {
-		var bmin, bmax d3.Vec3
-		bmin = d3.NewVec3()
-		bmax = d3.NewVec3()
-		base := q.nav.polyRefBase(tile)
+		pbmin, pbmax := d3.NewVec3(), d3.NewVec3()
 		for i := int32(0); i < tile.Header.PolyCount; i++ {
 			p := &tile.Polys[i]
-			// Do not return off-mesh connection polygons.
+			// Do not return off-mesh connection polygons, they are not in
+			// the BV-tree either.
 			if p.Type() == polyTypeOffMeshConnection {
-
-				log.Fatalf("do return off-mesh connection polygons")
 				continue
 			}
 			// Must pass filter
@@ -1316,18 +3224,8 @@ func (q *NavMeshQuery) queryPolygonsInTile(
 			if !filter.PassFilter(ref, tile, p) {
 				continue
 			}
-			// Calc polygon bounds.
-			vidx := p.Verts[0] * 3
-			v := tile.Verts[vidx : vidx+3]
-			bmin.Assign(v)
-			bmax.Assign(v)
-			for j := uint8(1); j < p.VertCount; j++ {
-				vidx = p.Verts[j] * 3
-				v = tile.Verts[vidx : vidx+3]
-				d3.Vec3Min(bmin, v)
-				d3.Vec3Max(bmax, v)
-			}
-			if OverlapBounds(qmin, qmax, bmin[:], bmax[:]) {
+			nbmin, nbmax := tile.quantPolyBounds(i, pbmin, pbmax)
+			if OverlapQuantBounds(bmin[:], bmax[:], nbmin[:], nbmax[:]) {
 				polyRefs[n] = ref
 				polys[n] = p
 
@@ -1403,6 +3301,20 @@ func (q *NavMeshQuery) IsValidPolyRef(ref PolyRef, filter QueryFilter) bool {
 // If the path array is too small to hold the result, it will be filled as far
 // as possible from the start position toward the end position.
 //
+// T, PathCount and PathCost of hit are reset by the call, the other
+// fields are overwritten when relevant.
+//
+// # Path Cost
+//
+// With the RaycastUseCosts option, hit.PathCost is the sum of the filter
+// costs of the ray segments crossing each visited polygon, heights being
+// taken on the polygon edges. When the ray reaches the end position, it is
+// the cost of moving in straight line from the start to the end position,
+// which can be used to weight a line of sight by the terrain it goes over.
+// When the ray hits a wall, it only is the cost until the wall, which says
+// nothing of the cost of reaching the end position: the path cost is only
+// meaningful if the ray is unobstructed.
+//
 // # Using the Hit Parameter t of RaycastHit
 //
 // If the hit parameter is a very high value (math.MaxFloat32), then the ray has
@@ -1459,6 +3371,9 @@ func (q *NavMeshQuery) Raycast(
 	curPos = d3.NewVec3From(startPos)
 	dir = endPos.Sub(startPos)
 	hit.HitNormal = d3.NewVec3()
+	hit.T = 0
+	hit.PathCount = 0
+	hit.PathCost = 0
 
 	st = Success
 
@@ -1788,7 +3703,7 @@ func (q *NavMeshQuery) InitSlicedFindPath(startRef, endRef PolyRef,
 	copy(startNode.Pos, startPos)
 	startNode.PIdx = 0
 	startNode.Cost = 0
-	startNode.Total = startPos.Dist(endPos) * HScale
+	startNode.Total = startPos.Dist(endPos) * q.hScale
 	startNode.ID = startRef
 	startNode.Flags = nodeOpen
 	q.openList.push(startNode)
@@ -1957,6 +3872,7 @@ func (q *NavMeshQuery) UpdateSlicedFindPath(maxIter int, doneIters *int) Status
 					neighbourRef, neighbourTile, neighbourPoly)
 				cost = bestNode.Cost + curCost
 			}
+			cost += q.extraCost(neighbourRef, neighbourNode.Pos)
 
 			// Special case for last node.
 			if neighbourRef == q.query.endRef {
@@ -1970,7 +3886,7 @@ func (q *NavMeshQuery) UpdateSlicedFindPath(maxIter int, doneIters *int) Status
 			} else {
 				//fmt.Println("neighbourNode.Pos", neighbourNode.Pos)
 				//fmt.Println("q.query.endPos", q.query.endPos)
-				heuristic = neighbourNode.Pos.Dist(q.query.endPos) * HScale
+				heuristic = neighbourNode.Pos.Dist(q.query.endPos) * q.hScale
 			}
 
 			total := cost + heuristic
@@ -0,0 +1,113 @@
+package detour
+
+import (
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// QueryConfig holds the tunables of a NavMeshQuery, so that they can be
+// saved, restored or shared by several queries, for example the queries of
+// a NavMeshQueryPool.
+//
+// The zero value of each field means the default value, so the zero
+// QueryConfig is the configuration of a query returned by NewNavMeshQuery,
+// whatever its node pool size.
+type QueryConfig struct {
+	// Scale applied to the search heuristic, 0 meaning HScale. (See
+	// SetHeuristicScale)
+	//
+	// As 0 means the default, a Dijkstra search is configured with a scale
+	// close to 0 instead, such as math.SmallestNonzeroFloat32.
+	HeuristicScale float32
+
+	// Maximum number of search nodes, 0 keeping the current node pool. (See
+	// NewNavMeshQuery) [Limits: 0 <= value <= 65535]
+	MaxNodes int32
+
+	// Maximum number of nodes a FindPath may expand, 0 meaning no limit.
+	// (See SetMaxSearchNodes)
+	MaxSearchNodes int
+
+	// Half extents for the nearest polygon searches, nil meaning
+	// DefaultExtents. (See NavMeshQuery.Extents)
+	Extents d3.Vec3
+
+	// Distance below which consecutive straight path vertices are merged, 0
+	// meaning that only equal vertices are. (See SetStraightPathEpsilon)
+	StraightPathEpsilon float32
+
+	// Weight of the vertical distance in SnapToMesh, 0 meaning
+	// DefaultSnapVerticalWeight. (See SetSnapVerticalWeight)
+	SnapVerticalWeight float32
+
+	// Vertical search range of Coverage, 0 meaning the height of the
+	// sampled region. (See SetCoverageVerticalRange)
+	CoverageVerticalRange float32
+}
+
+// DefaultExtents are the default half extents of the nearest polygon
+// searches, for an agent of about 2 units high.
+var DefaultExtents = d3.Vec3{2, 4, 2}
+
+// Config returns the current configuration of the query.
+//
+// The returned configuration has all its fields set, MaxNodes being the
+// size of the node pool, so that it can be applied to another query to
+// configure it identically.
+func (q *NavMeshQuery) Config() QueryConfig {
+	return QueryConfig{
+		HeuristicScale:        q.hScale,
+		MaxNodes:              q.nodePool.MaxNodes(),
+		MaxSearchNodes:        q.maxSearch,
+		Extents:               q.Extents(),
+		StraightPathEpsilon:   q.pathEps,
+		SnapVerticalWeight:    q.SnapVerticalWeight(),
+		CoverageVerticalRange: q.coverRange,
+	}
+}
+
+// ApplyConfig configures the query with cfg, the zero fields of cfg
+// restoring the default values.
+//
+// If cfg.MaxNodes is not 0 and differs from the size of the node pool, the
+// node pool is reallocated, so ApplyConfig must not be called while a sliced
+// path query is in progress. If cfg is invalid, Failure|InvalidParam is
+// returned and the query is left untouched.
+func (q *NavMeshQuery) ApplyConfig(cfg QueryConfig) Status {
+	if cfg.MaxNodes < 0 || cfg.MaxNodes > int32(nullIdx) || cfg.MaxNodes > int32(1<<nodeParentBits)-1 ||
+		cfg.HeuristicScale < 0 || cfg.StraightPathEpsilon < 0 || cfg.SnapVerticalWeight < 0 || cfg.CoverageVerticalRange < 0 || (cfg.Extents != nil && len(cfg.Extents) != 3) {
+		return Failure | InvalidParam
+	}
+
+	if cfg.MaxNodes != 0 && cfg.MaxNodes != q.nodePool.MaxNodes() {
+		q.nodePool = newNodePool(cfg.MaxNodes, int32(math32.NextPow2(uint32(cfg.MaxNodes/4))))
+		q.openList = newnodeQueue(cfg.MaxNodes)
+	}
+
+	q.hScale = cfg.HeuristicScale
+	if q.hScale == 0 {
+		q.hScale = HScale
+	}
+	q.SetMaxSearchNodes(cfg.MaxSearchNodes)
+	q.SetStraightPathEpsilon(cfg.StraightPathEpsilon)
+	q.SetSnapVerticalWeight(cfg.SnapVerticalWeight)
+	q.SetCoverageVerticalRange(cfg.CoverageVerticalRange)
+	q.extents = nil
+	if cfg.Extents != nil {
+		q.extents = d3.NewVec3From(cfg.Extents)
+	}
+	return Success
+}
+
+// Extents returns the half extents to use for the nearest polygon searches
+// run with this query, DefaultExtents if none has been configured.
+//
+// The search methods take their extents as argument, Extents does not
+// change them: it allows the code running queries, such as the borrowers of
+// the queries of a pool, to share the extents of the query configuration.
+func (q *NavMeshQuery) Extents() d3.Vec3 {
+	if q.extents == nil {
+		return d3.NewVec3From(DefaultExtents)
+	}
+	return d3.NewVec3From(q.extents)
+}
@@ -0,0 +1,106 @@
+package detour
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestQueryConfig(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	st, q := NewNavMeshQuery(mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+
+	def := QueryConfig{HeuristicScale: HScale, MaxNodes: 1000, Extents: DefaultExtents, SnapVerticalWeight: DefaultSnapVerticalWeight}
+	if cfg := q.Config(); !reflect.DeepEqual(cfg, def) {
+		t.Fatalf("default config = %+v, want %+v", cfg, def)
+	}
+
+	cfg := QueryConfig{HeuristicScale: 2, MaxNodes: 64, MaxSearchNodes: 10, Extents: d3.Vec3{1, 2, 3}, StraightPathEpsilon: 0.1, SnapVerticalWeight: 2, CoverageVerticalRange: 5}
+	if st := q.ApplyConfig(cfg); st != Success {
+		t.Fatalf("ApplyConfig status 0x%x, want 0x%x", uint32(st), uint32(Success))
+	}
+	if got := q.Config(); !reflect.DeepEqual(got, cfg) {
+		t.Errorf("config = %+v, want %+v", got, cfg)
+	}
+	if q.HeuristicScale() != 2 || q.MaxSearchNodes() != 10 || q.nodePool.MaxNodes() != 64 || q.openList.capacity != 64 || q.StraightPathEpsilon() != 0.1 {
+		t.Errorf("config %+v not applied", cfg)
+	}
+
+	// The applied extents are a copy.
+	cfg.Extents[0] = 10
+	if ext := q.Extents(); !ext.Approx(d3.Vec3{1, 2, 3}) {
+		t.Errorf("extents = %v, want [1 2 3]", ext)
+	}
+
+	// The query still works with its new node pool.
+	filter := NewStandardQueryFilter()
+	_, orgRef, org := q.FindNearestPoly(d3.Vec3{5, 0, 5}, q.Extents(), filter)
+	_, dstRef, dst := q.FindNearestPoly(d3.Vec3{30, 0, 20}, q.Extents(), filter)
+	path := make([]PolyRef, 100)
+	if n, st := q.FindPath(orgRef, dstRef, org, dst, filter, path); StatusFailed(st) || n == 0 || path[0] != orgRef {
+		t.Errorf("FindPath after ApplyConfig returned %#x and status 0x%x", path[:n], uint32(st))
+	}
+
+	// Invalid configs do not change the query.
+	want := q.Config()
+	for _, bad := range []QueryConfig{
+		{MaxNodes: -1},
+		{MaxNodes: 1 << 16},
+		{HeuristicScale: -1},
+		{Extents: d3.Vec3{1}},
+		{StraightPathEpsilon: -1},
+		{SnapVerticalWeight: -1},
+		{CoverageVerticalRange: -1},
+	} {
+		if st := q.ApplyConfig(bad); st != Failure|InvalidParam {
+			t.Errorf("ApplyConfig(%+v) status 0x%x, want 0x%x", bad, uint32(st), uint32(Failure|InvalidParam))
+		}
+		if got := q.Config(); !reflect.DeepEqual(got, want) {
+			t.Errorf("after ApplyConfig(%+v), config = %+v, want %+v", bad, got, want)
+		}
+	}
+
+	// The zero config restores the defaults, but keeps the node pool.
+	if st := q.ApplyConfig(QueryConfig{}); st != Success {
+		t.Fatalf("ApplyConfig status 0x%x, want 0x%x", uint32(st), uint32(Success))
+	}
+	def.MaxNodes = 64
+	if got := q.Config(); !reflect.DeepEqual(got, def) {
+		t.Errorf("after ApplyConfig of the zero config, config = %+v, want %+v", got, def)
+	}
+}
+
+func TestNavMeshQueryPoolApplyConfig(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	st, pool := NewNavMeshQueryPool(mesh, 256, 3)
+	if StatusFailed(st) {
+		t.Fatalf("NewNavMeshQueryPool failed with status 0x%x", uint32(st))
+	}
+
+	cfg := QueryConfig{HeuristicScale: 1.5, MaxNodes: 512, MaxSearchNodes: 100, Extents: d3.Vec3{1, 1, 1}, SnapVerticalWeight: 3}
+	if st := pool.ApplyConfig(cfg); st != Success {
+		t.Fatalf("ApplyConfig status 0x%x, want 0x%x", uint32(st), uint32(Success))
+	}
+	queries := make([]*NavMeshQuery, pool.Size())
+	for i := range queries {
+		queries[i] = pool.Get()
+		if got := queries[i].Config(); !reflect.DeepEqual(got, cfg) {
+			t.Errorf("query %d config = %+v, want %+v", i, got, cfg)
+		}
+	}
+	for _, q := range queries {
+		pool.Put(q)
+	}
+
+	if st := pool.ApplyConfig(QueryConfig{MaxNodes: -1}); st != Failure|InvalidParam {
+		t.Errorf("ApplyConfig of an invalid config status 0x%x, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+	}
+}
@@ -50,8 +50,8 @@ type QueryFilter interface {
 // StandardQueryFilter is a standard implementation of the QueryFilter
 // interface.
 //
-// At construction all area costs default to 1.0. All flags are included and
-// none are excluded.
+// At construction all area costs default to 1.0 and the off-mesh connection
+// cost to 0. All flags are included and none are excluded.
 // If a polygon has both an include and an exclude flag, it will be excluded.
 //
 // The way filtering works, a navigation mesh polygon must have at least one
@@ -69,6 +69,9 @@ type StandardQueryFilter struct {
 
 	// Flags for polygons that should not be visted
 	excludeFlags uint16
+
+	// Additional cost of traversing an off-mesh connection.
+	offMeshConCost float32
 }
 
 // NewStandardQueryFilter initializes a new standard query filter.
@@ -89,6 +92,23 @@ func (qf *StandardQueryFilter) AreaCost(i int32) float32 { return qf.areaCost[i]
 // SetAreaCost sets the traversal cost of the area which id is i.
 func (qf *StandardQueryFilter) SetAreaCost(i int32, cost float32) { qf.areaCost[i] = cost }
 
+// OffMeshConnectionCost returns the additional cost of traversing an
+// off-mesh connection.
+func (qf *StandardQueryFilter) OffMeshConnectionCost() float32 { return qf.offMeshConCost }
+
+// SetOffMeshConnectionCost sets the additional cost of traversing an
+// off-mesh connection.
+//
+// The cost is added, once per connection, to the cost of moving along the
+// connection, which is its length times the cost of its area. Raising it
+// makes paths avoid off-mesh connections (jumps, ladders, etc.) unless the
+// detour they save costs more. It defaults to 0, for which off-mesh
+// connections cost their length, like any other segment.
+//
+// The cost should not be negative, see QueryFilter about costs lower than
+// the travel distance.
+func (qf *StandardQueryFilter) SetOffMeshConnectionCost(cost float32) { qf.offMeshConCost = cost }
+
 // IncludeFlags returns the include flags for the filter.
 //
 // Any polygons that include one or more of these flags will be
@@ -137,5 +157,84 @@ func (qf *StandardQueryFilter) Cost(pa, pb d3.Vec3,
 	curRef PolyRef, curTile *MeshTile, curPoly *Poly,
 	nextRef PolyRef, nextTile *MeshTile, nextPoly *Poly) float32 {
 
-	return pa.Dist(pb) * qf.areaCost[curPoly.Area()]
+	cost := pa.Dist(pb) * qf.areaCost[curPoly.Area()]
+	if curPoly.Type() == polyTypeOffMeshConnection {
+		cost += qf.offMeshConCost
+	}
+	return cost
+}
+
+// forbiddenPolysFilter is a query filter rejecting the polygons of a set, in
+// addition to the ones rejected by the filter it wraps.
+type forbiddenPolysFilter struct {
+	QueryFilter
+	forbidden map[PolyRef]struct{}
+}
+
+// PassFilter returns true if the polygon is not forbidden and passes the
+// wrapped filter.
+func (f forbiddenPolysFilter) PassFilter(ref PolyRef, tile *MeshTile, poly *Poly) bool {
+	if _, ok := f.forbidden[ref]; ok {
+		return false
+	}
+	return f.QueryFilter.PassFilter(ref, tile, poly)
+}
+
+// tilesFilter is a query filter rejecting the polygons of the tiles that
+// are not allowed, in addition to the ones rejected by the filter it wraps.
+type tilesFilter struct {
+	QueryFilter
+	allowed func(tx, ty int32) bool
+}
+
+// PassFilter returns true if the polygon tile is allowed and the polygon
+// passes the wrapped filter.
+func (f tilesFilter) PassFilter(ref PolyRef, tile *MeshTile, poly *Poly) bool {
+	return f.allowed(tile.Header.X, tile.Header.Y) && f.QueryFilter.PassFilter(ref, tile, poly)
+}
+
+// areaFilter is a query filter rejecting the polygons of other areas than
+// area, in addition to the ones rejected by the filter it wraps.
+type areaFilter struct {
+	QueryFilter
+	area uint8
+}
+
+// PassFilter returns true if the polygon is of the filter area and passes
+// the wrapped filter.
+func (f areaFilter) PassFilter(ref PolyRef, tile *MeshTile, poly *Poly) bool {
+	return poly.Area() == f.area && f.QueryFilter.PassFilter(ref, tile, poly)
+}
+
+// A CapabilitySet describes the kind of polygons an agent can traverse, in
+// terms of polygon flags.
+//
+// Each polygon flag represents a capability, for example the ability to
+// walk, to swim or to jump. Navmesh polygons are tagged with the flags of the
+// capabilities required to traverse them. Flag values are chosen by the
+// navmesh builder, detour does not give them any meaning.
+//
+// The filter returned by NewQueryFilterForCapabilities lets an agent
+// traverse a polygon if all of the following hold:
+//  - the polygon has at least one of the Has flags,
+//  - the polygon has none of the Known flags missing from Has.
+// So, with Known set to all the capability flags used by the navmesh, an
+// agent only traverses the polygons for which it has every required
+// capability. With Known left to zero, one of the capabilities is enough.
+type CapabilitySet struct {
+	Has   uint16 // Flags of the capabilities of the agent.
+	Known uint16 // Flags of all the capabilities used in the navmesh.
+}
+
+// NewQueryFilterForCapabilities returns a standard query filter only
+// passing the polygons an agent having the specified capabilities can
+// traverse.
+//
+// The include flags of the filter are caps.Has and the exclude flags are the
+// ones of caps.Known not in caps.Has. Area costs are 1.0.
+func NewQueryFilterForCapabilities(caps CapabilitySet) *StandardQueryFilter {
+	qf := NewStandardQueryFilter()
+	qf.SetIncludeFlags(caps.Has)
+	qf.SetExcludeFlags(caps.Known &^ caps.Has)
+	return qf
 }
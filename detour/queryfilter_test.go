@@ -0,0 +1,36 @@
+package detour
+
+import "testing"
+
+func TestNewQueryFilterForCapabilities(t *testing.T) {
+	const (
+		walk uint16 = 1 << iota
+		swim
+		jump
+		all = walk | swim | jump
+	)
+
+	filterTests := []struct {
+		msg   string
+		caps  CapabilitySet
+		flags uint16 // polygon flags
+		want  bool
+	}{
+		{"walker on ground", CapabilitySet{Has: walk, Known: all}, walk, true},
+		{"walker in water", CapabilitySet{Has: walk, Known: all}, swim, false},
+		{"walker on jump link", CapabilitySet{Has: walk, Known: all}, jump, false},
+		{"walker in shallow water", CapabilitySet{Has: walk, Known: all}, walk | swim, false},
+		{"walker in shallow water, known unset", CapabilitySet{Has: walk}, walk | swim, true},
+		{"swimmer in shallow water", CapabilitySet{Has: walk | swim, Known: all}, walk | swim, true},
+		{"flier on jump link", CapabilitySet{Has: walk | jump, Known: all}, jump, true},
+		{"untagged poly", CapabilitySet{Has: all, Known: all}, 0, false},
+	}
+
+	for _, tt := range filterTests {
+		qf := NewQueryFilterForCapabilities(tt.caps)
+		poly := Poly{Flags: tt.flags}
+		if got := qf.PassFilter(0, nil, &poly); got != tt.want {
+			t.Errorf("%s, PassFilter() = %t, want %t", tt.msg, got, tt.want)
+		}
+	}
+}
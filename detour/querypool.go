@@ -0,0 +1,91 @@
+package detour
+
+// A NavMeshQueryPool holds a fixed number of queries, sharing the same
+// navmesh, to be used by concurrent goroutines.
+//
+// A NavMeshQuery is not safe for concurrent use, as its node pool and open
+// list are reused by each query. Each goroutine borrows a query with Get,
+// runs its queries, then gives it back with Put. As long as the navmesh is
+// not modified, queries of a pool can run concurrently.
+type NavMeshQueryPool struct {
+	queries chan *NavMeshQuery
+}
+
+// NewNavMeshQueryPool initializes a pool of queries.
+//
+//  Arguments:
+//   nav       The navmesh shared by the queries.
+//   maxNodes  Maximum number of search nodes of each query. (See
+//             NewNavMeshQuery)
+//   size      The number of queries in the pool. [Limit: > 0]
+//
+// Return the status flags for the initialization of the pool and the pool.
+func NewNavMeshQueryPool(nav *NavMesh, maxNodes int32, size int) (Status, *NavMeshQueryPool) {
+	if nav == nil || size <= 0 {
+		return Failure | InvalidParam, nil
+	}
+
+	p := &NavMeshQueryPool{queries: make(chan *NavMeshQuery, size)}
+	for i := 0; i < size; i++ {
+		st, q := NewNavMeshQuery(nav, maxNodes)
+		if StatusFailed(st) {
+			return st, nil
+		}
+		p.queries <- q
+	}
+	return Success, p
+}
+
+// Get borrows a query from the pool, waiting for one to be given back if
+// they are all in use.
+func (p *NavMeshQueryPool) Get() *NavMeshQuery {
+	return <-p.queries
+}
+
+// Put gives back a query borrowed with Get. The query must not be used
+// anymore by the caller.
+//
+// Settings applied to the query, such as the heuristic scale, are kept for
+// the next borrower.
+func (p *NavMeshQueryPool) Put(q *NavMeshQuery) {
+	p.queries <- q
+}
+
+// Size returns the number of queries of the pool.
+func (p *NavMeshQueryPool) Size() int {
+	return cap(p.queries)
+}
+
+// Warmup warms up all the queries of the pool. (See NavMeshQuery.Warmup)
+//
+// Warmup waits for all the borrowed queries to be given back.
+func (p *NavMeshQueryPool) Warmup() {
+	queries := make([]*NavMeshQuery, cap(p.queries))
+	for i := range queries {
+		queries[i] = p.Get()
+	}
+	for _, q := range queries {
+		q.Warmup()
+		p.Put(q)
+	}
+}
+
+// ApplyConfig applies cfg to all the queries of the pool. (See
+// NavMeshQuery.ApplyConfig)
+//
+// ApplyConfig waits for all the borrowed queries to be given back, and
+// returns the status of the first query for which the configuration failed.
+func (p *NavMeshQueryPool) ApplyConfig(cfg QueryConfig) Status {
+	queries := make([]*NavMeshQuery, cap(p.queries))
+	for i := range queries {
+		queries[i] = p.Get()
+	}
+	st := Status(Success)
+	for _, q := range queries {
+		if qst := q.ApplyConfig(cfg); StatusFailed(qst) && !StatusFailed(st) {
+			st = qst
+		}
+		p.Put(q)
+	}
+	return st
+}
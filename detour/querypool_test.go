@@ -0,0 +1,143 @@
+package detour
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+func TestNavMeshQueryPool(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	if st, _ := NewNavMeshQueryPool(mesh, 2048, 0); st != Failure|InvalidParam {
+		t.Errorf("NewNavMeshQueryPool with size 0 returned status 0x%x, want 0x%x",
+			uint32(st), uint32(Failure|InvalidParam))
+	}
+
+	st, pool := NewNavMeshQueryPool(mesh, 2048, 4)
+	if StatusFailed(st) {
+		t.Fatalf("NewNavMeshQueryPool failed with status 0x%x", uint32(st))
+	}
+	if pool.Size() != 4 {
+		t.Errorf("Size() = %d, want 4", pool.Size())
+	}
+
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+	findPath := func(q *NavMeshQuery, org, dst d3.Vec3) []PolyRef {
+		_, orgRef, orgPt := q.FindNearestPoly(org, extents, filter)
+		_, dstRef, dstPt := q.FindNearestPoly(dst, extents, filter)
+		path := make([]PolyRef, 256)
+		n, _ := q.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+		return path[:n]
+	}
+
+	pairs := [][2]d3.Vec3{
+		{{5, 0, 10}, {50, 0, 30}},
+		{{50, 0, 30}, {5, 0, 10}},
+		{{5, 0, 10}, {30, 0, 20}},
+		{{30, 0, 20}, {50, 0, 30}},
+	}
+	q := pool.Get()
+	want := make([][]PolyRef, len(pairs))
+	for i, p := range pairs {
+		want[i] = findPath(q, p[0], p[1])
+		if len(want[i]) == 0 {
+			t.Fatalf("no path found from %v to %v", p[0], p[1])
+		}
+	}
+	pool.Put(q)
+
+	// More goroutines than queries, each running all the paths.
+	var wg sync.WaitGroup
+	errs := make(chan string, 16*len(pairs))
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for k := range pairs {
+				i := (g + k) % len(pairs)
+				q := pool.Get()
+				got := findPath(q, pairs[i][0], pairs[i][1])
+				pool.Put(q)
+				if !reflect.DeepEqual(got, want[i]) {
+					errs <- "concurrent path differs from the serial one"
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+		break
+	}
+}
+
+func TestNavMeshQueryPoolWarmup(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	const maxNodes = 1000
+	st, pool := NewNavMeshQueryPool(mesh, maxNodes, 2)
+	if StatusFailed(st) {
+		t.Fatalf("NewNavMeshQueryPool failed with status 0x%x", uint32(st))
+	}
+
+	warm := func(q *NavMeshQuery) bool {
+		nodes := int32(len(q.nodePool.chunks)) * nodeChunkSize
+		return nodes >= maxNodes && len(q.nodePool.next) == maxNodes &&
+			len(q.tinyNodePool.next) == int(q.tinyNodePool.maxNodes) &&
+			cap(q.openList.heap) == maxNodes
+	}
+	q := pool.Get()
+	if warm(q) {
+		t.Fatalf("query is warm before Warmup")
+	}
+	pool.Put(q)
+
+	pool.Warmup()
+	queries := []*NavMeshQuery{pool.Get(), pool.Get()}
+	for i, q := range queries {
+		if !warm(q) {
+			t.Errorf("query %d is not warm after Warmup", i)
+		}
+	}
+
+	// Warming up again is a no-op.
+	q = queries[0]
+	first, heap := &q.nodePool.chunks[0][0], &q.openList.heap[:1][0]
+	q.Warmup()
+	if &q.nodePool.chunks[0][0] != first || &q.openList.heap[:1][0] != heap {
+		t.Errorf("Warmup reallocated a warm query")
+	}
+
+	filter := NewStandardQueryFilter()
+	_, orgRef, orgPt := q.FindNearestPoly(d3.Vec3{5, 0, 10}, d3.Vec3{2, 4, 2}, filter)
+	_, dstRef, dstPt := q.FindNearestPoly(d3.Vec3{50, 0, 30}, d3.Vec3{2, 4, 2}, filter)
+	path := make([]PolyRef, 256)
+	if n, st := q.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path); st != Success || n == 0 {
+		t.Errorf("FindPath on a warm query returned %d polys, status 0x%x", n, uint32(st))
+	}
+	if !warm(q) || &q.nodePool.chunks[0][0] != first {
+		t.Errorf("FindPath reallocated a warm query")
+	}
+	for _, q := range queries {
+		pool.Put(q)
+	}
+}
+
+func BenchmarkNewNavMeshQueryPool(b *testing.B) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if st, _ := NewNavMeshQueryPool(mesh, 65535, 64); StatusFailed(st) {
+			b.Fatalf("NewNavMeshQueryPool failed with status 0x%x", uint32(st))
+		}
+	}
+}
@@ -20,6 +20,7 @@ const (
 	BufferTooSmall   = 1 << 4 // Result buffer for the query was too small to store all results.
 	OutOfNodes       = 1 << 5 // Query ran out of nodes during search.
 	PartialResult    = 1 << 6 // Query did not reach the end location, returning best guess.
+	AlreadyOccupied  = 1 << 7 // A tile has already been assigned to the given x,y coordinate.
 )
 
 // Implementation of the error interface
@@ -34,10 +35,14 @@ func (s Status) Error() string {
 			return "out of memory"
 		case InvalidParam:
 			return "invalid parameter"
+		case BufferTooSmall:
+			return "buffer too small"
 		case OutOfNodes:
 			return "out of nodes"
 		case PartialResult:
 			return "partial result"
+		case AlreadyOccupied:
+			return "tile location already occupied"
 		default:
 			return fmt.Sprintf("unspecified error 0x%x", uint32(s))
 		}
@@ -67,3 +72,105 @@ func StatusInProgress(status Status) bool {
 func StatusDetail(status Status, detail uint32) bool {
 	return (uint32(status) & detail) != 0
 }
+
+// A statusError is an error corresponding to a status detail.
+type statusError struct {
+	msg    string
+	parent error // more generic error, or nil
+}
+
+func (e *statusError) Error() string { return e.msg }
+func (e *statusError) Unwrap() error { return e.parent }
+
+// Errors returned by Status.Err and Status.DetailErr, to be checked with
+// errors.Is.
+var (
+	ErrFailure        error = &statusError{msg: "detour: operation failed"}
+	ErrWrongMagic     error = &statusError{msg: "detour: wrong magic number"}
+	ErrWrongVersion   error = &statusError{msg: "detour: wrong version number"}
+	ErrOutOfMemory    error = &statusError{msg: "detour: out of memory"}
+	ErrInvalidParam   error = &statusError{msg: "detour: invalid parameter"}
+	ErrBufferTooSmall error = &statusError{msg: "detour: buffer too small"}
+	ErrOutOfNodes     error = &statusError{msg: "detour: out of nodes"}
+	ErrNoPath         error = &statusError{msg: "detour: destination not reached"}
+	ErrTileOccupied   error = &statusError{msg: "detour: tile location already occupied"}
+)
+
+// More specific errors, returned by NavMesh.CheckPolyRef only, as statuses do
+// not carry the detail of an invalid reference, only InvalidParam.
+// errors.Is(err, ErrInvalidParam) holds for them.
+var (
+	ErrInvalidPolyRef error = &statusError{msg: "detour: invalid polygon reference", parent: ErrInvalidParam}
+	ErrTileNotLoaded  error = &statusError{msg: "detour: tile not loaded", parent: ErrInvalidParam}
+)
+
+// A wrappedStatus is the error of a status, wrapping the error of its detail.
+type wrappedStatus struct {
+	st  Status
+	err error // error of the status detail
+}
+
+func (e *wrappedStatus) Error() string { return e.err.Error() }
+func (e *wrappedStatus) Unwrap() error { return e.err }
+
+// As sets target to the status, if target is a *Status.
+func (e *wrappedStatus) As(target interface{}) bool {
+	if st, ok := target.(*Status); ok {
+		*st = e.st
+		return true
+	}
+	return false
+}
+
+// Err returns the error corresponding to a failed status, or nil if the
+// status is not a failure.
+//
+// The error is the one of the status detail, to be checked with errors.Is,
+// and wraps the status itself, which errors.As recovers. Succeeded statuses
+// may have details, for example BufferTooSmall or PartialResult, Err returns
+// nil for them since their result is usable, use DetailErr to get the error
+// of their detail.
+func (s Status) Err() error {
+	if !StatusFailed(s) {
+		return nil
+	}
+	return s.DetailErr()
+}
+
+// DetailErr returns the error corresponding to the status detail, or to the
+// failure if there is no detail, or nil if the status is a success without
+// any detail.
+//
+// Unlike Err, a succeeded status with a detail returns the error of that
+// detail, for example ErrBufferTooSmall or ErrNoPath. A status having
+// several details returns the error of the first one in the order of
+// declaration of the detail flags. As with Err, the error wraps the status.
+func (s Status) DetailErr() error {
+	var err error
+	switch {
+	case s&StatusDetailMask == 0:
+		if !StatusFailed(s) {
+			return nil
+		}
+		err = ErrFailure
+	case StatusDetail(s, WrongMagic):
+		err = ErrWrongMagic
+	case StatusDetail(s, WrongVersion):
+		err = ErrWrongVersion
+	case StatusDetail(s, OutOfMemory):
+		err = ErrOutOfMemory
+	case StatusDetail(s, InvalidParam):
+		err = ErrInvalidParam
+	case StatusDetail(s, BufferTooSmall):
+		err = ErrBufferTooSmall
+	case StatusDetail(s, OutOfNodes):
+		err = ErrOutOfNodes
+	case StatusDetail(s, PartialResult):
+		err = ErrNoPath
+	case StatusDetail(s, AlreadyOccupied):
+		err = ErrTileOccupied
+	default:
+		err = ErrFailure
+	}
+	return &wrappedStatus{st: s, err: err}
+}
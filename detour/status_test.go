@@ -0,0 +1,54 @@
+package detour
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStatusErr(t *testing.T) {
+	statusTests := []struct {
+		st         Status
+		want       error // Err
+		wantDetail error // DetailErr
+	}{
+		{Success, nil, nil},
+		{InProgress, nil, nil},
+		{Failure, ErrFailure, ErrFailure},
+		{Failure | WrongMagic, ErrWrongMagic, ErrWrongMagic},
+		{Failure | WrongVersion, ErrWrongVersion, ErrWrongVersion},
+		{Failure | OutOfMemory, ErrOutOfMemory, ErrOutOfMemory},
+		{Failure | InvalidParam, ErrInvalidParam, ErrInvalidParam},
+		{Failure | BufferTooSmall, ErrBufferTooSmall, ErrBufferTooSmall},
+		{Success | BufferTooSmall, nil, ErrBufferTooSmall},
+		{Failure | OutOfNodes, ErrOutOfNodes, ErrOutOfNodes},
+		{Success | PartialResult, nil, ErrNoPath},
+		{Success | OutOfNodes | PartialResult, nil, ErrOutOfNodes},
+		{Failure | AlreadyOccupied, ErrTileOccupied, ErrTileOccupied},
+	}
+
+	check := func(method string, st Status, err, want error) {
+		if want == nil {
+			if err != nil {
+				t.Errorf("Status(0x%x).%s() = %v, want nil", st, method, err)
+			}
+			return
+		}
+		if !errors.Is(err, want) {
+			t.Errorf("Status(0x%x).%s() = %v, want %v", st, method, err, want)
+		}
+		var got Status
+		if !errors.As(err, &got) || got != st {
+			t.Errorf("Status(0x%x).%s() wraps status 0x%x", st, method, got)
+		}
+	}
+	for _, tt := range statusTests {
+		check("Err", tt.st, tt.st.Err(), tt.want)
+		check("DetailErr", tt.st, tt.st.DetailErr(), tt.wantDetail)
+	}
+
+	for _, err := range []error{ErrInvalidPolyRef, ErrTileNotLoaded} {
+		if !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("errors.Is(%v, ErrInvalidParam) = false, want true", err)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package detour
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// A TileProvider provides the data of navmesh tiles, on demand.
+type TileProvider interface {
+	// Load returns the data of the tile at the specified grid location, as
+	// accepted by NavMesh.AddTile. ok is false if there is no such tile.
+	//
+	// Load may be called concurrently, for different tile columns, by the
+	// goroutines using a TileStreamer.
+	Load(x, y, layer int32) (data []byte, ok bool)
+}
+
+// A TileStreamer loads the tiles of a navmesh on demand, from a TileProvider,
+// and unloads the least recently used ones when there are too many of them.
+//
+// Tiles are handled by column: all the layers of a tile grid location are
+// loaded and unloaded together. Layers of a column are loaded in increasing
+// order, starting at 0, until the provider returns ok=false. A column for
+// which the provider has no tile at all is remembered as empty and never
+// requested again. So is a column which tiles all failed to be added to the
+// navmesh, the acquisitions of which keep returning the status of the
+// failure.
+//
+// Queries are run through the streamer, with Query or with one of the query
+// methods, such as FindNearestPoly or FindPath, which load the tiles the
+// query needs before running it. Acquired tiles are pinned: they are never
+// unloaded until released, even if that means going over the tile budget. A
+// query can only see the tiles that have been acquired, or that are still
+// loaded, in particular FindPath can not find a path crossing tiles that are
+// not loaded.
+//
+// The TileStreamer methods are safe for concurrent use, with a different
+// NavMeshQuery per goroutine. Tiles are read from the provider without
+// blocking the goroutines which tiles are already loaded, a column being
+// loaded by a single goroutine at a time. The queries run through the
+// streamer hold a read lock on the navmesh, tiles being only added and
+// removed when no such query is running. Queries run directly on the
+// navmesh, after Acquire for example, are not locked: they must not run
+// while other goroutines call the TileStreamer methods.
+type TileStreamer struct {
+	mesh     *NavMesh
+	provider TileProvider
+	budget   int
+
+	// meshMu is held for reading by the queries run through the streamer,
+	// and for writing while tiles are added to, or removed from, mesh.
+	meshMu sync.RWMutex
+
+	mu           sync.Mutex
+	columns      map[tileColumn]*list.Element // loaded columns
+	lru          *list.List                   // loaded columns, most recent first
+	empty        map[tileColumn]struct{}      // columns without tiles
+	failed       map[tileColumn]Status        // columns which tiles failed to be added
+	busy         map[tileColumn]chan struct{} // columns being loaded or unloaded, closed once done
+	resident     int                          // number of loaded tiles
+	residentOnly bool                         // FindNearestPoly does not load tiles
+	pathMargin   float32                      // margin of the path query boxes
+}
+
+// tileColumn is the location of a tile column in the tile grid.
+type tileColumn struct {
+	x, y int32
+}
+
+// streamedColumn is a column of tiles loaded by a TileStreamer.
+type streamedColumn struct {
+	loc  tileColumn
+	refs []TileRef // one per layer
+	pins int       // number of acquisitions not released yet
+}
+
+// NewTileStreamer creates a TileStreamer loading the tiles of mesh from
+// provider.
+//
+//  Arguments:
+//   mesh      The navmesh, initialized with NavMesh.Init and usually empty.
+//   provider  The source of tile data.
+//   budget    The maximum number of tiles the streamer keeps loaded, when
+//             they are not pinned. Zero or less means no limit.
+//
+// Tiles already present in mesh are not handled by the streamer and never
+// unloaded.
+func NewTileStreamer(mesh *NavMesh, provider TileProvider, budget int) *TileStreamer {
+	return &TileStreamer{
+		mesh:     mesh,
+		provider: provider,
+		budget:   budget,
+		columns:  make(map[tileColumn]*list.Element),
+		lru:      list.New(),
+		empty:    make(map[tileColumn]struct{}),
+		failed:   make(map[tileColumn]Status),
+		busy:     make(map[tileColumn]chan struct{}),
+	}
+}
+
+// NavMesh returns the navmesh which tiles are streamed.
+func (s *TileStreamer) NavMesh() *NavMesh {
+	return s.mesh
+}
+
+// Resident returns the number of tiles currently loaded by the streamer.
+func (s *TileStreamer) Resident() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resident
+}
+
+// SetNearestPolyResidentOnly sets whether FindNearestPoly only searches the
+// tiles already loaded, false by default.
+//
+// By default FindNearestPoly loads all the tiles overlapping its search box,
+// so that a cheap snap may request several tiles from the TileProvider, and
+// add them to the navmesh. In resident only mode, the provider is not
+// called by FindNearestPoly: the loaded tiles overlapping the search box are
+// pinned during the search, and marked as recently used, the others are
+// ignored. If the nearest polygon is in a tile that is not loaded, no
+// polygon or a farther one is found, as if the tile had no polygon. The
+// other methods, such as Acquire, still load the tiles.
+func (s *TileStreamer) SetNearestPolyResidentOnly(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.residentOnly = on
+}
+
+// NearestPolyResidentOnly reports whether FindNearestPoly only searches the
+// tiles already loaded.
+//
+// see SetNearestPolyResidentOnly
+func (s *TileStreamer) NearestPolyResidentOnly() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.residentOnly
+}
+
+// SetPathMargin sets the margin of the boxes of the tiles loaded for the path
+// queries, 0 by default.
+//
+// FindPath, FindStraightPath and Raycast load the tiles overlapping the box
+// of their start and end positions, which is expanded by margin on each
+// side. A path going round an obstacle, further than margin from this box,
+// can only be found if its tiles are still loaded.
+func (s *TileStreamer) SetPathMargin(margin float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pathMargin = margin
+}
+
+// PathMargin returns the margin of the boxes of the tiles loaded for the path
+// queries.
+//
+// see SetPathMargin
+func (s *TileStreamer) PathMargin() float32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pathMargin
+}
+
+// Acquire loads and pins all the tiles overlapping the specified box.
+//
+//  Arguments:
+//   bmin     The minimum bounds of the box. [(x, y, z)]
+//   bmax     The maximum bounds of the box. [(x, y, z)]
+//
+//  Return values:
+//   release  Unpins the tiles, must be called once the query is done.
+//   st       The status flags of the operation.
+//
+// If a tile fails to be added to the navmesh, the other tiles are still
+// loaded and the returned status is the one of NavMesh.AddTile. release
+// must be called anyway.
+func (s *TileStreamer) Acquire(bmin, bmax d3.Vec3) (release func(), st Status) {
+	return s.acquire(bmin, bmax, true)
+}
+
+// acquire pins all the tiles overlapping the specified box, loading the
+// missing ones if load is true. It must be called without s.mu held.
+func (s *TileStreamer) acquire(bmin, bmax d3.Vec3, load bool) (release func(), st Status) {
+	minx, miny := s.mesh.CalcTileLoc(bmin)
+	maxx, maxy := s.mesh.CalcTileLoc(bmax)
+
+	st = Success
+	var pinned []*list.Element
+	s.mu.Lock()
+	for y := miny; y <= maxy; y++ {
+		for x := minx; x <= maxx; x++ {
+			if e, cst := s.column(tileColumn{x, y}, load); e != nil {
+				e.Value.(*streamedColumn).pins++
+				s.lru.MoveToFront(e)
+				pinned = append(pinned, e)
+			} else if StatusFailed(cst) {
+				st = cst
+			}
+		}
+	}
+	s.evict()
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			s.mu.Lock()
+			for _, e := range pinned {
+				e.Value.(*streamedColumn).pins--
+			}
+			s.evict()
+		})
+	}
+	return release, st
+}
+
+// column returns the loaded column at loc, loading it if load is true, or nil
+// and the status of the failure if the column has no tile. It must be called
+// with s.mu held, which is released while the column loads.
+func (s *TileStreamer) column(loc tileColumn, load bool) (*list.Element, Status) {
+	for {
+		if e, ok := s.columns[loc]; ok {
+			return e, Success
+		}
+		if _, ok := s.empty[loc]; ok || !load {
+			return nil, Success
+		}
+		if st, ok := s.failed[loc]; ok {
+			return nil, st
+		}
+		if done, ok := s.busy[loc]; ok {
+			// Another goroutine loads or unloads the column.
+			s.mu.Unlock()
+			<-done
+			s.mu.Lock()
+			continue
+		}
+
+		done := make(chan struct{})
+		s.busy[loc] = done
+		s.mu.Unlock()
+		col, st := s.loadColumn(loc)
+		s.mu.Lock()
+		delete(s.busy, loc)
+		close(done)
+
+		switch {
+		case len(col.refs) != 0:
+			s.resident += len(col.refs)
+			s.columns[loc] = s.lru.PushFront(col)
+		case StatusFailed(st):
+			s.failed[loc] = st
+		default:
+			s.empty[loc] = struct{}{}
+		}
+		if StatusFailed(st) {
+			// The failure of a partially loaded column is only reported
+			// to the acquisition loading it.
+			if e := s.columns[loc]; e != nil {
+				return e, st
+			}
+			return nil, st
+		}
+	}
+}
+
+// AcquireAround loads and pins the tiles overlapping the box of specified
+// center and half extents.
+func (s *TileStreamer) AcquireAround(center, halfExtents d3.Vec3) (release func(), st Status) {
+	return s.Acquire(center.Sub(halfExtents), center.Add(halfExtents))
+}
+
+// Query loads and pins the tiles overlapping the specified box, then calls
+// query, during which tiles are neither added to nor removed from the
+// navmesh.
+//
+//  Arguments:
+//   bmin     The minimum bounds of the box. [(x, y, z)]
+//   bmax     The maximum bounds of the box. [(x, y, z)]
+//   query    The function running the queries on the streamed navmesh.
+//
+// Returns the status flags of the tiles loading, as Acquire. query is called
+// anyway.
+//
+// Query allows to run several queries on the same tiles, such as FindPath
+// then FindStraightPath on its corridor, which could otherwise be unloaded
+// between both queries. query must not call the TileStreamer methods.
+func (s *TileStreamer) Query(bmin, bmax d3.Vec3, query func()) Status {
+	release, st := s.acquire(bmin, bmax, true)
+	defer release()
+	s.meshMu.RLock()
+	defer s.meshMu.RUnlock()
+	query()
+	return st
+}
+
+// FindNearestPoly loads the tiles around center and finds the polygon
+// nearest to it.
+//
+// See NavMeshQuery.FindNearestPoly. q must be a query of the streamed
+// navmesh. In resident only mode, the tiles are not loaded and only the
+// loaded tiles are searched. (See SetNearestPolyResidentOnly)
+func (s *TileStreamer) FindNearestPoly(q *NavMeshQuery, center, halfExtents d3.Vec3,
+	filter QueryFilter) (st Status, ref PolyRef, pt d3.Vec3) {
+
+	if len(center) != 3 || len(halfExtents) != 3 {
+		return Failure | InvalidParam, 0, nil
+	}
+	s.mu.Lock()
+	residentOnly := s.residentOnly
+	s.mu.Unlock()
+	release, _ := s.acquire(center.Sub(halfExtents), center.Add(halfExtents), !residentOnly)
+	defer release()
+	s.meshMu.RLock()
+	defer s.meshMu.RUnlock()
+	return q.FindNearestPoly(center, halfExtents, filter)
+}
+
+// FindPath loads the tiles between startPos and endPos and finds a path from
+// the start polygon to the end polygon.
+//
+// See NavMeshQuery.FindPath. q must be a query of the streamed navmesh. Only
+// the tiles overlapping the box of startPos and endPos, expanded by the path
+// margin, are loaded. (See SetPathMargin)
+//
+// startRef and endRef are usually found with FindNearestPoly. If their tiles
+// have been unloaded since, they are no longer valid and FindPath fails.
+func (s *TileStreamer) FindPath(q *NavMeshQuery,
+	startRef, endRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	path []PolyRef) (pathCount int, st Status) {
+
+	if len(startPos) != 3 || len(endPos) != 3 {
+		return 0, Failure | InvalidParam
+	}
+	bmin, bmax := s.pathBox(startPos, endPos)
+	s.Query(bmin, bmax, func() {
+		pathCount, st = q.FindPath(startRef, endRef, startPos, endPos, filter, path)
+	})
+	return pathCount, st
+}
+
+// FindStraightPath loads the tiles between startPos and endPos and finds the
+// straight path within the polygon corridor.
+//
+// See NavMeshQuery.FindStraightPath. q must be a query of the streamed
+// navmesh. The tiles are loaded as for FindPath.
+//
+// If the tiles of path have been unloaded since path has been found, its
+// polygon references are no longer valid. Use Query to run FindPath and
+// FindStraightPath on the same pinned tiles.
+func (s *TileStreamer) FindStraightPath(q *NavMeshQuery,
+	startPos, endPos d3.Vec3,
+	path []PolyRef,
+	straightPath []d3.Vec3,
+	straightPathFlags []uint8,
+	straightPathRefs []PolyRef,
+	options int32) (straightPathCount int, st Status) {
+
+	if len(startPos) != 3 || len(endPos) != 3 {
+		return 0, Failure | InvalidParam
+	}
+	bmin, bmax := s.pathBox(startPos, endPos)
+	s.Query(bmin, bmax, func() {
+		straightPathCount, st = q.FindStraightPath(startPos, endPos, path,
+			straightPath, straightPathFlags, straightPathRefs, options)
+	})
+	return straightPathCount, st
+}
+
+// Raycast loads the tiles between startPos and endPos and casts a walkability
+// ray along the surface of the navmesh.
+//
+// See NavMeshQuery.Raycast. q must be a query of the streamed navmesh. The
+// tiles are loaded as for FindPath.
+func (s *TileStreamer) Raycast(q *NavMeshQuery,
+	startRef PolyRef,
+	startPos, endPos d3.Vec3,
+	filter QueryFilter,
+	options int,
+	hit *RaycastHit,
+	prevRef PolyRef) (st Status) {
+
+	if len(startPos) != 3 || len(endPos) != 3 {
+		return Failure | InvalidParam
+	}
+	bmin, bmax := s.pathBox(startPos, endPos)
+	s.Query(bmin, bmax, func() {
+		st = q.Raycast(startRef, startPos, endPos, filter, options, hit, prevRef)
+	})
+	return st
+}
+
+// pathBox returns the box of the tiles loaded for a path query from startPos
+// to endPos.
+func (s *TileStreamer) pathBox(startPos, endPos d3.Vec3) (bmin, bmax d3.Vec3) {
+	s.mu.Lock()
+	margin := s.pathMargin
+	s.mu.Unlock()
+	bmin, bmax = d3.NewVec3(), d3.NewVec3()
+	for i := range bmin {
+		bmin[i] = math32.Min(startPos[i], endPos[i]) - margin
+		bmax[i] = math32.Max(startPos[i], endPos[i]) + margin
+	}
+	return bmin, bmax
+}
+
+// loadColumn loads all the layers of a tile column, without s.mu held. The
+// column has no tile if the provider has none for it, or if all of them
+// failed to be added to the navmesh, in which case the status is failed.
+func (s *TileStreamer) loadColumn(loc tileColumn) (*streamedColumn, Status) {
+	st := Status(Success)
+	col := &streamedColumn{loc: loc}
+	for layer := int32(0); ; layer++ {
+		data, ok := s.provider.Load(loc.x, loc.y, layer)
+		if !ok {
+			break
+		}
+		s.meshMu.Lock()
+		ast, ref := s.mesh.AddTile(data, 0)
+		s.meshMu.Unlock()
+		if StatusFailed(ast) {
+			st = ast
+			continue
+		}
+		col.refs = append(col.refs, ref)
+	}
+	return col, st
+}
+
+// evict unloads the least recently used columns that are not pinned, until
+// the number of loaded tiles fits in the budget. It must be called with s.mu
+// held, which it releases: the tiles are removed from the navmesh without
+// it, their columns being marked busy meanwhile.
+func (s *TileStreamer) evict() {
+	var victims []*streamedColumn
+	if s.budget > 0 {
+		for e := s.lru.Back(); e != nil && s.resident > s.budget; {
+			prev := e.Prev()
+			col := e.Value.(*streamedColumn)
+			if col.pins == 0 {
+				s.resident -= len(col.refs)
+				s.lru.Remove(e)
+				delete(s.columns, col.loc)
+				victims = append(victims, col)
+			}
+			e = prev
+		}
+	}
+	if len(victims) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	for _, col := range victims {
+		s.busy[col.loc] = done
+	}
+	s.mu.Unlock()
+
+	s.meshMu.Lock()
+	for _, col := range victims {
+		for _, ref := range col.refs {
+			s.mesh.RemoveTile(ref)
+		}
+	}
+	s.meshMu.Unlock()
+
+	s.mu.Lock()
+	for _, col := range victims {
+		delete(s.busy, col.loc)
+	}
+	s.mu.Unlock()
+	close(done)
+}
@@ -0,0 +1,407 @@
+package detour
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arl/gogeo/f32/d3"
+)
+
+// meshTileProvider provides the tiles of a navmesh.
+type meshTileProvider struct {
+	tiles map[[3]int32][]byte
+
+	mu    sync.Mutex
+	loads int
+}
+
+func newMeshTileProvider(m *NavMesh) *meshTileProvider {
+	p := &meshTileProvider{tiles: make(map[[3]int32][]byte)}
+	for i := range m.Tiles {
+		tile := &m.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		p.tiles[[3]int32{tile.Header.X, tile.Header.Y, tile.Header.Layer}] = tileData(tile)
+	}
+	return p
+}
+
+func (p *meshTileProvider) Load(x, y, layer int32) ([]byte, bool) {
+	data, ok := p.tiles[[3]int32{x, y, layer}]
+	if ok {
+		p.mu.Lock()
+		p.loads++
+		p.mu.Unlock()
+	}
+	return data, ok
+}
+
+// blockingTileProvider is a meshTileProvider which Load blocks for the first
+// layer of a column, until unblocked.
+type blockingTileProvider struct {
+	*meshTileProvider
+	x, y    int32
+	loading chan struct{} // receives when Load blocks
+	unblock chan struct{}
+}
+
+func (p *blockingTileProvider) Load(x, y, layer int32) ([]byte, bool) {
+	if x == p.x && y == p.y && layer == 0 {
+		p.loading <- struct{}{}
+		<-p.unblock
+	}
+	return p.meshTileProvider.Load(x, y, layer)
+}
+
+func TestTileStreamer(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	provider := newMeshTileProvider(src)
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", st)
+	}
+	s := NewTileStreamer(&mesh, provider, 1)
+	st, q := NewNavMeshQuery(&mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	f := NewStandardQueryFilter()
+
+	// 2 points in different tiles
+	pt1, pt2 := d3.Vec3{5, 0, 10}, d3.Vec3{50, 0, 30}
+	ext1, ext2 := d3.Vec3{0, 1, 0}, d3.Vec3{1, 0, 1}
+
+	// tile indices, hence poly refs, differ from src, compare nearest points
+	_, srcq := NewNavMeshQuery(src, 100)
+	_, _, want := srcq.FindNearestPoly(pt1, ext1, f)
+	st, ref, pt := s.FindNearestPoly(q, pt1, ext1, f)
+	if StatusFailed(st) || ref == 0 {
+		t.Fatalf("FindNearestPoly got (0x%x, 0x%x), want a poly", uint32(st), ref)
+	}
+	if !pt.Approx(want) {
+		t.Errorf("FindNearestPoly got point %v, want %v", pt, want)
+	}
+	if s.Resident() != 1 || provider.loads != 1 {
+		t.Errorf("got %d resident tiles, %d loads, want 1, 1", s.Resident(), provider.loads)
+	}
+
+	loaded := func(pt d3.Vec3) bool {
+		tx, ty := mesh.CalcTileLoc(pt)
+		return mesh.TileAt(tx, ty, 0) != nil
+	}
+
+	// pin both tiles, the budget is exceeded
+	release1, st := s.AcquireAround(pt1, ext1)
+	if StatusFailed(st) {
+		t.Fatalf("Acquire failed with status 0x%x", uint32(st))
+	}
+	release2, st := s.AcquireAround(pt2, ext2)
+	if StatusFailed(st) {
+		t.Fatalf("Acquire failed with status 0x%x", uint32(st))
+	}
+	if s.Resident() != 2 || !loaded(pt1) || !loaded(pt2) {
+		t.Errorf("got %d resident tiles, want 2", s.Resident())
+	}
+	if st, ref, _ = q.FindNearestPoly(pt2, ext2, f); StatusFailed(st) || ref == 0 {
+		t.Fatalf("FindNearestPoly got (0x%x, 0x%x), want a poly", uint32(st), ref)
+	}
+
+	// releasing the first tile unloads it, the second is still pinned
+	release1()
+	if s.Resident() != 1 || loaded(pt1) || !loaded(pt2) {
+		t.Errorf("got %d resident tiles, want 1", s.Resident())
+	}
+	release2()
+	if s.Resident() != 1 || !loaded(pt2) {
+		t.Errorf("got %d resident tiles, want 1", s.Resident())
+	}
+
+	// querying again reloads it
+	if st, ref, _ = s.FindNearestPoly(q, pt1, ext1, f); StatusFailed(st) || ref == 0 {
+		t.Fatalf("FindNearestPoly got (0x%x, 0x%x), want a poly", uint32(st), ref)
+	}
+	if provider.loads != 3 {
+		t.Errorf("got %d loads, want 3", provider.loads)
+	}
+}
+
+func TestTileStreamerNearestPolyResidentOnly(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	provider := newMeshTileProvider(src)
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", st)
+	}
+	s := NewTileStreamer(&mesh, provider, 0)
+	st, q := NewNavMeshQuery(&mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	f := NewStandardQueryFilter()
+	if s.NearestPolyResidentOnly() {
+		t.Fatalf("streamer is resident only by default")
+	}
+
+	pt1, pt2 := d3.Vec3{5, 0, 10}, d3.Vec3{50, 0, 30}
+	ext := d3.Vec3{1, 1, 1}
+	release, st := s.AcquireAround(pt1, ext)
+	if StatusFailed(st) {
+		t.Fatalf("Acquire failed with status 0x%x", uint32(st))
+	}
+	release()
+	loads := provider.loads
+
+	s.SetNearestPolyResidentOnly(true)
+	if st, ref, _ := s.FindNearestPoly(q, pt1, ext, f); StatusFailed(st) || ref == 0 {
+		t.Errorf("FindNearestPoly in a loaded tile got (0x%x, 0x%x), want a poly", uint32(st), ref)
+	}
+	if st, ref, _ := s.FindNearestPoly(q, pt2, ext, f); StatusFailed(st) || ref != 0 {
+		t.Errorf("FindNearestPoly in a tile not loaded got (0x%x, 0x%x), want no poly", uint32(st), ref)
+	}
+	if provider.loads != loads || s.Resident() != loads {
+		t.Errorf("got %d loads, %d resident tiles, want %d, %d", provider.loads, s.Resident(), loads, loads)
+	}
+
+	// The default mode loads the tile.
+	s.SetNearestPolyResidentOnly(false)
+	if st, ref, _ := s.FindNearestPoly(q, pt2, ext, f); StatusFailed(st) || ref == 0 {
+		t.Errorf("FindNearestPoly got (0x%x, 0x%x), want a poly", uint32(st), ref)
+	}
+	if provider.loads == loads {
+		t.Errorf("FindNearestPoly did not load the tile")
+	}
+}
+
+func TestTileStreamerPathQueries(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	provider := newMeshTileProvider(src)
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", st)
+	}
+	s := NewTileStreamer(&mesh, provider, 0)
+	s.SetPathMargin(10)
+	if m := s.PathMargin(); m != 10 {
+		t.Fatalf("PathMargin() = %v, want 10", m)
+	}
+	st, q := NewNavMeshQuery(&mesh, 1000)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x\n", st)
+	}
+	_, srcq := NewNavMeshQuery(src, 1000)
+	f := NewStandardQueryFilter()
+
+	// the straight path between 2 points in different tiles
+	pt1, pt2 := d3.Vec3{5, 0, 10}, d3.Vec3{50, 0, 30}
+	ext := d3.Vec3{1, 1, 1}
+	newStraightPath := func() []d3.Vec3 {
+		straight := make([]d3.Vec3, 100)
+		for i := range straight {
+			straight[i] = d3.NewVec3()
+		}
+		return straight
+	}
+	flags, refs := make([]uint8, 100), make([]PolyRef, 100)
+
+	_, start, startPos := srcq.FindNearestPoly(pt1, ext, f)
+	_, end, endPos := srcq.FindNearestPoly(pt2, ext, f)
+	path := make([]PolyRef, 100)
+	n, _ := srcq.FindPath(start, end, startPos, endPos, f, path)
+	want := newStraightPath()
+	n, _ = srcq.FindStraightPath(startPos, endPos, path[:n], want, flags, refs, 0)
+	want = want[:n]
+
+	// the path queries load the tiles they need
+	_, start, startPos = s.FindNearestPoly(q, pt1, ext, f)
+	_, end, endPos = s.FindNearestPoly(q, pt2, ext, f)
+	n, st = s.FindPath(q, start, end, startPos, endPos, f, path)
+	if st != Success {
+		t.Fatalf("FindPath(0x%x, 0x%x) returned status 0x%x", start, end, uint32(st))
+	}
+	got := newStraightPath()
+	n, st = s.FindStraightPath(q, startPos, endPos, path[:n], got, flags, refs, 0)
+	if StatusFailed(st) {
+		t.Fatalf("FindStraightPath returned status 0x%x", uint32(st))
+	}
+	got = got[:n]
+	if len(got) != len(want) {
+		t.Fatalf("got straight path %v, want %v", got, want)
+	}
+	for i := range got {
+		if !got[i].Approx(want[i]) {
+			t.Errorf("got straight path point %d %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// the ray gets as far as it does on the whole navmesh
+	var wantHit, hit RaycastHit
+	_, ref, pos := srcq.FindNearestPoly(pt1, ext, f)
+	if st := srcq.Raycast(ref, pos, pt2, f, 0, &wantHit, 0); StatusFailed(st) {
+		t.Fatalf("Raycast returned status 0x%x", uint32(st))
+	}
+	_, ref, pos = s.FindNearestPoly(q, pt1, ext, f)
+	if st := s.Raycast(q, ref, pos, pt2, f, 0, &hit, 0); StatusFailed(st) || hit.T != wantHit.T {
+		t.Errorf("Raycast got t=%v, status 0x%x, want t=%v", hit.T, uint32(st), wantHit.T)
+	}
+}
+
+func TestTileStreamerConcurrentQueries(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	provider := newMeshTileProvider(src)
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", st)
+	}
+	// with a budget of 1 tile, the tiles are loaded and unloaded while the
+	// queries of the other goroutines run
+	s := NewTileStreamer(&mesh, provider, 1)
+	f := NewStandardQueryFilter()
+	pts := []d3.Vec3{{5, 0, 10}, {50, 0, 30}}
+	ext := d3.Vec3{1, 1, 1}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		st, q := NewNavMeshQuery(&mesh, 100)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x\n", st)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				pt := pts[(i+j)%len(pts)]
+				var st Status
+				var ref PolyRef
+				s.Query(pt.Sub(ext), pt.Add(ext), func() {
+					st, ref, _ = q.FindNearestPoly(pt, ext, f)
+					if ref != 0 && !mesh.IsValidPolyRef(ref) {
+						st = Failure
+					}
+				})
+				if StatusFailed(st) || ref == 0 {
+					errs <- fmt.Errorf("FindNearestPoly(%v) got (0x%x, 0x%x), want a poly", pt, uint32(st), ref)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if s.Resident() != 1 {
+		t.Errorf("got %d resident tiles, want 1", s.Resident())
+	}
+}
+
+func TestTileStreamerSlowLoad(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	pt1, pt2 := d3.Vec3{5, 0, 10}, d3.Vec3{50, 0, 30}
+	ext := d3.Vec3{1, 1, 1}
+	x, y := src.CalcTileLoc(pt2)
+	provider := &blockingTileProvider{
+		meshTileProvider: newMeshTileProvider(src),
+		x:                x,
+		y:                y,
+		loading:          make(chan struct{}),
+		unblock:          make(chan struct{}),
+	}
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", st)
+	}
+	s := NewTileStreamer(&mesh, provider, 0)
+	release, st := s.AcquireAround(pt1, ext)
+	if StatusFailed(st) {
+		t.Fatalf("Acquire failed with status 0x%x", uint32(st))
+	}
+	release()
+	loads := provider.loads
+	for k := range provider.tiles {
+		if k[0] == x && k[1] == y {
+			loads++
+		}
+	}
+
+	// while a column loads, the other goroutines can acquire the loaded
+	// ones, and wait for the loading one
+	loaded := make(chan struct{})
+	go func() {
+		release, _ := s.AcquireAround(pt2, ext)
+		release()
+		close(loaded)
+	}()
+	<-provider.loading
+	waiting := make(chan struct{})
+	go func() {
+		release, _ := s.AcquireAround(pt2, ext)
+		release()
+		close(waiting)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		release, _ := s.AcquireAround(pt1, ext)
+		release()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Acquire of a loaded tile blocked by the load of another one")
+	}
+	close(provider.unblock)
+	<-loaded
+	<-waiting
+	if provider.loads != loads {
+		t.Errorf("got %d loads, want %d", provider.loads, loads)
+	}
+}
+
+func TestTileStreamerFailedColumn(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	provider := newMeshTileProvider(src)
+	pt := d3.Vec3{5, 0, 10}
+	x, y := src.CalcTileLoc(pt)
+	bad := 0
+	for k := range provider.tiles {
+		if k[0] == x && k[1] == y {
+			provider.tiles[k] = []byte("not a tile")
+			bad++
+		}
+	}
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", st)
+	}
+	s := NewTileStreamer(&mesh, provider, 0)
+
+	// the failure is remembered, the column is not requested again
+	for i := 0; i < 2; i++ {
+		release, st := s.Acquire(pt, pt)
+		release()
+		if !StatusFailed(st) {
+			t.Errorf("Acquire %d of a bad tile got status 0x%x, want a failure", i, uint32(st))
+		}
+	}
+	if provider.loads != bad || s.Resident() != 0 {
+		t.Errorf("got %d loads, %d resident tiles, want %d, 0", provider.loads, s.Resident(), bad)
+	}
+}
@@ -111,6 +111,33 @@ func (s *MeshHeader) size() int {
 	return 100
 }
 
+// dataSize returns the size of the tile data described by the header,
+// including itself, as read by MeshTile.unserialize with links of linkSize
+// bytes. ok is false if the header has negative counts.
+func (s *MeshHeader) dataSize(linkSize int64) (size int64, ok bool) {
+	counts := []struct {
+		n    int32 // element count
+		size int64 // element size
+	}{
+		{s.VertCount, 3 * 4},
+		{s.PolyCount, 32},
+		{s.MaxLinkCount, linkSize},
+		{s.DetailMeshCount, 12},
+		{s.DetailVertCount, 3 * 4},
+		{s.DetailTriCount, 4},
+		{s.BvNodeCount, 16},
+		{s.OffMeshConCount, 36},
+	}
+	size = int64(s.size())
+	for _, c := range counts {
+		if c.n < 0 {
+			return 0, false
+		}
+		size += int64(c.n) * c.size
+	}
+	return size, true
+}
+
 func (s *MeshHeader) serialize(dst []byte) {
 	if len(dst) < s.size() {
 		panic("undersized buffer for MeshHeader")
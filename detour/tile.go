@@ -4,30 +4,39 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
 )
 
 // TileRef is a reference to a tile of the navigation mesh.
-type TileRef uint32
+type TileRef uint64
 
 type navMeshTileHeader struct {
 	TileRef  TileRef
 	DataSize int32
 }
 
-func (s *navMeshTileHeader) Size() int {
+// size returns the size of the serialized structure, for a navmesh using the
+// specified reference layout.
+func (s *navMeshTileHeader) size(layout PolyRefLayout) int {
+	if layout.is64() {
+		// 64 bits tile reference, then data size padded to 8 bytes.
+		return 16
+	}
 	return 8
 }
 
-func (s *navMeshTileHeader) WriteTo(w io.Writer) (int64, error) {
-	buf := make([]byte, s.Size())
-	s.Serialize(buf)
+func (s *navMeshTileHeader) writeTo(w io.Writer, layout PolyRefLayout) (int64, error) {
+	buf := make([]byte, s.size(layout))
+	s.serialize(buf, layout)
 
 	n, err := w.Write(buf)
 	return int64(n), err
 }
 
-func (s *navMeshTileHeader) Serialize(dst []byte) {
-	if len(dst) < s.Size() {
+func (s *navMeshTileHeader) serialize(dst []byte, layout PolyRefLayout) {
+	if len(dst) < s.size(layout) {
 		panic("undersized buffer for navMeshTileHeader")
 	}
 	var (
@@ -36,10 +45,27 @@ func (s *navMeshTileHeader) Serialize(dst []byte) {
 	)
 
 	// write each field as little endian
+	if layout.is64() {
+		little.PutUint64(dst[off:], uint64(s.TileRef))
+		little.PutUint32(dst[off+8:], uint32(s.DataSize))
+		little.PutUint32(dst[off+12:], 0)
+		return
+	}
 	little.PutUint32(dst[off:], uint32(s.TileRef))
 	little.PutUint32(dst[off+4:], uint32(s.DataSize))
 }
 
+func (s *navMeshTileHeader) unserialize(src []byte, layout PolyRefLayout) {
+	little := binary.LittleEndian
+	if layout.is64() {
+		s.TileRef = TileRef(little.Uint64(src))
+		s.DataSize = int32(little.Uint32(src[8:]))
+		return
+	}
+	s.TileRef = TileRef(little.Uint32(src))
+	s.DataSize = int32(little.Uint32(src[4:]))
+}
+
 // MeshTile defines a navigation mesh tile.
 type MeshTile struct {
 
@@ -95,11 +121,74 @@ type MeshTile struct {
 	Next *MeshTile
 }
 
-func (s *MeshTile) serialize(dst []byte) {
-	serializeTileData(dst, s.Verts, s.Polys, s.Links, s.DetailMeshes, s.DetailVerts, s.DetailTris, s.BvTree, s.OffMeshCons)
+// UserID returns the user defined id of the tile, as set with
+// NavMeshCreateParams.UserID, or 0 if the tile is not loaded.
+func (s *MeshTile) UserID() uint32 {
+	if s.Header == nil {
+		return 0
+	}
+	return s.Header.UserID
+}
+
+// bvNodeCount returns the number of nodes of the tile BV-tree to traverse.
+//
+// The tree of n polygons has 2n-1 nodes, but CreateNavMeshData, like Detour,
+// stores 2n nodes, the last one being a zeroed node which would be taken for
+// a leaf of the first polygon.
+func (s *MeshTile) bvNodeCount() int32 {
+	n := s.Header.BvNodeCount
+	if used := 2*s.Header.OffMeshBase - 1; used > 0 && n > used {
+		n = used
+	}
+	return n
+}
+
+// polyBounds computes, into bmin and bmax, the bounds of the polygon at index
+// ip in the tile, including its detail mesh.
+func (s *MeshTile) polyBounds(ip int32, bmin, bmax d3.Vec3) {
+	p := &s.Polys[ip]
+	vidx := p.Verts[0] * 3
+	bmin.Assign(s.Verts[vidx : vidx+3])
+	bmax.Assign(s.Verts[vidx : vidx+3])
+	for j := uint8(1); j < p.VertCount; j++ {
+		vidx = p.Verts[j] * 3
+		d3.Vec3Min(bmin, s.Verts[vidx:vidx+3])
+		d3.Vec3Max(bmax, s.Verts[vidx:vidx+3])
+	}
+
+	if int(ip) >= len(s.DetailMeshes) {
+		return
+	}
+	pd := &s.DetailMeshes[ip]
+	for j := uint32(0); j < uint32(pd.VertCount); j++ {
+		vidx := (pd.VertBase + j) * 3
+		d3.Vec3Min(bmin, s.DetailVerts[vidx:vidx+3])
+		d3.Vec3Max(bmax, s.DetailVerts[vidx:vidx+3])
+	}
+}
+
+// quantPolyBounds returns the bounds of the polygon at index ip in the tile,
+// quantized like the BV-tree nodes, which are at most as large. bmin and bmax
+// receive the polygon bounds. (See polyBounds)
+func (s *MeshTile) quantPolyBounds(ip int32, bmin, bmax d3.Vec3) (qmin, qmax [3]uint16) {
+	s.polyBounds(ip, bmin, bmax)
+	qfac := s.Header.BvQuantFactor
+	for k := 0; k < 3; k++ {
+		qmin[k] = uint16(int32Clamp(int32((bmin[k]-s.Header.BMin[k])*qfac), 0, 0xffff))
+		qmax[k] = uint16(int32Clamp(int32(math32.Ceil((bmax[k]-s.Header.BMin[k])*qfac)), 0, 0xffff))
+	}
+	return qmin, qmax
+}
+
+// serialize encodes the tile data, without the header, into dst, with links
+// of linkSize bytes. (See PolyRefLayout)
+func (s *MeshTile) serialize(dst []byte, linkSize int) {
+	serializeTileData(dst, linkSize, s.Verts, s.Polys, s.Links, s.DetailMeshes, s.DetailVerts, s.DetailTris, s.BvTree, s.OffMeshCons)
 }
 
-func (s *MeshTile) unserialize(hdr *MeshHeader, src []byte) {
+// unserialize decodes the tile data, without the header, from src, with
+// links of linkSize bytes. (See PolyRefLayout)
+func (s *MeshTile) unserialize(hdr *MeshHeader, src []byte, linkSize int) {
 	var (
 		little = binary.LittleEndian
 		i, off int
@@ -135,14 +224,21 @@ func (s *MeshTile) unserialize(hdr *MeshHeader, src []byte) {
 	for i := range s.Links {
 		l := &s.Links[i]
 
-		l.Ref = PolyRef(little.Uint64(src[off:]))
-		l.Next = little.Uint32(src[off+4+4:])
+		o := off
+		if linkSize == link64Size {
+			l.Ref = PolyRef(little.Uint64(src[o:]))
+			o += 8
+		} else {
+			l.Ref = PolyRef(little.Uint32(src[o:]))
+			o += 4
+		}
+		l.Next = little.Uint32(src[o:])
 
-		l.Edge = src[off+4+8]
-		l.Side = src[off+4+9]
-		l.BMin = src[off+4+10]
-		l.BMax = src[off+4+11]
-		off += 12 + 4
+		l.Edge = src[o+4]
+		l.Side = src[o+5]
+		l.BMin = src[o+6]
+		l.BMax = src[o+7]
+		off += linkSize
 	}
 
 	s.DetailMeshes = make([]PolyDetail, hdr.DetailMeshCount)
@@ -188,14 +284,15 @@ func (s *MeshTile) unserialize(hdr *MeshHeader, src []byte) {
 		o.Pos[5] = math.Float32frombits(little.Uint32(src[off+20:]))
 		o.Rad = math.Float32frombits(little.Uint32(src[off+24:]))
 		o.Poly = little.Uint16(src[off+28:])
-		o.Flags = src[30]
-		o.Side = src[31]
+		o.Flags = src[off+30]
+		o.Side = src[off+31]
 		o.UserID = little.Uint32(src[off+32:])
 		off += 36
 	}
 }
 
 func serializeTileData(dst []byte,
+	linkSize int,
 	verts []float32,
 	polys []Poly,
 	links []Link,
@@ -236,14 +333,21 @@ func serializeTileData(dst []byte,
 	for i := range links {
 		l := &links[i]
 
-		little.PutUint32(dst[off:], uint32(l.Ref))
-		little.PutUint32(dst[off+4:], l.Next)
+		o := off
+		if linkSize == link64Size {
+			little.PutUint64(dst[o:], uint64(l.Ref))
+			o += 8
+		} else {
+			little.PutUint32(dst[o:], uint32(l.Ref))
+			o += 4
+		}
+		little.PutUint32(dst[o:], l.Next)
 
-		dst[off+8] = l.Edge
-		dst[off+9] = l.Side
-		dst[off+10] = l.BMin
-		dst[off+11] = l.BMax
-		off += 12
+		dst[o+4] = l.Edge
+		dst[o+5] = l.Side
+		dst[o+6] = l.BMin
+		dst[o+7] = l.BMax
+		off += linkSize
 	}
 
 	for i := range dmeshes {
@@ -283,8 +387,8 @@ func serializeTileData(dst []byte,
 		little.PutUint32(dst[off+20:], uint32(math.Float32bits(o.Pos[5])))
 		little.PutUint32(dst[off+24:], uint32(math.Float32bits(o.Rad)))
 		little.PutUint16(dst[off+28:], o.Poly)
-		dst[30] = o.Flags
-		dst[31] = o.Side
+		dst[off+30] = o.Flags
+		dst[off+31] = o.Side
 		little.PutUint32(dst[off+32:], o.UserID)
 		off += 36
 	}
@@ -1,9 +1,17 @@
 package detour
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
+	"unsafe"
 
 	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
 )
 
 func TestFindNearestPolyInTile(t *testing.T) {
@@ -20,7 +28,7 @@ func TestFindNearestPolyInTile(t *testing.T) {
 		{
 			d3.Vec3{5, 0, 10},
 			d3.Vec3{0, 1, 0},
-			0x440004,
+			0x440000,
 		},
 		{
 			d3.Vec3{50, 0, 30},
@@ -48,3 +56,1133 @@ func TestFindNearestPolyInTile(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckPolyRef(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	tx, ty := mesh.CalcTileLoc(d3.Vec3{5, 0, 10})
+	tile := mesh.TileAt(tx, ty, 0)
+	if tile == nil {
+		t.Fatalf("no tile at test location")
+	}
+	ref := mesh.polyRefBase(tile)
+
+	if err := mesh.CheckPolyRef(ref); err != nil {
+		t.Errorf("CheckPolyRef(0x%x) = %v, want nil", ref, err)
+	}
+	if err := mesh.CheckPolyRef(0); err != ErrInvalidPolyRef {
+		t.Errorf("CheckPolyRef(0) = %v, want %v", err, ErrInvalidPolyRef)
+	}
+	if err := mesh.CheckPolyRef(ref | PolyRef(tile.Header.PolyCount)); err != ErrInvalidPolyRef {
+		t.Errorf("CheckPolyRef out of tile range = %v, want %v", err, ErrInvalidPolyRef)
+	}
+
+	if _, st := mesh.RemoveTile(mesh.TileRef(tile)); StatusFailed(st) {
+		t.Fatalf("RemoveTile failed with status 0x%x", st)
+	}
+	if err := mesh.CheckPolyRef(ref); err != ErrTileNotLoaded {
+		t.Errorf("CheckPolyRef of removed tile = %v, want %v", err, ErrTileNotLoaded)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, fname := range []string{"mesh1.bin", "mesh2.bin", "offmeshcons.bin"} {
+		mesh, err := loadTestNavMesh(fname)
+		checkt(t, err)
+		if errs := mesh.Validate(); len(errs) != 0 {
+			t.Errorf("%s, got %d problems, want none, first is: %v", fname, len(errs), errs[0])
+		}
+	}
+
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	tile := mesh.TileAt(0, 1, 0)
+	if tile == nil {
+		t.Fatalf("no tile at (0,1,0)")
+	}
+
+	// malform the tile
+	tile.Polys[0].Verts[0] = uint16(tile.Header.VertCount)
+	tile.Links[tile.Polys[1].FirstLink].Next = tile.Polys[1].FirstLink
+	tile.BvTree[0].I = tile.Header.PolyCount
+
+	if errs := mesh.Validate(); len(errs) != 3 {
+		t.Errorf("got %d problems, want 3: %v", len(errs), errs)
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	var (
+		ntiles, npolys int
+		linkBytes      int64
+	)
+	for i := range mesh.Tiles {
+		tile := &mesh.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		ntiles++
+		npolys += len(tile.Polys)
+		linkBytes += int64(cap(tile.Links)) * int64(unsafe.Sizeof(Link{}))
+	}
+
+	s := mesh.MemoryStats()
+	if s.TileCount != ntiles || s.PolyCount != npolys {
+		t.Errorf("got %d tiles and %d polys, want %d and %d", s.TileCount, s.PolyCount, ntiles, npolys)
+	}
+	if s.LinkBytes != linkBytes {
+		t.Errorf("got LinkBytes = %d, want %d", s.LinkBytes, linkBytes)
+	}
+	if s.TileBytes == 0 || s.BvTreeBytes == 0 || s.DetailBytes == 0 || s.MeshBytes == 0 {
+		t.Errorf("got zero byte counts: %+v", s)
+	}
+
+	tile := mesh.TileAt(0, 1, 0)
+	if tile == nil {
+		t.Fatalf("no tile at (0,1,0)")
+	}
+	npolys = len(tile.Polys)
+	if _, st := mesh.RemoveTile(mesh.TileRef(tile)); StatusFailed(st) {
+		t.Fatalf("RemoveTile failed with status 0x%x", uint32(st))
+	}
+	after := mesh.MemoryStats()
+	if after.TileCount != s.TileCount-1 || after.PolyCount != s.PolyCount-npolys {
+		t.Errorf("after RemoveTile, got %d tiles and %d polys, want %d and %d",
+			after.TileCount, after.PolyCount, s.TileCount-1, s.PolyCount-npolys)
+	}
+	if after.TotalBytes() >= s.TotalBytes() || after.MeshBytes != s.MeshBytes {
+		t.Errorf("after RemoveTile, got %+v, before %+v", after, s)
+	}
+}
+
+func TestMemoryFlags(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", uint32(st))
+	}
+	mesh.SetMemoryFlags(MemoryNoBvTree | MemoryCompactLinks)
+	if added, st := mesh.MergeTilesFrom(src); StatusFailed(st) || added == 0 {
+		t.Fatalf("MergeTilesFrom added %d tiles, status 0x%x", added, uint32(st))
+	}
+	if errs := mesh.Validate(); len(errs) != 0 {
+		t.Fatalf("compact navmesh has problems: %v", errs)
+	}
+
+	full, compact := src.MemoryStats(), mesh.MemoryStats()
+	if compact.TileCount != full.TileCount || compact.PolyCount != full.PolyCount {
+		t.Fatalf("got %d tiles and %d polys, want %d and %d", compact.TileCount, compact.PolyCount, full.TileCount, full.PolyCount)
+	}
+	if compact.BvTreeBytes != 0 || full.BvTreeBytes == 0 {
+		t.Errorf("got BvTreeBytes = %d, want 0, from %d", compact.BvTreeBytes, full.BvTreeBytes)
+	}
+	if compact.LinkBytes >= full.LinkBytes {
+		t.Errorf("got LinkBytes = %d, want less than %d", compact.LinkBytes, full.LinkBytes)
+	}
+	t.Logf("full %d bytes, compact %d bytes", full.TotalBytes(), compact.TotalBytes())
+
+	// The queries give the same results, with the tile refs of each mesh.
+	_, srcq := NewNavMeshQuery(src, 2048)
+	_, q := NewNavMeshQuery(&mesh, 2048)
+	filter := NewStandardQueryFilter()
+	ext := d3.Vec3{2, 4, 2}
+	pairs := [][2]d3.Vec3{
+		{{5, 0, 5}, {30, 0, 20}},
+		{{5, 0, 10}, {50, 0, 30}},
+	}
+	for _, p := range pairs {
+		var corners [2][]d3.Vec3
+		for k, q := range []*NavMeshQuery{srcq, q} {
+			_, orgRef, org := q.FindNearestPoly(p[0], ext, filter)
+			_, dstRef, dst := q.FindNearestPoly(p[1], ext, filter)
+			path := make([]PolyRef, 256)
+			npath, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+			if StatusFailed(st) || npath == 0 {
+				t.Fatalf("FindPath from %v to %v failed with status 0x%x", p[0], p[1], uint32(st))
+			}
+			straight := make([]d3.Vec3, 256)
+			for i := range straight {
+				straight[i] = d3.NewVec3()
+			}
+			n, _ := q.FindStraightPath(org, dst, path[:npath], straight, nil, nil, 0)
+			corners[k] = straight[:n]
+		}
+		if len(corners[0]) != len(corners[1]) {
+			t.Errorf("path from %v to %v has %d corners on the compact mesh, want %d", p[0], p[1], len(corners[1]), len(corners[0]))
+			continue
+		}
+		for i := range corners[0] {
+			if !corners[0][i].Approx(corners[1][i]) {
+				t.Errorf("path from %v to %v, corner %d = %v, want %v", p[0], p[1], i, corners[1][i], corners[0][i])
+			}
+		}
+	}
+
+	// A removed tile is added back with compacted links.
+	tile := mesh.TileAt(0, 1, 0)
+	links := len(tile.Links)
+	data := mesh.tileData(tile)
+	if _, st := mesh.RemoveTile(mesh.TileRef(tile)); StatusFailed(st) {
+		t.Fatalf("RemoveTile failed with status 0x%x", uint32(st))
+	}
+	if st, _ := mesh.AddTile(data, 0); StatusFailed(st) {
+		t.Fatalf("AddTile failed with status 0x%x", uint32(st))
+	}
+	if tile := mesh.TileAt(0, 1, 0); len(tile.Links) != links || tile.LinksFreeList != nullLink {
+		t.Errorf("re-added tile has %d links, free list %d, want %d and none", len(tile.Links), tile.LinksFreeList, links)
+	}
+	if errs := mesh.Validate(); len(errs) != 0 {
+		t.Errorf("compact navmesh has problems after reloading a tile: %v", errs)
+	}
+
+	// The options apply to the loaded tiles too.
+	loaded, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	loaded.SetMemoryFlags(MemoryNoBvTree | MemoryCompactLinks)
+	if s := loaded.MemoryStats(); s.BvTreeBytes != 0 || s.LinkBytes != compact.LinkBytes {
+		t.Errorf("got %d BV-tree bytes, %d link bytes, want 0, %d", s.BvTreeBytes, s.LinkBytes, compact.LinkBytes)
+	}
+	if errs := loaded.Validate(); len(errs) != 0 {
+		t.Errorf("compacted navmesh has problems: %v", errs)
+	}
+}
+
+func TestAreaHistogram(t *testing.T) {
+	params := gridParams(4)
+	for i := range params.PolyAreas {
+		params.PolyAreas[i] = uint8(i % 3)
+	}
+	data, err := CreateNavMeshData(params)
+	checkt(t, err)
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+
+	// 20 unit square polygons.
+	wantCount := map[uint8]int{0: 7, 1: 7, 2: 6}
+	if got := mesh.AreaHistogram(); !reflect.DeepEqual(got, wantCount) {
+		t.Errorf("AreaHistogram() = %v, want %v", got, wantCount)
+	}
+	surf := mesh.AreaSurfaceHistogram()
+	if len(surf) != len(wantCount) {
+		t.Fatalf("AreaSurfaceHistogram() = %v, want %d areas", surf, len(wantCount))
+	}
+	for area, n := range wantCount {
+		if !math32.Approx(surf[area], float32(n)) {
+			t.Errorf("AreaSurfaceHistogram()[%d] = %f, want %d", area, surf[area], n)
+		}
+	}
+
+	// Off-mesh connections are not counted.
+	omesh, err := loadTestNavMesh("offmeshcons.bin")
+	checkt(t, err)
+	var npolys, noffmesh int
+	for i := range omesh.Tiles {
+		if hdr := omesh.Tiles[i].Header; hdr != nil {
+			npolys += int(hdr.PolyCount)
+			noffmesh += int(hdr.OffMeshConCount)
+		}
+	}
+	var total int
+	for _, n := range omesh.AreaHistogram() {
+		total += n
+	}
+	if noffmesh == 0 || total != npolys-noffmesh {
+		t.Errorf("AreaHistogram counts %d polygons, want %d ground polygons", total, npolys-noffmesh)
+	}
+}
+
+func TestMarkPolyAreaRegion(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	const water = 7
+
+	// The box straddles the border of tiles 9.6 units wide.
+	bmin, bmax := d3.Vec3{8, -5, 8}, d3.Vec3{11, 5, 11}
+	center, ext := d3.Vec3{9.5, 0, 9.5}, d3.Vec3{1.5, 5, 1.5}
+	if ref, _, _ := q.FindNearestPolyOfArea(center, ext, water, filter); ref != 0 {
+		t.Fatalf("found poly 0x%x of area %d before marking", ref, water)
+	}
+
+	n := mesh.MarkPolyAreaRegion(bmin, bmax, water)
+	if n == 0 {
+		t.Fatalf("MarkPolyAreaRegion changed no polygons")
+	}
+	if got := mesh.AreaHistogram()[water]; got != n {
+		t.Errorf("%d polygons of area %d, want %d", got, water, n)
+	}
+	tiles := make(map[uint32]bool)
+	polys := make([]PolyRef, 64)
+	npolys, _ := q.queryPolygons6(center, ext, filter, polys, int32(len(polys)))
+	for _, ref := range polys[:npolys] {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		mesh.TileAndPolyByRef(ref, &tile, &poly)
+		if poly.Area() != water {
+			t.Errorf("poly 0x%x overlapping the box has area %d, want %d", ref, poly.Area(), water)
+		}
+		tiles[mesh.decodePolyIDTile(ref)] = true
+	}
+	if len(tiles) < 2 {
+		t.Errorf("marked polygons in %d tiles, want several", len(tiles))
+	}
+
+	// Queries see the new areas right away.
+	if ref, _, _ := q.FindNearestPolyOfArea(center, ext, water, filter); ref == 0 {
+		t.Errorf("found no poly of area %d after marking", water)
+	}
+	if got := mesh.MarkPolyAreaRegion(bmin, bmax, water); got != 0 {
+		t.Errorf("marking again changed %d polygons, want 0", got)
+	}
+	if got := mesh.MarkPolyAreaRegion(bmin, bmax, 64); got != 0 {
+		t.Errorf("marking with an invalid area changed %d polygons, want 0", got)
+	}
+
+	if st := mesh.SetPolyArea(polys[0], 3); st != Success {
+		t.Errorf("SetPolyArea returned status 0x%x", uint32(st))
+	}
+	if st := mesh.SetPolyArea(polys[0], 64); st != Failure|InvalidParam {
+		t.Errorf("SetPolyArea with an invalid area returned status 0x%x, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+	}
+	if st := mesh.SetPolyArea(0, 3); !StatusFailed(st) {
+		t.Errorf("SetPolyArea with an invalid ref returned status 0x%x", uint32(st))
+	}
+}
+
+func TestPolyHasFlags(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 100)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	st, ref, _ := q.FindNearestPoly(d3.Vec3{5, 0, 5}, d3.Vec3{1, 4, 1}, NewStandardQueryFilter())
+	if StatusFailed(st) || ref == 0 {
+		t.Fatalf("FindNearestPoly failed with status 0x%x, ref 0x%x", uint32(st), ref)
+	}
+	var (
+		tile *MeshTile
+		poly *Poly
+	)
+	mesh.TileAndPolyByRef(ref, &tile, &poly)
+	const (
+		walk = 0x01
+		door = 0x04
+		jump = 0x08
+	)
+	poly.Flags = walk | door
+
+	tests := []struct {
+		mask     uint16
+		all, any bool
+	}{
+		{door, true, true},
+		{walk | door, true, true},
+		{door | jump, false, true},
+		{jump, false, false},
+		{0, true, false},
+	}
+	for _, tt := range tests {
+		if got, st := mesh.PolyHasFlags(ref, tt.mask); StatusFailed(st) || got != tt.all {
+			t.Errorf("PolyHasFlags(0x%x) = %t, 0x%x, want %t", tt.mask, got, uint32(st), tt.all)
+		}
+		if got, st := mesh.PolyHasAnyFlags(ref, tt.mask); StatusFailed(st) || got != tt.any {
+			t.Errorf("PolyHasAnyFlags(0x%x) = %t, 0x%x, want %t", tt.mask, got, uint32(st), tt.any)
+		}
+	}
+
+	if got, st := mesh.PolyHasFlags(0, 0); got || !StatusFailed(st) {
+		t.Errorf("PolyHasFlags(0) = %t, 0x%x, want false and a failure", got, uint32(st))
+	}
+	if got, st := mesh.PolyHasAnyFlags(0, door); got || !StatusFailed(st) {
+		t.Errorf("PolyHasAnyFlags(0) = %t, 0x%x, want false and a failure", got, uint32(st))
+	}
+}
+
+// tileData returns the serialized data of a tile, as accepted by AddTile.
+func tileData(tile *MeshTile) []byte {
+	size, _ := tile.Header.dataSize(link32Size)
+	data := make([]byte, size)
+	tile.Header.serialize(data)
+	tile.serialize(data[tile.Header.size():], link32Size)
+	return data
+}
+
+func TestAddTileCorruptData(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	srcTile := src.TileAt(0, 1, 0)
+	if srcTile == nil {
+		t.Fatalf("no tile at (0,1,0)")
+	}
+	valid := tileData(srcTile)
+
+	// corrupt returns a copy of the valid tile data, modified by f.
+	corrupt := func(f func(hdr *MeshHeader, data []byte) []byte) []byte {
+		data := append([]byte(nil), valid...)
+		hdr := *srcTile.Header
+		data = f(&hdr, data)
+		if len(data) >= hdr.size() {
+			hdr.serialize(data)
+		}
+		return data
+	}
+	// vertex index of the first poly
+	polyOff := srcTile.Header.size() + int(srcTile.Header.VertCount)*3*4 + 4
+
+	addTileTests := []struct {
+		msg  string
+		data []byte
+		want Status
+	}{
+		{"nil data", nil, Failure | InvalidParam},
+		{"truncated header", valid[:50], Failure | InvalidParam},
+		{"wrong magic", corrupt(func(hdr *MeshHeader, data []byte) []byte {
+			hdr.Magic++
+			return data
+		}), Failure | WrongMagic},
+		{"wrong version", corrupt(func(hdr *MeshHeader, data []byte) []byte {
+			hdr.Version++
+			return data
+		}), Failure | WrongVersion},
+		{"truncated data", valid[:len(valid)-1], Failure | InvalidParam},
+		{"negative count", corrupt(func(hdr *MeshHeader, data []byte) []byte {
+			hdr.PolyCount = -1
+			return data
+		}), Failure | InvalidParam},
+		{"huge count", corrupt(func(hdr *MeshHeader, data []byte) []byte {
+			hdr.DetailTriCount = 1 << 30
+			return data
+		}), Failure | InvalidParam},
+		{"vertex out of range", corrupt(func(hdr *MeshHeader, data []byte) []byte {
+			data[polyOff] = 0xff
+			data[polyOff+1] = 0xff
+			return data
+		}), Failure | InvalidParam},
+		{"valid data", valid, Success},
+	}
+
+	for _, tt := range addTileTests {
+		var mesh NavMesh
+		if st := mesh.Init(&src.Params); StatusFailed(st) {
+			t.Fatalf("Init failed with status 0x%x", uint32(st))
+		}
+		if st, _ := mesh.AddTile(tt.data, 0); st != tt.want {
+			t.Errorf("%s, AddTile returned status 0x%x, want 0x%x", tt.msg, uint32(st), uint32(tt.want))
+		}
+	}
+}
+
+func TestPolyRefLayoutRoundTrip(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	if l := src.PolyRefLayout(); l != PolyRefLayout32 {
+		t.Fatalf("testdata layout detected as %v, want %v", l, PolyRefLayout32)
+	}
+
+	dir, err := ioutil.TempDir("", "detour")
+	checkt(t, err)
+	defer os.RemoveAll(dir)
+
+	layouts := []PolyRefLayout{PolyRefLayout32, PolyRefLayout64, PolyRefLayoutTrinityCore}
+	for _, layout := range layouts {
+		var mesh NavMesh
+		if st := mesh.InitWithLayout(&src.Params, layout); StatusFailed(st) {
+			t.Fatalf("%v: InitWithLayout failed with 0x%x", layout, uint32(st))
+		}
+		var ntiles int
+		for i := range src.Tiles {
+			tile := &src.Tiles[i]
+			if tile.Header == nil {
+				continue
+			}
+			if st, _ := mesh.AddTile(tileData(tile), 0); StatusFailed(st) {
+				t.Fatalf("%v: AddTile failed with 0x%x", layout, uint32(st))
+			}
+			ntiles++
+		}
+
+		fname := filepath.Join(dir, fmt.Sprintf("mesh%d.bin", layout))
+		checkt(t, mesh.SaveToFile(fname))
+		f, err := os.Open(fname)
+		checkt(t, err)
+		got, err := Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%v: Decode failed: %v", layout, err)
+		}
+
+		if l := got.PolyRefLayout(); l != layout {
+			t.Errorf("%v: decoded layout is %v", layout, l)
+		}
+		if errs := got.Validate(); len(errs) != 0 {
+			t.Errorf("%v: decoded navmesh has problems: %v", layout, errs)
+		}
+		// Same tiles, at the same references, with the same polygons.
+		var n int
+		for i := range mesh.Tiles {
+			tile := &mesh.Tiles[i]
+			if tile.Header == nil {
+				continue
+			}
+			n++
+			h := tile.Header
+			gtile := got.TileAt(h.X, h.Y, h.Layer)
+			if gtile == nil {
+				t.Errorf("%v: no tile at (%d,%d,%d)", layout, h.X, h.Y, h.Layer)
+				continue
+			}
+			if ref, gref := mesh.TileRef(tile), got.TileRef(gtile); ref != gref {
+				t.Errorf("%v: tile ref is 0x%x, want 0x%x", layout, gref, ref)
+			}
+			if !reflect.DeepEqual(gtile.Polys, tile.Polys) {
+				t.Errorf("%v: tile (%d,%d,%d) polygons differ", layout, h.X, h.Y, h.Layer)
+			}
+		}
+		if n != ntiles {
+			t.Errorf("%v: %d tiles, want %d", layout, n, ntiles)
+		}
+
+		// References decode to the right polygons.
+		pt := d3.Vec3{50, 0, 30}
+		sref := src.FindNearestPolyInTile(src.TileAt(0, 1, 0), pt, d3.Vec3{1, 0, 1}, d3.NewVec3())
+		gref := got.FindNearestPolyInTile(got.TileAt(0, 1, 0), pt, d3.Vec3{1, 0, 1}, d3.NewVec3())
+		var (
+			stile, gtile *MeshTile
+			spoly, gpoly *Poly
+		)
+		src.TileAndPolyByRef(sref, &stile, &spoly)
+		if st := got.TileAndPolyByRef(gref, &gtile, &gpoly); StatusFailed(st) || !reflect.DeepEqual(*spoly, *gpoly) {
+			t.Errorf("%v: nearest poly 0x%x doesn't match source poly 0x%x", layout, gref, sref)
+		}
+	}
+	// Too many tiles and polygons for 32 bits references.
+	params := NavMeshParams{MaxTiles: 1 << 12, MaxPolys: 1 << 12}
+	var mesh NavMesh
+	if st := mesh.InitWithLayout(&params, PolyRefLayout32); st != Failure|InvalidParam {
+		t.Errorf("InitWithLayout(32 bits) with too many tiles returned 0x%x, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+	}
+	if st := mesh.InitWithLayout(&params, PolyRefLayout64); StatusFailed(st) {
+		t.Errorf("InitWithLayout(64 bits) failed with 0x%x", uint32(st))
+	}
+	// Init keeps the TrinityCore layout, which holds the polygon counts of
+	// the WoW maps.
+	params.MaxPolys = 1 << 31
+	if st := mesh.Init(&params); StatusFailed(st) || mesh.refLayout != PolyRefLayoutTrinityCore {
+		t.Errorf("Init returned 0x%x, layout %v, want the TrinityCore layout", uint32(st), mesh.refLayout)
+	}
+}
+
+func TestTileUserID(t *testing.T) {
+	params := slopedEdgeParams()
+	params.UserID = 0xc0ffee
+	data, err := CreateNavMeshData(params)
+	checkt(t, err)
+
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+
+	dir, err := ioutil.TempDir("", "detour")
+	checkt(t, err)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "userid.bin")
+	checkt(t, mesh.SaveToFile(fname))
+	f, err := os.Open(fname)
+	checkt(t, err)
+	defer f.Close()
+	loaded, err := Decode(f)
+	checkt(t, err)
+
+	for _, m := range []*NavMesh{&mesh, loaded} {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		ref := m.polyRefBase(&m.Tiles[0])
+		if st := m.TileAndPolyByRef(ref, &tile, &poly); StatusFailed(st) {
+			t.Fatalf("TileAndPolyByRef failed with status 0x%x", uint32(st))
+		}
+		if id := tile.UserID(); id != params.UserID {
+			t.Errorf("got tile user id 0x%x, want 0x%x", id, params.UserID)
+		}
+	}
+
+	if id := (&MeshTile{}).UserID(); id != 0 {
+		t.Errorf("got user id 0x%x for an empty tile, want 0", id)
+	}
+}
+
+func TestAddTileOrder(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	org := d3.Vec3{5, 0, 10}
+	dst := d3.Vec3{50, 0, 30}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+
+	// findPath returns the straight path from org to dst.
+	findPath := func(mesh *NavMesh) []d3.Vec3 {
+		st, q := NewNavMeshQuery(mesh, 2048)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		_, orgRef, orgPt := q.FindNearestPoly(org, extents, filter)
+		_, dstRef, dstPt := q.FindNearestPoly(dst, extents, filter)
+		if orgRef == 0 || dstRef == 0 {
+			t.Fatalf("start or end poly not found")
+		}
+		if mesh.decodePolyIDTile(orgRef) == mesh.decodePolyIDTile(dstRef) {
+			t.Fatalf("start and end polys are in the same tile")
+		}
+		path := make([]PolyRef, 256)
+		n, st := q.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+		if st != Success || path[n-1] != dstRef {
+			t.Fatalf("FindPath returned status 0x%x and didn't reach the end poly", uint32(st))
+		}
+		straight := make([]d3.Vec3, 256)
+		for i := range straight {
+			straight[i] = d3.NewVec3()
+		}
+		ns, st := q.FindStraightPath(orgPt, dstPt, path[:n], straight, nil, nil, 0)
+		if StatusFailed(st) {
+			t.Fatalf("FindStraightPath failed with status 0x%x", uint32(st))
+		}
+		return straight[:ns]
+	}
+	want := findPath(src)
+
+	var tiles []*MeshTile
+	for i := range src.Tiles {
+		if src.Tiles[i].Header != nil {
+			tiles = append(tiles, &src.Tiles[i])
+		}
+	}
+
+	orders := map[string]func(i, j int) bool{
+		"increasing x,y": func(i, j int) bool {
+			return tiles[i].Header.Y < tiles[j].Header.Y ||
+				tiles[i].Header.Y == tiles[j].Header.Y && tiles[i].Header.X < tiles[j].Header.X
+		},
+		"decreasing x,y": func(i, j int) bool {
+			return tiles[i].Header.Y > tiles[j].Header.Y ||
+				tiles[i].Header.Y == tiles[j].Header.Y && tiles[i].Header.X > tiles[j].Header.X
+		},
+	}
+	for name, less := range orders {
+		sort.Slice(tiles, less)
+		var mesh NavMesh
+		if st := mesh.Init(&src.Params); StatusFailed(st) {
+			t.Fatalf("Init failed with status 0x%x", uint32(st))
+		}
+		for _, tile := range tiles {
+			if st, _ := mesh.AddTile(tileData(tile), 0); StatusFailed(st) {
+				t.Fatalf("%s: AddTile failed with status 0x%x", name, uint32(st))
+			}
+		}
+		if errs := mesh.Validate(); len(errs) != 0 {
+			t.Errorf("%s: got %d problems, first is: %v", name, len(errs), errs[0])
+		}
+		checkPath := func(msg string) {
+			got := findPath(&mesh)
+			if len(got) != len(want) {
+				t.Errorf("%s: got path %v, want %v", msg, got, want)
+				return
+			}
+			for i := range want {
+				if got[i].Dist(want[i]) > 1e-4 {
+					t.Errorf("%s: got path %v, want %v", msg, got, want)
+					return
+				}
+			}
+		}
+		checkPath(name)
+
+		// Reload the tile of the start position, once its neighbours are
+		// present.
+		tx, ty := mesh.CalcTileLoc(org)
+		if _, st := mesh.RemoveTile(mesh.TileRefAt(tx, ty, 0)); StatusFailed(st) {
+			t.Fatalf("%s: RemoveTile failed with status 0x%x", name, uint32(st))
+		}
+		if st, _ := mesh.AddTile(tileData(src.TileAt(tx, ty, 0)), 0); StatusFailed(st) {
+			t.Fatalf("%s: AddTile failed with status 0x%x", name, uint32(st))
+		}
+		checkPath(name + ", reloaded start tile")
+	}
+}
+
+func TestAddTileOccupied(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	srcTile := src.TileAt(0, 1, 0)
+	if srcTile == nil {
+		t.Fatalf("no tile at (0,1,0)")
+	}
+
+	var mesh NavMesh
+	if st := mesh.Init(&src.Params); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", uint32(st))
+	}
+	st, ref := mesh.AddTile(tileData(srcTile), 0)
+	if StatusFailed(st) {
+		t.Fatalf("AddTile failed with status 0x%x", uint32(st))
+	}
+
+	if n := mesh.TileChanges(); n != 1 {
+		t.Errorf("TileChanges() = %d after adding a tile, want 1", n)
+	}
+
+	// Adding a tile at the same location must fail, leaving the first tile.
+	if st, _ := mesh.AddTile(tileData(srcTile), 0); st != Failure|AlreadyOccupied {
+		t.Errorf("AddTile on occupied location returned status 0x%x, want 0x%x",
+			uint32(st), uint32(Failure|AlreadyOccupied))
+	}
+	if got := mesh.TileRefAt(0, 1, 0); got != ref {
+		t.Errorf("TileRefAt(0, 1, 0) = 0x%x, want 0x%x", got, ref)
+	}
+
+	// ReplaceTile overwrites it and returns the replaced tile data.
+	st, newRef, old := mesh.ReplaceTile(tileData(srcTile))
+	if StatusFailed(st) {
+		t.Fatalf("ReplaceTile failed with status 0x%x", uint32(st))
+	}
+	if old == nil {
+		t.Errorf("ReplaceTile returned nil data for the replaced tile")
+	}
+	if newRef == ref || mesh.TileRefAt(0, 1, 0) != newRef {
+		t.Errorf("ReplaceTile returned ref 0x%x, old ref was 0x%x, ref at (0,1,0) is 0x%x",
+			newRef, ref, mesh.TileRefAt(0, 1, 0))
+	}
+	if errs := mesh.Validate(); len(errs) != 0 {
+		t.Errorf("got %d problems, first is: %v", len(errs), errs[0])
+	}
+	// the failed AddTile is not a change, the replacement is 2 changes
+	if n := mesh.TileChanges(); n != 3 {
+		t.Errorf("TileChanges() = %d after replacing a tile, want 3", n)
+	}
+
+	// A failed replacement restores the previous tile.
+	if st, _, _ := mesh.ReplaceTile(tileData(srcTile)[:50]); !StatusFailed(st) {
+		t.Errorf("ReplaceTile with truncated data succeeded")
+	}
+	if mesh.TileRefAt(0, 1, 0) != newRef {
+		t.Errorf("ReplaceTile didn't restore the replaced tile")
+	}
+}
+
+func TestStackedTileLayers(t *testing.T) {
+	// layerData returns a flat 2x2 single poly tile, at height y.
+	layerData := func(layer int32, y float32) []byte {
+		params := slopedEdgeParams()
+		params.DetailVerts = []float32{0, y, 0, 0, y, 2, 2, y, 2, 2, y, 0, 1, y, 1}
+		params.TileLayer = layer
+		params.BMin[1] = y
+		params.BMax[1] = y + 2
+		params.WalkableClimb = 2
+		data, err := CreateNavMeshData(params)
+		checkt(t, err)
+		return data
+	}
+
+	var mesh NavMesh
+	if st := mesh.Init(&NavMeshParams{TileWidth: 2, TileHeight: 2, MaxTiles: 4, MaxPolys: 1}); StatusFailed(st) {
+		t.Fatalf("Init failed with status 0x%x", uint32(st))
+	}
+	var refs [2]PolyRef
+	for layer, y := range []float32{0, 3} {
+		st, tref := mesh.AddTile(layerData(int32(layer), y), 0)
+		if StatusFailed(st) {
+			t.Fatalf("AddTile(layer %d) failed with status 0x%x", layer, uint32(st))
+		}
+		refs[layer] = mesh.polyRefBase(mesh.TileByRef(tref))
+	}
+
+	tiles := make([]*MeshTile, 4)
+	if n := mesh.TilesAt(0, 0, tiles, 4); n != 2 {
+		t.Errorf("TilesAt(0, 0) returned %d tiles, want 2", n)
+	}
+	for layer := int32(0); layer < 2; layer++ {
+		if mesh.TileAt(0, 0, layer) == nil {
+			t.Errorf("TileAt(0, 0, %d) = nil", layer)
+		}
+	}
+
+	st, q := NewNavMeshQuery(&mesh, 16)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	// Both layers are within climb height of the points between them.
+	nearestPolyTests := []struct {
+		y     float32
+		layer int
+	}{
+		{-0.5, 0},
+		{0.2, 0},
+		{1.4, 0},
+		{1.6, 1},
+		{2.8, 1},
+		{4, 1},
+	}
+	for _, tt := range nearestPolyTests {
+		pos := d3.Vec3{1, tt.y, 1}
+		_, ref, pt := q.FindNearestPoly(pos, d3.Vec3{1, 4, 1}, NewStandardQueryFilter())
+		if ref != refs[tt.layer] {
+			t.Errorf("FindNearestPoly(%v) = 0x%x, want 0x%x (layer %d)", pos, ref, refs[tt.layer], tt.layer)
+			continue
+		}
+		if want := float32(3 * tt.layer); math32.Abs(pt[1]-want) > 1e-3 {
+			t.Errorf("FindNearestPoly(%v) point = %v, want height %v", pos, pt, want)
+		}
+	}
+}
+
+func TestMergeTilesFrom(t *testing.T) {
+	src, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+
+	org := d3.Vec3{5, 0, 10}
+	dst := d3.Vec3{50, 0, 30}
+	extents := d3.NewVec3XYZ(2, 4, 2)
+	filter := NewStandardQueryFilter()
+	orgX, _ := src.CalcTileLoc(org)
+	dstX, _ := src.CalcTileLoc(dst)
+	if orgX >= dstX {
+		t.Fatalf("org and dst tiles are not ordered by x")
+	}
+
+	// Split the navmesh in 2 zones, between org and dst.
+	var zones [2]NavMesh
+	for i := range zones {
+		if st := zones[i].Init(&src.Params); StatusFailed(st) {
+			t.Fatalf("Init failed with status 0x%x", uint32(st))
+		}
+	}
+	var ntiles int
+	for i := range src.Tiles {
+		tile := &src.Tiles[i]
+		if tile.Header == nil {
+			continue
+		}
+		ntiles++
+		zone := &zones[0]
+		if tile.Header.X > orgX {
+			zone = &zones[1]
+		}
+		if st, _ := zone.AddTile(tileData(tile), 0); StatusFailed(st) {
+			t.Fatalf("AddTile failed with status 0x%x", uint32(st))
+		}
+	}
+
+	// findPath returns the status of the path from org to dst.
+	findPath := func(mesh *NavMesh) Status {
+		_, q := NewNavMeshQuery(mesh, 2048)
+		_, orgRef, orgPt := q.FindNearestPoly(org, extents, filter)
+		_, dstRef, dstPt := q.FindNearestPoly(dst, extents, filter)
+		if orgRef == 0 || dstRef == 0 {
+			return Failure
+		}
+		path := make([]PolyRef, 256)
+		n, st := q.FindPath(orgRef, dstRef, orgPt, dstPt, filter, path)
+		if st == Success && path[n-1] != dstRef {
+			return Failure
+		}
+		return st
+	}
+	if st := findPath(&zones[0]); st == Success {
+		t.Fatalf("path found before merging the zones")
+	}
+
+	mesh := &zones[0]
+	added, st := mesh.MergeTilesFrom(&zones[1])
+	if st != Success || added != zones[1].MemoryStats().TileCount {
+		t.Fatalf("MergeTilesFrom = %d, 0x%x, want %d, 0x%x",
+			added, uint32(st), zones[1].MemoryStats().TileCount, uint32(Success))
+	}
+	if n := mesh.MemoryStats().TileCount; n != ntiles {
+		t.Errorf("merged navmesh has %d tiles, want %d", n, ntiles)
+	}
+	if errs := mesh.Validate(); len(errs) != 0 {
+		t.Errorf("got %d problems, first is: %v", len(errs), errs[0])
+	}
+	if st := findPath(mesh); st != Success {
+		t.Errorf("FindPath on the merged navmesh returned status 0x%x, want 0x%x", uint32(st), uint32(Success))
+	}
+
+	// The tiles are already there.
+	if added, st := mesh.MergeTilesFrom(&zones[1]); added != 0 || st != Failure|AlreadyOccupied {
+		t.Errorf("second MergeTilesFrom = %d, 0x%x, want 0, 0x%x", added, uint32(st), uint32(Failure|AlreadyOccupied))
+	}
+
+	// Incompatible tile grids.
+	params := src.Params
+	params.TileWidth *= 2
+	var other NavMesh
+	other.Init(&params)
+	if added, st := mesh.MergeTilesFrom(&other); added != 0 || st != Failure|InvalidParam {
+		t.Errorf("MergeTilesFrom with another tile width = %d, 0x%x, want 0, 0x%x", added, uint32(st), uint32(Failure|InvalidParam))
+	}
+}
+
+func TestBuildAgentParams(t *testing.T) {
+	var empty NavMesh
+	if _, _, _, ok := empty.BuildAgentParams(); ok {
+		t.Errorf("BuildAgentParams of an empty navmesh returned ok")
+	}
+
+	params := slopedEdgeParams()
+	params.WalkableRadius, params.WalkableHeight, params.WalkableClimb = 0.6, 2.1, 0.9
+	data, err := CreateNavMeshData(params)
+	checkt(t, err)
+
+	var mesh NavMesh
+	if st := mesh.InitForSingleTile(data, 0); StatusFailed(st) {
+		t.Fatalf("InitForSingleTile failed with status 0x%x", uint32(st))
+	}
+
+	dir, err := ioutil.TempDir("", "detour")
+	checkt(t, err)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "agent.bin")
+	checkt(t, mesh.SaveToFile(fname))
+	f, err := os.Open(fname)
+	checkt(t, err)
+	defer f.Close()
+	loaded, err := Decode(f)
+	checkt(t, err)
+
+	for _, m := range []*NavMesh{&mesh, loaded} {
+		radius, height, climb, ok := m.BuildAgentParams()
+		if !ok || radius != 0.6 || height != 2.1 || climb != 0.9 {
+			t.Errorf("BuildAgentParams() = %v, %v, %v, %v, want 0.6, 2.1, 0.9, true", radius, height, climb, ok)
+		}
+	}
+}
+
+func TestFindPathAcrossStackedLayers(t *testing.T) {
+	// A lower cave and the surface above it are the layers 0 and 1 of the
+	// tile (0,0), 3 units apart. The tile (1,0) holds a ramp which x- edge
+	// rises from the cave floor, at z = 0, to the surface, at z = 4. Tiles
+	// are 4x4 units.
+	tileParams := func(tx, layer int32, verts []uint16, ymax float32) *NavMeshCreateParams {
+		return &NavMeshCreateParams{
+			Verts:     verts,
+			VertCount: 4,
+			Polys: []uint16{
+				0, 1, 2, 3, 0xffff, 0xffff,
+				0x800f, 0x800f, 0x800f, 0x800f, 0xffff, 0xffff,
+			},
+			PolyFlags:      []uint16{1},
+			PolyAreas:      []uint8{0},
+			PolyCount:      1,
+			Nvp:            6,
+			WalkableHeight: 2,
+			WalkableRadius: 0.5,
+			WalkableClimb:  0.5,
+			TileX:          tx,
+			TileLayer:      layer,
+			BMin:           [3]float32{4 * float32(tx), 0, 0},
+			BMax:           [3]float32{4*float32(tx) + 4, ymax, 4},
+			Cs:             0.5,
+			Ch:             0.2,
+			BuildBvTree:    true,
+		}
+	}
+	cave := tileParams(0, 0, []uint16{0, 0, 0, 0, 0, 8, 8, 0, 8, 8, 0, 0}, 1)
+	cave.Polys[6+2] = 0x8002 // x+ portal
+	surface := tileParams(0, 1, []uint16{0, 15, 0, 0, 15, 8, 8, 15, 8, 8, 15, 0}, 4)
+	surface.Polys[6+2] = 0x8002 // x+ portal
+	ramp := tileParams(1, 0, []uint16{0, 0, 0, 0, 15, 8, 8, 15, 8, 8, 0, 0}, 4)
+	ramp.Polys[6+0] = 0x8000 // x- portal
+
+	// Links do not depend on the order the tiles are added.
+	tiles := []*NavMeshCreateParams{cave, surface, ramp}
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}} {
+		var mesh NavMesh
+		st := mesh.Init(&NavMeshParams{TileWidth: 4, TileHeight: 4, MaxTiles: 4, MaxPolys: 4})
+		if StatusFailed(st) {
+			t.Fatalf("Init failed with status 0x%x", uint32(st))
+		}
+		for _, i := range order {
+			params := tiles[i]
+			data, err := CreateNavMeshData(params)
+			checkt(t, err)
+			if st, _ := mesh.AddTile(data, 0); StatusFailed(st) {
+				t.Fatalf("AddTile(%d,%d) failed with status 0x%x", params.TileX, params.TileLayer, uint32(st))
+			}
+		}
+
+		st, q := NewNavMeshQuery(&mesh, 100)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		filter := NewStandardQueryFilter()
+		ext := d3.Vec3{0.5, 0.5, 0.5}
+		_, caveRef, cavePt := q.FindNearestPoly(d3.Vec3{2, 0, 2}, ext, filter)
+		_, surfRef, surfPt := q.FindNearestPoly(d3.Vec3{2, 3, 2}, ext, filter)
+		if caveRef == 0 || surfRef == 0 || caveRef == surfRef {
+			t.Fatalf("cave poly 0x%x, surface poly 0x%x, want two polygons", caveRef, surfRef)
+		}
+		if tile := mesh.TileAt(0, 0, 1); tile == nil || mesh.decodePolyIDTile(surfRef) != mesh.decodePolyIDTile(PolyRef(mesh.TileRef(tile))) {
+			t.Fatalf("surface poly 0x%x is not in the tile (0,0,1)", surfRef)
+		}
+
+		path := make([]PolyRef, 8)
+		for _, tt := range []struct {
+			name             string
+			orgRef, dstRef   PolyRef
+			orgPt, dstPt     d3.Vec3
+			wantOrg, wantDst PolyRef
+		}{
+			{"up", caveRef, surfRef, cavePt, surfPt, caveRef, surfRef},
+			{"down", surfRef, caveRef, surfPt, cavePt, surfRef, caveRef},
+		} {
+			n, st := q.FindPath(tt.orgRef, tt.dstRef, tt.orgPt, tt.dstPt, filter, path)
+			if st != Success || n != 3 || path[0] != tt.wantOrg || path[2] != tt.wantDst {
+				t.Errorf("order %v, path %s, got %#x and status 0x%x, want 3 polygons through the ramp", order, tt.name, path[:n], uint32(st))
+			}
+		}
+
+		// The layers are not linked to each other, but only to the ramp.
+		for _, ref := range []PolyRef{caveRef, surfRef} {
+			var (
+				tile *MeshTile
+				poly *Poly
+			)
+			mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+			var nlinks int
+			for i := poly.FirstLink; i != nullLink; i = tile.Links[i].Next {
+				if other := tile.Links[i].Ref; other == caveRef || other == surfRef {
+					t.Errorf("poly 0x%x is linked to the other layer 0x%x", ref, other)
+				}
+				nlinks++
+			}
+			if nlinks != 1 {
+				t.Errorf("poly 0x%x has %d links, want 1", ref, nlinks)
+			}
+		}
+	}
+}
+
+// withMaxLinks returns a copy of the tile data, with a link pool of n links,
+// as if it had been built with too few links.
+func withMaxLinks(data []byte, n int32) []byte {
+	var (
+		hdr  MeshHeader
+		tile MeshTile
+	)
+	hdr.unserialize(data)
+	tile.unserialize(&hdr, data[hdr.size():], link32Size)
+	hdr.MaxLinkCount = n
+	tile.Links = make([]Link, n)
+	size, _ := hdr.dataSize(link32Size)
+	out := make([]byte, size)
+	hdr.serialize(out)
+	tile.serialize(out[hdr.size():], link32Size)
+	return out
+}
+
+func TestAddTileLinkSaturated(t *testing.T) {
+	data, err := CreateNavMeshData(sliverParams())
+	checkt(t, err)
+
+	// The 3 polygons in a row have 4 internal links.
+	for _, n := range []int32{0, 1, 3} {
+		var mesh NavMesh
+		if st := mesh.InitForSingleTile(withMaxLinks(data, n), 0); st != Success {
+			t.Fatalf("with %d links, InitForSingleTile returned status 0x%x", n, uint32(st))
+		}
+		tile := &mesh.Tiles[0]
+		if tile.Header.MaxLinkCount < 4 || int(tile.Header.MaxLinkCount) != len(tile.Links) {
+			t.Errorf("with %d links, pool grown to %d links, %d in header, want at least 4",
+				n, len(tile.Links), tile.Header.MaxLinkCount)
+		}
+
+		base := mesh.polyRefBase(tile)
+		if !mesh.ArePolysAdjacent(base, base|1) || !mesh.ArePolysAdjacent(base|1, base|2) {
+			t.Errorf("with %d links, polygons are not connected", n)
+		}
+		st, q := NewNavMeshQuery(&mesh, 100)
+		if StatusFailed(st) {
+			t.Fatalf("query creation failed with status 0x%x", uint32(st))
+		}
+		path := make([]PolyRef, 10)
+		org, dst := d3.Vec3{0.5, 0, 1}, d3.Vec3{3.5, 0, 1}
+		if npath, st := q.FindPath(base, base|2, org, dst, NewStandardQueryFilter(), path); st != Success || npath != 3 {
+			t.Errorf("with %d links, FindPath returned %#x and status 0x%x, want 3 polygons", n, path[:npath], uint32(st))
+		}
+
+		// The grown tile serializes consistently.
+		var copied NavMesh
+		if st := copied.InitForSingleTile(mesh.tileData(tile), 0); st != Success {
+			t.Errorf("with %d links, adding the grown tile data returned status 0x%x", n, uint32(st))
+		}
+	}
+}
+
+func TestTilesAlongPath(t *testing.T) {
+	mesh, err := loadTestNavMesh("mesh2.bin")
+	checkt(t, err)
+	st, q := NewNavMeshQuery(mesh, 2048)
+	if StatusFailed(st) {
+		t.Fatalf("query creation failed with status 0x%x", uint32(st))
+	}
+	filter := NewStandardQueryFilter()
+	ext := d3.Vec3{1, 4, 1}
+	_, orgRef, org := q.FindNearestPoly(d3.Vec3{5, 0, 5}, ext, filter)
+	_, dstRef, dst := q.FindNearestPoly(d3.Vec3{30, 0, 20}, ext, filter)
+	path := make([]PolyRef, 256)
+	n, st := q.FindPath(orgRef, dstRef, org, dst, filter, path)
+	if st != Success {
+		t.Fatalf("FindPath failed with status 0x%x", uint32(st))
+	}
+	path = path[:n]
+
+	var want []TileRef
+	seen := make(map[TileRef]bool)
+	for _, ref := range path {
+		var (
+			tile *MeshTile
+			poly *Poly
+		)
+		mesh.TileAndPolyByRefUnsafe(ref, &tile, &poly)
+		if tref := mesh.TileRef(tile); !seen[tref] {
+			seen[tref] = true
+			want = append(want, tref)
+		}
+	}
+	if len(want) < 2 {
+		t.Fatalf("path crosses %d tiles, want at least 2", len(want))
+	}
+
+	// Invalid references are skipped.
+	path = append([]PolyRef{0, path[0] ^ 1<<mesh.polyBits<<mesh.tileBits}, path...)
+	out := make([]TileRef, 16)
+	if got := out[:mesh.TilesAlongPath(path, out)]; !reflect.DeepEqual(got, want) {
+		t.Errorf("TilesAlongPath() = %v, want %v", got, want)
+	}
+
+	// The result is capped to the out length.
+	out = out[:1]
+	if got := mesh.TilesAlongPath(path, out); got != 1 || out[0] != want[0] {
+		t.Errorf("TilesAlongPath() = %d, %v, want 1, %v", got, out, want[:1])
+	}
+	if got := mesh.TilesAlongPath(path, nil); got != 0 {
+		t.Errorf("TilesAlongPath() = %d for a nil out slice, want 0", got)
+	}
+}
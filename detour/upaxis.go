@@ -0,0 +1,74 @@
+package detour
+
+import "github.com/arl/gogeo/f32/d3"
+
+// UpAxis is the world axis pointing up in the data of a navigation mesh.
+//
+// Detour meshes are y-up, which is what Recast builds and what cmd/wow
+// converts the TrinityCore data to, but meshes baked by converters that do
+// not swap the axes may be z-up. The up axis of a NavMesh only changes the
+// computations that need to tell heights apart from horizontal distances:
+// polygon heights and slopes, and the vertical penalties of the nearest
+// polygon searches. The mesh data itself must be consistent with the chosen
+// axis, as mixing a y-up mesh with a z-up axis, or the opposite, gives wrong
+// heights. (See NavMesh.SetUpAxis)
+type UpAxis uint8
+
+const (
+	// UpAxisY is the Detour convention, and the default.
+	UpAxisY UpAxis = iota
+
+	// UpAxisZ is the convention of most modelling tools and game engines
+	// such as the WoW client.
+	UpAxisZ
+
+	// UpAxisX is for meshes which x axis points up.
+	UpAxisX
+)
+
+// index returns the index of the up component of a vector.
+func (a UpAxis) index() int {
+	switch a {
+	case UpAxisZ:
+		return 2
+	case UpAxisX:
+		return 0
+	}
+	return 1
+}
+
+// yUp sets dst to v with its up and y components swapped, so that the
+// computations made on the xz-plane work whatever the up axis. As it is its
+// own inverse, yUp also converts back the results of these computations.
+func (a UpAxis) yUp(dst, v d3.Vec3) {
+	dst.Assign(v)
+	if i := a.index(); i != 1 {
+		dst[1], dst[i] = v[i], v[1]
+	}
+}
+
+// extents returns the half extents of a search box, horiz along the
+// horizontal axes and vert along the up axis.
+func (a UpAxis) extents(horiz, vert float32) d3.Vec3 {
+	ext := d3.NewVec3XYZ(horiz, horiz, horiz)
+	ext[a.index()] = vert
+	return ext
+}
+
+// SetUpAxis sets the up axis of the navmesh data, UpAxisY by default.
+//
+// The up axis is not part of the navmesh data, it must be set again after
+// loading or decoding a navmesh that is not y-up. Failure|InvalidParam is
+// returned if axis is not one of the UpAxis constants.
+func (m *NavMesh) SetUpAxis(axis UpAxis) Status {
+	if axis > UpAxisX {
+		return Failure | InvalidParam
+	}
+	m.upAxis = axis
+	return Success
+}
+
+// UpAxis returns the up axis of the navmesh data.
+func (m *NavMesh) UpAxis() UpAxis {
+	return m.upAxis
+}
@@ -0,0 +1,100 @@
+package detour
+
+import (
+	"testing"
+
+	"github.com/arl/gogeo/f32/d3"
+	"github.com/arl/math32"
+)
+
+// zUp converts the vertices of the tiles of mesh from y-up to z-up.
+func zUp(mesh *NavMesh) {
+	swap := func(verts []float32) {
+		for i := 0; i+2 < len(verts); i += 3 {
+			verts[i+1], verts[i+2] = verts[i+2], verts[i+1]
+		}
+	}
+	for i := range mesh.Tiles {
+		swap(mesh.Tiles[i].Verts)
+		swap(mesh.Tiles[i].DetailVerts)
+	}
+}
+
+func TestUpAxis(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		mesh func(*testing.T) *NavMesh
+	}{
+		{"detail mesh", slopedEdgeMesh},
+		{"no detail mesh", noDetailMesh},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ymesh, zmesh := tt.mesh(t), tt.mesh(t)
+			zUp(zmesh)
+			if st := zmesh.SetUpAxis(UpAxisZ); st != Success {
+				t.Fatalf("SetUpAxis failed with status 0x%x", uint32(st))
+			}
+			if zmesh.UpAxis() != UpAxisZ || ymesh.UpAxis() != UpAxisY {
+				t.Fatalf("got up axes %v and %v, want %v and %v", ymesh.UpAxis(), zmesh.UpAxis(), UpAxisY, UpAxisZ)
+			}
+
+			_, yq := NewNavMeshQuery(ymesh, 16)
+			_, zq := NewNavMeshQuery(zmesh, 16)
+			ref := ymesh.polyRefBase(&ymesh.Tiles[0])
+
+			yslope, _ := ymesh.PolySlope(ref)
+			zslope, st := zmesh.PolySlope(ref)
+			if st != Success || !math32.Approx(yslope, zslope) {
+				t.Errorf("got z-up slope %f (status 0x%x), want the y-up slope %f", zslope, uint32(st), yslope)
+			}
+
+			for _, pos := range []d3.Vec3{{0.5, 5, 0.5}, {1, 5, 1}, {1.5, 5, 0.25}, {0.2, 5, 1.9}, {1, 5, 0}} {
+				yh, yst := yq.PolyHeight(ref, pos)
+				zh, zst := zq.PolyHeight(ref, d3.Vec3{pos[0], pos[2], pos[1]})
+				if yst != Success || zst != Success || math32.Abs(yh-zh) > 1e-5 {
+					t.Errorf("PolyHeight at %v: got %f (0x%x) z-up, %f (0x%x) y-up, want the same", pos, zh, uint32(zst), yh, uint32(yst))
+				}
+
+				// The height is applied to the up component.
+				closest, zclosest := d3.NewVec3(), d3.NewVec3()
+				yq.ClosestPointOnPoly(ref, pos, closest, nil)
+				zq.ClosestPointOnPoly(ref, d3.Vec3{pos[0], pos[2], pos[1]}, zclosest, nil)
+				if want := (d3.Vec3{closest[0], closest[2], closest[1]}); zclosest.Dist(want) > 1e-5 {
+					t.Errorf("ClosestPointOnPoly at %v: got %v z-up, want %v", pos, zclosest, want)
+				}
+			}
+
+			// Outside of the polygon, the closest point is on the nearest
+			// edge on the horizontal plane.
+			for _, pos := range []d3.Vec3{{3, 0.5, 1}, {-1, 0.5, -1}, {1, 0.05, 2.5}, {2.5, 0.2, 0.3}} {
+				closest, zclosest := d3.NewVec3(), d3.NewVec3()
+				yq.ClosestPointOnPoly(ref, pos, closest, nil)
+				zq.ClosestPointOnPoly(ref, d3.Vec3{pos[0], pos[2], pos[1]}, zclosest, nil)
+				if want := (d3.Vec3{closest[0], closest[2], closest[1]}); zclosest.Dist(want) > 1e-5 {
+					t.Errorf("ClosestPointOnPoly outside at %v: got %v z-up, want %v", pos, zclosest, want)
+				}
+
+				_, yref, ypt := yq.FindNearestPoly(pos, d3.Vec3{2, 4, 2}, NewStandardQueryFilter())
+				_, zref, zpt := zq.FindNearestPoly(d3.Vec3{pos[0], pos[2], pos[1]}, d3.Vec3{2, 2, 4}, NewStandardQueryFilter())
+				if yref == 0 || zref != yref {
+					t.Errorf("FindNearestPoly outside at %v: got 0x%x z-up, want 0x%x", pos, zref, yref)
+				} else if want := (d3.Vec3{ypt[0], ypt[2], ypt[1]}); zpt.Dist(want) > 1e-5 {
+					t.Errorf("FindNearestPoly outside at %v: got %v z-up, want %v", pos, zpt, want)
+				}
+			}
+
+			// Outside of the polygon.
+			if _, st := zq.PolyHeight(ref, d3.Vec3{3, 3, 0}); st != Failure|InvalidParam {
+				t.Errorf("got status 0x%x for a position outside of the polygon, want 0x%x", uint32(st), uint32(Failure|InvalidParam))
+			}
+		})
+	}
+
+	var mesh NavMesh
+	if st := mesh.SetUpAxis(UpAxisX + 1); st != Failure|InvalidParam {
+		t.Errorf("SetUpAxis(%d) returned status 0x%x, want 0x%x", UpAxisX+1, uint32(st), uint32(Failure|InvalidParam))
+	}
+	if mesh.UpAxis() != UpAxisY {
+		t.Errorf("got up axis %v after an invalid SetUpAxis, want %v", mesh.UpAxis(), UpAxisY)
+	}
+}
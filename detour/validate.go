@@ -0,0 +1,153 @@
+package detour
+
+import "fmt"
+
+// Validate checks the integrity of the navmesh data and returns the list of
+// the problems found, or nil.
+//
+// Every loaded tile is checked for out of range indices in polygon vertices,
+// neighbours and links, in detail meshes, in BV-tree nodes and in off-mesh
+// connections, so that malformed tiles can be detected before any query
+// crashes on them. Each returned error names the tile, and the polygon if
+// any, where the problem has been found. The navmesh is not modified.
+func (m *NavMesh) Validate() []error {
+	var errs []error
+	for it := range m.Tiles {
+		tile := &m.Tiles[it]
+		if tile.Header == nil {
+			continue
+		}
+		errs = append(errs, m.validateTile(it, tile, true)...)
+	}
+	return errs
+}
+
+// validateTile checks the integrity of a single tile, at index it.
+//
+// If links is false, polygon links are not checked. This is the case of
+// tiles which have not been added to the navmesh yet, and which links are
+// still to be built.
+func (m *NavMesh) validateTile(it int, tile *MeshTile, links bool) []error {
+	var errs []error
+	hdr := tile.Header
+	tileErr := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf("tile %d (%d,%d,%d): %s",
+			it, hdr.X, hdr.Y, hdr.Layer, fmt.Sprintf(format, a...)))
+	}
+	polyErr := func(ip int, format string, a ...interface{}) {
+		tileErr("poly %d: %s", ip, fmt.Sprintf(format, a...))
+	}
+
+	// Indices are checked against the actual data size, report any
+	// difference with the header.
+	counts := []struct {
+		name      string
+		hdr, data int
+	}{
+		{"polys", int(hdr.PolyCount), len(tile.Polys)},
+		{"vertices", int(hdr.VertCount), len(tile.Verts) / 3},
+		{"links", int(hdr.MaxLinkCount), len(tile.Links)},
+		{"detail meshes", int(hdr.DetailMeshCount), len(tile.DetailMeshes)},
+		{"detail vertices", int(hdr.DetailVertCount), len(tile.DetailVerts) / 3},
+		{"detail triangles", int(hdr.DetailTriCount), len(tile.DetailTris) / 4},
+		{"BV-tree nodes", int(hdr.BvNodeCount), len(tile.BvTree)},
+		{"off-mesh connections", int(hdr.OffMeshConCount), len(tile.OffMeshCons)},
+	}
+	for _, c := range counts {
+		if c.hdr != c.data {
+			tileErr("header has %d %s, data has %d", c.hdr, c.name, c.data)
+		}
+	}
+
+	npolys := len(tile.Polys)
+	nverts := len(tile.Verts) / 3
+	nlinks := len(tile.Links)
+	ndverts := len(tile.DetailVerts) / 3
+	ndtris := len(tile.DetailTris) / 4
+
+	for ip := range tile.Polys {
+		poly := &tile.Polys[ip]
+		nv := int(poly.VertCount)
+		if nv > int(VertsPerPolygon) {
+			polyErr(ip, "%d vertices, more than %d", nv, VertsPerPolygon)
+			nv = int(VertsPerPolygon)
+		}
+		for j := 0; j < nv; j++ {
+			if int(poly.Verts[j]) >= nverts {
+				polyErr(ip, "vertex %d index %d out of range [0,%d)", j, poly.Verts[j], nverts)
+			}
+			nei := poly.Neis[j]
+			if nei != 0 && nei&extLink == 0 && int(nei) > npolys {
+				polyErr(ip, "edge %d neighbour %d out of range [1,%d]", j, nei, npolys)
+			}
+		}
+
+		// Walk the link list, stopping on cycles.
+		var n int
+		for i := poly.FirstLink; links && i != nullLink; i = tile.Links[i].Next {
+			if int(i) >= nlinks {
+				polyErr(ip, "link index %d out of range [0,%d)", i, nlinks)
+				break
+			}
+			if n++; n > nlinks {
+				polyErr(ip, "cycle in link list")
+				break
+			}
+			link := &tile.Links[i]
+			if link.Edge != 0xff && int(link.Edge) >= nv {
+				polyErr(ip, "link %d edge %d out of range [0,%d)", i, link.Edge, nv)
+			}
+			if err := m.CheckPolyRef(link.Ref); err != nil {
+				polyErr(ip, "link %d to 0x%x: %v", i, link.Ref, err)
+			}
+		}
+
+		// Off-mesh connections do not have detail meshes.
+		if poly.Type() == polyTypeOffMeshConnection || ip >= len(tile.DetailMeshes) {
+			continue
+		}
+		pd := &tile.DetailMeshes[ip]
+		if int(pd.VertBase)+int(pd.VertCount) > ndverts {
+			polyErr(ip, "detail vertices [%d,%d) out of range [0,%d)",
+				pd.VertBase, int(pd.VertBase)+int(pd.VertCount), ndverts)
+		}
+		if int(pd.TriBase)+int(pd.TriCount) > ndtris {
+			polyErr(ip, "detail triangles [%d,%d) out of range [0,%d)",
+				pd.TriBase, int(pd.TriBase)+int(pd.TriCount), ndtris)
+			continue
+		}
+		for j := 0; j < int(pd.TriCount); j++ {
+			t := tile.DetailTris[(int(pd.TriBase)+j)*4:][:3]
+			for k := range t {
+				if int(t[k]) >= nv+int(pd.VertCount) {
+					polyErr(ip, "detail triangle %d vertex %d out of range [0,%d)",
+						int(pd.TriBase)+j, t[k], nv+int(pd.VertCount))
+				}
+			}
+		}
+	}
+
+	nnodes := len(tile.BvTree)
+	for i := range tile.BvTree {
+		node := &tile.BvTree[i]
+		if node.I >= 0 {
+			if int(node.I) >= npolys {
+				tileErr("BV-tree node %d poly %d out of range [0,%d)", i, node.I, npolys)
+			}
+		} else if i-int(node.I) > nnodes {
+			tileErr("BV-tree node %d escape index %d out of range", i, -node.I)
+		}
+	}
+
+	for i := range tile.OffMeshCons {
+		con := &tile.OffMeshCons[i]
+		switch {
+		case int(con.Poly) >= npolys:
+			tileErr("off-mesh connection %d poly %d out of range [0,%d)", i, con.Poly, npolys)
+		case tile.Polys[con.Poly].Type() != polyTypeOffMeshConnection:
+			tileErr("off-mesh connection %d poly %d is not an off-mesh connection", i, con.Poly)
+		}
+	}
+
+	return errs
+}
@@ -1,6 +1,6 @@
 module github.com/arl/go-detour
 
-go 1.14
+go 1.16
 
 require (
 	github.com/arl/assertgo v0.0.0-20180702120748-a1be5afdc871
@@ -8,6 +8,7 @@ require (
 	github.com/arl/gogeo v0.0.0-20200405111831-9d419f5f7a90
 	github.com/arl/math32 v0.2.0
 	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/spf13/cobra v1.0.0
 	gopkg.in/yaml.v2 v2.2.8
 )
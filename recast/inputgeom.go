@@ -102,7 +102,35 @@ func (ig *InputGeom) LoadOBJMesh(r io.Reader) error {
 	if err = ig.mesh.Load(r); err != nil {
 		return err
 	}
+	return ig.initMesh()
+}
+
+// SetMesh sets the geometry from a triangle mesh, verts holding the vertices
+// [(x, y, z) * nverts] and tris the indices of the triangles vertices in
+// verts [(vertA, vertB, vertC) * ntris].
+//
+// The slices are copied. SetMesh fails if a triangle refers to a vertex out
+// of range.
+func (ig *InputGeom) SetMesh(verts []float32, tris []int32) error {
+	if len(verts)%3 != 0 || len(tris)%3 != 0 {
+		return fmt.Errorf("vertex or triangle count is not a multiple of 3")
+	}
+	nverts := int32(len(verts) / 3)
+	for _, v := range tris {
+		if v < 0 || v >= nverts {
+			return fmt.Errorf("triangle vertex %d out of range [0, %d)", v, nverts)
+		}
+	}
+
+	ig.chunkyMesh = nil
+	ig.offMeshConCount = 0
+	ig.volumeCount = 0
+	ig.mesh = newMeshLoaderTris(verts, tris)
+	return ig.initMesh()
+}
 
+// initMesh computes the bounds and the chunky mesh of the loaded mesh.
+func (ig *InputGeom) initMesh() error {
 	CalcBounds(ig.mesh.Verts(), ig.mesh.VertCount(), ig.meshBMin[:], ig.meshBMax[:])
 
 	ig.chunkyMesh = new(ChunkyTriMesh)
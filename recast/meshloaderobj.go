@@ -58,7 +58,21 @@ func (mlo *MeshLoaderOBJ) Load(r io.Reader) error {
 		}
 	}
 
-	// Calculate normals.
+	mlo.calcNormals()
+	return nil
+}
+
+// newMeshLoaderTris returns a mesh made of the provided triangles.
+func newMeshLoaderTris(verts []float32, tris []int32) *MeshLoaderOBJ {
+	mlo := NewMeshLoaderOBJ()
+	mlo.verts = append(mlo.verts, verts...)
+	mlo.tris = append(mlo.tris, tris...)
+	mlo.calcNormals()
+	return mlo
+}
+
+// calcNormals calculates the normals of the mesh triangles.
+func (mlo *MeshLoaderOBJ) calcNormals() {
 	// TODO: factor this with recast.calcTriNormal
 	var e0, e1 [3]float32
 	mlo.normals = make([]float32, len(mlo.tris))
@@ -82,8 +96,6 @@ func (mlo *MeshLoaderOBJ) Load(r io.Reader) error {
 			n[2] *= d
 		}
 	}
-
-	return nil
 }
 
 func (mlo *MeshLoaderOBJ) Scale() float32 {
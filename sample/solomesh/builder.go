@@ -42,6 +42,13 @@ func (sm *SoloMesh) LoadGeometry(r io.Reader) error {
 	return sm.geom.LoadOBJMesh(r)
 }
 
+// SetGeometry sets the geometry from a triangle mesh, with its vertices and
+// the indices of the triangles vertices, without having to read a geometry
+// file. (See recast.InputGeom.SetMesh)
+func (sm *SoloMesh) SetGeometry(verts []float32, tris []int32) error {
+	return sm.geom.SetMesh(verts, tris)
+}
+
 // InputGeom returns the nav mesh input geometry.
 func (sm *SoloMesh) InputGeom() *recast.InputGeom {
 	return &sm.geom
@@ -358,7 +365,8 @@ func (sm *SoloMesh) Build() (*detour.NavMesh, bool) {
 		// navQuery *detour.NavMeshQuery
 		status detour.Status
 	)
-	status = navMesh.InitForSingleTile(navData, 0)
+	// RecastDemo navmeshes use 32 bits references
+	status = navMesh.InitForSingleTileWithLayout(navData, 0, detour.PolyRefLayout32)
 	if detour.StatusFailed(status) {
 		sm.ctx.Errorf("Could not init Detour navmesh")
 		return nil, false
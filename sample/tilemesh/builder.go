@@ -77,30 +77,12 @@ func (tm *TileMesh) Build() (*detour.NavMesh, bool) {
 		return nil, false
 	}
 
-	bmin := tm.geom.NavMeshBoundsMin()
-	bmax := tm.geom.NavMeshBoundsMax()
-	gw, gh := recast.CalcGridSize(bmin[:], bmax[:], tm.settings.CellSize)
-	ts := int32(tm.settings.TileSize)
-	tw := (gw + ts - 1) / ts
-	th := (gh + ts - 1) / ts
-
-	// Max tiles and max polys affect how the tile IDs are caculated.
-	// There are 22 bits available for identifying a tile and a polygon.
-	tileBits := math32.MinInt32(int32(math32.Ilog2(math32.NextPow2(uint32(tw*th)))), 14)
-	polyBits := 22 - tileBits
-	tm.maxTiles = 1 << uint(tileBits)
-	tm.maxPolysPerTile = 1 << uint(polyBits)
+	params, _, _ := navMeshParams(&tm.geom, tm.settings)
+	tm.maxTiles = params.MaxTiles
+	tm.maxPolysPerTile = params.MaxPolys
 
-	var (
-		params detour.NavMeshParams
-		status detour.Status
-	)
-	copy(params.Orig[:], tm.geom.NavMeshBoundsMin()[:3])
-	params.TileWidth = tm.settings.TileSize * tm.settings.CellSize
-	params.TileHeight = tm.settings.TileSize * tm.settings.CellSize
-	params.MaxTiles = tm.maxTiles
-	params.MaxPolys = tm.maxPolysPerTile
-	status = tm.navMesh.Init(&params)
+	// RecastDemo navmeshes use 32 bits references
+	status := tm.navMesh.InitWithLayout(&params, detour.PolyRefLayout32)
 	if detour.StatusFailed(status) {
 		tm.ctx.Errorf("TileMesh.Build: Could not init navmesh")
 		return nil, false
@@ -116,6 +98,29 @@ func (tm *TileMesh) Build() (*detour.NavMesh, bool) {
 	return &tm.navMesh, true
 }
 
+// navMeshParams returns the parameters of the navmesh built from geom, and
+// its size in tiles.
+func navMeshParams(geom *recast.InputGeom, settings recast.BuildSettings) (params detour.NavMeshParams, tw, th int32) {
+	bmin := geom.NavMeshBoundsMin()
+	bmax := geom.NavMeshBoundsMax()
+	gw, gh := recast.CalcGridSize(bmin[:], bmax[:], settings.CellSize)
+	ts := int32(settings.TileSize)
+	tw = (gw + ts - 1) / ts
+	th = (gh + ts - 1) / ts
+
+	// Max tiles and max polys affect how the tile IDs are caculated.
+	// There are 22 bits available for identifying a tile and a polygon.
+	tileBits := math32.MinInt32(int32(math32.Ilog2(math32.NextPow2(uint32(tw*th)))), 14)
+	polyBits := 22 - tileBits
+
+	copy(params.Orig[:], bmin[:3])
+	params.TileWidth = settings.TileSize * settings.CellSize
+	params.TileHeight = settings.TileSize * settings.CellSize
+	params.MaxTiles = 1 << uint(tileBits)
+	params.MaxPolys = 1 << uint(polyBits)
+	return params, tw, th
+}
+
 func (tm *TileMesh) buildAllTiles() (*detour.NavMesh, bool) {
 	bmin := tm.geom.NavMeshBoundsMin()
 	bmax := tm.geom.NavMeshBoundsMax()
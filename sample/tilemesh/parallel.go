@@ -0,0 +1,142 @@
+package tilemesh
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/arl/go-detour/detour"
+	"github.com/arl/go-detour/recast"
+)
+
+// TileError reports the failure to build, or to add, a navmesh tile.
+type TileError struct {
+	X, Y int32 // Tile location in the navmesh tile grid.
+	Err  error
+}
+
+func (e *TileError) Error() string {
+	return fmt.Sprintf("tile (%d,%d): %v", e.X, e.Y, e.Err)
+}
+
+// BuildErrors gathers the errors of the tiles that failed during a build.
+type BuildErrors []*TileError
+
+func (e BuildErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return fmt.Sprintf("%d tile(s) failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// BuildTiledNavMeshParallel builds a tiled navmesh from geom, building the
+// tiles concurrently.
+//
+//  Arguments:
+//   geom      The input geometry.
+//   settings  The build settings, DefaultSettings() is a good start.
+//   workers   The number of tiles built concurrently, or 0 for
+//             runtime.GOMAXPROCS(0).
+//
+// Tiles are independent from each other until they are added to the navmesh,
+// so each worker builds tiles with its own Recast state. The tiles are then
+// added, by a single goroutine, in the order TileMesh.Build adds them, which
+// gives the same navmesh.
+//
+// A tile that fails to build is left empty and does not abort the build: the
+// navmesh is returned along with a BuildErrors error listing the failed
+// tiles. The navmesh is nil only if it could not be created.
+//
+// geom must not be modified during the build.
+func BuildTiledNavMeshParallel(geom *recast.InputGeom, settings recast.BuildSettings, workers int) (*detour.NavMesh, error) {
+	if geom.Mesh() == nil {
+		return nil, errors.New("no input geometry")
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	params, tw, th := navMeshParams(geom, settings)
+	var mesh detour.NavMesh
+	if st := mesh.InitWithLayout(&params, detour.PolyRefLayout32); detour.StatusFailed(st) {
+		return nil, fmt.Errorf("could not init navmesh: %v", st)
+	}
+
+	bmin := geom.NavMeshBoundsMin()
+	bmax := geom.NavMeshBoundsMax()
+	tcs := settings.TileSize * settings.CellSize
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make([]result, tw*th)
+	jobs := make(chan int32)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each worker has its own build state and context.
+			ctx := recast.NewBuildContext(true)
+			tm := New(ctx)
+			tm.geom = *geom
+			tm.settings = settings
+
+			for idx := range jobs {
+				x, y := idx%tw, idx/tw
+				tbmin := []float32{bmin[0] + float32(x)*tcs, bmin[1], bmin[2] + float32(y)*tcs}
+				tbmax := []float32{bmin[0] + float32(x+1)*tcs, bmax[1], bmin[2] + float32(y+1)*tcs}
+
+				ctx.ResetLog()
+				data := tm.buildTileMesh(x, y, tbmin, tbmax)
+				results[idx] = result{data: data, err: logErrors(ctx)}
+			}
+		}()
+	}
+	for idx := int32(0); idx < tw*th; idx++ {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	var errs BuildErrors
+	for idx, res := range results {
+		x, y := int32(idx)%tw, int32(idx)/tw
+		if res.err != nil {
+			errs = append(errs, &TileError{X: x, Y: y, Err: res.err})
+			continue
+		}
+		if res.data == nil {
+			// Empty tile.
+			continue
+		}
+		if st, _ := mesh.AddTile(res.data, 0); detour.StatusFailed(st) {
+			errs = append(errs, &TileError{X: x, Y: y, Err: st})
+		}
+	}
+	if errs != nil {
+		return &mesh, errs
+	}
+	return &mesh, nil
+}
+
+// logErrors returns an error made of the error entries of ctx log, or nil if
+// there are none.
+func logErrors(ctx *recast.BuildContext) error {
+	var msgs []string
+	for i := 0; i < ctx.LogCount(); i++ {
+		if msg := ctx.LogText(int32(i)); strings.HasPrefix(msg, "ERR ") {
+			msgs = append(msgs, strings.TrimPrefix(msg, "ERR "))
+		}
+	}
+	if msgs == nil {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, ", "))
+}
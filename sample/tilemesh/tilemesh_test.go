@@ -94,10 +94,10 @@ func TestCreateDevelerTileNavMesh(t *testing.T) {
 	testCreateTileMesh(t, "develer")
 }
 
-// FIXME: generated binaries are different because of an issue with the
-// stabiblity of the sorting functions used for BvTree (see createBvTree). This
-// has no influence over the generated navmesh but that means that we can't just
-// compare the produced binaries.
+// FIXME: generated binaries are different from the reference ones, because
+// the BV-tree items with equal bounds are not sorted in the same order (see
+// bvItemLess). This has no influence over the generated navmesh but that means
+// that we can't just compare the produced binaries.
 /*
 func TestCreateDungeonTileNavMesh(t *testing.T) {
 	testCreateTileMesh(t, "dungeon")
@@ -136,6 +136,32 @@ func TestCreateHillTileNavMesh(t *testing.T) {
 	testCreateTileMesh(t, "hill")
 }
 
+func TestBuildTiledNavMeshParallel(t *testing.T) {
+	for _, objName := range []string{"develer", "hill"} {
+		var geom recast.InputGeom
+		r, err := os.Open(OBJDir + objName + ".obj")
+		check(t, err)
+		err = geom.LoadOBJMesh(r)
+		r.Close()
+		check(t, err)
+
+		navMesh, err := BuildTiledNavMeshParallel(&geom, DefaultSettings(), 4)
+		if err != nil {
+			t.Fatalf("couldn't build navmesh for %v: %v", objName, err)
+		}
+
+		// The navmesh is the same as the one built serially.
+		outBin := "out.bin"
+		check(t, navMesh.SaveToFile(outBin))
+		ok, err := compareFiles(outBin, testDataDir+objName+".bin")
+		os.Remove(outBin)
+		check(t, err)
+		if !ok {
+			t.Errorf("%v navmesh built in parallel differs from %v", objName, testDataDir+objName+".bin")
+		}
+	}
+}
+
 func benchmarkCreateTileNavMesh(b *testing.B, objName string) {
 	path := OBJDir + objName + ".obj"
 